@@ -0,0 +1,254 @@
+package main
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/backup"
+	"AlChats/service/database"
+	"AlChats/service/whatsapp"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ardanlabs/conf"
+)
+
+// runCommand executes the command in args on the database.
+func runCommand(db database.AppDatabase, args conf.Args, out *printer) error {
+	switch command := args.Num(0) + " " + args.Num(1); command {
+	case "users list":
+		users, err := allUsers(db)
+		if err != nil {
+			return err
+		}
+		return out.users(users)
+
+	case "users find":
+		if args.Num(2) == "" {
+			return fmt.Errorf("usage: users find <username>")
+		}
+		user, err := db.GetUserByUsername(args.Num(2))
+		if err != nil {
+			return err
+		}
+		return out.users([]models.User{user})
+
+	case "users delete":
+		if args.Num(2) == "" {
+			return fmt.Errorf("usage: users delete <user id>")
+		}
+		if err := db.DeleteUserByID(args.Num(2)); err != nil {
+			return err
+		}
+		return out.message(fmt.Sprintf("user %s deleted", args.Num(2)))
+
+	case "conversations list":
+		conversations, err := allConversations(db)
+		if err != nil {
+			return err
+		}
+		return out.conversations(conversations)
+
+	case "conversations members":
+		if args.Num(2) == "" {
+			return fmt.Errorf("usage: conversations members <conversation id>")
+		}
+		if _, err := db.GetConversationByID(args.Num(2)); err != nil {
+			return err
+		}
+		members, err := db.GetConversationMembers(args.Num(2))
+		if err != nil {
+			return err
+		}
+		return out.users(members)
+
+	case "bots list":
+		bots, err := db.GetBots()
+		if err != nil {
+			return err
+		}
+		return out.users(bots)
+
+	case "bots create":
+		if args.Num(2) == "" {
+			return fmt.Errorf("usage: bots create <username>")
+		}
+		bot, err := db.CreateBot(args.Num(2))
+		if err != nil {
+			return err
+		}
+		key, err := db.CreateAPIKey(bot.UserID, time.Now())
+		if err != nil {
+			return err
+		}
+		return out.apiKey(key)
+
+	case "bots key":
+		if args.Num(2) == "" {
+			return fmt.Errorf("usage: bots key <bot id>")
+		}
+		key, err := db.CreateAPIKey(args.Num(2), time.Now())
+		if err != nil {
+			return err
+		}
+		return out.apiKey(key)
+
+	case "bots revoke":
+		if args.Num(2) == "" || args.Num(3) == "" {
+			return fmt.Errorf("usage: bots revoke <bot id> <key id>")
+		}
+		if err := db.DeleteAPIKey(args.Num(2), args.Num(3)); err != nil {
+			return err
+		}
+		return out.message(fmt.Sprintf("API key %s revoked", args.Num(3)))
+	}
+
+	switch args.Num(0) {
+	case "vacuum":
+		if err := db.Vacuum(); err != nil {
+			return err
+		}
+		return out.message("vacuum completed")
+
+	case "check":
+		problems, err := db.IntegrityCheck()
+		if err != nil {
+			return err
+		}
+		if err := out.problems(problems); err != nil {
+			return err
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("%d problems found", len(problems))
+		}
+		return nil
+
+	case "stats":
+		stats, err := db.Stats()
+		if err != nil {
+			return err
+		}
+		return out.stats(stats)
+	}
+
+	return fmt.Errorf("unknown command %q\n\n%s", args.Num(0), commandsUsage)
+}
+
+// runBackupCommand executes the backup commands.
+func runBackupCommand(backups *backup.Manager, args conf.Args, out *printer) error {
+	if args.Num(0) == "backup" {
+		info, err := backups.Run()
+		if err != nil {
+			return err
+		}
+		return out.backups([]backup.Info{info})
+	}
+
+	list, err := backups.List()
+	if err != nil {
+		return err
+	}
+	return out.backups(list)
+}
+
+// restore replaces the database file with the backup in args.
+func restore(cfg AlChatCtlConfiguration, out *printer) error {
+	if cfg.Args.Num(1) == "" {
+		return fmt.Errorf("usage: restore <backup file>")
+	}
+	if cfg.DB.Driver != database.DriverSQLite {
+		return fmt.Errorf("restore: %w", database.ErrNotSupported)
+	}
+	info, err := backup.Restore(cfg.Args.Num(1), cfg.DB.Filename)
+	if err != nil {
+		return err
+	}
+	return out.message(fmt.Sprintf("database restored from %s, the previous one is in %s.pre-restore",
+		info.File, cfg.DB.Filename))
+}
+
+// importChat imports the WhatsApp chat export in args.
+func importChat(db database.AppDatabase, cfg AlChatCtlConfiguration, out *printer) error {
+	if cfg.Args.Num(1) != "whatsapp" || cfg.Args.Num(2) == "" {
+		return fmt.Errorf("usage: import whatsapp <file> [<name>=<username>...]")
+	}
+
+	loc, err := time.LoadLocation(cfg.Import.Timezone)
+	if err != nil {
+		return fmt.Errorf("invalid time zone: %w", err)
+	}
+	participants := make(map[string]string)
+	for _, arg := range cfg.Args[3:] {
+		i := strings.LastIndex(arg, "=")
+		if i <= 0 || i == len(arg)-1 {
+			return fmt.Errorf("invalid participant mapping %q, expected <name>=<username>", arg)
+		}
+		participants[arg[:i]] = arg[i+1:]
+	}
+
+	fp, err := os.Open(cfg.Args.Num(2))
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	chat, err := whatsapp.Parse(fp, whatsapp.ParseOptions{
+		Location:  loc,
+		DateOrder: whatsapp.DateOrder(strings.ToUpper(cfg.Import.DateOrder)),
+	})
+	if err != nil {
+		return err
+	}
+	result, err := whatsapp.Import(db, chat, whatsapp.ImportOptions{Participants: participants, GroupName: cfg.Import.GroupName})
+	if err != nil {
+		return err
+	}
+	return out.importResult(result)
+}
+
+// migrate creates or upgrades the schema of the database.
+func migrate(dbconn *sql.DB, out *printer) error {
+	from, to, err := database.Migrate(dbconn)
+	if err != nil {
+		return err
+	}
+	if from == to {
+		return out.message(fmt.Sprintf("schema is up to date (version %d)", to))
+	}
+	return out.message(fmt.Sprintf("schema migrated from version %d to version %d", from, to))
+}
+
+// allUsers reads every page of users.
+func allUsers(db database.AppDatabase) ([]models.User, error) {
+	var users []models.User
+	var page = database.Page{Limit: database.MaxPageLimit}
+	for {
+		items, info, err := db.GetAllUsers(page)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, items...)
+		if info.NextKey == "" {
+			return users, nil
+		}
+		page.After = info.NextKey
+	}
+}
+
+// allConversations reads every page of conversations.
+func allConversations(db database.AppDatabase) ([]models.Conversation, error) {
+	var conversations []models.Conversation
+	var page = database.Page{Limit: database.MaxPageLimit}
+	for {
+		items, info, err := db.GetAllConversations(page)
+		if err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, items...)
+		if info.NextKey == "" {
+			return conversations, nil
+		}
+		page.After = info.NextKey
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ardanlabs/conf"
+	"gopkg.in/yaml.v2"
+)
+
+// AlChatCtlConfiguration describes the alchatctl configuration. The configuration file and the database settings are
+// the same as the webapi ones (see cmd/webapi/load-configuration.go), so both executables open the same database.
+type AlChatCtlConfiguration struct {
+	Config struct {
+		Path string `conf:"default:/conf/config.yml"`
+	}
+	DB struct {
+		Driver   string `conf:"default:sqlite3"`
+		Filename string `conf:"default:./alChat.db"`
+		DSN      string `conf:"noprint"`
+	}
+	Backup struct {
+		Dir    string `conf:"default:./backups"`
+		Retain int    `conf:"default:7"`
+	}
+	Import struct {
+		Timezone  string `conf:"default:UTC,help:time zone of the phone that exported the chat"`
+		DateOrder string `conf:"help:date order of the chat export: DMY, MDY or YMD (detected when empty)"`
+		GroupName string `conf:"help:name of the imported conversation (the group name in the chat when empty)"`
+	}
+	Output string `conf:"default:table,help:output format: table or json"`
+
+	// Args are the command and its arguments
+	Args conf.Args
+}
+
+// loadConfiguration creates an AlChatCtlConfiguration starting from flags, environment variables and configuration
+// file, with the same precedence rules as the webapi executable.
+func loadConfiguration() (AlChatCtlConfiguration, error) {
+	var cfg AlChatCtlConfiguration
+
+	// Try to load configuration from environment variables and command line switches
+	if err := conf.Parse(os.Args[1:], "CFG", &cfg); err != nil {
+		if errors.Is(err, conf.ErrHelpWanted) {
+			usage, err := conf.Usage("CFG", &cfg)
+			if err != nil {
+				return cfg, fmt.Errorf("generating config usage: %w", err)
+			}
+			fmt.Println(usage)         //nolint:forbidigo
+			fmt.Println(commandsUsage) //nolint:forbidigo
+			return cfg, conf.ErrHelpWanted
+		}
+		return cfg, fmt.Errorf("parsing config: %w", err)
+	}
+
+	// Override values from YAML if specified and if it exists (useful in k8s/compose)
+	fp, err := os.Open(cfg.Config.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return cfg, fmt.Errorf("can't read the config file, while it exists: %w", err)
+	} else if err == nil {
+		yamlFile, err := io.ReadAll(fp)
+		if err != nil {
+			return cfg, fmt.Errorf("can't read config file: %w", err)
+		}
+		err = yaml.Unmarshal(yamlFile, &cfg)
+		if err != nil {
+			return cfg, fmt.Errorf("can't unmarshal config file: %w", err)
+		}
+		_ = fp.Close()
+	}
+
+	if cfg.Output != "table" && cfg.Output != "json" {
+		return cfg, fmt.Errorf("unknown output format %q", cfg.Output)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,170 @@
+/*
+Alchatctl is the administration tool for the AlChats database. It opens the same database as the webapi executable
+(see `cfg.DB`) and runs maintenance commands on it, so that operators do not need to open the database by hand.
+Backups and restores are only available for SQLite databases.
+
+Usage:
+
+	alchatctl [flags] <command> [arguments]
+
+The commands are:
+
+	users list
+		List all users
+	users find <username>
+		Show the user with the given username
+	users delete <user id>
+		Delete a user, with the same cleanup as the account deletion API
+	conversations list
+		List all conversations
+	conversations members <conversation id>
+		List the members of a conversation
+	bots list
+		List the bot accounts
+	bots create <username>
+		Create a bot account, and print its first API key. The key is only printed once: only its hash is stored
+	bots key <bot id>
+		Create a new API key of a bot, e.g. to rotate its keys
+	bots revoke <bot id> <key id>
+		Revoke an API key of a bot
+	migrate
+		Create or upgrade the database schema
+	vacuum
+		Rebuild the database file, reclaiming unused space
+	check
+		Run the SQLite integrity and foreign key checks
+	stats
+		Print statistics about the database
+	backup
+		Back up the database in the backup directory, applying the retention policy
+	backups
+		List the backups, verifying their checksums
+	restore <backup file>
+		Replace the database with a backup, after verifying it; the webapi must be stopped
+	import whatsapp <file> [<name>=<username>...]
+		Import a WhatsApp chat export as a new conversation. Each <name>=<username> argument maps a name in the
+		chat to an existing user; a placeholder user is created for every other participant. The time zone and
+		the date order of the export are set with --import-timezone and --import-date-order.
+	vapid-keys
+		Generate a VAPID key pair for the Web Push notifications of the webapi (`cfg.Notify.VAPIDPrivateKey`). It
+		does not open the database.
+
+Flags and configurations are handled automatically by the code in `load-configuration.go`. The output is a table by
+default, or JSON with `--output json`.
+
+Return values (exit codes):
+
+	0
+		The command ended successfully
+
+	> 0
+		The command ended due to an error (including integrity check failures)
+*/
+package main
+
+import (
+	"AlChats/service/backup"
+	"AlChats/service/database"
+	"AlChats/service/notify"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ardanlabs/conf"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// commandsUsage is printed after the flags in the help message
+const commandsUsage = `COMMANDS
+  users list                               list all users
+  users find <username>                    show the user with the given username
+  users delete <user id>                   delete a user and clean up its data
+  conversations list                       list all conversations
+  conversations members <conversation id>  list the members of a conversation
+  bots list                                list the bot accounts
+  bots create <username>                   create a bot and print its API key
+  bots key <bot id>                        create a new API key of a bot
+  bots revoke <bot id> <key id>            revoke an API key of a bot
+  migrate                                  create or upgrade the database schema
+  vacuum                                   rebuild the database file
+  check                                    run the integrity and foreign key checks
+  stats                                    print statistics about the database
+  backup                                   back up the database
+  backups                                  list and verify the backups
+  restore <backup file>                    replace the database with a backup (stop the webapi first)
+  import whatsapp <file> [<name>=<user>]   import a WhatsApp chat export, mapping names to usernames
+  vapid-keys                               generate a VAPID key pair for the Web Push notifications`
+
+// main is the program entry point. The only purpose of this function is to call run() and set the exit code if there is
+// any error
+func main() {
+	if err := run(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "error: ", err)
+		os.Exit(1)
+	}
+}
+
+// run reads the configuration, opens the database and executes the requested command.
+func run() error {
+	cfg, err := loadConfiguration()
+	if err != nil {
+		if errors.Is(err, conf.ErrHelpWanted) {
+			return nil
+		}
+		return err
+	}
+	if len(cfg.Args) == 0 {
+		return fmt.Errorf("missing command\n\n%s", commandsUsage)
+	}
+
+	out := newPrinter(os.Stdout, cfg.Output)
+
+	if cfg.Args.Num(0) == "vapid-keys" {
+		privateKey, publicKey, err := notify.GenerateVAPIDKeys()
+		if err != nil {
+			return err
+		}
+		return out.vapidKeys(privateKey, publicKey)
+	}
+
+	source := cfg.DB.Filename
+	if cfg.DB.Driver == database.DriverPostgres {
+		source = cfg.DB.DSN
+	}
+	dbconn, err := database.Open(cfg.DB.Driver, source)
+	if err != nil {
+		return fmt.Errorf("opening the database: %w", err)
+	}
+	defer func() {
+		_ = dbconn.Close()
+	}()
+
+	switch cfg.Args.Num(0) {
+	case "restore":
+		// The database must not be opened while it is being replaced
+		_ = dbconn.Close()
+		return restore(cfg, out)
+	case "migrate":
+		// The migrate command works on the raw connection, as database.New already migrates the schema
+		return migrate(dbconn, out)
+	}
+
+	db, err := database.New(dbconn)
+	if err != nil {
+		return fmt.Errorf("creating AppDatabase: %w", err)
+	}
+
+	if cfg.Args.Num(0) == "backup" || cfg.Args.Num(0) == "backups" {
+		backups, err := backup.New(backup.Config{Database: db, Dir: cfg.Backup.Dir, Retain: cfg.Backup.Retain})
+		if err != nil {
+			return fmt.Errorf("creating the backup manager: %w", err)
+		}
+		return runBackupCommand(backups, cfg.Args, out)
+	}
+
+	if cfg.Args.Num(0) == "import" {
+		return importChat(db, cfg, out)
+	}
+
+	return runCommand(db, cfg.Args, out)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/backup"
+	"AlChats/service/database"
+	"AlChats/service/whatsapp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// printer writes the results of the commands as a table or as JSON
+type printer struct {
+	w      io.Writer
+	asJSON bool
+}
+
+func newPrinter(w io.Writer, format string) *printer {
+	return &printer{w: w, asJSON: format == "json"}
+}
+
+// json writes v as indented JSON.
+func (p *printer) json(v interface{}) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// table writes a header and rows aligned in columns.
+func (p *printer) table(header []interface{}, rows [][]interface{}) error {
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	for _, row := range append([][]interface{}{header}, rows...) {
+		for i, cell := range row {
+			if i > 0 {
+				_, _ = fmt.Fprint(tw, "\t")
+			}
+			_, _ = fmt.Fprint(tw, cell)
+		}
+		_, _ = fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func (p *printer) message(msg string) error {
+	if p.asJSON {
+		return p.json(map[string]string{"message": msg})
+	}
+	_, err := fmt.Fprintln(p.w, msg)
+	return err
+}
+
+func (p *printer) users(users []models.User) error {
+	if users == nil {
+		users = []models.User{}
+	}
+	if p.asJSON {
+		return p.json(users)
+	}
+	var rows [][]interface{}
+	for _, u := range users {
+		rows = append(rows, []interface{}{u.UserID, u.Username, u.Photo})
+	}
+	return p.table([]interface{}{"USER ID", "USERNAME", "PHOTO"}, rows)
+}
+
+func (p *printer) apiKey(key models.APIKey) error {
+	if p.asJSON {
+		return p.json(key)
+	}
+	return p.table([]interface{}{"API KEY", "VALUE"}, [][]interface{}{
+		{"bot id", key.UserID},
+		{"key id", key.KeyID},
+		{"key", key.Key},
+	})
+}
+
+func (p *printer) conversations(conversations []models.Conversation) error {
+	if conversations == nil {
+		conversations = []models.Conversation{}
+	}
+	if p.asJSON {
+		return p.json(conversations)
+	}
+	var rows [][]interface{}
+	for _, c := range conversations {
+		rows = append(rows, []interface{}{c.ConversationID, c.IsGroup, c.GroupName, c.GroupPhoto})
+	}
+	return p.table([]interface{}{"CONVERSATION ID", "GROUP", "NAME", "PHOTO"}, rows)
+}
+
+func (p *printer) problems(problems []string) error {
+	if p.asJSON {
+		return p.json(map[string]interface{}{"ok": len(problems) == 0, "problems": append([]string{}, problems...)})
+	}
+	if len(problems) == 0 {
+		_, err := fmt.Fprintln(p.w, "ok")
+		return err
+	}
+	for _, problem := range problems {
+		if _, err := fmt.Fprintln(p.w, problem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *printer) stats(stats database.Stats) error {
+	if p.asJSON {
+		return p.json(stats)
+	}
+	return p.table([]interface{}{"STATISTIC", "VALUE"}, [][]interface{}{
+		{"schema version", stats.SchemaVersion},
+		{"users", stats.Users},
+		{"conversations", stats.Conversations},
+		{"groups", stats.Groups},
+		{"memberships", stats.Memberships},
+		{"messages", stats.Messages},
+		{"size (bytes)", stats.SizeBytes},
+	})
+}
+
+func (p *printer) vapidKeys(privateKey, publicKey string) error {
+	if p.asJSON {
+		return p.json(map[string]string{"privateKey": privateKey, "publicKey": publicKey})
+	}
+	return p.table([]interface{}{"KEY", "VALUE"}, [][]interface{}{
+		{"private", privateKey},
+		{"public", publicKey},
+	})
+}
+
+func (p *printer) backups(backups []backup.Info) error {
+	if backups == nil {
+		backups = []backup.Info{}
+	}
+	if p.asJSON {
+		return p.json(backups)
+	}
+	var rows [][]interface{}
+	for _, b := range backups {
+		rows = append(rows, []interface{}{b.File, b.CreatedAt.Format(time.RFC3339), b.SizeBytes, b.SHA256})
+	}
+	return p.table([]interface{}{"FILE", "CREATED AT", "SIZE", "SHA256"}, rows)
+}
+
+func (p *printer) importResult(result whatsapp.Result) error {
+	if p.asJSON {
+		return p.json(result)
+	}
+	var rows = [][]interface{}{
+		{"conversation", result.Conversation.ConversationID},
+		{"group", result.Conversation.GroupName},
+		{"messages", result.Messages},
+		{"skipped system lines", result.Skipped},
+	}
+	for _, u := range result.Placeholders {
+		rows = append(rows, []interface{}{"placeholder user", u.Username + " (" + u.UserID + ")"})
+	}
+	return p.table([]interface{}{"IMPORT", "VALUE"}, rows)
+}
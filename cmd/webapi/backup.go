@@ -0,0 +1,30 @@
+package main
+
+import (
+	"AlChats/service/backup"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// scheduleBackups backs up the database every `interval`, until `stop` is closed. Failures are logged, and the next
+// backup is attempted at the next tick.
+func scheduleBackups(backups *backup.Manager, interval time.Duration, logger logrus.FieldLogger, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	logger.Infof("scheduled backups every %s", interval)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			info, err := backups.Run()
+			if err != nil {
+				logger.WithError(err).Error("scheduled backup failed")
+				continue
+			}
+			logger.WithField("file", info.File).Info("scheduled backup completed")
+		}
+	}
+}
@@ -26,7 +26,52 @@ type WebAPIConfiguration struct {
 	}
 	Debug bool
 	DB    struct {
+		// Driver selects the database: "sqlite3" (the file in Filename) or "postgres" (the connection string in DSN)
+		Driver   string `conf:"default:sqlite3"`
 		Filename string `conf:"default:./alChat.db"`
+		DSN      string `conf:"noprint"`
+	}
+	Admin struct {
+		// Token enables the administration endpoints, when not empty
+		Token string `conf:"noprint"`
+	}
+	Backup struct {
+		Dir string `conf:"default:./backups"`
+		// Interval between scheduled backups; zero disables the backups, including those requested to the admin API.
+		// Backups are only available with SQLite
+		Interval time.Duration
+		Retain   int `conf:"default:7"`
+	}
+	Messages struct {
+		// EditWindow is how long after sending a message its author can edit it
+		EditWindow time.Duration `conf:"default:15m"`
+		// DeleteWindow is how long after sending a message its author can delete it for everyone
+		DeleteWindow time.Duration `conf:"default:1h"`
+	}
+	Notify struct {
+		// Driver notifies the users who are not connected of the new messages: "webpush", "webhook" (posting them to
+		// WebhookURL), "log" (for development) or empty to disable the notifications
+		Driver     string
+		WebhookURL string
+		// VAPIDPrivateKey identifies the server to the push services (see `alchatctl vapid-keys`)
+		VAPIDPrivateKey string `conf:"noprint"`
+		VAPIDSubject    string
+		// TTL is how long the push services keep the notifications for the clients that are offline
+		TTL time.Duration `conf:"default:24h"`
+	}
+	Webhooks struct {
+		// Enabled lets the members subscribe webhooks to the events of their conversations. Deliveries are not claimed:
+		// when several instances share a database, enable the webhooks on one of them only, or events are sent twice
+		Enabled bool `conf:"default:true"`
+		// Timeout bounds each request to a webhook
+		Timeout time.Duration `conf:"default:10s"`
+		// Concurrency is how many webhooks are posted to at the same time
+		Concurrency int `conf:"default:4"`
+		// MaxAttempts is how many times an event is posted before it is dead-lettered
+		MaxAttempts int `conf:"default:8"`
+		// RetryDelay is the delay before the first retry, doubled at each following one up to MaxRetryDelay
+		RetryDelay    time.Duration `conf:"default:30s"`
+		MaxRetryDelay time.Duration `conf:"default:6h"`
 	}
 }
 
@@ -25,10 +25,11 @@ package main
 
 import (
 	"AlChats/service/api"
+	"AlChats/service/backup"
 	"AlChats/service/database"
 	"AlChats/service/globaltime"
+	"AlChats/service/webhooks"
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -83,10 +84,14 @@ func run() error {
 
 	// Start Database
 	logger.Println("initializing database support")
-	dbconn, err := sql.Open("sqlite3", cfg.DB.Filename)
+	source := cfg.DB.Filename
+	if cfg.DB.Driver == database.DriverPostgres {
+		source = cfg.DB.DSN
+	}
+	dbconn, err := database.Open(cfg.DB.Driver, source)
 	if err != nil {
-		logger.WithError(err).Error("error opening SQLite DB")
-		return fmt.Errorf("opening SQLite: %w", err)
+		logger.WithError(err).Error("error opening the database")
+		return fmt.Errorf("opening the database: %w", err)
 	}
 	defer func() {
 		logger.Debug("database stopping")
@@ -110,10 +115,62 @@ func run() error {
 	// buffered channel so the goroutine can exit if we don't collect this error.
 	serverErrors := make(chan error, 1)
 
+	// Start backups, only available with SQLite (PostgreSQL has its own tools, like pg_dump)
+	var backups *backup.Manager
+	if cfg.DB.Driver == database.DriverSQLite && cfg.Backup.Interval > 0 {
+		backups, err = backup.New(backup.Config{
+			Database: db,
+			Dir:      cfg.Backup.Dir,
+			Retain:   cfg.Backup.Retain,
+		})
+		if err != nil {
+			logger.WithError(err).Error("error creating the backup manager")
+			return fmt.Errorf("creating the backup manager: %w", err)
+		}
+		stopBackups := make(chan struct{})
+		defer close(stopBackups)
+		go scheduleBackups(backups, cfg.Backup.Interval, logger, stopBackups)
+	}
+
+	// Create the notifier of the offline users
+	notifier, err := newNotifier(cfg, logger)
+	if err != nil {
+		logger.WithError(err).Error("error creating the notifier")
+		return fmt.Errorf("creating the notifier: %w", err)
+	}
+
+	// Start the delivery of the webhook events
+	var dispatcher *webhooks.Dispatcher
+	if cfg.Webhooks.Enabled {
+		dispatcher, err = webhooks.New(webhooks.Config{
+			Database:      db,
+			Logger:        logger,
+			Timeout:       cfg.Webhooks.Timeout,
+			Concurrency:   cfg.Webhooks.Concurrency,
+			MaxAttempts:   cfg.Webhooks.MaxAttempts,
+			RetryDelay:    cfg.Webhooks.RetryDelay,
+			MaxRetryDelay: cfg.Webhooks.MaxRetryDelay,
+		})
+		if err != nil {
+			logger.WithError(err).Error("error creating the webhook dispatcher")
+			return fmt.Errorf("creating the webhook dispatcher: %w", err)
+		}
+		stopWebhooks := make(chan struct{})
+		defer close(stopWebhooks)
+		go dispatcher.Run(stopWebhooks)
+	}
+
 	// Create the API router
 	apirouter, err := api.New(api.Config{
-		Logger:   logger,
-		Database: db,
+		Logger:       logger,
+		Database:     db,
+		AdminToken:   cfg.Admin.Token,
+		Backups:      backups,
+		EditWindow:   cfg.Messages.EditWindow,
+		DeleteWindow: cfg.Messages.DeleteWindow,
+		Notifier:     notifier,
+		Webhooks:     dispatcher,
+		ValidateSpec: cfg.Debug,
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
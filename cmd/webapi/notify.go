@@ -0,0 +1,28 @@
+package main
+
+import (
+	"AlChats/service/notify"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newNotifier returns the notifier selected by the configuration, or nil if the notifications are disabled.
+func newNotifier(cfg WebAPIConfiguration, logger logrus.FieldLogger) (notify.Notifier, error) {
+	switch cfg.Notify.Driver {
+	case "":
+		return nil, nil
+	case "log":
+		return notify.NewLog(logger)
+	case "webhook":
+		return notify.NewWebhook(notify.WebhookConfig{URL: cfg.Notify.WebhookURL})
+	case "webpush":
+		return notify.NewWebPush(notify.WebPushConfig{
+			PrivateKey: cfg.Notify.VAPIDPrivateKey,
+			Subject:    cfg.Notify.VAPIDSubject,
+			TTL:        cfg.Notify.TTL,
+		})
+	default:
+		return nil, fmt.Errorf("unknown notification driver %q", cfg.Notify.Driver)
+	}
+}
@@ -0,0 +1,10 @@
+// Package doc embeds the API documentation, so that it can be served or used to validate the API at runtime and in
+// tests.
+package doc
+
+import _ "embed"
+
+// OpenAPI is the OpenAPI document of the web API (api.yaml)
+//
+//go:embed api.yaml
+var OpenAPI []byte
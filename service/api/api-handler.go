@@ -1,24 +1,104 @@
 package api
 
 import (
+	"AlChats/doc"
+	"AlChats/service/api/openapi"
 	"net/http"
+
+	"github.com/julienschmidt/httprouter"
 )
 
 // Handler returns an instance of httprouter.Router that handle APIs registered here
 func (rt *_router) Handler() http.Handler {
 	// Register routes
-	rt.router.GET("/", rt.getHelloWorld)
+	rt.handle(http.MethodGet, "/", rt.getHelloWorld)
 
 	// Special routes
-	rt.router.GET("/liveness", rt.liveness)
+	rt.handle(http.MethodGet, "/liveness", rt.liveness)
 
 	//USER ENDPOINT
-	rt.router.POST("/user/session", rt.createUserHandler)
-	rt.router.GET("/users", rt.getAllUsersHandler)
-	rt.router.POST("/user", rt.updateUsernameHandler)
+	rt.handle(http.MethodPost, "/user/session", rt.createUserHandler)
+	rt.handle(http.MethodGet, "/users", rt.getAllUsersHandler)
+	rt.handle(http.MethodGet, "/users/:id", rt.getUserHandler)
+	rt.handle(http.MethodGet, "/users/:id/presence", rt.getPresenceHandler)
+	rt.handle(http.MethodPost, "/user", rt.updateUsernameHandler)
+	rt.handle(http.MethodDelete, "/user", rt.deleteUserHandler)
+	rt.handle(http.MethodGet, "/user/export", rt.exportUserHandler)
+	rt.handle(http.MethodGet, "/user/privacy", rt.getPrivacyHandler)
+	rt.handle(http.MethodPut, "/user/privacy", rt.setPrivacyHandler)
+	rt.handle(http.MethodPost, "/user/push-subscriptions", rt.addPushSubscriptionHandler)
+	rt.handle(http.MethodGet, "/user/push-subscriptions", rt.getPushSubscriptionsHandler)
+	rt.handle(http.MethodDelete, "/user/push-subscriptions/:id", rt.deletePushSubscriptionHandler)
+	rt.handle(http.MethodGet, "/push/vapid-key", rt.vapidKeyHandler)
 
 	//CONVERSATION ENDPOINT
-	rt.router.POST("/conversation", rt.setConversationHandler)
+	rt.handle(http.MethodPost, "/conversation", rt.setConversationHandler)
+	rt.handle(http.MethodPost, "/conversation/import", rt.importWhatsAppHandler)
+	rt.handle(http.MethodGet, "/conversations", rt.getMyConversationsHandler)
+	rt.handle(http.MethodGet, "/conversations/:id", rt.getConversationHandler)
+	rt.handle(http.MethodPatch, "/conversations/:id", rt.updateGroupHandler)
+	rt.handle(http.MethodPost, "/conversations/:id/members", rt.addMembersHandler)
+	rt.handle(http.MethodDelete, "/conversations/:id/members/:uid", rt.removeMemberHandler)
+	rt.handle(http.MethodPut, "/conversations/:id/members/:uid/role", rt.setMemberRoleHandler)
+	rt.handle(http.MethodPatch, "/conversations/:id/settings", rt.updateSettingsHandler)
+	rt.handle(http.MethodPost, "/conversations/:id/invites", rt.createInviteHandler)
+	rt.handle(http.MethodGet, "/conversations/:id/invites", rt.getInvitesHandler)
+	rt.handle(http.MethodDelete, "/conversations/:id/invites/:token", rt.revokeInviteHandler)
+	rt.handle(http.MethodGet, "/conversations/:id/invites/:token/joins", rt.getInviteJoinsHandler)
+	rt.handle(http.MethodPost, "/invites/:token/join", rt.joinWithInviteHandler)
+	rt.handle(http.MethodPost, "/conversations/:id/webhooks", rt.createWebhookHandler)
+	rt.handle(http.MethodGet, "/conversations/:id/webhooks", rt.getWebhooksHandler)
+	rt.handle(http.MethodDelete, "/conversations/:id/webhooks/:wid", rt.deleteWebhookHandler)
+	rt.handle(http.MethodGet, "/conversations/:id/webhooks/:wid/deliveries", rt.getWebhookDeliveriesHandler)
+	rt.handle(http.MethodPost, "/conversations/:id/webhooks/:wid/deliveries/:did/retry", rt.retryWebhookDeliveryHandler)
+	rt.handle(http.MethodPost, "/conversations/:id/incoming-webhooks", rt.createIncomingWebhookHandler)
+	rt.handle(http.MethodGet, "/conversations/:id/incoming-webhooks", rt.getIncomingWebhooksHandler)
+	rt.handle(http.MethodDelete, "/conversations/:id/incoming-webhooks/:token", rt.deleteIncomingWebhookHandler)
+	rt.handle(http.MethodPost, "/incoming-webhooks/:token", rt.postIncomingMessageHandler)
+	rt.handle(http.MethodGet, "/conversations/:id/messages", rt.getMessagesHandler)
+	rt.handle(http.MethodPost, "/conversations/:id/messages", rt.sendMessageHandler)
+	rt.handle(http.MethodGet, "/conversations/:id/commands", rt.getCommandsHandler)
+	rt.handle(http.MethodPatch, "/conversations/:id/messages/:mid", rt.editMessageHandler)
+	rt.handle(http.MethodDelete, "/conversations/:id/messages/:mid", rt.deleteMessageHandler)
+	rt.handle(http.MethodGet, "/conversations/:id/messages/:mid/history", rt.getMessageHistoryHandler)
+	rt.handle(http.MethodPut, "/conversations/:id/messages/:mid/votes", rt.voteHandler)
+	rt.handle(http.MethodDelete, "/conversations/:id/messages/:mid/votes", rt.retractVoteHandler)
+	rt.handle(http.MethodPost, "/conversations/:id/messages/:mid/close", rt.closePollHandler)
+	rt.handle(http.MethodPost, "/conversations/:id/polls", rt.createPollHandler)
+
+	//REALTIME ENDPOINT
+	rt.handle(http.MethodGet, "/ws", rt.websocketHandler)
+
+	//ADMIN ENDPOINT
+	rt.handle(http.MethodPost, "/admin/backup", rt.createBackupHandler)
+	rt.handle(http.MethodPost, "/admin/bots", rt.createBotHandler)
+	rt.handle(http.MethodGet, "/admin/bots", rt.getBotsHandler)
+	rt.handle(http.MethodPost, "/admin/bots/:id/keys", rt.createAPIKeyHandler)
+	rt.handle(http.MethodGet, "/admin/bots/:id/keys", rt.getAPIKeysHandler)
+	rt.handle(http.MethodDelete, "/admin/bots/:id/keys/:kid", rt.deleteAPIKeyHandler)
+
+	if rt.validateSpec {
+		spec, err := openapi.Load(doc.OpenAPI)
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("cannot load the OpenAPI document, responses will not be validated")
+			return rt.router
+		}
+		return spec.Middleware(rt.router, func(v openapi.Violation) {
+			rt.baseLogger.WithError(v).Warning("the API does not match the OpenAPI document")
+		})
+	}
 
 	return rt.router
 }
+
+// route is an endpoint registered in the router
+type route struct {
+	method string
+	path   string
+}
+
+// handle registers the handler for the method and path, and keeps track of the route.
+func (rt *_router) handle(method, path string, handle httprouter.Handle) {
+	rt.router.Handle(method, path, handle)
+	rt.routes = append(rt.routes, route{method: method, path: path})
+}
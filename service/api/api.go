@@ -37,9 +37,16 @@ See the `main.go` file inside the `cmd/webapi` for a full usage example.
 package api
 
 import (
+	"AlChats/service/backup"
+	"AlChats/service/commands"
 	"AlChats/service/database"
+	"AlChats/service/notify"
+	"AlChats/service/realtime"
+	"AlChats/service/webhooks"
 	"errors"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
@@ -52,8 +59,38 @@ type Config struct {
 
 	// Database is the instance of database.AppDatabase where data are saved
 	Database database.AppDatabase
+
+	// AdminToken is the bearer token for the administration endpoints (`/admin/...`). If empty, they are disabled.
+	AdminToken string
+
+	// Backups creates the backups requested through the administration endpoints (optional)
+	Backups *backup.Manager
+
+	// EditWindow is how long after sending a message its author can edit it (default DefaultEditWindow)
+	EditWindow time.Duration
+
+	// DeleteWindow is how long after sending a message its author can delete it for everyone (default
+	// DefaultDeleteWindow)
+	DeleteWindow time.Duration
+
+	// Notifier notifies the members who are not connected of the new messages (optional)
+	Notifier notify.Notifier
+
+	// Webhooks delivers the events of the conversations to their webhooks (optional). Without it, the webhook
+	// endpoints answer 503 and no event is queued.
+	Webhooks *webhooks.Dispatcher
+
+	// ValidateSpec enables checking every request and response against the OpenAPI document (doc/api.yaml), logging
+	// the differences as warnings. It slows down every request, so it should be enabled only in debug mode.
+	ValidateSpec bool
 }
 
+// Defaults of the time limits of Config
+const (
+	DefaultEditWindow   = 15 * time.Minute
+	DefaultDeleteWindow = time.Hour
+)
+
 // Router is the package API interface representing an API handler builder
 type Router interface {
 	// Handler returns an HTTP handler for APIs provided in this package
@@ -79,11 +116,37 @@ func New(cfg Config) (Router, error) {
 	router.RedirectTrailingSlash = false
 	router.RedirectFixedPath = false
 
-	return &_router{
-		router:     router,
-		baseLogger: cfg.Logger,
-		db:         cfg.Database,
-	}, nil
+	if cfg.EditWindow == 0 {
+		cfg.EditWindow = DefaultEditWindow
+	}
+	if cfg.DeleteWindow == 0 {
+		cfg.DeleteWindow = DefaultDeleteWindow
+	}
+
+	hub, err := realtime.New(realtime.Config{Logger: cfg.Logger})
+	if err != nil {
+		return nil, err
+	}
+
+	rt := &_router{
+		router:       router,
+		baseLogger:   cfg.Logger,
+		db:           cfg.Database,
+		validateSpec: cfg.ValidateSpec,
+		adminToken:   cfg.AdminToken,
+		backups:      cfg.Backups,
+		editWindow:   cfg.EditWindow,
+		deleteWindow: cfg.DeleteWindow,
+		hub:          hub,
+		presence:     newPresenceTracker(),
+		notifier:     cfg.Notifier,
+		webhooks:     cfg.Webhooks,
+		commands:     commands.NewRegistry(),
+	}
+	if err := rt.registerCommands(); err != nil {
+		return nil, err
+	}
+	return rt, nil
 }
 
 type _router struct {
@@ -94,4 +157,39 @@ type _router struct {
 	baseLogger logrus.FieldLogger
 
 	db database.AppDatabase
+
+	// routes are the endpoints registered in the router
+	routes []route
+
+	// validateSpec enables the OpenAPI validation middleware
+	validateSpec bool
+
+	// adminToken is the bearer token for the administration endpoints
+	adminToken string
+
+	backups *backup.Manager
+
+	// editWindow is how long after sending a message its author can edit it
+	editWindow time.Duration
+
+	// deleteWindow is how long after sending a message its author can delete it for everyone
+	deleteWindow time.Duration
+
+	// hub keeps track of the WebSocket connections
+	hub *realtime.Hub
+
+	// presence keeps the online status and the typing signals of the users
+	presence *presenceTracker
+
+	// notifier notifies the offline members of the new messages, if set
+	notifier notify.Notifier
+
+	// webhooks delivers the queued webhook events, if set
+	webhooks *webhooks.Dispatcher
+
+	// commands are the slash commands, built-in and registered by the connected bots
+	commands *commands.Registry
+
+	// notifying tracks the notifications being sent, to wait for them on Close
+	notifying sync.WaitGroup
 }
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"AlChats/service/globaltime"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// authenticate returns the user identified by the bearer token in the Authorization header. The token is the session
+// token returned by `POST /user/session`, which stops working when the user is deleted. Bots authenticate with one of
+// their API keys instead (see models.APIKeyPrefix).
+// If the request is not authenticated, the error response is already written and ok is false.
+func (rt *_router) authenticate(w http.ResponseWriter, r *http.Request) (user models.User, ok bool) {
+	token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if token == "" {
+		http.Error(w, `{"error":"missing bearer token"}`, http.StatusUnauthorized)
+		return user, false
+	}
+
+	var err error
+	if strings.HasPrefix(token, models.APIKeyPrefix) {
+		user, err = rt.db.GetUserByAPIKey(token, globaltime.Now())
+	} else {
+		user, err = rt.db.GetUserBySession(token)
+	}
+	if errors.Is(err, database.ErrUserNotFound) || errors.Is(err, database.ErrAPIKeyNotFound) ||
+		errors.Is(err, database.ErrSessionNotFound) {
+		http.Error(w, `{"error":"invalid bearer token"}`, http.StatusUnauthorized)
+		return user, false
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return user, false
+	}
+
+	rt.presence.touch(user.UserID)
+	return user, true
+}
+
+// authenticateAdmin checks that the bearer token in the Authorization header is the admin token. If it is not, the
+// error response is already written and false is returned.
+func (rt *_router) authenticateAdmin(w http.ResponseWriter, r *http.Request) bool {
+	token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if rt.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(rt.adminToken)) != 1 {
+		http.Error(w, `{"error":"invalid admin token"}`, http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/globaltime"
+	"AlChats/service/websocket"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestIncomingWebhookMessage(t *testing.T) {
+	rt := newTestRouter(t)
+	srv := newValidatingServer(t, rt)
+
+	alice, _ := rt.db.SetUser("alice")
+	bot, err := rt.db.CreateBot("ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := rt.db.CreateAPIKey(bot.UserID, globaltime.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := rt.db.SetConversation([]string{alice.UserID, bot.UserID}, true, "builds", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	incoming, err := rt.db.CreateIncomingWebhook(group.ConversationID, bot.UserID, globaltime.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bots connect with their API keys too
+	aliceConn := dialWS(t, srv, newSession(t, rt.db, alice.UserID))
+	botConn := dialWS(t, srv, key.Key)
+
+	resp, err := http.Post(srv.URL+"/incoming-webhooks/"+incoming.Token, "application/json", strings.NewReader(`{"content":"build is green"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	for name, conn := range map[string]*websocket.Conn{"alice": aliceConn, "bot": botConn} {
+		f := readFrame(t, conn)
+		for f.Type == framePresence {
+			f = readFrame(t, conn)
+		}
+		var message models.Message
+		if err := json.Unmarshal(f.Data, &message); err != nil {
+			t.Fatal(err)
+		}
+		if f.Type != frameNewMessage || message.SenderID != bot.UserID || message.Content != "build is green" {
+			t.Errorf("%s: unexpected frame %+v", name, f)
+		}
+	}
+}
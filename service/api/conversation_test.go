@@ -0,0 +1,51 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGroupCreatedByCaller(t *testing.T) {
+	rt := newTestRouter(t)
+	alice, _ := rt.db.SetUser("alice")
+	bob, _ := rt.db.SetUser("bob")
+	carol, _ := rt.db.SetUser("carol")
+
+	// Carol creates the group without being listed first
+	body := `{"user_ids":["` + alice.UserID + `","` + bob.UserID + `","` + carol.UserID + `"],"is_group":true,"group_name":"friends"}`
+	req := httptest.NewRequest(http.MethodPost, "/conversation", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+newSession(t, rt.db, carol.UserID))
+	w := httptest.NewRecorder()
+	rt.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("creating the group: %d %s", w.Code, w.Body)
+	}
+	var group models.Conversation
+	if err := json.NewDecoder(w.Body).Decode(&group); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := rt.db.GetConversationMemberRoles(group.ConversationID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range members {
+		if (member.Role == models.RoleOwner) != (member.UserID == carol.UserID) {
+			t.Errorf("expected carol to be the only owner, got %+v", members)
+		}
+	}
+
+	messages, _, err := rt.db.GetConversationMessages(group.ConversationID, database.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].System == nil || messages[0].System.Action != models.SystemGroupCreated ||
+		messages[0].System.ActorID != carol.UserID {
+		t.Errorf("expected carol to have created the group, got %+v", messages)
+	}
+}
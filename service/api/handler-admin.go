@@ -0,0 +1,32 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// createBackupHandler creates a backup of the database on demand.
+func (rt *_router) createBackupHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !rt.authenticateAdmin(w, r) {
+		return
+	}
+	if rt.backups == nil {
+		http.Error(w, `{"error":"backups are not configured"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	info, err := rt.backups.Run()
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
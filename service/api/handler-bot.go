@@ -0,0 +1,302 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"AlChats/service/globaltime"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// CreateBotRequest is the body of `POST /admin/bots`
+type CreateBotRequest struct {
+	Username string `json:"username"`
+}
+
+// CreateBotResponse is the response of `POST /admin/bots`: the bot, with its first API key
+type CreateBotResponse struct {
+	Bot    models.User   `json:"bot"`
+	APIKey models.APIKey `json:"apiKey"`
+}
+
+// IncomingMessageRequest is the body of `POST /incoming-webhooks/:token`
+type IncomingMessageRequest struct {
+	Content string `json:"content"`
+}
+
+// createBotHandler creates a bot account with its first API key. Only the administrators can do it.
+func (rt *_router) createBotHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !rt.authenticateAdmin(w, r) {
+		return
+	}
+
+	var req CreateBotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Username) == "" {
+		http.Error(w, `{"error":"username is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	bot, err := rt.db.CreateBot(req.Username)
+	if err != nil {
+		if strings.Contains(err.Error(), "already exists") {
+			http.Error(w, `{"error":"username already exists"}`, http.StatusConflict)
+		} else {
+			http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		}
+		return
+	}
+	key, err := rt.db.CreateAPIKey(bot.UserID, globaltime.Now())
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(CreateBotResponse{Bot: bot, APIKey: key}); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// getBotsHandler returns all the bot accounts. Only the administrators can do it.
+func (rt *_router) getBotsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !rt.authenticateAdmin(w, r) {
+		return
+	}
+
+	bots, err := rt.db.GetBots()
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if bots == nil {
+		bots = []models.User{}
+	}
+
+	if err := json.NewEncoder(w).Encode(newListResponse(bots, database.PageInfo{})); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// createAPIKeyHandler creates a new API key of a bot, e.g. to rotate the keys. The response is the only one including
+// the key itself. Only the administrators can do it.
+func (rt *_router) createAPIKeyHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !rt.authenticateAdmin(w, r) {
+		return
+	}
+
+	key, err := rt.db.CreateAPIKey(ps.ByName("id"), globaltime.Now())
+	if errors.Is(err, database.ErrUserNotFound) {
+		http.Error(w, `{"error":"bot not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(key); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// getAPIKeysHandler returns the API keys of a bot, oldest first, without the keys themselves. Only the administrators
+// can do it.
+func (rt *_router) getAPIKeysHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !rt.authenticateAdmin(w, r) {
+		return
+	}
+
+	bot, err := rt.db.GetUserByID(ps.ByName("id"))
+	if errors.Is(err, database.ErrUserNotFound) || (err == nil && !bot.IsBot) {
+		http.Error(w, `{"error":"bot not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	keys, err := rt.db.GetAPIKeys(bot.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if keys == nil {
+		keys = []models.APIKey{}
+	}
+
+	if err := json.NewEncoder(w).Encode(newListResponse(keys, database.PageInfo{})); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// deleteAPIKeyHandler revokes an API key of a bot. Only the administrators can do it.
+func (rt *_router) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !rt.authenticateAdmin(w, r) {
+		return
+	}
+
+	err := rt.db.DeleteAPIKey(ps.ByName("id"), ps.ByName("kid"))
+	if errors.Is(err, database.ErrAPIKeyNotFound) {
+		http.Error(w, `{"error":"API key not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// botConversation returns the conversation whose incoming webhooks the user manages: only bots have them, in the
+// conversations they are members of. Otherwise, the error response is already written and ok is false.
+func (rt *_router) botConversation(w http.ResponseWriter, user models.User, conversationID string) (models.Conversation, bool) {
+	if !user.IsBot {
+		http.Error(w, `{"error":"only bots have incoming webhooks"}`, http.StatusForbidden)
+		return models.Conversation{}, false
+	}
+	conversation, _, ok := rt.memberConversation(w, user, conversationID)
+	return conversation, ok
+}
+
+// createIncomingWebhookHandler creates an incoming webhook posting as the authenticated bot into a conversation.
+func (rt *_router) createIncomingWebhookHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+	conversation, ok := rt.botConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	webhook, err := rt.db.CreateIncomingWebhook(conversation.ConversationID, user.UserID, globaltime.Now())
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(webhook); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// getIncomingWebhooksHandler returns the incoming webhooks of the authenticated bot in a conversation, oldest first.
+func (rt *_router) getIncomingWebhooksHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+	conversation, ok := rt.botConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	webhooks, err := rt.db.GetIncomingWebhooks(conversation.ConversationID, user.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if webhooks == nil {
+		webhooks = []models.IncomingWebhook{}
+	}
+
+	if err := json.NewEncoder(w).Encode(newListResponse(webhooks, database.PageInfo{})); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// deleteIncomingWebhookHandler deletes an incoming webhook of the authenticated bot.
+func (rt *_router) deleteIncomingWebhookHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+	conversation, ok := rt.botConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	err := rt.db.DeleteIncomingWebhook(conversation.ConversationID, user.UserID, ps.ByName("token"))
+	if errors.Is(err, database.ErrIncomingWebhookNotFound) {
+		http.Error(w, `{"error":"incoming webhook not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// postIncomingMessageHandler posts a message as the bot of an incoming webhook. The token of the URL is the only
+// credential, and the bot must still be a member of the conversation.
+func (rt *_router) postIncomingMessageHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req IncomingMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		http.Error(w, `{"error":"content is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := rt.db.GetIncomingWebhook(ps.ByName("token"))
+	if errors.Is(err, database.ErrIncomingWebhookNotFound) {
+		http.Error(w, `{"error":"incoming webhook not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	members, err := rt.db.GetConversationMembers(webhook.ConversationID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if !isMember(members, webhook.UserID) {
+		http.Error(w, `{"error":"the bot is not a member of the conversation anymore"}`, http.StatusForbidden)
+		return
+	}
+
+	saved, err := rt.db.AddMessages(webhook.ConversationID, []models.Message{{
+		SenderID:  webhook.UserID,
+		Content:   req.Content,
+		CreatedAt: globaltime.Now(),
+	}})
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	rt.deliverMessage(members, saved[0], nil)
+
+	if err := json.NewEncoder(w).Encode(saved[0]); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
@@ -1,9 +1,13 @@
 package api
 
 import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
@@ -16,10 +20,16 @@ type ConversationRequest struct {
 	GroupPhoto string   `json:"group_photo,omitempty"`
 }
 
-// SetConversationHandler handles the creation of new conversations.
+// SetConversationHandler handles the creation of new conversations. The authenticated user must be one of the
+// members, and is the owner of the group.
 func (h *_router) setConversationHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	w.Header().Set("Content-Type", "application/json")
 
+	user, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
 	// Parse the JSON request body
 	var req ConversationRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -33,8 +43,20 @@ func (h *_router) setConversationHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	// Put the caller first, since the first user is the owner of the group
+	userIDs := []string{user.UserID}
+	for _, id := range req.UserIDs {
+		if id != user.UserID {
+			userIDs = append(userIDs, id)
+		}
+	}
+	if len(userIDs) == len(req.UserIDs)+1 {
+		http.Error(w, `{"error":"user_ids must include yourself"}`, http.StatusForbidden)
+		return
+	}
+
 	// Call the SetConversation function
-	conversation, err := h.db.SetConversation(req.UserIDs, req.IsGroup, req.GroupName, req.GroupPhoto)
+	conversation, err := h.db.SetConversation(userIDs, req.IsGroup, req.GroupName, req.GroupPhoto)
 	if err != nil {
 		if strings.Contains(err.Error(), "cannot create a conversation with only one user") ||
 			strings.Contains(err.Error(), "cannot create a group conversation") {
@@ -47,8 +69,117 @@ func (h *_router) setConversationHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	// The group is created by the authenticated user
+	if conversation.IsGroup {
+		h.addSystemMessage(conversation.ConversationID, models.SystemEvent{Action: models.SystemGroupCreated, ActorID: user.UserID})
+	}
+
 	// Respond with the created conversation
 	if err := json.NewEncoder(w).Encode(conversation); err != nil {
 		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
 	}
 }
+
+// getMyConversationsHandler returns a page of the conversations of the authenticated user, with their settings: the
+// pinned ones first, and the archived ones only if `include_archived` is true.
+func (h *_router) getMyConversationsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	// Read the requested page
+	page, err := parsePage(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusBadRequest)
+		return
+	}
+
+	includeArchived := false
+	if value := r.URL.Query().Get("include_archived"); value != "" {
+		if includeArchived, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, `{"error":"include_archived must be true or false"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	conversations, info, err := h.db.GetConversationsForMember(user.UserID, includeArchived, page)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if conversations == nil {
+		conversations = []models.Conversation{}
+	}
+
+	if err := json.NewEncoder(w).Encode(newListResponse(conversations, info)); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// getConversationHandler returns a conversation together with its members and their roles. Only members can read it.
+func (h *_router) getConversationHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	conversation, _, ok := h.memberConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+	members, err := h.db.GetConversationMemberRoles(conversation.ConversationID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	details := models.ConversationDetails{
+		Conversation: conversation,
+		Members:      members,
+	}
+	if err := json.NewEncoder(w).Encode(details); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// memberConversation returns the conversation with its members, checking that the user is one of them. If the
+// conversation does not exist or the user is not a member, the error response is already written and ok is false.
+func (h *_router) memberConversation(w http.ResponseWriter, user models.User, conversationID string) (conversation models.Conversation, members []models.User, ok bool) {
+	conversation, err := h.db.GetConversationByID(conversationID)
+	if errors.Is(err, database.ErrConversationNotFound) {
+		http.Error(w, `{"error":"conversation not found"}`, http.StatusNotFound)
+		return conversation, nil, false
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return conversation, nil, false
+	}
+
+	members, err = h.db.GetConversationMembers(conversation.ConversationID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return conversation, nil, false
+	}
+
+	// Only members of the conversation can see it
+	if !isMember(members, user.UserID) {
+		http.Error(w, `{"error":"not a member of the conversation"}`, http.StatusForbidden)
+		return conversation, nil, false
+	}
+
+	return conversation, members, true
+}
+
+// isMember reports whether userID is in the members list.
+func isMember(members []models.User, userID string) bool {
+	for _, member := range members {
+		if member.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
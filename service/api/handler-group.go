@@ -0,0 +1,312 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// groupAction is an operation on a group that not every member can do
+type groupAction int
+
+const (
+	actionEditGroup         groupAction = iota // Change the name or the photo
+	actionAddMembers                           // Add users to the group
+	actionRemoveMember                         // Remove another member
+	actionPromote                              // Make a member an admin
+	actionDemote                               // Make an admin a member
+	actionTransferOwnership                    // Make another member the owner
+	actionManageInvites                        // Create, list and revoke the invites
+	actionManageWebhooks                       // Create, list and delete the webhooks, and read their deliveries
+	actionRunAdminCommands                     // Run the slash commands that bots reserve to the admins
+)
+
+// can reports whether the member `actor` can do the action (on the member `target`, for the actions on members). This
+// is the only place deciding what each role can do:
+//   - admins and the owner edit the group, add members, manage the invites and the webhooks, run the admin commands of
+//     the bots, and promote members to admins
+//   - admins remove members; the owner removes anyone else
+//   - only the owner demotes admins and transfers the ownership
+//
+// Anyone can leave a group, which is not an action subject to permissions.
+func can(actor models.Member, action groupAction, target models.Member) bool {
+	isAdmin := actor.Role == models.RoleAdmin || actor.Role == models.RoleOwner
+	switch action {
+	case actionEditGroup, actionAddMembers, actionManageInvites, actionManageWebhooks, actionRunAdminCommands:
+		return isAdmin
+	case actionPromote:
+		return isAdmin && target.Role == models.RoleMember
+	case actionRemoveMember:
+		if actor.UserID == target.UserID {
+			return false
+		}
+		if actor.Role == models.RoleOwner {
+			return true
+		}
+		return isAdmin && target.Role == models.RoleMember
+	case actionDemote:
+		return actor.Role == models.RoleOwner && target.Role == models.RoleAdmin
+	case actionTransferOwnership:
+		return actor.Role == models.RoleOwner && target.UserID != actor.UserID
+	}
+	return false
+}
+
+// groupMembers returns the group with its members, and the user as a member. If the conversation does not exist, is
+// not a group or the user is not a member, the error response is already written and ok is false.
+func (rt *_router) groupMembers(w http.ResponseWriter, user models.User, conversationID string) (conversation models.Conversation, members []models.Member, self models.Member, ok bool) {
+	conversation, _, ok = rt.memberConversation(w, user, conversationID)
+	if !ok {
+		return conversation, nil, self, false
+	}
+	if !conversation.IsGroup {
+		http.Error(w, `{"error":"not a group"}`, http.StatusBadRequest)
+		return conversation, nil, self, false
+	}
+
+	members, err := rt.db.GetConversationMemberRoles(conversation.ConversationID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return conversation, nil, self, false
+	}
+	self, _ = findMember(members, user.UserID)
+	return conversation, members, self, true
+}
+
+// findMember returns the member with the user ID.
+func findMember(members []models.Member, userID string) (models.Member, bool) {
+	for _, member := range members {
+		if member.UserID == userID {
+			return member, true
+		}
+	}
+	return models.Member{}, false
+}
+
+// writeGroupDetails writes the group with its current members as the response.
+func (rt *_router) writeGroupDetails(w http.ResponseWriter, conversation models.Conversation) {
+	members, err := rt.db.GetConversationMemberRoles(conversation.ConversationID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	details := models.ConversationDetails{Conversation: conversation, Members: members}
+	if err := json.NewEncoder(w).Encode(details); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// UpdateGroupRequest is the body of `PATCH /conversations/:id`: the fields left out are not changed
+type UpdateGroupRequest struct {
+	GroupName  *string `json:"group_name,omitempty"`
+	GroupPhoto *string `json:"group_photo,omitempty"`
+}
+
+// updateGroupHandler changes the name or the photo of a group.
+func (rt *_router) updateGroupHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if req.GroupName != nil && strings.TrimSpace(*req.GroupName) == "" {
+		http.Error(w, `{"error":"group_name cannot be empty"}`, http.StatusBadRequest)
+		return
+	}
+
+	conversation, _, self, ok := rt.groupMembers(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+	if !can(self, actionEditGroup, models.Member{}) {
+		http.Error(w, `{"error":"only admins can edit the group"}`, http.StatusForbidden)
+		return
+	}
+
+	name, photo := conversation.GroupName, conversation.GroupPhoto
+	if req.GroupName != nil {
+		name = *req.GroupName
+	}
+	if req.GroupPhoto != nil {
+		photo = *req.GroupPhoto
+	}
+	updated, err := rt.db.UpdateConversation(conversation.ConversationID, name, photo)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if updated.GroupName != conversation.GroupName {
+		rt.addSystemMessage(updated.ConversationID,
+			models.SystemEvent{Action: models.SystemGroupRenamed, ActorID: user.UserID, Target: updated.GroupName})
+	}
+	if updated.GroupPhoto != conversation.GroupPhoto {
+		rt.addSystemMessage(updated.ConversationID,
+			models.SystemEvent{Action: models.SystemPhotoChanged, ActorID: user.UserID, Target: updated.GroupPhoto})
+	}
+
+	rt.writeGroupDetails(w, updated)
+}
+
+// AddMembersRequest is the body of `POST /conversations/:id/members`
+type AddMembersRequest struct {
+	UserIDs []string `json:"user_ids"`
+}
+
+// addMembersHandler adds users to a group.
+func (rt *_router) addMembersHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var req AddMembersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if len(req.UserIDs) == 0 {
+		http.Error(w, `{"error":"user_ids is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	conversation, _, self, ok := rt.groupMembers(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+	if !can(self, actionAddMembers, models.Member{}) {
+		http.Error(w, `{"error":"only admins can add members"}`, http.StatusForbidden)
+		return
+	}
+
+	err := rt.db.AddConversationMembers(conversation.ConversationID, req.UserIDs)
+	if errors.Is(err, database.ErrUserNotFound) {
+		http.Error(w, `{"error":"user not found"}`, http.StatusNotFound)
+		return
+	} else if errors.Is(err, database.ErrAlreadyMember) {
+		http.Error(w, `{"error":"the user is already a member"}`, http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	for _, userID := range req.UserIDs {
+		rt.addSystemMessage(conversation.ConversationID,
+			models.SystemEvent{Action: models.SystemMemberAdded, ActorID: user.UserID, Target: userID})
+	}
+
+	rt.writeGroupDetails(w, conversation)
+}
+
+// removeMemberHandler removes a member from a group. Members remove themselves to leave the group: if the owner leaves,
+// the ownership passes to an admin (or to a member, if there are no admins).
+func (rt *_router) removeMemberHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	conversation, members, self, ok := rt.groupMembers(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+	target, ok := findMember(members, ps.ByName("uid"))
+	if !ok {
+		http.Error(w, `{"error":"the user is not a member"}`, http.StatusNotFound)
+		return
+	}
+	if target.UserID != self.UserID && !can(self, actionRemoveMember, target) {
+		http.Error(w, `{"error":"not allowed to remove this member"}`, http.StatusForbidden)
+		return
+	}
+
+	if err := rt.db.RemoveConversationMember(conversation.ConversationID, target.UserID); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	// The group is deleted when its last member leaves
+	if len(members) > 1 {
+		event := models.SystemEvent{Action: models.SystemMemberRemoved, ActorID: user.UserID, Target: target.UserID}
+		if target.UserID == user.UserID {
+			event = models.SystemEvent{Action: models.SystemMemberLeft, ActorID: user.UserID}
+		}
+		rt.addSystemMessage(conversation.ConversationID, event)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetRoleRequest is the body of `PUT /conversations/:id/members/:uid/role`
+type SetRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// setMemberRoleHandler promotes a member to admin, demotes an admin to member, or makes a member the owner (the
+// current owner becomes an admin).
+func (rt *_router) setMemberRoleHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var req SetRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	var action groupAction
+	switch req.Role {
+	case models.RoleOwner:
+		action = actionTransferOwnership
+	case models.RoleAdmin:
+		action = actionPromote
+	case models.RoleMember:
+		action = actionDemote
+	default:
+		http.Error(w, `{"error":"role must be owner, admin or member"}`, http.StatusBadRequest)
+		return
+	}
+
+	conversation, members, self, ok := rt.groupMembers(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+	target, ok := findMember(members, ps.ByName("uid"))
+	if !ok {
+		http.Error(w, `{"error":"the user is not a member"}`, http.StatusNotFound)
+		return
+	}
+
+	if target.Role != req.Role {
+		if !can(self, action, target) {
+			http.Error(w, `{"error":"not allowed to change the role of this member"}`, http.StatusForbidden)
+			return
+		}
+		if err := rt.db.SetMemberRole(conversation.ConversationID, target.UserID, req.Role); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	rt.writeGroupDetails(w, conversation)
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"AlChats/service/whatsapp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// maxImportSize is the maximum size of an import request
+const maxImportSize = 32 << 20
+
+// WhatsAppImportRequest is the body of `POST /conversation/import`
+type WhatsAppImportRequest struct {
+	// Chat is the content of the `.txt` file exported by WhatsApp
+	Chat string `json:"chat"`
+
+	// Self is the name of the authenticated user in the chat, if they wrote in it
+	Self string `json:"self,omitempty"`
+
+	// Participants maps names in the chat to AlChats usernames. Users can only map names to themselves, like Self:
+	// nobody else is added to a conversation without their consent. Placeholders are created for the other names.
+	Participants map[string]string `json:"participants,omitempty"`
+
+	GroupName string `json:"group_name,omitempty"`
+
+	// Timezone is the IANA time zone of the phone that exported the chat (default UTC)
+	Timezone string `json:"timezone,omitempty"`
+
+	// DateOrder is DMY, MDY or YMD; it is detected from the dates when empty
+	DateOrder string `json:"date_order,omitempty"`
+}
+
+// importWhatsAppHandler imports a WhatsApp chat export as a new conversation of the authenticated user.
+func (rt *_router) importWhatsAppHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var req WhatsAppImportRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportSize)).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	var opts = whatsapp.ParseOptions{DateOrder: whatsapp.DateOrder(strings.ToUpper(req.DateOrder))}
+	switch opts.DateOrder {
+	case "", whatsapp.DayMonthYear, whatsapp.MonthDayYear, whatsapp.YearMonthDay:
+	default:
+		http.Error(w, `{"error":"date_order must be DMY, MDY or YMD"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Timezone != "" {
+		loc, err := time.LoadLocation(req.Timezone)
+		if err != nil {
+			http.Error(w, `{"error":"unknown timezone"}`, http.StatusBadRequest)
+			return
+		}
+		opts.Location = loc
+	}
+
+	chat, err := whatsapp.Parse(strings.NewReader(req.Chat), opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusBadRequest)
+		return
+	}
+
+	// The authenticated user is always a member, and writes the messages of `self`
+	var participants = make(map[string]string)
+	for name, username := range req.Participants {
+		if username != user.Username {
+			http.Error(w, `{"error":"participants can only be mapped to yourself"}`, http.StatusForbidden)
+			return
+		}
+		participants[name] = username
+	}
+	if req.Self != "" {
+		participants[req.Self] = user.Username
+	}
+
+	result, err := whatsapp.Import(rt.db, chat, whatsapp.ImportOptions{
+		Participants: participants,
+		Members:      []string{user.UserID},
+		GroupName:    req.GroupName,
+	})
+	if errors.Is(err, whatsapp.ErrUnknownParticipant) || errors.Is(err, whatsapp.ErrNoMessages) {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	rt.baseLogger.WithField("user", user.UserID).WithField("conversation", result.Conversation.ConversationID).
+		Infof("imported %d messages from WhatsApp", result.Messages)
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
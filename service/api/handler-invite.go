@@ -0,0 +1,209 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"AlChats/service/globaltime"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// CreateInviteRequest is the body of `POST /conversations/:id/invites`: by default, the invite never expires and has
+// no limit of uses
+type CreateInviteRequest struct {
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxUses   int        `json:"max_uses,omitempty"`
+}
+
+// createInviteHandler creates an invite to a group. Only admins can do it.
+func (rt *_router) createInviteHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	now := globaltime.Now()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		http.Error(w, `{"error":"expires_at must be in the future"}`, http.StatusBadRequest)
+		return
+	}
+	if req.MaxUses < 0 {
+		http.Error(w, `{"error":"max_uses cannot be negative"}`, http.StatusBadRequest)
+		return
+	}
+
+	conversation, _, self, ok := rt.groupMembers(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+	if !can(self, actionManageInvites, models.Member{}) {
+		http.Error(w, `{"error":"only admins can manage the invites"}`, http.StatusForbidden)
+		return
+	}
+
+	invite, err := rt.db.CreateInvite(models.Invite{
+		ConversationID: conversation.ConversationID,
+		CreatedBy:      user.UserID,
+		CreatedAt:      now,
+		ExpiresAt:      req.ExpiresAt,
+		MaxUses:        req.MaxUses,
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(invite); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// getInvitesHandler returns the invites of a group, oldest first, including the ones that cannot be used anymore.
+// Only admins can read them, as anyone with a token can join.
+func (rt *_router) getInvitesHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	conversation, _, self, ok := rt.groupMembers(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+	if !can(self, actionManageInvites, models.Member{}) {
+		http.Error(w, `{"error":"only admins can manage the invites"}`, http.StatusForbidden)
+		return
+	}
+
+	invites, err := rt.db.GetConversationInvites(conversation.ConversationID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if invites == nil {
+		invites = []models.Invite{}
+	}
+
+	if err := json.NewEncoder(w).Encode(newListResponse(invites, database.PageInfo{})); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// revokeInviteHandler stops an invite from working. The invite is kept, with the users who joined with it.
+func (rt *_router) revokeInviteHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	conversation, _, self, ok := rt.groupMembers(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+	if !can(self, actionManageInvites, models.Member{}) {
+		http.Error(w, `{"error":"only admins can manage the invites"}`, http.StatusForbidden)
+		return
+	}
+
+	_, err := rt.db.RevokeInvite(conversation.ConversationID, ps.ByName("token"), globaltime.Now())
+	if errors.Is(err, database.ErrInviteNotFound) {
+		http.Error(w, `{"error":"invite not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getInviteJoinsHandler returns who joined a group with an invite, earliest first. Only admins can read it.
+func (rt *_router) getInviteJoinsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	conversation, _, self, ok := rt.groupMembers(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+	if !can(self, actionManageInvites, models.Member{}) {
+		http.Error(w, `{"error":"only admins can manage the invites"}`, http.StatusForbidden)
+		return
+	}
+
+	joins, err := rt.db.GetInviteJoins(conversation.ConversationID, ps.ByName("token"))
+	if errors.Is(err, database.ErrInviteNotFound) {
+		http.Error(w, `{"error":"invite not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if joins == nil {
+		joins = []models.InviteJoin{}
+	}
+
+	if err := json.NewEncoder(w).Encode(newListResponse(joins, database.PageInfo{})); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// joinWithInviteHandler adds the authenticated user to the group of an invite, as a member.
+func (rt *_router) joinWithInviteHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	invite, err := rt.db.JoinWithInvite(ps.ByName("token"), user.UserID, globaltime.Now())
+	switch {
+	case errors.Is(err, database.ErrInviteNotFound):
+		http.Error(w, `{"error":"invite not found"}`, http.StatusNotFound)
+		return
+	case errors.Is(err, database.ErrInviteRevoked):
+		http.Error(w, `{"error":"the invite was revoked"}`, http.StatusGone)
+		return
+	case errors.Is(err, database.ErrInviteExpired):
+		http.Error(w, `{"error":"the invite expired"}`, http.StatusGone)
+		return
+	case errors.Is(err, database.ErrInviteUsedUp):
+		http.Error(w, `{"error":"the invite reached its maximum number of uses"}`, http.StatusGone)
+		return
+	case errors.Is(err, database.ErrAlreadyMember):
+		http.Error(w, `{"error":"already a member of the group"}`, http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	conversation, err := rt.db.GetConversationByID(invite.ConversationID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	rt.addSystemMessage(conversation.ConversationID, models.SystemEvent{Action: models.SystemMemberJoined, ActorID: user.UserID})
+	rt.writeGroupDetails(w, conversation)
+}
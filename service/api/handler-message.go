@@ -0,0 +1,281 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"AlChats/service/globaltime"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// getMessagesHandler returns a page of the messages of a conversation, oldest first, without the ones the user deleted
+// for themselves. Only members can read them.
+func (rt *_router) getMessagesHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	// Read the requested page
+	page, err := parsePage(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusBadRequest)
+		return
+	}
+
+	conversation, _, ok := rt.memberConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	messages, info, err := rt.db.GetConversationMessagesForUser(conversation.ConversationID, user.UserID, page)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if messages == nil {
+		messages = []models.Message{}
+	}
+
+	if err := json.NewEncoder(w).Encode(newListResponse(messages, info)); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// SendMessageRequest is the body of `POST /conversations/:id/messages`
+type SendMessageRequest struct {
+	Content string `json:"content"`
+}
+
+// sendMessageHandler sends a message of the user to a conversation, for the clients without a WebSocket connection.
+// It is delivered like the `message.send` frames; slash commands are only run over the WebSocket, so the content is
+// sent as it is.
+func (rt *_router) sendMessageHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var req SendMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		http.Error(w, `{"error":"content is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	conversation, members, ok := rt.memberConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	saved, err := rt.db.AddMessages(conversation.ConversationID, []models.Message{{
+		SenderID:  user.UserID,
+		Content:   req.Content,
+		CreatedAt: globaltime.Now(),
+	}})
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	// The new message replaces the typing signal of the sender
+	rt.presence.setTyping(conversation.ConversationID, user.UserID, false)
+	rt.deliverMessage(members, saved[0], nil)
+
+	if err := json.NewEncoder(w).Encode(saved[0]); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// EditMessageRequest is the body of `PATCH /conversations/:id/messages/:mid`
+type EditMessageRequest struct {
+	Content string `json:"content"`
+}
+
+// editMessageHandler replaces the content of a message. Only its author can edit it, within the edit window; the
+// previous content is kept in the history of the message, and the other members are notified.
+func (rt *_router) editMessageHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var req EditMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		http.Error(w, `{"error":"content is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	conversation, members, ok := rt.memberConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+	message, ok := rt.conversationMessage(w, conversation.ConversationID, ps.ByName("mid"))
+	if !ok {
+		return
+	}
+
+	if message.SenderID != user.UserID {
+		http.Error(w, `{"error":"only the author can edit the message"}`, http.StatusForbidden)
+		return
+	}
+	if message.DeletedAt != nil {
+		http.Error(w, `{"error":"the message was deleted"}`, http.StatusForbidden)
+		return
+	}
+	if message.Poll != nil {
+		http.Error(w, `{"error":"polls cannot be edited"}`, http.StatusForbidden)
+		return
+	}
+	if globaltime.Since(message.CreatedAt) > rt.editWindow {
+		http.Error(w, `{"error":"the message is too old to be edited"}`, http.StatusForbidden)
+		return
+	}
+
+	// Saving the same content again would only add a copy to the history
+	if req.Content != message.Content {
+		var err error
+		message, err = rt.db.EditMessage(conversation.ConversationID, message.MessageID, req.Content, globaltime.Now())
+		if errors.Is(err, database.ErrMessageDeleted) {
+			// Deleted for everyone since it was read above
+			http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusForbidden)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+			return
+		}
+		rt.publish(memberIDs(members, ""), frameEditedMessage, message, nil)
+		rt.emitWebhookEvent(conversation.ConversationID, models.EventMessageEdited, message)
+	}
+
+	if err := json.NewEncoder(w).Encode(message); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// Values of the `for` query parameter of `DELETE /conversations/:id/messages/:mid`
+const (
+	deleteForMe       = "me"
+	deleteForEveryone = "everyone"
+)
+
+// deleteMessageHandler deletes a message. With `for=everyone`, its author replaces it with a tombstone for all the
+// members, within the delete window. With `for=me` (the default), any member hides it from their own view.
+func (rt *_router) deleteMessageHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	mode := r.URL.Query().Get("for")
+	if mode == "" {
+		mode = deleteForMe
+	}
+	if mode != deleteForMe && mode != deleteForEveryone {
+		http.Error(w, `{"error":"for must be me or everyone"}`, http.StatusBadRequest)
+		return
+	}
+
+	conversation, members, ok := rt.memberConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+	message, ok := rt.conversationMessage(w, conversation.ConversationID, ps.ByName("mid"))
+	if !ok {
+		return
+	}
+
+	event := wsDeletedData{ConversationID: conversation.ConversationID, MessageID: message.MessageID}
+	if mode == deleteForEveryone {
+		if message.SenderID != user.UserID {
+			http.Error(w, `{"error":"only the author can delete the message for everyone"}`, http.StatusForbidden)
+			return
+		}
+		if message.DeletedAt == nil && globaltime.Since(message.CreatedAt) > rt.deleteWindow {
+			http.Error(w, `{"error":"the message is too old to be deleted for everyone"}`, http.StatusForbidden)
+			return
+		}
+		deleted, err := rt.db.DeleteMessageForEveryone(conversation.ConversationID, message.MessageID, globaltime.Now())
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+			return
+		}
+		event.ForEveryone = true
+		rt.publish(memberIDs(members, ""), frameDeletedMessage, event, nil)
+		if message.DeletedAt == nil {
+			rt.emitWebhookEvent(conversation.ConversationID, models.EventMessageDeleted, deleted)
+		}
+	} else {
+		if err := rt.db.HideMessage(conversation.ConversationID, message.MessageID, user.UserID); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+			return
+		}
+		rt.publish([]string{user.UserID}, frameDeletedMessage, event, nil)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getMessageHistoryHandler returns the previous versions of a message, oldest first. Only members can read them.
+func (rt *_router) getMessageHistoryHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	conversation, _, ok := rt.memberConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	versions, err := rt.db.GetMessageHistory(conversation.ConversationID, ps.ByName("mid"))
+	if errors.Is(err, database.ErrMessageNotFound) {
+		http.Error(w, `{"error":"message not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if versions == nil {
+		versions = []models.MessageVersion{}
+	}
+
+	if err := json.NewEncoder(w).Encode(newListResponse(versions, database.PageInfo{})); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// conversationMessage returns the message of the conversation. If it does not exist, the error response is already
+// written and ok is false.
+func (rt *_router) conversationMessage(w http.ResponseWriter, conversationID, messageID string) (message models.Message, ok bool) {
+	message, err := rt.db.GetMessage(conversationID, messageID)
+	if errors.Is(err, database.ErrMessageNotFound) {
+		http.Error(w, `{"error":"message not found"}`, http.StatusNotFound)
+		return message, false
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return message, false
+	}
+	return message, true
+}
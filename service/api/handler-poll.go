@@ -0,0 +1,234 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"AlChats/service/globaltime"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// maxPollOptions is the largest number of options of a poll
+const maxPollOptions = 10
+
+// CreatePollRequest is the body of `POST /conversations/:id/polls`
+type CreatePollRequest struct {
+	Question       string   `json:"question"`
+	Options        []string `json:"options"`
+	MultipleChoice bool     `json:"multiple_choice,omitempty"`
+	Anonymous      bool     `json:"anonymous,omitempty"`
+}
+
+// poll returns the poll described by the request, or why it is not valid.
+func (req CreatePollRequest) poll() (models.Poll, error) {
+	poll := models.Poll{
+		Question:       strings.TrimSpace(req.Question),
+		MultipleChoice: req.MultipleChoice,
+		Anonymous:      req.Anonymous,
+	}
+	if poll.Question == "" {
+		return poll, errors.New("question is required")
+	}
+	if len(req.Options) < 2 || len(req.Options) > maxPollOptions {
+		return poll, fmt.Errorf("a poll has from 2 to %d options", maxPollOptions)
+	}
+	for _, option := range req.Options {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			return poll, errors.New("options cannot be empty")
+		}
+		poll.Options = append(poll.Options, models.PollOption{Text: option})
+	}
+	return poll, nil
+}
+
+// VoteRequest is the body of `PUT /conversations/:id/messages/:mid/votes`
+type VoteRequest struct {
+	Options []int `json:"options"`
+}
+
+// wsPollData is the data of the poll.updated events
+type wsPollData struct {
+	ConversationID string      `json:"conversationId"`
+	MessageID      string      `json:"messageId"`
+	Poll           models.Poll `json:"poll"`
+}
+
+// createPoll sends a poll as a message of the user, and delivers it like the other messages. The content of the
+// message is the question, for the clients that do not know polls.
+func (rt *_router) createPoll(conversationID string, members []models.User, senderID string, poll models.Poll) (models.Message, error) {
+	saved, err := rt.db.AddMessages(conversationID, []models.Message{{
+		SenderID:  senderID,
+		Content:   poll.Question,
+		CreatedAt: globaltime.Now(),
+		Poll:      &poll,
+	}})
+	if err != nil {
+		return models.Message{}, err
+	}
+	rt.deliverMessage(members, saved[0], nil)
+	return saved[0], nil
+}
+
+// publishPoll sends the new tally of a poll to the members. The choices of the voter are left out.
+func (rt *_router) publishPoll(members []models.User, conversationID, messageID string, poll models.Poll) {
+	poll.MyVotes = nil
+	rt.publish(memberIDs(members, ""), framePollUpdated,
+		wsPollData{ConversationID: conversationID, MessageID: messageID, Poll: poll}, nil)
+}
+
+// writePollError writes the response for an error of the polls.
+func writePollError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrMessageNotFound):
+		http.Error(w, `{"error":"message not found"}`, http.StatusNotFound)
+	case errors.Is(err, database.ErrPollNotFound):
+		http.Error(w, `{"error":"the message is not a poll"}`, http.StatusNotFound)
+	case errors.Is(err, database.ErrPollClosed):
+		http.Error(w, `{"error":"the poll is closed"}`, http.StatusConflict)
+	case errors.Is(err, database.ErrInvalidVote):
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusBadRequest)
+	default:
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// createPollHandler sends a poll to a conversation. Any member can do it.
+func (rt *_router) createPollHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreatePollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	poll, err := req.poll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusBadRequest)
+		return
+	}
+
+	conversation, members, ok := rt.memberConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	message, err := rt.createPoll(conversation.ConversationID, members, user.UserID, poll)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(message); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// voteHandler replaces the choices of the user in a poll, and sends the new tally to the members. Only members can
+// vote, while the poll is open.
+func (rt *_router) voteHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var req VoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	conversation, members, ok := rt.memberConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	poll, err := rt.db.Vote(conversation.ConversationID, ps.ByName("mid"), user.UserID, req.Options, globaltime.Now())
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+	rt.publishPoll(members, conversation.ConversationID, ps.ByName("mid"), poll)
+
+	if err := json.NewEncoder(w).Encode(poll); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// retractVoteHandler deletes the choices of the user in a poll, while it is open, and sends the new tally to the
+// members.
+func (rt *_router) retractVoteHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	conversation, members, ok := rt.memberConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	poll, err := rt.db.RetractVote(conversation.ConversationID, ps.ByName("mid"), user.UserID)
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+	rt.publishPoll(members, conversation.ConversationID, ps.ByName("mid"), poll)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// closePollHandler stops the voting in a poll, and sends the final tally to the members. Only the creator of the poll
+// can close it.
+func (rt *_router) closePollHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	conversation, members, ok := rt.memberConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+	message, ok := rt.conversationMessage(w, conversation.ConversationID, ps.ByName("mid"))
+	if !ok {
+		return
+	}
+	if message.Poll == nil {
+		http.Error(w, `{"error":"the message is not a poll"}`, http.StatusNotFound)
+		return
+	}
+	if message.SenderID != user.UserID {
+		http.Error(w, `{"error":"only the creator can close the poll"}`, http.StatusForbidden)
+		return
+	}
+
+	poll, err := rt.db.ClosePoll(conversation.ConversationID, message.MessageID, globaltime.Now())
+	if err != nil {
+		writePollError(w, err)
+		return
+	}
+	if message.Poll.ClosedAt == nil {
+		rt.publishPoll(members, conversation.ConversationID, message.MessageID, poll)
+	}
+
+	if err := json.NewEncoder(w).Encode(poll); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
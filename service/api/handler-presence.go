@@ -0,0 +1,179 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// getPresenceHandler returns the online status of a user. The last seen time is omitted if the user hides it (unless
+// they are asking for themselves), and only the conversations shared with the authenticated user are listed in
+// `typingIn`.
+func (rt *_router) getPresenceHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	viewer, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := rt.db.GetUserByID(ps.ByName("id"))
+	if errors.Is(err, database.ErrUserNotFound) {
+		http.Error(w, `{"error":"user not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	presence, err := rt.presenceOf(user.UserID, viewer.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(presence); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// presenceOf returns the presence of the user as seen by the viewer.
+func (rt *_router) presenceOf(userID, viewerID string) (models.Presence, error) {
+	online, lastSeen, typingIn := rt.presence.get(userID)
+	presence := models.Presence{UserID: userID, Online: online, TypingIn: []string{}}
+
+	if !lastSeen.IsZero() {
+		hidden := false
+		if userID != viewerID {
+			privacy, err := rt.db.GetPrivacy(userID)
+			if err != nil {
+				return presence, err
+			}
+			hidden = privacy.HideLastSeen
+		}
+		if !hidden {
+			presence.LastSeen = &lastSeen
+		}
+	}
+
+	for _, conversationID := range typingIn {
+		members, err := rt.db.GetConversationMembers(conversationID)
+		if err != nil {
+			return presence, err
+		}
+		if isMember(members, viewerID) {
+			presence.TypingIn = append(presence.TypingIn, conversationID)
+		}
+	}
+	return presence, nil
+}
+
+// getPrivacyHandler returns the privacy settings of the authenticated user.
+func (rt *_router) getPrivacyHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	privacy, err := rt.db.GetPrivacy(user.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(privacy); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// setPrivacyHandler replaces the privacy settings of the authenticated user.
+func (rt *_router) setPrivacyHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var privacy models.Privacy
+	if err := json.NewDecoder(r.Body).Decode(&privacy); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := rt.db.SetPrivacy(user.UserID, privacy); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(privacy); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// wsPresenceData is the data of the presence events
+type wsPresenceData struct {
+	UserID   string     `json:"userId"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"lastSeen,omitempty"`
+}
+
+// publishPresence sends the presence of the user to the users sharing a conversation with them, after they connected
+// or disconnected.
+func (rt *_router) publishPresence(userID string) {
+	contacts, err := rt.contactIDs(userID)
+	if err != nil {
+		rt.baseLogger.WithError(err).WithField("user", userID).Error("reading the contacts of the user")
+		return
+	}
+	if len(contacts) == 0 {
+		return
+	}
+
+	// The last seen time is the same for every contact, who are never the user themselves
+	presence, err := rt.presenceOf(userID, "")
+	if err != nil {
+		rt.baseLogger.WithError(err).WithField("user", userID).Error("reading the presence of the user")
+		return
+	}
+	rt.publish(contacts, framePresence,
+		wsPresenceData{UserID: userID, Online: presence.Online, LastSeen: presence.LastSeen}, nil)
+}
+
+// contactIDs returns the users sharing at least one conversation with the user.
+func (rt *_router) contactIDs(userID string) ([]string, error) {
+	seen := map[string]bool{userID: true}
+	var contacts []string
+
+	page := database.Page{Limit: database.MaxPageLimit}
+	for {
+		conversations, info, err := rt.db.GetAllConversationsByMember(userID, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, conversation := range conversations {
+			members, err := rt.db.GetConversationMembers(conversation.ConversationID)
+			if err != nil {
+				return nil, err
+			}
+			for _, member := range members {
+				if !seen[member.UserID] {
+					seen[member.UserID] = true
+					contacts = append(contacts, member.UserID)
+				}
+			}
+		}
+		if info.NextKey == "" {
+			return contacts, nil
+		}
+		page.After = info.NextKey
+	}
+}
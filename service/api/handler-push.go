@@ -0,0 +1,194 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"AlChats/service/globaltime"
+	"AlChats/service/notify"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// notifyTimeout bounds the delivery of the notifications of a message
+const notifyTimeout = 30 * time.Second
+
+// PushSubscriptionRequest is the body of `POST /user/push-subscriptions`, as returned by `PushSubscription.toJSON()` in
+// the browser.
+type PushSubscriptionRequest struct {
+	Endpoint string          `json:"endpoint"`
+	Keys     models.PushKeys `json:"keys"`
+}
+
+// VAPIDKey is the response of `GET /push/vapid-key`
+type VAPIDKey struct {
+	PublicKey string `json:"publicKey"`
+}
+
+// vapidKeyHandler returns the public key that clients subscribe with, when the notifications are sent with Web Push.
+func (rt *_router) vapidKeyHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, ok := rt.authenticate(w, r); !ok {
+		return
+	}
+
+	webpush, ok := rt.notifier.(interface{ PublicKey() string })
+	if !ok {
+		http.Error(w, `{"error":"web push is not enabled"}`, http.StatusNotFound)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(VAPIDKey{PublicKey: webpush.PublicKey()}); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// addPushSubscriptionHandler registers a push subscription of the authenticated user. Registering an endpoint again
+// replaces its subscription.
+func (rt *_router) addPushSubscriptionHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var req PushSubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	subscription := models.PushSubscription{Endpoint: req.Endpoint, Keys: req.Keys, CreatedAt: globaltime.Now()}
+	if err := notify.CheckSubscription(r.Context(), subscription); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusBadRequest)
+		return
+	}
+
+	subscription, err := rt.db.AddPushSubscription(user.UserID, subscription)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(subscription); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// getPushSubscriptionsHandler returns the push subscriptions of the authenticated user, oldest first.
+func (rt *_router) getPushSubscriptionsHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	subscriptions, err := rt.db.GetPushSubscriptions(user.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if subscriptions == nil {
+		subscriptions = []models.PushSubscription{}
+	}
+
+	if err := json.NewEncoder(w).Encode(newListResponse(subscriptions, database.PageInfo{})); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// deletePushSubscriptionHandler deletes a push subscription of the authenticated user, e.g. when they log out.
+func (rt *_router) deletePushSubscriptionHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	err := rt.db.DeletePushSubscription(user.UserID, ps.ByName("id"))
+	if errors.Is(err, database.ErrPushSubscriptionNotFound) {
+		http.Error(w, `{"error":"push subscription not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pushNewMessage sends the notification of the message to the recipients who are not connected, in the background.
+// The subscriptions that the push service reports as expired are deleted.
+func (rt *_router) pushNewMessage(recipients []string, message models.Message) {
+	if rt.notifier == nil {
+		return
+	}
+	var offline []string
+	for _, userID := range recipients {
+		if !rt.hub.Connected(userID) {
+			offline = append(offline, userID)
+		}
+	}
+	if len(offline) == 0 {
+		return
+	}
+
+	rt.notifying.Add(1)
+	go func() {
+		defer rt.notifying.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		defer cancel()
+
+		// The title names the sender, and the group if the message was sent to one
+		title := rt.username(message.SenderID)
+		conversation, err := rt.db.GetConversationByID(message.ConversationID)
+		if err != nil {
+			rt.baseLogger.WithError(err).WithField("conversation", message.ConversationID).Error("reading the conversation")
+			return
+		}
+		if conversation.IsGroup {
+			title = fmt.Sprintf("%s in %s", title, conversation.GroupName)
+		}
+
+		for _, userID := range offline {
+			logger := rt.baseLogger.WithField("user", userID).WithField("message", message.MessageID)
+			subscriptions, err := rt.db.GetPushSubscriptions(userID)
+			if err != nil {
+				logger.WithError(err).Error("reading the push subscriptions")
+				continue
+			}
+
+			err = rt.notifier.Notify(ctx, notify.Notification{
+				UserID:         userID,
+				ConversationID: message.ConversationID,
+				MessageID:      message.MessageID,
+				SenderID:       message.SenderID,
+				Title:          title,
+				Body:           message.Content,
+				SentAt:         message.CreatedAt,
+			}, subscriptions)
+
+			var gone *notify.GoneError
+			if errors.As(err, &gone) {
+				for _, subscriptionID := range gone.SubscriptionIDs {
+					if err := rt.db.DeletePushSubscription(userID, subscriptionID); err != nil &&
+						!errors.Is(err, database.ErrPushSubscriptionNotFound) {
+						logger.WithError(err).Error("deleting an expired push subscription")
+					}
+				}
+				err = gone.Err
+			}
+			if err != nil {
+				logger.WithError(err).Warning("sending the notification")
+			}
+		}
+	}()
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/globaltime"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// UpdateSettingsRequest is the body of `PATCH /conversations/:id/settings`: the fields left out are not changed.
+// MutedUntil is kept raw to tell a null (unmute) from a missing field.
+type UpdateSettingsRequest struct {
+	Pinned     *bool           `json:"pinned,omitempty"`
+	MutedUntil json.RawMessage `json:"muted_until,omitempty"`
+	Archived   *bool           `json:"archived,omitempty"`
+}
+
+// updateSettingsHandler changes the settings of the authenticated user for a conversation, and returns them. Muting
+// until a time that is not in the future unmutes the conversation.
+func (rt *_router) updateSettingsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	var mutedUntil *time.Time
+	if len(req.MutedUntil) > 0 {
+		if err := json.Unmarshal(req.MutedUntil, &mutedUntil); err != nil {
+			http.Error(w, `{"error":"muted_until must be a time or null"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	conversation, _, ok := rt.memberConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+	settings, err := rt.db.GetMemberSettings(conversation.ConversationID, user.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	if req.Pinned != nil {
+		settings.Pinned = *req.Pinned
+	}
+	if req.Archived != nil {
+		settings.Archived = *req.Archived
+	}
+	if len(req.MutedUntil) > 0 {
+		settings.MutedUntil = mutedUntil
+		if mutedUntil != nil && !mutedUntil.After(globaltime.Now()) {
+			settings.MutedUntil = nil
+		}
+	}
+	if err := rt.db.SetMemberSettings(conversation.ConversationID, user.UserID, settings); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(settings); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// notifyNewMessage sends the notification event about a new message to the members who did not mute the conversation,
+// apart from its sender. Unlike message.new, which keeps every client in sync, the notification is what clients alert
+// the user with. The members who are not connected are notified through the notifier instead.
+func (rt *_router) notifyNewMessage(members []models.User, message models.Message) {
+	muted, err := rt.db.GetMutedMembers(message.ConversationID, globaltime.Now())
+	if err != nil {
+		rt.baseLogger.WithError(err).WithField("conversation", message.ConversationID).Error("reading the muted members")
+		return
+	}
+	skip := map[string]bool{message.SenderID: true}
+	for _, userID := range muted {
+		skip[userID] = true
+	}
+
+	var recipients []string
+	for _, member := range members {
+		if !skip[member.UserID] {
+			recipients = append(recipients, member.UserID)
+		}
+	}
+	if len(recipients) > 0 {
+		rt.publish(recipients, frameNotification, message, nil)
+		rt.pushNewMessage(recipients, message)
+	}
+}
@@ -1,7 +1,12 @@
 package api
 
 import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"AlChats/service/export"
+	"AlChats/service/globaltime"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -31,8 +36,15 @@ func (rt *_router) createUserHandler(w http.ResponseWriter, r *http.Request, _ h
 		return
 	}
 
-	// Write the created user as a JSON response
-	if err := json.NewEncoder(w).Encode(user); err != nil {
+	// Open the first session of the user: its token is the bearer token of the authenticated operations
+	token, err := rt.db.CreateSession(user.UserID, globaltime.Now())
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the created user with the session token as a JSON response
+	if err := json.NewEncoder(w).Encode(models.Session{User: user, Token: token}); err != nil {
 		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
 	}
 }
@@ -55,6 +67,15 @@ func (rt *_router) updateUsernameHandler(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	// The database reports unknown users and taken usernames with the same error, tell them apart first
+	if _, err := rt.db.GetUserByID(userId); errors.Is(err, database.ErrUserNotFound) {
+		http.Error(w, `{"error":"user not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
 	// Call the UpdateUsername function to update the username
 	user, err := rt.db.UpdateUsername(userId, newUsername)
 	if err != nil {
@@ -74,19 +95,90 @@ func (rt *_router) updateUsernameHandler(w http.ResponseWriter, r *http.Request,
 	}
 }
 
+// getUserHandler returns the public profile of a single user.
+func (rt *_router) getUserHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	// Set response header to JSON
+	w.Header().Set("Content-Type", "application/json")
+
+	user, err := rt.db.GetUserByID(ps.ByName("id"))
+	if errors.Is(err, database.ErrUserNotFound) {
+		http.Error(w, `{"error":"user not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	// Write the user as a JSON response
+	if err := json.NewEncoder(w).Encode(user); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
 func (rt *_router) getAllUsersHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
-	// Call GetAllUsers to fetch all users
-	users, err := rt.db.GetAllUsers()
+	// Read the requested page
+	page, err := parsePage(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusBadRequest)
+		return
+	}
+
+	// Call GetAllUsers to fetch a page of users
+	users, info, err := rt.db.GetAllUsers(page)
 	if err != nil {
 		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
 		return
 	}
+	if users == nil {
+		users = []models.User{}
+	}
 
-	// Write the list of users as a JSON response
-	if err := json.NewEncoder(w).Encode(users); err != nil {
+	// Write the page of users as a JSON response
+	if err := json.NewEncoder(w).Encode(newListResponse(users, info)); err != nil {
 		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
 	}
 }
+
+// deleteUserHandler deletes the account of the authenticated user, together with their memberships and their empty 1:1
+// conversations (see DeleteUserByID).
+func (rt *_router) deleteUserHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	// Set response header to JSON
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	// Call DeleteUserByID to remove the user and its data
+	if err := rt.db.DeleteUserByID(user.UserID); err != nil {
+		if strings.Contains(err.Error(), "no user found") {
+			http.Error(w, `{"error":"user not found"}`, http.StatusNotFound)
+		} else {
+			http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		}
+		return
+	}
+	rt.presence.forget(user.UserID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// exportUserHandler streams the account data export (a ZIP archive) of the authenticated user.
+func (rt *_router) exportUserHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="alchats-export-%s.zip"`, user.UserID))
+
+	// The archive is streamed: once it started, errors cannot be reported to the client anymore
+	if err := export.Write(w, rt.db, user); err != nil {
+		rt.baseLogger.WithError(err).WithField("user", user.UserID).Error("exporting user data")
+	}
+}
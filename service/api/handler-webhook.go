@@ -0,0 +1,280 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"AlChats/service/globaltime"
+	"AlChats/service/webhooks"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// CreateWebhookRequest is the body of `POST /conversations/:id/webhooks`: by default, the webhook receives all the
+// events
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+}
+
+// webhookConversation returns the conversation whose webhooks the user manages: any member of a direct conversation,
+// the admins of a group. Otherwise, the error response is already written and ok is false.
+func (rt *_router) webhookConversation(w http.ResponseWriter, user models.User, conversationID string) (models.Conversation, bool) {
+	if rt.webhooks == nil {
+		http.Error(w, `{"error":"webhooks are not configured"}`, http.StatusServiceUnavailable)
+		return models.Conversation{}, false
+	}
+
+	conversation, _, ok := rt.memberConversation(w, user, conversationID)
+	if !ok || !conversation.IsGroup {
+		return conversation, ok
+	}
+	members, err := rt.db.GetConversationMemberRoles(conversation.ConversationID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return conversation, false
+	}
+	self, _ := findMember(members, user.UserID)
+	if !can(self, actionManageWebhooks, models.Member{}) {
+		http.Error(w, `{"error":"only admins can manage the webhooks"}`, http.StatusForbidden)
+		return conversation, false
+	}
+	return conversation, true
+}
+
+// conversationWebhook returns the webhook of the conversation. If it does not exist, the error response is already
+// written and ok is false.
+func (rt *_router) conversationWebhook(w http.ResponseWriter, conversationID, webhookID string) (models.Webhook, bool) {
+	webhook, err := rt.db.GetWebhook(webhookID)
+	if errors.Is(err, database.ErrWebhookNotFound) || (err == nil && webhook.ConversationID != conversationID) {
+		http.Error(w, `{"error":"webhook not found"}`, http.StatusNotFound)
+		return webhook, false
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return webhook, false
+	}
+	return webhook, true
+}
+
+// createWebhookHandler subscribes a URL to the events of a conversation. The response is the only one including the
+// secret that signs the payloads.
+func (rt *_router) createWebhookHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if err := webhooks.CheckURL(r.Context(), req.URL); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusBadRequest)
+		return
+	}
+	for _, event := range req.Events {
+		if !isWebhookEvent(event) {
+			http.Error(w, fmt.Sprintf(`{"error":"unknown event %q"}`, event), http.StatusBadRequest)
+			return
+		}
+	}
+
+	conversation, ok := rt.webhookConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	webhook, err := rt.db.CreateWebhook(models.Webhook{
+		ConversationID: conversation.ConversationID,
+		URL:            req.URL,
+		Events:         req.Events,
+		CreatedBy:      user.UserID,
+		CreatedAt:      globaltime.Now(),
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(webhook); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// isWebhookEvent reports whether webhooks can subscribe to the event.
+func isWebhookEvent(event string) bool {
+	for _, e := range models.WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// getWebhooksHandler returns the webhooks of a conversation, oldest first, without their secrets.
+func (rt *_router) getWebhooksHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	conversation, ok := rt.webhookConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	list, err := rt.db.GetConversationWebhooks(conversation.ConversationID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []models.Webhook{}
+	}
+	for i := range list {
+		list[i].Secret = ""
+	}
+
+	if err := json.NewEncoder(w).Encode(newListResponse(list, database.PageInfo{})); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// deleteWebhookHandler deletes a webhook with its delivery log. The events not delivered yet are dropped.
+func (rt *_router) deleteWebhookHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	conversation, ok := rt.webhookConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	err := rt.db.DeleteWebhook(conversation.ConversationID, ps.ByName("wid"))
+	if errors.Is(err, database.ErrWebhookNotFound) {
+		http.Error(w, `{"error":"webhook not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getWebhookDeliveriesHandler returns the delivery log of a webhook, oldest first.
+func (rt *_router) getWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusBadRequest)
+		return
+	}
+
+	conversation, ok := rt.webhookConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+	webhook, ok := rt.conversationWebhook(w, conversation.ConversationID, ps.ByName("wid"))
+	if !ok {
+		return
+	}
+
+	deliveries, info, err := rt.db.GetWebhookDeliveries(webhook.WebhookID, page)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+
+	if err := json.NewEncoder(w).Encode(newListResponse(deliveries, info)); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// retryWebhookDeliveryHandler queues a dead delivery again, for as many attempts as a new one.
+func (rt *_router) retryWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	conversation, ok := rt.webhookConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+	webhook, ok := rt.conversationWebhook(w, conversation.ConversationID, ps.ByName("wid"))
+	if !ok {
+		return
+	}
+
+	delivery, err := rt.db.GetWebhookDelivery(webhook.WebhookID, ps.ByName("did"))
+	if errors.Is(err, database.ErrDeliveryNotFound) {
+		http.Error(w, `{"error":"delivery not found"}`, http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if delivery.Status != models.DeliveryDead {
+		http.Error(w, `{"error":"only dead deliveries can be retried"}`, http.StatusConflict)
+		return
+	}
+
+	now := globaltime.Now()
+	delivery.Status, delivery.Attempts, delivery.NextAttemptAt = models.DeliveryPending, 0, &now
+	if err := rt.db.UpdateWebhookDelivery(delivery); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	rt.webhooks.Wake()
+
+	if err := json.NewEncoder(w).Encode(delivery); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+// emitWebhookEvent queues the event for the webhooks of the conversation subscribed to it. The change already
+// happened, so errors are only logged.
+func (rt *_router) emitWebhookEvent(conversationID, event string, data interface{}) {
+	if rt.webhooks == nil {
+		return
+	}
+	logger := rt.baseLogger.WithField("conversation", conversationID).WithField("event", event)
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		logger.WithError(err).Error("encoding the webhook event")
+		return
+	}
+	queued, err := rt.db.EnqueueWebhookEvent(conversationID, event, payload, globaltime.Now())
+	if err != nil {
+		logger.WithError(err).Error("queueing the webhook event")
+		return
+	}
+	if queued > 0 {
+		rt.webhooks.Wake()
+	}
+}
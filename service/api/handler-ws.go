@@ -0,0 +1,223 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/commands"
+	"AlChats/service/database"
+	"AlChats/service/globaltime"
+	"AlChats/service/realtime"
+	"AlChats/service/websocket"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// WebSocket frame types (see the realtime package for the envelope)
+const (
+	// Sent by clients
+	frameSendMessage = "message.send"
+	frameTyping      = "typing"
+	frameRead        = "read"
+
+	// Sent by bots
+	frameRegisterCommands = "commands.register"
+
+	// Sent by the server
+	frameNewMessage     = "message.new"
+	frameEditedMessage  = "message.edited"
+	frameDeletedMessage = "message.deleted"
+	framePresence       = "presence"
+	frameNotification   = "notification"
+	frameCommand        = "command" // To a bot, when a member runs one of its commands
+	framePollUpdated    = "poll.updated"
+)
+
+// wsConversationData is the data of the client frames about a conversation
+type wsConversationData struct {
+	ConversationID string `json:"conversationId"`
+	Content        string `json:"content,omitempty"`
+	MessageID      string `json:"messageId,omitempty"`
+
+	// Typing is false to stop a typing signal before it expires
+	Typing *bool `json:"typing,omitempty"`
+}
+
+// wsTypingData is the data of the typing events sent to the other members
+type wsTypingData struct {
+	ConversationID string     `json:"conversationId"`
+	UserID         string     `json:"userId"`
+	Typing         bool       `json:"typing"`
+	ExpiresAt      *time.Time `json:"expiresAt,omitempty"`
+}
+
+// wsEventData is the data of the read events sent to the other members
+type wsEventData struct {
+	ConversationID string `json:"conversationId"`
+	UserID         string `json:"userId"`
+	MessageID      string `json:"messageId,omitempty"`
+}
+
+// wsDeletedData is the data of the message deletion events
+type wsDeletedData struct {
+	ConversationID string `json:"conversationId"`
+	MessageID      string `json:"messageId"`
+
+	// ForEveryone is false when the user deleted the message for themselves (the event goes to their connections only)
+	ForEveryone bool `json:"forEveryone"`
+}
+
+// websocketHandler upgrades the request to a WebSocket connection of the authenticated user. Browsers cannot set
+// headers on WebSocket requests, so the token can also be passed in the `access_token` query parameter. The server
+// does not log the URLs of the requests, but reverse proxies usually do: their access logs then hold the tokens.
+func (rt *_router) websocketHandler(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		if !errors.Is(err, websocket.ErrBadHandshake) {
+			rt.baseLogger.WithError(err).Error("upgrading to websocket")
+		}
+		return
+	}
+
+	if rt.presence.connect(user.UserID) {
+		rt.publishPresence(user.UserID)
+	}
+	err = rt.hub.Serve(conn, user.UserID, func(c *realtime.Client, f realtime.Frame) {
+		rt.presence.touch(c.UserID())
+		rt.handleFrame(c, f)
+	})
+	if rt.presence.disconnect(user.UserID) {
+		// The commands of a bot are served by its connections
+		_ = rt.commands.ReplaceBotCommands(user.UserID, nil)
+		rt.publishPresence(user.UserID)
+	}
+	if err != nil && !errors.Is(err, realtime.ErrClosed) {
+		rt.baseLogger.WithError(err).WithField("user", user.UserID).Debug("websocket connection closed")
+	}
+}
+
+// handleFrame executes the request of a client.
+func (rt *_router) handleFrame(c *realtime.Client, f realtime.Frame) {
+	if f.Type == frameRegisterCommands {
+		rt.registerBotCommands(c, f)
+		return
+	}
+	if f.Type != frameSendMessage && f.Type != frameTyping && f.Type != frameRead {
+		c.SendError(f.ID, "unknown frame type")
+		return
+	}
+
+	var data wsConversationData
+	if err := json.Unmarshal(f.Data, &data); err != nil || data.ConversationID == "" {
+		c.SendError(f.ID, "data.conversationId is required")
+		return
+	}
+
+	members, err := rt.db.GetConversationMembers(data.ConversationID)
+	if err != nil {
+		c.SendError(f.ID, "cannot read the conversation")
+		return
+	}
+	if !isMember(members, c.UserID()) {
+		c.SendError(f.ID, "not a member of the conversation")
+		return
+	}
+
+	switch f.Type {
+	case frameSendMessage:
+		if strings.TrimSpace(data.Content) == "" {
+			c.SendError(f.ID, "data.content is required")
+			return
+		}
+		if name, args, ok := commands.Parse(data.Content); ok {
+			rt.runCommand(c, f, members, data.ConversationID, name, args)
+			return
+		}
+		saved, err := rt.db.AddMessages(data.ConversationID, []models.Message{{
+			SenderID:  c.UserID(),
+			Content:   commands.Unescape(data.Content),
+			CreatedAt: globaltime.Now(),
+		}})
+		if err != nil {
+			rt.baseLogger.WithError(err).Error("saving websocket message")
+			c.SendError(f.ID, "cannot save the message")
+			return
+		}
+		// The new message replaces the typing signal of the sender
+		rt.presence.setTyping(data.ConversationID, c.UserID(), false)
+		c.SendAck(f.ID, saved[0])
+		rt.deliverMessage(members, saved[0], c)
+
+	case frameTyping:
+		event := wsTypingData{ConversationID: data.ConversationID, UserID: c.UserID(), Typing: true}
+		if data.Typing != nil {
+			event.Typing = *data.Typing
+		}
+		expiresAt := rt.presence.setTyping(data.ConversationID, c.UserID(), event.Typing)
+		if event.Typing {
+			event.ExpiresAt = &expiresAt
+		}
+		rt.publish(memberIDs(members, c.UserID()), frameTyping, event, nil)
+		c.SendAck(f.ID, nil)
+
+	case frameRead:
+		if data.MessageID == "" {
+			c.SendError(f.ID, "data.messageId is required")
+			return
+		}
+		// Receipts are only sent for the messages of the conversation
+		if _, err := rt.db.GetMessage(data.ConversationID, data.MessageID); errors.Is(err, database.ErrMessageNotFound) {
+			c.SendError(f.ID, "message not found")
+			return
+		} else if err != nil {
+			rt.baseLogger.WithError(err).Error("reading the message of a read receipt")
+			c.SendError(f.ID, "cannot read the message")
+			return
+		}
+		rt.publish(memberIDs(members, ""), frameRead,
+			wsEventData{ConversationID: data.ConversationID, UserID: c.UserID(), MessageID: data.MessageID}, c)
+		c.SendAck(f.ID, nil)
+	}
+}
+
+// publish sends an event to the connections of the users.
+func (rt *_router) publish(userIDs []string, frameType string, data interface{}, except *realtime.Client) {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("encoding websocket event")
+		return
+	}
+	rt.hub.Publish(userIDs, realtime.Frame{Type: frameType, Data: buf}, except)
+}
+
+// deliverMessage sends a new message to the connections of the members (except `except`), to the push subscriptions
+// of those who are offline, and to the webhooks of the conversation.
+func (rt *_router) deliverMessage(members []models.User, message models.Message, except *realtime.Client) {
+	rt.publish(memberIDs(members, ""), frameNewMessage, message, except)
+	rt.notifyNewMessage(members, message)
+	rt.emitWebhookEvent(message.ConversationID, models.EventMessageNew, message)
+}
+
+// memberIDs returns the IDs of the members, without `exclude`.
+func memberIDs(members []models.User, exclude string) []string {
+	var ids []string
+	for _, member := range members {
+		if member.UserID != exclude {
+			ids = append(ids, member.UserID)
+		}
+	}
+	return ids
+}
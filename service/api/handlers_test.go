@@ -0,0 +1,620 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"AlChats/service/globaltime"
+	"AlChats/service/webhooks"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// update rewrites the golden files with the current responses: go test ./service/api -run TestHandlers -update
+var update = flag.Bool("update", false, "rewrite the golden files of TestHandlers")
+
+// handlerFixture is the content of the database at the start of TestHandlers. The IDs are sequential, so the
+// responses are the same at every run.
+type handlerFixture struct {
+	alice, bob, carol, dave models.User
+	direct, group           models.Conversation
+
+	// oldMessage is older than the edit and delete windows, recentMessage is not
+	oldMessage, recentMessage models.Message
+
+	// invites to the group: without limits, expired, and usable once
+	invite, expiredInvite, singleUseInvite models.Invite
+
+	// webhook of the direct conversation, receiving all the events, with a dead delivery
+	webhook      models.Webhook
+	deadDelivery models.WebhookDelivery
+
+	// bot member of the group, with an API key and an incoming webhook there
+	bot      models.User
+	botKey   models.APIKey
+	incoming models.IncomingWebhook
+
+	// poll of Alice in the direct conversation, without votes
+	poll models.Message
+
+	// sessions are the session tokens of the users, by placeholder (e.g., `{alice}`): the cases name the users in the
+	// token column
+	sessions map[string]string
+}
+
+func newHandlerFixture(t *testing.T, db database.AppDatabase) handlerFixture {
+	t.Helper()
+
+	var f handlerFixture
+	var err error
+	for _, u := range []struct {
+		user     *models.User
+		username string
+	}{{&f.alice, "alice"}, {&f.bob, "bob"}, {&f.carol, "carol"}, {&f.dave, "dave"}} {
+		if *u.user, err = db.SetUser(u.username); err != nil {
+			t.Fatalf("creating %s: %v", u.username, err)
+		}
+	}
+
+	if f.direct, err = db.SetConversation([]string{f.alice.UserID, f.bob.UserID}, false, "", ""); err != nil {
+		t.Fatalf("creating the direct conversation: %v", err)
+	}
+	if f.group, err = db.SetConversation([]string{f.alice.UserID, f.bob.UserID, f.carol.UserID}, true, "friends", ""); err != nil {
+		t.Fatalf("creating the group: %v", err)
+	}
+
+	start := globaltime.Now().Add(-2 * time.Hour)
+	messages, err := db.AddMessages(f.direct.ConversationID, []models.Message{
+		{SenderID: f.alice.UserID, Content: "Hi Bob!", CreatedAt: start},
+		{SenderID: f.bob.UserID, Content: "Hi Alice, how are you?", CreatedAt: start.Add(time.Minute)},
+		{SenderID: f.alice.UserID, Content: "Fine, thanks", CreatedAt: start.Add(2 * time.Minute)},
+		{SenderID: f.bob.UserID, Content: "See you later", CreatedAt: globaltime.Now().Add(-5 * time.Minute)},
+	})
+	if err != nil {
+		t.Fatalf("adding messages: %v", err)
+	}
+	f.oldMessage, f.recentMessage = messages[0], messages[3]
+
+	expired := globaltime.Now().Add(-time.Hour)
+	for _, i := range []struct {
+		invite *models.Invite
+		data   models.Invite
+	}{
+		{&f.invite, models.Invite{}},
+		{&f.expiredInvite, models.Invite{ExpiresAt: &expired}},
+		{&f.singleUseInvite, models.Invite{MaxUses: 1}},
+	} {
+		i.data.ConversationID, i.data.CreatedBy, i.data.CreatedAt = f.group.ConversationID, f.alice.UserID, start
+		if *i.invite, err = db.CreateInvite(i.data); err != nil {
+			t.Fatalf("creating invite: %v", err)
+		}
+	}
+
+	f.webhook, err = db.CreateWebhook(models.Webhook{ConversationID: f.direct.ConversationID, URL: "https://ci.example.com/hooks", CreatedBy: f.alice.UserID, CreatedAt: start})
+	if err != nil {
+		t.Fatalf("creating webhook: %v", err)
+	}
+	data, _ := json.Marshal(f.oldMessage)
+	if _, err := db.EnqueueWebhookEvent(f.direct.ConversationID, models.EventMessageNew, data, f.oldMessage.CreatedAt); err != nil {
+		t.Fatalf("queueing webhook event: %v", err)
+	}
+	due, err := db.GetDueWebhookDeliveries(globaltime.Now(), 1)
+	if err != nil || len(due) != 1 {
+		t.Fatalf("reading the webhook delivery: %v, %+v", err, due)
+	}
+	f.deadDelivery = due[0]
+	f.deadDelivery.Status, f.deadDelivery.Attempts, f.deadDelivery.NextAttemptAt = models.DeliveryDead, webhooks.DefaultMaxAttempts, nil
+	f.deadDelivery.LastStatusCode, f.deadDelivery.LastError = http.StatusInternalServerError, "webhook answered 500 Internal Server Error"
+	if err := db.UpdateWebhookDelivery(f.deadDelivery); err != nil {
+		t.Fatalf("updating webhook delivery: %v", err)
+	}
+
+	if f.bot, err = db.CreateBot("ci"); err != nil {
+		t.Fatalf("creating the bot: %v", err)
+	}
+	if f.botKey, err = db.CreateAPIKey(f.bot.UserID, start); err != nil {
+		t.Fatalf("creating the API key: %v", err)
+	}
+	if err := db.AddConversationMembers(f.group.ConversationID, []string{f.bot.UserID}); err != nil {
+		t.Fatalf("adding the bot to the group: %v", err)
+	}
+	if f.incoming, err = db.CreateIncomingWebhook(f.group.ConversationID, f.bot.UserID, start); err != nil {
+		t.Fatalf("creating the incoming webhook: %v", err)
+	}
+
+	polls, err := db.AddMessages(f.direct.ConversationID, []models.Message{{
+		SenderID:  f.alice.UserID,
+		Content:   "Pizza or sushi?",
+		CreatedAt: start.Add(3 * time.Minute),
+		Poll: &models.Poll{
+			Question: "Pizza or sushi?",
+			Options:  []models.PollOption{{Text: "Pizza"}, {Text: "Sushi"}},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("adding the poll: %v", err)
+	}
+	f.poll = polls[0]
+
+	f.sessions = make(map[string]string)
+	for _, u := range []struct {
+		placeholder string
+		user        models.User
+	}{{"{alice}", f.alice}, {"{bob}", f.bob}, {"{carol}", f.carol}, {"{dave}", f.dave}} {
+		if f.sessions[u.placeholder], err = db.CreateSession(u.user.UserID, start); err != nil {
+			t.Fatalf("creating the session of %s: %v", u.user.Username, err)
+		}
+	}
+	return f
+}
+
+// replacers returns the replacer of the fixture placeholders (e.g., `{alice}`) with the IDs, and the one of the IDs
+// with the placeholders.
+func (f handlerFixture) replacers() (toIDs, toPlaceholders *strings.Replacer) {
+	var pairs = []string{
+		"{alice}", f.alice.UserID,
+		"{bob}", f.bob.UserID,
+		"{carol}", f.carol.UserID,
+		"{dave}", f.dave.UserID,
+		"{direct}", f.direct.ConversationID,
+		"{group}", f.group.ConversationID,
+		"{old-message}", f.oldMessage.MessageID,
+		"{recent-message}", f.recentMessage.MessageID,
+		"{invite}", f.invite.Token,
+		"{expired-invite}", f.expiredInvite.Token,
+		"{single-use-invite}", f.singleUseInvite.Token,
+		"{webhook}", f.webhook.WebhookID,
+		"{dead-delivery}", f.deadDelivery.DeliveryID,
+		"{bot}", f.bot.UserID,
+		"{bot-key}", f.botKey.Key,
+		"{bot-key-id}", f.botKey.KeyID,
+		"{incoming}", f.incoming.Token,
+		"{poll}", f.poll.MessageID,
+	}
+	var reversed = make([]string, len(pairs))
+	for i := 0; i < len(pairs); i += 2 {
+		reversed[i], reversed[i+1] = pairs[i+1], pairs[i]
+	}
+	return strings.NewReplacer(pairs...), strings.NewReplacer(reversed...)
+}
+
+// handlerCase is a request of TestHandlers. Path, token and body can contain the fixture placeholders. The response
+// is compared with testdata/handlers/<name>.golden.
+type handlerCase struct {
+	name                      string
+	method, path, token, body string
+	status                    int
+}
+
+// handlerCases run in order, on the same database: the requests changing the data come after the ones reading it.
+var handlerCases = []handlerCase{
+	{"hello", http.MethodGet, "/", "", "", http.StatusOK},
+	{"liveness", http.MethodGet, "/liveness", "", "", http.StatusOK},
+
+	// Users
+	{"session-create", http.MethodPost, "/user/session?username=erin", "", "", http.StatusOK},
+	{"session-missing-username", http.MethodPost, "/user/session", "", "", http.StatusBadRequest},
+	{"session-duplicate-username", http.MethodPost, "/user/session?username=alice", "", "", http.StatusConflict},
+	{"users-list", http.MethodGet, "/users", "", "", http.StatusOK},
+	{"users-first-page", http.MethodGet, "/users?limit=2", "", "", http.StatusOK},
+	{"users-invalid-limit", http.MethodGet, "/users?limit=0", "", "", http.StatusBadRequest},
+	{"users-invalid-cursor", http.MethodGet, "/users?cursor=invalid", "", "", http.StatusBadRequest},
+	{"user-get", http.MethodGet, "/users/{bob}", "", "", http.StatusOK},
+	{"user-unknown", http.MethodGet, "/users/unknown", "", "", http.StatusNotFound},
+
+	// Presence and privacy
+	{"presence-get", http.MethodGet, "/users/{bob}/presence", "{alice}", "", http.StatusOK},
+	{"presence-unknown-user", http.MethodGet, "/users/unknown/presence", "{alice}", "", http.StatusNotFound},
+	{"presence-unauthorized", http.MethodGet, "/users/{bob}/presence", "", "", http.StatusUnauthorized},
+	{"privacy-get", http.MethodGet, "/user/privacy", "{bob}", "", http.StatusOK},
+	{"privacy-unauthorized", http.MethodGet, "/user/privacy", "", "", http.StatusUnauthorized},
+	{"privacy-set", http.MethodPut, "/user/privacy", "{bob}", `{"hideLastSeen":true}`, http.StatusOK},
+	{"privacy-set-invalid-body", http.MethodPut, "/user/privacy", "{bob}", `not json`, http.StatusBadRequest},
+	{"privacy-set-unauthorized", http.MethodPut, "/user/privacy", "", `{"hideLastSeen":true}`, http.StatusUnauthorized},
+
+	// Push subscriptions (the notifications are tested in push_test.go)
+	{"push-subscribe", http.MethodPost, "/user/push-subscriptions", "{bob}", `{"endpoint":"https://push.example/bob","keys":{"p256dh":"BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4","auth":"BTBZMqHH6r4Tts7J_aSIgg"}}`, http.StatusOK},
+	{"push-subscribe-invalid-endpoint", http.MethodPost, "/user/push-subscriptions", "{bob}", `{"endpoint":"http://push.example/bob","keys":{"p256dh":"BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4","auth":"BTBZMqHH6r4Tts7J_aSIgg"}}`, http.StatusBadRequest},
+	{"push-subscribe-internal-endpoint", http.MethodPost, "/user/push-subscriptions", "{bob}", `{"endpoint":"https://169.254.169.254/bob","keys":{"p256dh":"BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4","auth":"BTBZMqHH6r4Tts7J_aSIgg"}}`, http.StatusBadRequest},
+	{"push-subscribe-invalid-keys", http.MethodPost, "/user/push-subscriptions", "{bob}", `{"endpoint":"https://push.example/bob","keys":{"p256dh":"AAAA","auth":"BTBZMqHH6r4Tts7J_aSIgg"}}`, http.StatusBadRequest},
+	{"push-subscribe-invalid-body", http.MethodPost, "/user/push-subscriptions", "{bob}", `not json`, http.StatusBadRequest},
+	{"push-subscribe-unauthorized", http.MethodPost, "/user/push-subscriptions", "", `{"endpoint":"https://push.example/bob","keys":{"p256dh":"BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4","auth":"BTBZMqHH6r4Tts7J_aSIgg"}}`, http.StatusUnauthorized},
+	{"push-subscriptions", http.MethodGet, "/user/push-subscriptions", "{bob}", "", http.StatusOK},
+	{"push-subscriptions-none", http.MethodGet, "/user/push-subscriptions", "{alice}", "", http.StatusOK},
+	{"push-subscriptions-unauthorized", http.MethodGet, "/user/push-subscriptions", "", "", http.StatusUnauthorized},
+	{"push-unsubscribe-unknown", http.MethodDelete, "/user/push-subscriptions/unknown", "{bob}", "", http.StatusNotFound},
+	{"push-unsubscribe-unauthorized", http.MethodDelete, "/user/push-subscriptions/unknown", "", "", http.StatusUnauthorized},
+	{"push-vapid-key-disabled", http.MethodGet, "/push/vapid-key", "{bob}", "", http.StatusNotFound},
+	{"push-vapid-key-unauthorized", http.MethodGet, "/push/vapid-key", "", "", http.StatusUnauthorized},
+	{"presence-hidden-last-seen", http.MethodGet, "/users/{bob}/presence", "{alice}", "", http.StatusOK},
+
+	// Conversations
+	{"conversations-list", http.MethodGet, "/conversations", "{alice}", "", http.StatusOK},
+	{"conversations-first-page", http.MethodGet, "/conversations?limit=1", "{alice}", "", http.StatusOK},
+	{"conversations-unauthorized", http.MethodGet, "/conversations", "", "", http.StatusUnauthorized},
+	{"conversations-invalid-token", http.MethodGet, "/conversations", "unknown", "", http.StatusUnauthorized},
+	{"conversation-get", http.MethodGet, "/conversations/{group}", "{carol}", "", http.StatusOK},
+	{"conversation-not-member", http.MethodGet, "/conversations/{direct}", "{carol}", "", http.StatusForbidden},
+	{"conversation-unknown", http.MethodGet, "/conversations/unknown", "{alice}", "", http.StatusNotFound},
+	{"conversation-create", http.MethodPost, "/conversation", "{alice}", `{"user_ids":["{alice}","{carol}"]}`, http.StatusOK},
+	{"conversation-create-group", http.MethodPost, "/conversation", "{bob}", `{"user_ids":["{bob}","{carol}","{dave}"],"is_group":true,"group_name":"climbing"}`, http.StatusOK},
+	{"conversation-invalid-body", http.MethodPost, "/conversation", "{alice}", `not json`, http.StatusBadRequest},
+	{"conversation-missing-user-ids", http.MethodPost, "/conversation", "{alice}", `{}`, http.StatusBadRequest},
+	{"conversation-one-user", http.MethodPost, "/conversation", "{alice}", `{"user_ids":["{alice}"]}`, http.StatusBadRequest},
+	{"conversation-group-without-flag", http.MethodPost, "/conversation", "{alice}", `{"user_ids":["{alice}","{bob}","{carol}"]}`, http.StatusBadRequest},
+	{"conversation-unknown-user", http.MethodPost, "/conversation", "{alice}", `{"user_ids":["{alice}","unknown"]}`, http.StatusNotFound},
+	{"conversation-unauthorized", http.MethodPost, "/conversation", "", `{"user_ids":["{alice}","{carol}"]}`, http.StatusUnauthorized},
+	{"conversation-without-caller", http.MethodPost, "/conversation", "{alice}", `{"user_ids":["{bob}","{carol}"]}`, http.StatusForbidden},
+	{"settings-pin", http.MethodPatch, "/conversations/{direct}/settings", "{alice}", `{"pinned":true}`, http.StatusOK},
+	{"settings-mute", http.MethodPatch, "/conversations/{group}/settings", "{alice}", `{"muted_until":"2024-05-02T12:00:00Z"}`, http.StatusOK},
+	{"settings-archive", http.MethodPatch, "/conversations/{group}/settings", "{alice}", `{"archived":true}`, http.StatusOK},
+	{"settings-mute-past", http.MethodPatch, "/conversations/{direct}/settings", "{alice}", `{"muted_until":"2024-05-01T11:00:00Z"}`, http.StatusOK},
+	{"settings-invalid-muted-until", http.MethodPatch, "/conversations/{group}/settings", "{alice}", `{"muted_until":"tomorrow"}`, http.StatusBadRequest},
+	{"settings-invalid-body", http.MethodPatch, "/conversations/{group}/settings", "{alice}", `not json`, http.StatusBadRequest},
+	{"settings-not-member", http.MethodPatch, "/conversations/{direct}/settings", "{carol}", `{"pinned":true}`, http.StatusForbidden},
+	{"settings-unknown", http.MethodPatch, "/conversations/unknown/settings", "{alice}", `{"pinned":true}`, http.StatusNotFound},
+	{"settings-unauthorized", http.MethodPatch, "/conversations/{group}/settings", "", `{"pinned":true}`, http.StatusUnauthorized},
+	{"conversations-pinned-first", http.MethodGet, "/conversations", "{alice}", "", http.StatusOK},
+	{"conversations-include-archived", http.MethodGet, "/conversations?include_archived=true", "{alice}", "", http.StatusOK},
+	{"conversations-include-archived-invalid", http.MethodGet, "/conversations?include_archived=maybe", "{alice}", "", http.StatusBadRequest},
+	{"settings-unarchive-unmute", http.MethodPatch, "/conversations/{group}/settings", "{alice}", `{"archived":false,"muted_until":null}`, http.StatusOK},
+
+	// Messages
+	{"messages-list", http.MethodGet, "/conversations/{direct}/messages", "{bob}", "", http.StatusOK},
+	{"messages-first-page", http.MethodGet, "/conversations/{direct}/messages?limit=2", "{bob}", "", http.StatusOK},
+	{"messages-empty", http.MethodGet, "/conversations/{group}/messages", "{bob}", "", http.StatusOK},
+	{"messages-invalid-cursor", http.MethodGet, "/conversations/{direct}/messages?cursor=invalid", "{bob}", "", http.StatusBadRequest},
+	{"messages-not-member", http.MethodGet, "/conversations/{direct}/messages", "{carol}", "", http.StatusForbidden},
+	{"messages-unknown-conversation", http.MethodGet, "/conversations/unknown/messages", "{bob}", "", http.StatusNotFound},
+	{"messages-unauthorized", http.MethodGet, "/conversations/{direct}/messages", "", "", http.StatusUnauthorized},
+	{"message-send", http.MethodPost, "/conversations/{direct}/messages", "{bob}", `{"content":"Sent without a websocket"}`, http.StatusOK},
+	{"message-send-empty", http.MethodPost, "/conversations/{direct}/messages", "{bob}", `{"content":" "}`, http.StatusBadRequest},
+	{"message-send-invalid-body", http.MethodPost, "/conversations/{direct}/messages", "{bob}", `not json`, http.StatusBadRequest},
+	{"message-send-not-member", http.MethodPost, "/conversations/{direct}/messages", "{carol}", `{"content":"hi"}`, http.StatusForbidden},
+	{"message-send-unknown-conversation", http.MethodPost, "/conversations/unknown/messages", "{bob}", `{"content":"hi"}`, http.StatusNotFound},
+	{"message-send-unauthorized", http.MethodPost, "/conversations/{direct}/messages", "", `{"content":"hi"}`, http.StatusUnauthorized},
+	{"commands-group", http.MethodGet, "/conversations/{group}/commands", "{alice}", "", http.StatusOK},
+	{"commands-direct", http.MethodGet, "/conversations/{direct}/commands", "{bob}", "", http.StatusOK},
+	{"commands-not-member", http.MethodGet, "/conversations/{direct}/commands", "{carol}", "", http.StatusForbidden},
+	{"commands-unauthorized", http.MethodGet, "/conversations/{group}/commands", "", "", http.StatusUnauthorized},
+
+	// Polls
+	{"poll-create", http.MethodPost, "/conversations/{group}/polls", "{carol}", `{"question":"Where do we climb?","options":["Arco","Finale"],"multiple_choice":true,"anonymous":true}`, http.StatusOK},
+	{"poll-create-one-option", http.MethodPost, "/conversations/{group}/polls", "{carol}", `{"question":"Where do we climb?","options":["Arco"]}`, http.StatusBadRequest},
+	{"poll-create-missing-question", http.MethodPost, "/conversations/{group}/polls", "{carol}", `{"options":["Arco","Finale"]}`, http.StatusBadRequest},
+	{"poll-create-invalid-body", http.MethodPost, "/conversations/{group}/polls", "{carol}", `not json`, http.StatusBadRequest},
+	{"poll-create-not-member", http.MethodPost, "/conversations/{direct}/polls", "{carol}", `{"question":"Where do we climb?","options":["Arco","Finale"]}`, http.StatusForbidden},
+	{"poll-create-unauthorized", http.MethodPost, "/conversations/{group}/polls", "", `{"question":"Where do we climb?","options":["Arco","Finale"]}`, http.StatusUnauthorized},
+	{"poll-vote", http.MethodPut, "/conversations/{direct}/messages/{poll}/votes", "{bob}", `{"options":[1]}`, http.StatusOK},
+	{"poll-vote-change", http.MethodPut, "/conversations/{direct}/messages/{poll}/votes", "{bob}", `{"options":[0]}`, http.StatusOK},
+	{"poll-vote-single-choice", http.MethodPut, "/conversations/{direct}/messages/{poll}/votes", "{alice}", `{"options":[0,1]}`, http.StatusBadRequest},
+	{"poll-vote-unknown-option", http.MethodPut, "/conversations/{direct}/messages/{poll}/votes", "{alice}", `{"options":[2]}`, http.StatusBadRequest},
+	{"poll-vote-no-option", http.MethodPut, "/conversations/{direct}/messages/{poll}/votes", "{alice}", `{"options":[]}`, http.StatusBadRequest},
+	{"poll-vote-invalid-body", http.MethodPut, "/conversations/{direct}/messages/{poll}/votes", "{alice}", `not json`, http.StatusBadRequest},
+	{"poll-vote-not-a-poll", http.MethodPut, "/conversations/{direct}/messages/{old-message}/votes", "{alice}", `{"options":[0]}`, http.StatusNotFound},
+	{"poll-vote-unknown-message", http.MethodPut, "/conversations/{direct}/messages/unknown/votes", "{alice}", `{"options":[0]}`, http.StatusNotFound},
+	{"poll-vote-not-member", http.MethodPut, "/conversations/{direct}/messages/{poll}/votes", "{carol}", `{"options":[0]}`, http.StatusForbidden},
+	{"poll-vote-unauthorized", http.MethodPut, "/conversations/{direct}/messages/{poll}/votes", "", `{"options":[0]}`, http.StatusUnauthorized},
+	{"poll-vote-second-voter", http.MethodPut, "/conversations/{direct}/messages/{poll}/votes", "{alice}", `{"options":[1]}`, http.StatusOK},
+	{"messages-with-poll", http.MethodGet, "/conversations/{direct}/messages", "{bob}", "", http.StatusOK},
+	{"poll-retract", http.MethodDelete, "/conversations/{direct}/messages/{poll}/votes", "{alice}", "", http.StatusNoContent},
+	{"poll-retract-not-a-poll", http.MethodDelete, "/conversations/{direct}/messages/{old-message}/votes", "{alice}", "", http.StatusNotFound},
+	{"poll-retract-not-member", http.MethodDelete, "/conversations/{direct}/messages/{poll}/votes", "{carol}", "", http.StatusForbidden},
+	{"poll-retract-unauthorized", http.MethodDelete, "/conversations/{direct}/messages/{poll}/votes", "", "", http.StatusUnauthorized},
+	{"poll-close-not-creator", http.MethodPost, "/conversations/{direct}/messages/{poll}/close", "{bob}", "", http.StatusForbidden},
+	{"poll-close-not-a-poll", http.MethodPost, "/conversations/{direct}/messages/{old-message}/close", "{alice}", "", http.StatusNotFound},
+	{"poll-close-not-member", http.MethodPost, "/conversations/{direct}/messages/{poll}/close", "{carol}", "", http.StatusForbidden},
+	{"poll-close-unauthorized", http.MethodPost, "/conversations/{direct}/messages/{poll}/close", "", "", http.StatusUnauthorized},
+	{"poll-close", http.MethodPost, "/conversations/{direct}/messages/{poll}/close", "{alice}", "", http.StatusOK},
+	{"poll-close-again", http.MethodPost, "/conversations/{direct}/messages/{poll}/close", "{alice}", "", http.StatusOK},
+	{"poll-vote-closed", http.MethodPut, "/conversations/{direct}/messages/{poll}/votes", "{bob}", `{"options":[1]}`, http.StatusConflict},
+	{"poll-retract-closed", http.MethodDelete, "/conversations/{direct}/messages/{poll}/votes", "{bob}", "", http.StatusConflict},
+	{"poll-edit", http.MethodPatch, "/conversations/{direct}/messages/{poll}", "{alice}", `{"content":"Pizza?"}`, http.StatusForbidden},
+
+	// Message edits
+	{"message-history-not-edited", http.MethodGet, "/conversations/{direct}/messages/{recent-message}/history", "{alice}", "", http.StatusOK},
+	{"message-edit", http.MethodPatch, "/conversations/{direct}/messages/{recent-message}", "{bob}", `{"content":"See you tomorrow"}`, http.StatusOK},
+	{"message-edit-same-content", http.MethodPatch, "/conversations/{direct}/messages/{recent-message}", "{bob}", `{"content":"See you tomorrow"}`, http.StatusOK},
+	{"message-edit-not-author", http.MethodPatch, "/conversations/{direct}/messages/{recent-message}", "{alice}", `{"content":"See you never"}`, http.StatusForbidden},
+	{"message-edit-too-old", http.MethodPatch, "/conversations/{direct}/messages/{old-message}", "{alice}", `{"content":"Hi Robert!"}`, http.StatusForbidden},
+	{"message-edit-empty-content", http.MethodPatch, "/conversations/{direct}/messages/{recent-message}", "{bob}", `{"content":" "}`, http.StatusBadRequest},
+	{"message-edit-invalid-body", http.MethodPatch, "/conversations/{direct}/messages/{recent-message}", "{bob}", `not json`, http.StatusBadRequest},
+	{"message-edit-unknown-message", http.MethodPatch, "/conversations/{direct}/messages/unknown", "{bob}", `{"content":"Hello"}`, http.StatusNotFound},
+	{"message-edit-other-conversation", http.MethodPatch, "/conversations/{group}/messages/{recent-message}", "{bob}", `{"content":"Hello"}`, http.StatusNotFound},
+	{"message-edit-not-member", http.MethodPatch, "/conversations/{direct}/messages/{recent-message}", "{carol}", `{"content":"Hello"}`, http.StatusForbidden},
+	{"message-edit-unauthorized", http.MethodPatch, "/conversations/{direct}/messages/{recent-message}", "", `{"content":"Hello"}`, http.StatusUnauthorized},
+	{"message-history", http.MethodGet, "/conversations/{direct}/messages/{recent-message}/history", "{alice}", "", http.StatusOK},
+	{"message-history-unknown-message", http.MethodGet, "/conversations/{direct}/messages/unknown/history", "{alice}", "", http.StatusNotFound},
+	{"message-history-not-member", http.MethodGet, "/conversations/{direct}/messages/{recent-message}/history", "{carol}", "", http.StatusForbidden},
+	{"message-history-unauthorized", http.MethodGet, "/conversations/{direct}/messages/{recent-message}/history", "", "", http.StatusUnauthorized},
+	{"messages-after-edit", http.MethodGet, "/conversations/{direct}/messages", "{alice}", "", http.StatusOK},
+
+	// Message deletions
+	{"message-delete-invalid-mode", http.MethodDelete, "/conversations/{direct}/messages/{recent-message}?for=nobody", "{bob}", "", http.StatusBadRequest},
+	{"message-delete-for-everyone-not-author", http.MethodDelete, "/conversations/{direct}/messages/{recent-message}?for=everyone", "{alice}", "", http.StatusForbidden},
+	{"message-delete-for-everyone-too-old", http.MethodDelete, "/conversations/{direct}/messages/{old-message}?for=everyone", "{alice}", "", http.StatusForbidden},
+	{"message-delete-unknown-message", http.MethodDelete, "/conversations/{direct}/messages/unknown", "{bob}", "", http.StatusNotFound},
+	{"message-delete-not-member", http.MethodDelete, "/conversations/{direct}/messages/{old-message}", "{carol}", "", http.StatusForbidden},
+	{"message-delete-unauthorized", http.MethodDelete, "/conversations/{direct}/messages/{old-message}", "", "", http.StatusUnauthorized},
+	{"message-delete-for-me", http.MethodDelete, "/conversations/{direct}/messages/{old-message}", "{bob}", "", http.StatusNoContent},
+	{"messages-after-delete-for-me", http.MethodGet, "/conversations/{direct}/messages", "{bob}", "", http.StatusOK},
+	{"message-delete-for-everyone", http.MethodDelete, "/conversations/{direct}/messages/{recent-message}?for=everyone", "{bob}", "", http.StatusNoContent},
+	{"message-delete-for-everyone-again", http.MethodDelete, "/conversations/{direct}/messages/{recent-message}?for=everyone", "{bob}", "", http.StatusNoContent},
+	{"message-edit-deleted", http.MethodPatch, "/conversations/{direct}/messages/{recent-message}", "{bob}", `{"content":"Hello again"}`, http.StatusForbidden},
+	{"message-history-deleted", http.MethodGet, "/conversations/{direct}/messages/{recent-message}/history", "{alice}", "", http.StatusOK},
+	{"messages-after-delete-for-everyone", http.MethodGet, "/conversations/{direct}/messages", "{alice}", "", http.StatusOK},
+
+	// Groups: alice owns the group, bob and carol are members
+	{"group-update-not-admin", http.MethodPatch, "/conversations/{group}", "{bob}", `{"group_name":"enemies"}`, http.StatusForbidden},
+	{"group-update-empty-name", http.MethodPatch, "/conversations/{group}", "{alice}", `{"group_name":" "}`, http.StatusBadRequest},
+	{"group-update-invalid-body", http.MethodPatch, "/conversations/{group}", "{alice}", `not json`, http.StatusBadRequest},
+	{"group-update-not-group", http.MethodPatch, "/conversations/{direct}", "{alice}", `{"group_name":"us"}`, http.StatusBadRequest},
+	{"group-update-not-member", http.MethodPatch, "/conversations/{group}", "{dave}", `{"group_name":"us"}`, http.StatusForbidden},
+	{"group-update-unauthorized", http.MethodPatch, "/conversations/{group}", "", `{"group_name":"us"}`, http.StatusUnauthorized},
+	{"group-update", http.MethodPatch, "/conversations/{group}", "{alice}", `{"group_name":"best friends","group_photo":"https://example.com/friends.jpg"}`, http.StatusOK},
+	{"group-add-members-not-admin", http.MethodPost, "/conversations/{group}/members", "{bob}", `{"user_ids":["{dave}"]}`, http.StatusForbidden},
+	{"group-promote-not-admin", http.MethodPut, "/conversations/{group}/members/{bob}/role", "{carol}", `{"role":"admin"}`, http.StatusForbidden},
+	{"group-promote", http.MethodPut, "/conversations/{group}/members/{bob}/role", "{alice}", `{"role":"admin"}`, http.StatusOK},
+	{"group-add-members", http.MethodPost, "/conversations/{group}/members", "{bob}", `{"user_ids":["{dave}"]}`, http.StatusOK},
+	{"group-add-members-already-member", http.MethodPost, "/conversations/{group}/members", "{bob}", `{"user_ids":["{carol}"]}`, http.StatusConflict},
+	{"group-add-members-unknown-user", http.MethodPost, "/conversations/{group}/members", "{bob}", `{"user_ids":["unknown"]}`, http.StatusNotFound},
+	{"group-add-members-empty", http.MethodPost, "/conversations/{group}/members", "{bob}", `{"user_ids":[]}`, http.StatusBadRequest},
+	{"group-add-members-unauthorized", http.MethodPost, "/conversations/{group}/members", "", `{"user_ids":["{dave}"]}`, http.StatusUnauthorized},
+	{"group-set-role-invalid", http.MethodPut, "/conversations/{group}/members/{carol}/role", "{alice}", `{"role":"king"}`, http.StatusBadRequest},
+	{"group-set-role-unknown-member", http.MethodPut, "/conversations/{group}/members/unknown/role", "{alice}", `{"role":"admin"}`, http.StatusNotFound},
+	{"group-set-role-unauthorized", http.MethodPut, "/conversations/{group}/members/{carol}/role", "", `{"role":"admin"}`, http.StatusUnauthorized},
+	{"group-demote-not-owner", http.MethodPut, "/conversations/{group}/members/{bob}/role", "{bob}", `{"role":"member"}`, http.StatusForbidden},
+	{"group-transfer-not-owner", http.MethodPut, "/conversations/{group}/members/{bob}/role", "{bob}", `{"role":"owner"}`, http.StatusForbidden},
+	{"group-remove-owner-by-admin", http.MethodDelete, "/conversations/{group}/members/{alice}", "{bob}", "", http.StatusForbidden},
+	{"group-remove-by-member", http.MethodDelete, "/conversations/{group}/members/{dave}", "{carol}", "", http.StatusForbidden},
+	{"group-remove-by-admin", http.MethodDelete, "/conversations/{group}/members/{dave}", "{bob}", "", http.StatusNoContent},
+	{"group-remove-not-member", http.MethodDelete, "/conversations/{group}/members/{dave}", "{bob}", "", http.StatusNotFound},
+	{"group-remove-unauthorized", http.MethodDelete, "/conversations/{group}/members/{carol}", "", "", http.StatusUnauthorized},
+	{"group-transfer-ownership", http.MethodPut, "/conversations/{group}/members/{carol}/role", "{alice}", `{"role":"owner"}`, http.StatusOK},
+	{"group-leave-owner", http.MethodDelete, "/conversations/{group}/members/{carol}", "{carol}", "", http.StatusNoContent},
+	{"group-after-owner-left", http.MethodGet, "/conversations/{group}", "{alice}", "", http.StatusOK},
+	{"group-demote", http.MethodPut, "/conversations/{group}/members/{bob}/role", "{alice}", `{"role":"member"}`, http.StatusOK},
+
+	// Invites: alice owns the group, bob is a member
+	{"invite-create-not-admin", http.MethodPost, "/conversations/{group}/invites", "{bob}", `{}`, http.StatusForbidden},
+	{"invite-create-past-expiry", http.MethodPost, "/conversations/{group}/invites", "{alice}", `{"expires_at":"2024-05-01T11:00:00Z"}`, http.StatusBadRequest},
+	{"invite-create-negative-max-uses", http.MethodPost, "/conversations/{group}/invites", "{alice}", `{"max_uses":-1}`, http.StatusBadRequest},
+	{"invite-create-invalid-body", http.MethodPost, "/conversations/{group}/invites", "{alice}", `not json`, http.StatusBadRequest},
+	{"invite-create-not-group", http.MethodPost, "/conversations/{direct}/invites", "{alice}", `{}`, http.StatusBadRequest},
+	{"invite-create-unauthorized", http.MethodPost, "/conversations/{group}/invites", "", `{}`, http.StatusUnauthorized},
+	{"invite-create", http.MethodPost, "/conversations/{group}/invites", "{alice}", `{"expires_at":"2024-05-02T12:00:00Z","max_uses":5}`, http.StatusOK},
+	{"invites-list", http.MethodGet, "/conversations/{group}/invites", "{alice}", "", http.StatusOK},
+	{"invites-list-not-admin", http.MethodGet, "/conversations/{group}/invites", "{bob}", "", http.StatusForbidden},
+	{"invites-list-unauthorized", http.MethodGet, "/conversations/{group}/invites", "", "", http.StatusUnauthorized},
+	{"invite-join-expired", http.MethodPost, "/invites/{expired-invite}/join", "{carol}", "", http.StatusGone},
+	{"invite-join-single-use", http.MethodPost, "/invites/{single-use-invite}/join", "{carol}", "", http.StatusOK},
+	{"invite-join-used-up", http.MethodPost, "/invites/{single-use-invite}/join", "{dave}", "", http.StatusGone},
+	{"invite-join", http.MethodPost, "/invites/{invite}/join", "{dave}", "", http.StatusOK},
+	{"invite-join-already-member", http.MethodPost, "/invites/{invite}/join", "{dave}", "", http.StatusConflict},
+	{"invite-join-unknown", http.MethodPost, "/invites/unknown/join", "{dave}", "", http.StatusNotFound},
+	{"invite-join-unauthorized", http.MethodPost, "/invites/{invite}/join", "", "", http.StatusUnauthorized},
+	{"invite-joins", http.MethodGet, "/conversations/{group}/invites/{invite}/joins", "{alice}", "", http.StatusOK},
+	{"invite-joins-unknown-invite", http.MethodGet, "/conversations/{group}/invites/unknown/joins", "{alice}", "", http.StatusNotFound},
+	{"invite-joins-not-admin", http.MethodGet, "/conversations/{group}/invites/{invite}/joins", "{dave}", "", http.StatusForbidden},
+	{"invite-joins-unauthorized", http.MethodGet, "/conversations/{group}/invites/{invite}/joins", "", "", http.StatusUnauthorized},
+	{"invite-revoke-not-admin", http.MethodDelete, "/conversations/{group}/invites/{invite}", "{dave}", "", http.StatusForbidden},
+	{"invite-revoke-unknown-invite", http.MethodDelete, "/conversations/{group}/invites/unknown", "{alice}", "", http.StatusNotFound},
+	{"invite-revoke-unauthorized", http.MethodDelete, "/conversations/{group}/invites/{invite}", "", "", http.StatusUnauthorized},
+	{"invite-revoke", http.MethodDelete, "/conversations/{group}/invites/{invite}", "{alice}", "", http.StatusNoContent},
+	{"invite-leave-after-join", http.MethodDelete, "/conversations/{group}/members/{dave}", "{dave}", "", http.StatusNoContent},
+	{"invite-join-revoked", http.MethodPost, "/invites/{invite}/join", "{dave}", "", http.StatusGone},
+	{"invites-after-revoke", http.MethodGet, "/conversations/{group}/invites", "{alice}", "", http.StatusOK},
+	{"group-system-messages", http.MethodGet, "/conversations/{group}/messages", "{alice}", "", http.StatusOK},
+
+	// Webhooks (the deliveries are tested in the webhooks package; the dispatcher does not run here, so the events
+	// of the direct conversation stay queued)
+	{"webhook-create", http.MethodPost, "/conversations/{group}/webhooks", "{alice}", `{"url":"https://ci.example.com/hooks/chat","events":["member.joined","member.left"]}`, http.StatusOK},
+	{"webhook-create-direct", http.MethodPost, "/conversations/{direct}/webhooks", "{bob}", `{"url":"https://bot.example.com/events"}`, http.StatusOK},
+	{"webhook-create-not-admin", http.MethodPost, "/conversations/{group}/webhooks", "{bob}", `{"url":"https://ci.example.com/hooks/chat"}`, http.StatusForbidden},
+	{"webhook-create-not-member", http.MethodPost, "/conversations/{direct}/webhooks", "{carol}", `{"url":"https://ci.example.com/hooks/chat"}`, http.StatusForbidden},
+	{"webhook-create-invalid-url", http.MethodPost, "/conversations/{group}/webhooks", "{alice}", `{"url":"ftp://ci.example.com"}`, http.StatusBadRequest},
+	{"webhook-create-internal-url", http.MethodPost, "/conversations/{group}/webhooks", "{alice}", `{"url":"http://169.254.169.254/latest/meta-data"}`, http.StatusBadRequest},
+	{"webhook-create-unknown-event", http.MethodPost, "/conversations/{group}/webhooks", "{alice}", `{"url":"https://ci.example.com","events":["message.read"]}`, http.StatusBadRequest},
+	{"webhook-create-invalid-body", http.MethodPost, "/conversations/{group}/webhooks", "{alice}", `not json`, http.StatusBadRequest},
+	{"webhook-create-unauthorized", http.MethodPost, "/conversations/{group}/webhooks", "", `{"url":"https://ci.example.com/hooks/chat"}`, http.StatusUnauthorized},
+	{"webhooks-list", http.MethodGet, "/conversations/{direct}/webhooks", "{alice}", "", http.StatusOK},
+	{"webhooks-list-not-admin", http.MethodGet, "/conversations/{group}/webhooks", "{bob}", "", http.StatusForbidden},
+	{"webhooks-list-unauthorized", http.MethodGet, "/conversations/{group}/webhooks", "", "", http.StatusUnauthorized},
+	{"webhook-deliveries", http.MethodGet, "/conversations/{direct}/webhooks/{webhook}/deliveries", "{alice}", "", http.StatusOK},
+	{"webhook-deliveries-first-page", http.MethodGet, "/conversations/{direct}/webhooks/{webhook}/deliveries?limit=1", "{alice}", "", http.StatusOK},
+	{"webhook-deliveries-unknown-webhook", http.MethodGet, "/conversations/{direct}/webhooks/unknown/deliveries", "{alice}", "", http.StatusNotFound},
+	{"webhook-deliveries-other-conversation", http.MethodGet, "/conversations/{group}/webhooks/{webhook}/deliveries", "{alice}", "", http.StatusNotFound},
+	{"webhook-deliveries-unauthorized", http.MethodGet, "/conversations/{direct}/webhooks/{webhook}/deliveries", "", "", http.StatusUnauthorized},
+	{"webhook-retry", http.MethodPost, "/conversations/{direct}/webhooks/{webhook}/deliveries/{dead-delivery}/retry", "{bob}", "", http.StatusOK},
+	{"webhook-retry-not-dead", http.MethodPost, "/conversations/{direct}/webhooks/{webhook}/deliveries/{dead-delivery}/retry", "{bob}", "", http.StatusConflict},
+	{"webhook-retry-unknown-delivery", http.MethodPost, "/conversations/{direct}/webhooks/{webhook}/deliveries/unknown/retry", "{bob}", "", http.StatusNotFound},
+	{"webhook-retry-unauthorized", http.MethodPost, "/conversations/{direct}/webhooks/{webhook}/deliveries/{dead-delivery}/retry", "", "", http.StatusUnauthorized},
+	{"webhook-delete-not-admin", http.MethodDelete, "/conversations/{group}/webhooks/unknown", "{bob}", "", http.StatusForbidden},
+	{"webhook-delete-unknown", http.MethodDelete, "/conversations/{direct}/webhooks/unknown", "{alice}", "", http.StatusNotFound},
+	{"webhook-delete-unauthorized", http.MethodDelete, "/conversations/{direct}/webhooks/{webhook}", "", "", http.StatusUnauthorized},
+	{"webhook-delete", http.MethodDelete, "/conversations/{direct}/webhooks/{webhook}", "{alice}", "", http.StatusNoContent},
+	{"webhook-deliveries-after-delete", http.MethodGet, "/conversations/{direct}/webhooks/{webhook}/deliveries", "{alice}", "", http.StatusNotFound},
+
+	// WhatsApp import
+	{"import", http.MethodPost, "/conversation/import", "{alice}", `{"chat":"31/12/2020, 21:41 - Alice: Happy new year!\n01/01/2021, 00:02 - Bob: <Media omitted>\n01/01/2021, 00:03 - Frank: Cheers","self":"Alice","timezone":"Europe/Rome"}`, http.StatusOK},
+	{"import-not-an-export", http.MethodPost, "/conversation/import", "{alice}", `{"chat":"not an export"}`, http.StatusBadRequest},
+	{"import-invalid-body", http.MethodPost, "/conversation/import", "{alice}", `not json`, http.StatusBadRequest},
+	{"import-unknown-participant", http.MethodPost, "/conversation/import", "{alice}", `{"chat":"31/12/2020, 21:41 - Alice: Hi","participants":{"Eve":"alice"}}`, http.StatusBadRequest},
+	{"import-other-user", http.MethodPost, "/conversation/import", "{alice}", `{"chat":"31/12/2020, 21:41 - Bob: Hi","participants":{"Bob":"bob"}}`, http.StatusForbidden},
+	{"import-self-alias", http.MethodPost, "/conversation/import", "{alice}", `{"chat":"31/12/2020, 21:41 - Ali: Hi\n31/12/2020, 21:42 - Bob: Hi","participants":{"Ali":"alice"}}`, http.StatusOK},
+	{"import-unknown-timezone", http.MethodPost, "/conversation/import", "{alice}", `{"chat":"31/12/2020, 21:41 - Alice: Hi","timezone":"Mars/Olympus"}`, http.StatusBadRequest},
+	{"import-invalid-date-order", http.MethodPost, "/conversation/import", "{alice}", `{"chat":"31/12/2020, 21:41 - Alice: Hi","date_order":"DDD"}`, http.StatusBadRequest},
+	{"import-unauthorized", http.MethodPost, "/conversation/import", "", `{"chat":"31/12/2020, 21:41 - Alice: Hi"}`, http.StatusUnauthorized},
+
+	// Export and WebSocket (the upgrade itself is tested in ws_test.go)
+	{"export", http.MethodGet, "/user/export", "{alice}", "", http.StatusOK},
+	{"export-unauthorized", http.MethodGet, "/user/export", "", "", http.StatusUnauthorized},
+	{"ws-not-websocket", http.MethodGet, "/ws", "{alice}", "", http.StatusBadRequest},
+	{"ws-unauthorized", http.MethodGet, "/ws", "", "", http.StatusUnauthorized},
+
+	// Admin
+	{"backup-unauthorized", http.MethodPost, "/admin/backup", "", "", http.StatusUnauthorized},
+	{"backup-user-token", http.MethodPost, "/admin/backup", "{alice}", "", http.StatusUnauthorized},
+	{"backup-not-configured", http.MethodPost, "/admin/backup", "admin-secret", "", http.StatusServiceUnavailable},
+
+	// Bots
+	{"bot-create", http.MethodPost, "/admin/bots", "admin-secret", `{"username":"deploy"}`, http.StatusOK},
+	{"bot-create-duplicate", http.MethodPost, "/admin/bots", "admin-secret", `{"username":"alice"}`, http.StatusConflict},
+	{"bot-create-missing-username", http.MethodPost, "/admin/bots", "admin-secret", `{}`, http.StatusBadRequest},
+	{"bot-create-invalid-body", http.MethodPost, "/admin/bots", "admin-secret", `not json`, http.StatusBadRequest},
+	{"bot-create-user-token", http.MethodPost, "/admin/bots", "{alice}", `{"username":"deploy"}`, http.StatusUnauthorized},
+	{"bots-list", http.MethodGet, "/admin/bots", "admin-secret", "", http.StatusOK},
+	{"bots-list-unauthorized", http.MethodGet, "/admin/bots", "", "", http.StatusUnauthorized},
+	{"bot-key-create", http.MethodPost, "/admin/bots/{bot}/keys", "admin-secret", "", http.StatusOK},
+	{"bot-key-create-not-a-bot", http.MethodPost, "/admin/bots/{alice}/keys", "admin-secret", "", http.StatusNotFound},
+	{"bot-key-create-unauthorized", http.MethodPost, "/admin/bots/{bot}/keys", "{bot-key}", "", http.StatusUnauthorized},
+	{"bot-user-id-token", http.MethodGet, "/conversations", "{bot}", "", http.StatusUnauthorized},
+	{"bot-invalid-key", http.MethodGet, "/conversations", "alk_unknown", "", http.StatusUnauthorized},
+	{"bot-conversations", http.MethodGet, "/conversations", "{bot-key}", "", http.StatusOK},
+	{"bot-keys-list", http.MethodGet, "/admin/bots/{bot}/keys", "admin-secret", "", http.StatusOK},
+	{"bot-keys-list-not-a-bot", http.MethodGet, "/admin/bots/{alice}/keys", "admin-secret", "", http.StatusNotFound},
+	{"bot-keys-list-unauthorized", http.MethodGet, "/admin/bots/{bot}/keys", "", "", http.StatusUnauthorized},
+	{"incoming-webhook-create", http.MethodPost, "/conversations/{group}/incoming-webhooks", "{bot-key}", "", http.StatusOK},
+	{"incoming-webhook-create-not-a-bot", http.MethodPost, "/conversations/{group}/incoming-webhooks", "{alice}", "", http.StatusForbidden},
+	{"incoming-webhook-create-not-member", http.MethodPost, "/conversations/{direct}/incoming-webhooks", "{bot-key}", "", http.StatusForbidden},
+	{"incoming-webhook-create-unauthorized", http.MethodPost, "/conversations/{group}/incoming-webhooks", "", "", http.StatusUnauthorized},
+	{"incoming-webhooks-list", http.MethodGet, "/conversations/{group}/incoming-webhooks", "{bot-key}", "", http.StatusOK},
+	{"incoming-webhooks-list-not-a-bot", http.MethodGet, "/conversations/{group}/incoming-webhooks", "{alice}", "", http.StatusForbidden},
+	{"incoming-message", http.MethodPost, "/incoming-webhooks/{incoming}", "", `{"content":"Build #42 passed"}`, http.StatusOK},
+	{"incoming-message-empty", http.MethodPost, "/incoming-webhooks/{incoming}", "", `{"content":" "}`, http.StatusBadRequest},
+	{"incoming-message-invalid-body", http.MethodPost, "/incoming-webhooks/{incoming}", "", `not json`, http.StatusBadRequest},
+	{"incoming-message-unknown-token", http.MethodPost, "/incoming-webhooks/unknown", "", `{"content":"hi"}`, http.StatusNotFound},
+	{"incoming-webhook-delete-unknown", http.MethodDelete, "/conversations/{group}/incoming-webhooks/unknown", "{bot-key}", "", http.StatusNotFound},
+	{"incoming-webhook-delete-unauthorized", http.MethodDelete, "/conversations/{group}/incoming-webhooks/{incoming}", "", "", http.StatusUnauthorized},
+	{"incoming-webhook-delete", http.MethodDelete, "/conversations/{group}/incoming-webhooks/{incoming}", "{bot-key}", "", http.StatusNoContent},
+	{"incoming-message-after-delete", http.MethodPost, "/incoming-webhooks/{incoming}", "", `{"content":"hi"}`, http.StatusNotFound},
+	{"bot-key-delete-unknown", http.MethodDelete, "/admin/bots/{bot}/keys/unknown", "admin-secret", "", http.StatusNotFound},
+	{"bot-key-delete-unauthorized", http.MethodDelete, "/admin/bots/{bot}/keys/{bot-key-id}", "", "", http.StatusUnauthorized},
+	{"bot-key-delete", http.MethodDelete, "/admin/bots/{bot}/keys/{bot-key-id}", "admin-secret", "", http.StatusNoContent},
+	{"bot-revoked-key", http.MethodGet, "/conversations", "{bot-key}", "", http.StatusUnauthorized},
+
+	// Changes to the users come last
+	{"username-update", http.MethodPost, "/user?userId={bob}&newUsername=robert", "", "", http.StatusOK},
+	{"username-missing-user-id", http.MethodPost, "/user?newUsername=robert", "", "", http.StatusBadRequest},
+	{"username-missing-new-username", http.MethodPost, "/user?userId={bob}", "", "", http.StatusBadRequest},
+	{"username-duplicate", http.MethodPost, "/user?userId={bob}&newUsername=alice", "", "", http.StatusConflict},
+	{"username-unknown-user", http.MethodPost, "/user?userId=unknown&newUsername=zoe", "", "", http.StatusNotFound},
+	{"user-delete", http.MethodDelete, "/user", "{dave}", "", http.StatusNoContent},
+	{"user-delete-unauthorized", http.MethodDelete, "/user", "", "", http.StatusUnauthorized},
+	{"user-delete-deleted", http.MethodDelete, "/user", "{dave}", "", http.StatusUnauthorized},
+	{"users-after-changes", http.MethodGet, "/users", "", "", http.StatusOK},
+}
+
+// TestHandlers drives every route with the requests in handlerCases, comparing the responses with the golden files.
+func TestHandlers(t *testing.T) {
+	globaltime.FixedTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	defer func() { globaltime.FixedTime = time.Time{} }()
+
+	rt := newRouterFor(t, database.NewSequentialMemory())
+	rt.adminToken = "admin-secret"
+	var err error
+	if rt.webhooks, err = webhooks.New(webhooks.Config{Database: rt.db, Logger: rt.baseLogger}); err != nil {
+		t.Fatal(err)
+	}
+	handler := rt.Handler()
+	fixture := newHandlerFixture(t, rt.db)
+	toIDs, toPlaceholders := fixture.replacers()
+
+	var covered = make(map[string]bool)
+	for _, tc := range handlerCases {
+		req := httptest.NewRequest(tc.method, toIDs.Replace(tc.path), strings.NewReader(toIDs.Replace(tc.body)))
+		if session, ok := fixture.sessions[tc.token]; ok {
+			req.Header.Set("Authorization", "Bearer "+session)
+		} else if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+toIDs.Replace(tc.token))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		if w.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tc.name, tc.status, w.Code, w.Body)
+		}
+		checkGolden(t, tc.name, goldenResponse(w, toPlaceholders))
+
+		for _, r := range rt.routes {
+			if r.method == tc.method && matchesRoute(r.path, req.URL.Path) {
+				covered[r.method+" "+r.path] = true
+			}
+		}
+	}
+
+	for _, r := range rt.routes {
+		if !covered[r.method+" "+r.path] {
+			t.Errorf("no case for %s %s", r.method, r.path)
+		}
+	}
+}
+
+// goldenResponse formats the response for the golden files: the status, the content type and the body, with the IDs
+// of the fixture replaced by their placeholders. JSON bodies are indented, binary ones are left out.
+func goldenResponse(w *httptest.ResponseRecorder, toPlaceholders *strings.Replacer) []byte {
+	var b bytes.Buffer
+	contentType := w.Header().Get("Content-Type")
+	fmt.Fprintf(&b, "%d %s\nContent-Type: %s\n\n", w.Code, http.StatusText(w.Code), contentType)
+
+	body := w.Body.Bytes()
+	var indented bytes.Buffer
+	switch {
+	case len(body) == 0:
+	case json.Valid(body) && json.Indent(&indented, body, "", "  ") == nil:
+		b.WriteString(toPlaceholders.Replace(indented.String()))
+		b.WriteString("\n")
+	case strings.HasPrefix(contentType, "text/"):
+		b.WriteString(toPlaceholders.Replace(string(body)))
+	default:
+		fmt.Fprintf(&b, "(%s body)\n", contentType)
+	}
+	return b.Bytes()
+}
+
+// checkGolden compares the response with testdata/handlers/<name>.golden, or rewrites the file with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", "handlers", name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Errorf("%s: reading the golden file (run with -update to create it): %v", name, err)
+		return
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s: response differs from %s\ngot:\n%s\nwant:\n%s", name, path, got, want)
+	}
+}
+
+// matchesRoute reports whether the path matches the httprouter route (with `:name` parameters).
+func matchesRoute(route, path string) bool {
+	routeSegments, pathSegments := strings.Split(route, "/"), strings.Split(path, "/")
+	if len(routeSegments) != len(pathSegments) {
+		return false
+	}
+	for i, s := range routeSegments {
+		if !strings.HasPrefix(s, ":") && s != pathSegments[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// APIKeyPrefix starts every API key, so that they are told apart from the tokens of the users
+const APIKeyPrefix = "alk_"
+
+// APIKey authenticates a bot, as a bearer token
+type APIKey struct {
+	KeyID      string     `json:"keyId"`                // Unique identifier of the key
+	UserID     string     `json:"userId"`               // Bot authenticated by the key
+	CreatedAt  time.Time  `json:"createdAt"`            // When the key was created
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"` // When the key last authenticated a request, nil if never
+
+	// Key is the secret itself. Only a hash of it is stored, so it is only shown when the key is created.
+	Key string `json:"key,omitempty"`
+}
+
+// IncomingWebhook is a secret URL posting messages as a bot into a conversation, for systems that cannot hold an API
+// key (e.g. alerting or CI tools)
+type IncomingWebhook struct {
+	Token          string    `json:"token"`          // Secret of the URL, `/incoming-webhooks/{token}`
+	ConversationID string    `json:"conversationId"` // Conversation where the messages are posted
+	UserID         string    `json:"userId"`         // Bot sending the messages
+	CreatedAt      time.Time `json:"createdAt"`      // When the webhook was created
+}
@@ -1,9 +1,45 @@
 package models
 
+import "time"
+
 // Conversation represents a conversation entity
 type Conversation struct {
 	ConversationID string `json:"conversationId"` // Unique identifier for the conversation
 	IsGroup        bool   `json:"isGroup"`        // Indicates if the conversation is a group
 	GroupName      string `json:"groupName"`      // Name of the group (optional, only for group conversations)
 	GroupPhoto     string `json:"groupPhoto"`     // Photo of the group (optional, only for group conversations)
+
+	// Settings are the settings of the user the conversation is listed to, absent elsewhere
+	Settings *ConversationSettings `json:"settings,omitempty"`
+}
+
+// ConversationSettings are the settings of a member for a conversation, which only they see
+type ConversationSettings struct {
+	Pinned     bool       `json:"pinned"`               // Listed before the conversations not pinned
+	MutedUntil *time.Time `json:"mutedUntil,omitempty"` // No notifications until this time, absent if not muted
+	Archived   bool       `json:"archived"`             // Left out of the list, unless requested
+}
+
+// Muted reports whether the notifications are muted at the time `now`.
+func (s ConversationSettings) Muted(now time.Time) bool {
+	return s.MutedUntil != nil && now.Before(*s.MutedUntil)
+}
+
+// Roles of the members of a group. Every group has exactly one owner; members of 1:1 conversations are all members.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// Member is a user taking part in a conversation, with their role
+type Member struct {
+	User
+	Role string `json:"role"` // RoleOwner, RoleAdmin or RoleMember
+}
+
+// ConversationDetails is a conversation together with its members
+type ConversationDetails struct {
+	Conversation
+	Members []Member `json:"members"` // Users taking part in the conversation
 }
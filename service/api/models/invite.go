@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Invite is a shareable link to join a group: anyone with its token can join, until it expires, is used up or revoked
+type Invite struct {
+	Token          string     `json:"token"`               // Secret identifier of the invite, to be shared
+	ConversationID string     `json:"conversationId"`      // Group the invite joins
+	CreatedBy      string     `json:"createdBy,omitempty"` // Admin who created the invite, empty if their account was deleted
+	CreatedAt      time.Time  `json:"createdAt"`           // When the invite was created
+	ExpiresAt      *time.Time `json:"expiresAt,omitempty"` // When the invite stops working, absent if it never expires
+	MaxUses        int        `json:"maxUses,omitempty"`   // How many users can join with the invite, 0 for no limit
+	Uses           int        `json:"uses"`                // How many users joined with the invite
+	RevokedAt      *time.Time `json:"revokedAt,omitempty"` // When the invite was revoked, absent if it was not
+}
+
+// InviteJoin records a user joining a group with an invite
+type InviteJoin struct {
+	Token    string    `json:"token"`    // Invite used
+	UserID   string    `json:"userId"`   // User who joined
+	JoinedAt time.Time `json:"joinedAt"` // When they joined
+}
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Message represents a message sent in a conversation
+type Message struct {
+	MessageID      string     `json:"messageId"`           // Unique identifier for the message
+	ConversationID string     `json:"conversationId"`      // Conversation the message belongs to
+	SenderID       string     `json:"senderId,omitempty"`  // Author of the message, empty if their account was deleted
+	Content        string     `json:"content"`             // Text of the message
+	CreatedAt      time.Time  `json:"createdAt"`           // When the message was sent
+	EditedAt       *time.Time `json:"editedAt,omitempty"`  // When the content was last changed, absent if never edited
+	DeletedAt      *time.Time `json:"deletedAt,omitempty"` // When the message was deleted for everyone (its content is empty)
+
+	// System is set on the messages written by the server about a change to the group: they have no sender, and their
+	// content is a plain text description, for the clients that do not know the action
+	System *SystemEvent `json:"system,omitempty"`
+
+	// Poll is set on the messages of the poll type, with the current tally
+	Poll *Poll `json:"poll,omitempty"`
+}
+
+// Actions of the system messages
+const (
+	SystemGroupCreated  = "group.created"       // The actor created the group
+	SystemGroupRenamed  = "group.renamed"       // The actor renamed the group, the target is the new name
+	SystemPhotoChanged  = "group.photo_changed" // The actor changed the photo, the target is the new photo
+	SystemMemberAdded   = "member.added"        // The actor added the target member
+	SystemMemberJoined  = "member.joined"       // The actor joined with an invite
+	SystemMemberLeft    = "member.left"         // The actor left the group
+	SystemMemberRemoved = "member.removed"      // The actor removed the target member
+)
+
+// SystemEvent is the change to a group recorded by a system message
+type SystemEvent struct {
+	Action  string `json:"action"`            // One of the System* actions
+	ActorID string `json:"actorId,omitempty"` // User who made the change, empty if their account was deleted
+	Target  string `json:"target,omitempty"`  // What the action is about, depending on the action
+}
+
+// MessageVersion is a previous content of an edited message
+type MessageVersion struct {
+	Version    int       `json:"version"`    // 1 for the content the message was sent with
+	Content    string    `json:"content"`    // Text of the message in this version
+	CreatedAt  time.Time `json:"createdAt"`  // When this version was written
+	ReplacedAt time.Time `json:"replacedAt"` // When this version was replaced by the next one
+}
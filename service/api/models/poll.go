@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Poll is attached to the messages of the poll type: their content is the question, for the clients that do not know
+// polls. The options are identified by their index.
+type Poll struct {
+	Question       string       `json:"question"`           // What the members vote on
+	Options        []PollOption `json:"options"`            // Answers the members choose from, in order
+	MultipleChoice bool         `json:"multipleChoice"`     // Members can choose more than one option
+	Anonymous      bool         `json:"anonymous"`          // The voters are not shown, only the counts
+	ClosedAt       *time.Time   `json:"closedAt,omitempty"` // When the creator closed the poll, absent while it is open
+	Voters         int          `json:"voters"`             // Number of members who voted
+
+	// MyVotes are the options chosen by the user the poll is listed to, absent elsewhere or if they did not vote
+	MyVotes []int `json:"myVotes,omitempty"`
+}
+
+// PollOption is an answer of a poll, with its tally
+type PollOption struct {
+	Text     string   `json:"text"`               // Text of the answer
+	Votes    int      `json:"votes"`              // Number of members who chose it
+	VoterIDs []string `json:"voterIds,omitempty"` // Members who chose it, in the order they voted; absent if anonymous
+}
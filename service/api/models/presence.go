@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Privacy holds the privacy settings of a user
+type Privacy struct {
+	HideLastSeen bool `json:"hideLastSeen"` // Hide from the other users when the user was last seen online
+}
+
+// Presence is the online status of a user
+type Presence struct {
+	UserID   string     `json:"userId"`
+	Online   bool       `json:"online"`             // The user has at least one realtime connection open
+	LastSeen *time.Time `json:"lastSeen,omitempty"` // Last activity of the user, absent if unknown or hidden
+	TypingIn []string   `json:"typingIn"`           // Conversations (shared with the requester) where the user is typing
+}
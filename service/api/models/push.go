@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// PushSubscription is a browser (or other Web Push client) where the notifications of a user are delivered. The fields
+// are those of the PushSubscription of the Push API, as returned by its toJSON method.
+type PushSubscription struct {
+	SubscriptionID string    `json:"subscriptionId"` // Unique identifier of the subscription
+	Endpoint       string    `json:"endpoint"`       // URL of the push service the notifications are sent to
+	Keys           PushKeys  `json:"keys"`           // Keys encrypting the notifications for the client
+	CreatedAt      time.Time `json:"createdAt"`      // When the subscription was registered
+}
+
+// PushKeys are the keys of a push subscription, base64url encoded
+type PushKeys struct {
+	P256dh string `json:"p256dh"` // Public key of the client, on the P-256 curve
+	Auth   string `json:"auth"`   // Authentication secret of the client
+}
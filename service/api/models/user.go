@@ -5,4 +5,14 @@ type User struct {
 	UserID   string `json:"userId"`
 	Username string `json:"username"`
 	Photo    string `json:"photo,omitempty"`
+
+	// IsBot is set for the bot accounts, created by the administrators and authenticated with API keys
+	IsBot bool `json:"isBot,omitempty"`
+}
+
+// Session is a user with the bearer token of a new session, returned by `POST /user/session`. Only a hash of the
+// token is stored, so it is only shown when the session is created.
+type Session struct {
+	User
+	Token string `json:"token"`
 }
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Events sent to the webhooks. The membership events are the actions of the system messages.
+const (
+	EventMessageNew     = "message.new"       // A message was sent, the data is the Message
+	EventMessageEdited  = "message.edited"    // A message was edited, the data is the Message
+	EventMessageDeleted = "message.deleted"   // A message was deleted for everyone, the data is the Message
+	EventMemberAdded    = SystemMemberAdded   // The data is a MemberEvent
+	EventMemberJoined   = SystemMemberJoined  // The data is a MemberEvent
+	EventMemberLeft     = SystemMemberLeft    // The data is a MemberEvent
+	EventMemberRemoved  = SystemMemberRemoved // The data is a MemberEvent
+)
+
+// WebhookEvents are all the events that webhooks can subscribe to
+var WebhookEvents = []string{
+	EventMessageNew, EventMessageEdited, EventMessageDeleted,
+	EventMemberAdded, EventMemberJoined, EventMemberLeft, EventMemberRemoved,
+}
+
+// Webhook is a URL receiving the events of a conversation, for integrations like bots and CI systems
+type Webhook struct {
+	WebhookID      string    `json:"webhookId"`           // Unique identifier of the webhook
+	ConversationID string    `json:"conversationId"`      // Conversation whose events are sent
+	URL            string    `json:"url"`                 // Where the events are posted
+	Events         []string  `json:"events"`              // Events sent, empty for all of them
+	CreatedBy      string    `json:"createdBy,omitempty"` // Member who created the webhook, empty if their account was deleted
+	CreatedAt      time.Time `json:"createdAt"`           // When the webhook was created
+
+	// Secret is the key of the HMAC-SHA256 signatures of the payloads. It is only shown when the webhook is created.
+	Secret string `json:"secret,omitempty"`
+}
+
+// Subscribed reports whether the webhook receives the event.
+func (w Webhook) Subscribed(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Statuses of the webhook deliveries
+const (
+	DeliveryPending   = "pending"   // Waiting for its first attempt or a retry
+	DeliveryDelivered = "delivered" // The webhook accepted it
+	DeliveryDead      = "dead"      // Every attempt failed: it is not retried anymore, unless requested
+)
+
+// WebhookDelivery is an event queued for a webhook, with the outcome of its attempts
+type WebhookDelivery struct {
+	DeliveryID     string          `json:"deliveryId"`               // Unique identifier of the delivery
+	WebhookID      string          `json:"webhookId"`                // Webhook the event is sent to
+	Event          string          `json:"event"`                    // One of WebhookEvents
+	Data           json.RawMessage `json:"data"`                     // Data of the event
+	Status         string          `json:"status"`                   // DeliveryPending, DeliveryDelivered or DeliveryDead
+	Attempts       int             `json:"attempts"`                 // How many times the delivery was attempted
+	NextAttemptAt  *time.Time      `json:"nextAttemptAt,omitempty"`  // When it is attempted next, absent if not pending
+	LastStatusCode int             `json:"lastStatusCode,omitempty"` // HTTP status of the last attempt, absent if there was no response
+	LastError      string          `json:"lastError,omitempty"`      // Why the last attempt failed, absent if it did not
+	CreatedAt      time.Time       `json:"createdAt"`                // When the event happened
+	DeliveredAt    *time.Time      `json:"deliveredAt,omitempty"`    // When the webhook accepted it, absent if it did not
+}
+
+// MemberEvent is the data of the membership events
+type MemberEvent struct {
+	ConversationID string `json:"conversationId"`
+	UserID         string `json:"userId"`            // Member who was added, joined, left or was removed
+	ActorID        string `json:"actorId,omitempty"` // User who made the change, empty if their account was deleted
+}
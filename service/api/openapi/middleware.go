@@ -0,0 +1,250 @@
+package openapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MaxBodySize is the size of the largest request body that the middleware buffers: larger requests are rejected with
+// the status 413. It fits the largest request of the API, a chat import.
+const MaxBodySize = 32 << 20
+
+// Violation is a difference between the API behavior and its documentation
+type Violation struct {
+	// Method and Path of the request
+	Method string
+	Path   string
+
+	// Status is the HTTP status code of the response
+	Status int
+
+	// Err describes the violation
+	Err error
+}
+
+func (v Violation) Error() string {
+	return fmt.Sprintf("%s %s (%d): %v", v.Method, v.Path, v.Status, v.Err)
+}
+
+// Middleware returns a handler that serves requests using `next`, then checks the request and the response against
+// the document and calls `report` for each violation. Requests not matching the document are not violations by
+// themselves (clients can send anything): they are reported only when the API accepts them with a 2xx status code.
+func (s *Spec) Middleware(next http.Handler, report func(Violation)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Keep a copy of the request body, as the handler consumes it
+		var reqBody []byte
+		if r.Body != nil {
+			var err error
+			reqBody, err = io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodySize))
+			_ = r.Body.Close()
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, `{"error":"the request body is too large"}`, http.StatusRequestEntityTooLarge)
+				return
+			} else if err != nil {
+				http.Error(w, `{"error":"cannot read the request body"}`, http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(reqBody))
+		}
+
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.hijacked {
+			return
+		}
+
+		for _, err := range s.check(r, reqBody, rec.status, rec.body.Bytes()) {
+			report(Violation{Method: r.Method, Path: r.URL.Path, Status: rec.status, Err: err})
+		}
+	})
+}
+
+// check returns the violations of a request/response pair.
+func (s *Spec) check(r *http.Request, reqBody []byte, status int, respBody []byte) []error {
+	template, pathParams := s.findPath(r.URL.Path)
+	if template == "" {
+		if status == http.StatusNotFound {
+			return nil
+		}
+		return []error{errors.New("path is not documented")}
+	}
+	op, ok := s.Paths[template].operations()[r.Method]
+	if !ok {
+		if status == http.StatusMethodNotAllowed || status == http.StatusNotFound {
+			return nil
+		}
+		return []error{fmt.Errorf("method is not documented for %s", template)}
+	}
+
+	var violations []error
+	if status >= 200 && status < 300 {
+		if err := s.checkRequest(op, r, pathParams, reqBody); err != nil {
+			violations = append(violations, fmt.Errorf("request accepted but not valid: %w", err))
+		}
+	}
+	if err := s.checkResponse(op, status, respBody); err != nil {
+		violations = append(violations, fmt.Errorf("response: %w", err))
+	}
+	return violations
+}
+
+// checkRequest checks parameters and body of a request.
+func (s *Spec) checkRequest(op *operation, r *http.Request, pathParams map[string]string, body []byte) error {
+	params, err := s.parameters(op)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range params {
+		var value string
+		var present bool
+		switch p.In {
+		case "path":
+			value, present = pathParams[p.Name]
+		case "query":
+			value, present = r.URL.Query().Get(p.Name), r.URL.Query().Has(p.Name)
+		case "header":
+			value, present = r.Header.Get(p.Name), r.Header.Get(p.Name) != ""
+		default:
+			continue
+		}
+		if !present {
+			if p.Required {
+				return fmt.Errorf("missing required %s parameter %q", p.In, p.Name)
+			}
+			continue
+		}
+		if err := s.checkParameter(p, value); err != nil {
+			return err
+		}
+	}
+
+	if op.RequestBody != nil {
+		if len(body) == 0 {
+			if op.RequestBody.Required {
+				return errors.New("missing request body")
+			}
+			return nil
+		}
+		media, ok := op.RequestBody.Content["application/json"]
+		if !ok || media.Schema == nil {
+			return nil
+		}
+		value, err := decodeJSON(body)
+		if err != nil {
+			return fmt.Errorf("request body: %w", err)
+		}
+		if err := s.validate(media.Schema, value, "body"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkParameter checks the value of a path or query parameter against its schema.
+func (s *Spec) checkParameter(p *parameter, value string) error {
+	if p.Schema == nil {
+		return nil
+	}
+	schema, err := s.resolve(p.Schema)
+	if err != nil {
+		return err
+	}
+
+	var decoded interface{} = value
+	switch schema.Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("parameter %q: expected a number", p.Name)
+		}
+		decoded = json.Number(value)
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("parameter %q: expected a boolean", p.Name)
+		}
+		decoded = b
+	}
+	return s.validate(schema, decoded, "parameter "+p.Name)
+}
+
+// checkResponse checks that the status code is documented, and that JSON bodies match the schema.
+func (s *Spec) checkResponse(op *operation, status int, body []byte) error {
+	resp, err := s.response(op, status)
+	if err != nil {
+		return err
+	}
+
+	media, ok := resp.Content["application/json"]
+	if !ok || media.Schema == nil {
+		return nil
+	}
+	value, err := decodeJSON(body)
+	if err != nil {
+		return err
+	}
+	return s.validate(media.Schema, value, "body")
+}
+
+// decodeJSON decodes a JSON document, keeping numbers as json.Number.
+func decodeJSON(data []byte) (interface{}, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return value, nil
+}
+
+// recorder is an http.ResponseWriter that keeps a copy of the status code and of the body.
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	hijacked    bool
+	body        bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(p []byte) (int, error) {
+	rec.wroteHeader = true
+	// Only keep bodies that can be checked, so that streamed binary responses are not buffered
+	if ct := rec.Header().Get("Content-Type"); ct == "" || strings.Contains(ct, "json") || strings.HasPrefix(ct, "text/plain") {
+		rec.body.Write(p)
+	}
+	return rec.ResponseWriter.Write(p)
+}
+
+// Flush sends buffered data to the client, if supported by the underlying writer.
+func (rec *recorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection; hijacked requests are not checked.
+func (rec *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer does not support hijacking")
+	}
+	rec.hijacked = true
+	return h.Hijack()
+}
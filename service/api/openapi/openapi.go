@@ -0,0 +1,203 @@
+/*
+Package openapi checks HTTP requests and responses against the OpenAPI document of the API (see the `doc` package), so
+that the implementation and its documentation cannot drift apart silently.
+
+Only the subset of OpenAPI 3.0 used by `doc/api.yaml` is supported: path and query parameters, JSON request and
+response bodies, `$ref` to components, and schemas made of `type`, `properties`, `required`, `items`, `enum`,
+`nullable`, `allOf` and `additionalProperties`. Object schemas are closed by default: a property that is not documented
+is reported as a violation, unless the schema sets `additionalProperties`.
+
+Example:
+
+	spec, err := openapi.Load(doc.OpenAPI)
+	if err != nil {
+		return err
+	}
+	handler = spec.Middleware(handler, func(v openapi.Violation) {
+		logger.Warn(v.Error())
+	})
+*/
+package openapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Spec is a parsed OpenAPI document
+type Spec struct {
+	Paths      map[string]*pathItem `yaml:"paths"`
+	Components struct {
+		Schemas    map[string]*Schema    `yaml:"schemas"`
+		Parameters map[string]*parameter `yaml:"parameters"`
+		Responses  map[string]*response  `yaml:"responses"`
+	} `yaml:"components"`
+}
+
+type pathItem struct {
+	Get    *operation `yaml:"get"`
+	Put    *operation `yaml:"put"`
+	Post   *operation `yaml:"post"`
+	Patch  *operation `yaml:"patch"`
+	Delete *operation `yaml:"delete"`
+}
+
+type operation struct {
+	OperationID string       `yaml:"operationId"`
+	Parameters  []*parameter `yaml:"parameters"`
+	RequestBody *struct {
+		Required bool                  `yaml:"required"`
+		Content  map[string]*mediaType `yaml:"content"`
+	} `yaml:"requestBody"`
+	Responses map[string]*response `yaml:"responses"`
+}
+
+type parameter struct {
+	Ref      string  `yaml:"$ref"`
+	Name     string  `yaml:"name"`
+	In       string  `yaml:"in"`
+	Required bool    `yaml:"required"`
+	Schema   *Schema `yaml:"schema"`
+}
+
+type response struct {
+	Ref     string                `yaml:"$ref"`
+	Content map[string]*mediaType `yaml:"content"`
+}
+
+type mediaType struct {
+	Schema *Schema `yaml:"schema"`
+}
+
+// Operation identifies an operation of the document
+type Operation struct {
+	// Method is the HTTP method, in upper case
+	Method string
+
+	// Path is the path template, with parameters in curly braces (e.g., `/users/{id}`)
+	Path string
+
+	// ID is the operationId
+	ID string
+}
+
+// Load parses an OpenAPI document.
+func Load(document []byte) (*Spec, error) {
+	var spec Spec
+	if err := yaml.Unmarshal(document, &spec); err != nil {
+		return nil, fmt.Errorf("parsing OpenAPI document: %w", err)
+	}
+	if len(spec.Paths) == 0 {
+		return nil, errors.New("the OpenAPI document has no paths")
+	}
+	return &spec, nil
+}
+
+// Operations returns all operations in the document, sorted by path and method.
+func (s *Spec) Operations() []Operation {
+	var ops []Operation
+	for path, item := range s.Paths {
+		for method, op := range item.operations() {
+			ops = append(ops, Operation{Method: method, Path: path, ID: op.OperationID})
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].Path == ops[j].Path {
+			return ops[i].Method < ops[j].Method
+		}
+		return ops[i].Path < ops[j].Path
+	})
+	return ops
+}
+
+func (p *pathItem) operations() map[string]*operation {
+	var ops = map[string]*operation{}
+	for method, op := range map[string]*operation{
+		http.MethodGet:    p.Get,
+		http.MethodPut:    p.Put,
+		http.MethodPost:   p.Post,
+		http.MethodPatch:  p.Patch,
+		http.MethodDelete: p.Delete,
+	} {
+		if op != nil {
+			ops[method] = op
+		}
+	}
+	return ops
+}
+
+// findPath returns the path template matching the request path, and the values of the path parameters. Static
+// segments are preferred over parameters, as in the router.
+func (s *Spec) findPath(path string) (string, map[string]string) {
+	var best string
+	var bestParams map[string]string
+	var bestStatic = -1
+
+	segments := strings.Split(path, "/")
+	for template := range s.Paths {
+		templateSegments := strings.Split(template, "/")
+		if len(templateSegments) != len(segments) {
+			continue
+		}
+
+		var static int
+		var params = map[string]string{}
+		var matches = true
+		for i, ts := range templateSegments {
+			if strings.HasPrefix(ts, "{") && strings.HasSuffix(ts, "}") && segments[i] != "" {
+				params[strings.Trim(ts, "{}")] = segments[i]
+			} else if ts == segments[i] {
+				static++
+			} else {
+				matches = false
+				break
+			}
+		}
+		if matches && static > bestStatic {
+			best, bestParams, bestStatic = template, params, static
+		}
+	}
+	return best, bestParams
+}
+
+// parameters returns the parameters of the operation, with references resolved.
+func (s *Spec) parameters(op *operation) ([]*parameter, error) {
+	var params []*parameter
+	for _, p := range op.Parameters {
+		if p.Ref != "" {
+			name := strings.TrimPrefix(p.Ref, "#/components/parameters/")
+			resolved, ok := s.Components.Parameters[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown parameter reference %q", p.Ref)
+			}
+			p = resolved
+		}
+		params = append(params, p)
+	}
+	return params, nil
+}
+
+// response returns the documented response for the status code, with references resolved.
+func (s *Spec) response(op *operation, status int) (*response, error) {
+	resp, ok := op.Responses[fmt.Sprint(status)]
+	if !ok {
+		resp, ok = op.Responses["default"]
+	}
+	if !ok {
+		return nil, fmt.Errorf("status %d is not documented", status)
+	}
+	if resp.Ref != "" {
+		name := strings.TrimPrefix(resp.Ref, "#/components/responses/")
+		resolved, ok := s.Components.Responses[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown response reference %q", resp.Ref)
+		}
+		resp = resolved
+	}
+	return resp, nil
+}
@@ -0,0 +1,184 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Schema is the subset of the OpenAPI schema object supported by the validator
+type Schema struct {
+	Ref                  string             `yaml:"$ref"`
+	Type                 string             `yaml:"type"`
+	Properties           map[string]*Schema `yaml:"properties"`
+	Required             []string           `yaml:"required"`
+	Items                *Schema            `yaml:"items"`
+	Enum                 []interface{}      `yaml:"enum"`
+	Nullable             bool               `yaml:"nullable"`
+	AllOf                []*Schema          `yaml:"allOf"`
+	AdditionalProperties interface{}        `yaml:"additionalProperties"`
+	Minimum              *float64           `yaml:"minimum"`
+	Maximum              *float64           `yaml:"maximum"`
+}
+
+// resolve follows references and merges `allOf` schemas into a single object schema.
+func (s *Spec) resolve(schema *Schema) (*Schema, error) {
+	for schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		resolved, ok := s.Components.Schemas[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown schema reference %q", schema.Ref)
+		}
+		schema = resolved
+	}
+
+	if len(schema.AllOf) == 0 {
+		return schema, nil
+	}
+
+	var merged = Schema{Type: "object", Properties: map[string]*Schema{}}
+	for _, part := range schema.AllOf {
+		part, err := s.resolve(part)
+		if err != nil {
+			return nil, err
+		}
+		for name, prop := range part.Properties {
+			merged.Properties[name] = prop
+		}
+		merged.Required = append(merged.Required, part.Required...)
+		if part.AdditionalProperties != nil {
+			merged.AdditionalProperties = part.AdditionalProperties
+		}
+	}
+	return &merged, nil
+}
+
+// validate checks a JSON value (decoded with json.Decoder.UseNumber) against the schema. `at` is the location of the
+// value in the document, used in error messages.
+func (s *Spec) validate(schema *Schema, value interface{}, at string) error {
+	schema, err := s.resolve(schema)
+	if err != nil {
+		return err
+	}
+
+	if value == nil {
+		if schema.Nullable || schema.Type == "" {
+			return nil
+		}
+		return fmt.Errorf("%s: null is not allowed", at)
+	}
+
+	if len(schema.Enum) > 0 && !inEnum(schema.Enum, value) {
+		return fmt.Errorf("%s: %v is not one of %v", at, value, schema.Enum)
+	}
+
+	switch schema.Type {
+	case "":
+		return nil
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an object", at)
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", at, name)
+			}
+		}
+
+		// Validate properties in a stable order, so that the reported error is always the same
+		var names []string
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := schema.Properties[name]
+			if !ok {
+				switch additional := schema.AdditionalProperties.(type) {
+				case nil:
+					return fmt.Errorf("%s: property %q is not documented", at, name)
+				case bool:
+					if !additional {
+						return fmt.Errorf("%s: property %q is not documented", at, name)
+					}
+					continue
+				default:
+					// additionalProperties is a schema: it describes every undocumented property
+					prop = &Schema{}
+					if err := remarshal(additional, prop); err != nil {
+						return fmt.Errorf("%s: invalid additionalProperties: %w", at, err)
+					}
+				}
+			}
+			if err := s.validate(prop, object[name], at+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an array", at)
+		}
+		if schema.Items != nil {
+			for i, item := range array {
+				if err := s.validate(schema.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: expected a string", at)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean", at)
+		}
+	case "integer", "number":
+		number, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: expected a number", at)
+		}
+		f, err := number.Float64()
+		if err != nil {
+			return fmt.Errorf("%s: expected a number", at)
+		}
+		if schema.Type == "integer" {
+			if _, err := number.Int64(); err != nil {
+				return fmt.Errorf("%s: expected an integer", at)
+			}
+		}
+		if schema.Minimum != nil && f < *schema.Minimum {
+			return fmt.Errorf("%s: %v is less than %v", at, f, *schema.Minimum)
+		}
+		if schema.Maximum != nil && f > *schema.Maximum {
+			return fmt.Errorf("%s: %v is greater than %v", at, f, *schema.Maximum)
+		}
+	default:
+		return fmt.Errorf("%s: unsupported schema type %q", at, schema.Type)
+	}
+	return nil
+}
+
+// inEnum reports whether the JSON value is one of the enum values.
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// remarshal converts a generic YAML value into a Schema.
+func remarshal(in interface{}, out *Schema) error {
+	buf, err := yaml.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(buf, out)
+}
@@ -0,0 +1,405 @@
+package api
+
+import (
+	"AlChats/doc"
+	"AlChats/service/api/models"
+	"AlChats/service/api/openapi"
+	"AlChats/service/backup"
+	"AlChats/service/database"
+	"AlChats/service/globaltime"
+	"AlChats/service/netguard"
+	"AlChats/service/webhooks"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestMain resolves all the hosts to a public address, so that the webhooks and the push subscriptions of the tests
+// can be registered without a DNS server.
+func TestMain(m *testing.M) {
+	netguard.LookupIPAddr = func(context.Context, string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.IPv4(192, 0, 2, 1)}}, nil
+	}
+	os.Exit(m.Run())
+}
+
+// newTestRouter returns a router backed by a new in-memory database.
+func newTestRouter(t *testing.T) *_router {
+	t.Helper()
+	return newRouterFor(t, database.NewMemory())
+}
+
+// newSQLiteTestRouter returns a router backed by a new SQLite database in a temporary directory, for the features that
+// need a real database (e.g., backups).
+func newSQLiteTestRouter(t *testing.T) *_router {
+	t.Helper()
+
+	dbconn, err := database.Open(database.DriverSQLite, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("opening SQLite: %v", err)
+	}
+	t.Cleanup(func() { _ = dbconn.Close() })
+
+	db, err := database.New(dbconn)
+	if err != nil {
+		t.Fatalf("creating AppDatabase: %v", err)
+	}
+	return newRouterFor(t, db)
+}
+
+// newRouterFor returns a router backed by the database, discarding the logs.
+func newRouterFor(t *testing.T, db database.AppDatabase) *_router {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	rt, err := New(Config{Logger: logger, Database: db})
+	if err != nil {
+		t.Fatalf("creating the router: %v", err)
+	}
+	return rt.(*_router)
+}
+
+// newSession opens a session of the user, and returns its bearer token.
+func newSession(t *testing.T, db database.AppDatabase, userID string) string {
+	t.Helper()
+	token, err := db.CreateSession(userID, globaltime.Now())
+	if err != nil {
+		t.Fatalf("creating the session: %v", err)
+	}
+	return token
+}
+
+// newValidatingServer starts a test server for the router, failing the test for every difference between the API
+// and the OpenAPI document.
+func newValidatingServer(t *testing.T, rt *_router) *httptest.Server {
+	t.Helper()
+
+	spec, err := openapi.Load(doc.OpenAPI)
+	if err != nil {
+		t.Fatalf("loading the OpenAPI document: %v", err)
+	}
+
+	srv := httptest.NewServer(spec.Middleware(rt.Handler(), func(v openapi.Violation) {
+		t.Errorf("OpenAPI violation: %v", v)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// TestRoutesAreDocumented checks that every route registered in Handler() is documented, and vice versa.
+func TestRoutesAreDocumented(t *testing.T) {
+	rt := newTestRouter(t)
+	_ = rt.Handler()
+
+	spec, err := openapi.Load(doc.OpenAPI)
+	if err != nil {
+		t.Fatalf("loading the OpenAPI document: %v", err)
+	}
+
+	var registered, documented []string
+	for _, r := range rt.routes {
+		// httprouter parameters (`:id`) are written as `{id}` in the document
+		var segments = strings.Split(r.path, "/")
+		for i, s := range segments {
+			if strings.HasPrefix(s, ":") {
+				segments[i] = "{" + s[1:] + "}"
+			}
+		}
+		registered = append(registered, r.method+" "+strings.Join(segments, "/"))
+	}
+	for _, op := range spec.Operations() {
+		documented = append(documented, op.Method+" "+op.Path)
+	}
+	sort.Strings(registered)
+	sort.Strings(documented)
+
+	if strings.Join(registered, "\n") != strings.Join(documented, "\n") {
+		t.Errorf("routes and documentation differ\nregistered:\n%s\n\ndocumented:\n%s",
+			strings.Join(registered, "\n"), strings.Join(documented, "\n"))
+	}
+}
+
+// TestAPIMatchesDocumentation drives every route, checking requests and responses against the OpenAPI document.
+func TestAPIMatchesDocumentation(t *testing.T) {
+	rt := newSQLiteTestRouter(t)
+	backups, err := backup.New(backup.Config{Database: rt.db, Dir: t.TempDir(), Retain: 1})
+	if err != nil {
+		t.Fatalf("creating the backup manager: %v", err)
+	}
+	dispatcher, err := webhooks.New(webhooks.Config{Database: rt.db, Logger: rt.baseLogger})
+	if err != nil {
+		t.Fatalf("creating the webhook dispatcher: %v", err)
+	}
+	rt.adminToken, rt.backups, rt.webhooks = "admin-secret", backups, dispatcher
+	srv := newValidatingServer(t, rt)
+
+	do := func(method, path, token, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		return resp
+	}
+	decode := func(resp *http.Response, v interface{}) {
+		t.Helper()
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+	}
+
+	var alice, bob, carol struct {
+		UserID string `json:"userId"`
+		Token  string `json:"token"`
+	}
+	decode(do(http.MethodPost, "/user/session?username=alice", "", ""), &alice)
+	decode(do(http.MethodPost, "/user/session?username=bob", "", ""), &bob)
+	decode(do(http.MethodPost, "/user/session?username=carol", "", ""), &carol)
+
+	var conversation, group struct {
+		ConversationID string `json:"conversationId"`
+	}
+	decode(do(http.MethodPost, "/conversation", alice.Token, `{"user_ids":["`+alice.UserID+`","`+bob.UserID+`"]}`), &conversation)
+	decode(do(http.MethodPost, "/conversation", alice.Token, `{"user_ids":["`+alice.UserID+`","`+bob.UserID+`"],"is_group":true,"group_name":"climbing"}`), &group)
+	members := "/conversations/" + group.ConversationID + "/members/"
+	var invite struct {
+		Token string `json:"token"`
+	}
+	decode(do(http.MethodPost, "/conversations/"+group.ConversationID+"/invites", alice.Token, `{}`), &invite)
+	invites := "/conversations/" + group.ConversationID + "/invites"
+	var webhook struct {
+		WebhookID string `json:"webhookId"`
+	}
+	hooks := "/conversations/" + group.ConversationID + "/webhooks"
+	decode(do(http.MethodPost, hooks, alice.Token, `{"url":"https://ci.example.com/hooks"}`), &webhook)
+
+	var imported struct {
+		Conversation struct {
+			ConversationID string `json:"conversationId"`
+		} `json:"conversation"`
+	}
+	chat := `"31/12/2020, 21:41 - Alice: Happy new year!\n01/01/2021, 00:02 - Bob: <Media omitted>\n01/01/2021, 00:03 - Dave: Cheers"`
+	decode(do(http.MethodPost, "/conversation/import", alice.Token,
+		`{"chat":`+chat+`,"self":"Alice","timezone":"Europe/Rome"}`), &imported)
+
+	var importedMessages struct {
+		Items []struct {
+			MessageID string `json:"messageId"`
+		} `json:"items"`
+	}
+	decode(do(http.MethodGet, "/conversations/"+imported.Conversation.ConversationID+"/messages", alice.Token, ""), &importedMessages)
+	message := "/conversations/" + imported.Conversation.ConversationID + "/messages/" + importedMessages.Items[0].MessageID
+
+	keys := `"keys":{"p256dh":"BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4","auth":"BTBZMqHH6r4Tts7J_aSIgg"}`
+	var subscription struct {
+		SubscriptionID string `json:"subscriptionId"`
+	}
+	decode(do(http.MethodPost, "/user/push-subscriptions", bob.Token, `{"endpoint":"https://push.example/bob",`+keys+`}`), &subscription)
+
+	var bot struct {
+		Bot    models.User   `json:"bot"`
+		APIKey models.APIKey `json:"apiKey"`
+	}
+	decode(do(http.MethodPost, "/admin/bots", "admin-secret", `{"username":"ci"}`), &bot)
+	do(http.MethodPost, "/conversations/"+group.ConversationID+"/members", alice.Token, `{"user_ids":["`+bot.Bot.UserID+`"]}`)
+	var incoming models.IncomingWebhook
+	decode(do(http.MethodPost, "/conversations/"+group.ConversationID+"/incoming-webhooks", bot.APIKey.Key, ""), &incoming)
+	var poll models.Message
+	decode(do(http.MethodPost, "/conversations/"+group.ConversationID+"/polls", bot.APIKey.Key, `{"question":"Deploy today?","options":["Yes","No"]}`), &poll)
+	votes := "/conversations/" + group.ConversationID + "/messages/" + poll.MessageID + "/votes"
+
+	for _, tc := range []struct {
+		method, path, token, body string
+		status                    int
+	}{
+		{http.MethodGet, "/", "", "", http.StatusOK},
+		{http.MethodGet, "/liveness", "", "", http.StatusOK},
+		{http.MethodPost, "/user/session", "", "", http.StatusBadRequest},
+		{http.MethodPost, "/user/session?username=alice", "", "", http.StatusConflict},
+		{http.MethodPost, "/user?userId=" + bob.UserID + "&newUsername=robert", "", "", http.StatusOK},
+		{http.MethodPost, "/user?userId=" + bob.UserID, "", "", http.StatusBadRequest},
+		{http.MethodPost, "/user?userId=" + bob.UserID + "&newUsername=alice", "", "", http.StatusConflict},
+		{http.MethodGet, "/users", "", "", http.StatusOK},
+		{http.MethodGet, "/users?limit=1", "", "", http.StatusOK},
+		{http.MethodGet, "/users?cursor=invalid", "", "", http.StatusBadRequest},
+		{http.MethodGet, "/users/" + alice.UserID, "", "", http.StatusOK},
+		{http.MethodGet, "/users/unknown", "", "", http.StatusNotFound},
+		{http.MethodPost, "/conversation", alice.Token, `{"user_ids":["` + alice.UserID + `"]}`, http.StatusBadRequest},
+		{http.MethodPost, "/conversation", alice.Token, `{"user_ids":["` + alice.UserID + `","unknown"]}`, http.StatusNotFound},
+		{http.MethodPost, "/conversation", "", `{"user_ids":["` + alice.UserID + `","` + bob.UserID + `"]}`, http.StatusUnauthorized},
+		{http.MethodPost, "/conversation", alice.Token, `{"user_ids":["` + bob.UserID + `","` + carol.UserID + `"]}`, http.StatusForbidden},
+		{http.MethodPost, "/conversation", alice.Token, `{"user_ids":["` + alice.UserID + `","` + bob.UserID + `","` + carol.UserID + `"],"is_group":true,"group_name":"friends"}`, http.StatusOK},
+		{http.MethodGet, "/conversations", alice.Token, "", http.StatusOK},
+		{http.MethodGet, "/conversations", "", "", http.StatusUnauthorized},
+		{http.MethodGet, "/conversations/" + conversation.ConversationID, alice.Token, "", http.StatusOK},
+		{http.MethodGet, "/conversations/" + conversation.ConversationID, carol.Token, "", http.StatusForbidden},
+		{http.MethodGet, "/conversations/unknown", alice.Token, "", http.StatusNotFound},
+		{http.MethodPatch, "/conversations/" + conversation.ConversationID + "/settings", alice.Token, `{"pinned":true,"muted_until":"2030-01-01T00:00:00Z"}`, http.StatusOK},
+		{http.MethodPatch, "/conversations/" + conversation.ConversationID + "/settings", alice.Token, `{"archived":true,"muted_until":null}`, http.StatusOK},
+		{http.MethodPatch, "/conversations/" + conversation.ConversationID + "/settings", carol.Token, `{"pinned":true}`, http.StatusForbidden},
+		{http.MethodGet, "/conversations?include_archived=true", alice.Token, "", http.StatusOK},
+		{http.MethodPost, "/conversation/import", alice.Token, `{"chat":"not an export"}`, http.StatusBadRequest},
+		{http.MethodPost, "/conversation/import", alice.Token, `{"chat":` + chat + `,"participants":{"Eve":"alice"}}`, http.StatusBadRequest},
+		{http.MethodPost, "/conversation/import", alice.Token, `{"chat":` + chat + `,"participants":{"Bob":"bob"}}`, http.StatusForbidden},
+		{http.MethodPost, "/conversation/import", "", `{"chat":` + chat + `}`, http.StatusUnauthorized},
+		{http.MethodGet, "/conversations/" + imported.Conversation.ConversationID + "/messages", alice.Token, "", http.StatusOK},
+		{http.MethodGet, "/conversations/" + imported.Conversation.ConversationID + "/messages?limit=1", alice.Token, "", http.StatusOK},
+		{http.MethodGet, "/conversations/" + conversation.ConversationID + "/messages", alice.Token, "", http.StatusOK},
+		{http.MethodGet, "/conversations/" + conversation.ConversationID + "/messages", carol.Token, "", http.StatusForbidden},
+		{http.MethodGet, "/conversations/unknown/messages", alice.Token, "", http.StatusNotFound},
+		{http.MethodPost, "/conversations/" + conversation.ConversationID + "/messages", alice.Token, `{"content":"hi"}`, http.StatusOK},
+		{http.MethodPost, "/conversations/" + conversation.ConversationID + "/messages", alice.Token, `{}`, http.StatusBadRequest},
+		{http.MethodPost, "/conversations/" + conversation.ConversationID + "/messages", carol.Token, `{"content":"hi"}`, http.StatusForbidden},
+		{http.MethodPatch, message, alice.Token, `{"content":"Happy 2021!"}`, http.StatusForbidden},
+		{http.MethodPatch, message, alice.Token, `{}`, http.StatusBadRequest},
+		{http.MethodPatch, "/conversations/" + imported.Conversation.ConversationID + "/messages/unknown", alice.Token, `{"content":"hi"}`, http.StatusNotFound},
+		{http.MethodGet, message + "/history", alice.Token, "", http.StatusOK},
+		{http.MethodGet, message + "/history", carol.Token, "", http.StatusForbidden},
+		{http.MethodDelete, message + "?for=everyone", alice.Token, "", http.StatusForbidden},
+		{http.MethodDelete, message + "?for=me", alice.Token, "", http.StatusNoContent},
+		{http.MethodDelete, message, carol.Token, "", http.StatusForbidden},
+		{http.MethodPatch, "/conversations/" + group.ConversationID, alice.Token, `{"group_name":"bouldering"}`, http.StatusOK},
+		{http.MethodPatch, "/conversations/" + group.ConversationID, bob.Token, `{"group_photo":"https://example.com/rock.jpg"}`, http.StatusForbidden},
+		{http.MethodPatch, "/conversations/" + conversation.ConversationID, alice.Token, `{"group_name":"us"}`, http.StatusBadRequest},
+		{http.MethodPost, "/conversations/" + group.ConversationID + "/members", alice.Token, `{"user_ids":["` + carol.UserID + `"]}`, http.StatusOK},
+		{http.MethodPost, "/conversations/" + group.ConversationID + "/members", alice.Token, `{"user_ids":["` + carol.UserID + `"]}`, http.StatusConflict},
+		{http.MethodPost, "/conversations/" + group.ConversationID + "/members", bob.Token, `{"user_ids":["` + carol.UserID + `"]}`, http.StatusForbidden},
+		{http.MethodPut, members + bob.UserID + "/role", alice.Token, `{"role":"admin"}`, http.StatusOK},
+		{http.MethodPut, members + alice.UserID + "/role", bob.Token, `{"role":"member"}`, http.StatusForbidden},
+		{http.MethodPut, members + "unknown/role", alice.Token, `{"role":"admin"}`, http.StatusNotFound},
+		{http.MethodDelete, members + alice.UserID, bob.Token, "", http.StatusForbidden},
+		{http.MethodDelete, members + carol.UserID, bob.Token, "", http.StatusNoContent},
+		{http.MethodDelete, members + alice.UserID, alice.Token, "", http.StatusNoContent},
+		{http.MethodPost, invites, bob.Token, `{"expires_at":"2100-01-01T00:00:00Z","max_uses":10}`, http.StatusOK},
+		{http.MethodPost, invites, bob.Token, `{"max_uses":-1}`, http.StatusBadRequest},
+		{http.MethodGet, invites, bob.Token, "", http.StatusOK},
+		{http.MethodGet, invites, alice.Token, "", http.StatusForbidden},
+		{http.MethodPost, "/invites/" + invite.Token + "/join", carol.Token, "", http.StatusOK},
+		{http.MethodPost, "/invites/" + invite.Token + "/join", carol.Token, "", http.StatusConflict},
+		{http.MethodPost, "/invites/unknown/join", carol.Token, "", http.StatusNotFound},
+		{http.MethodGet, invites + "/" + invite.Token + "/joins", bob.Token, "", http.StatusOK},
+		{http.MethodDelete, invites + "/" + invite.Token, carol.Token, "", http.StatusForbidden},
+		{http.MethodDelete, invites + "/" + invite.Token, bob.Token, "", http.StatusNoContent},
+		{http.MethodPost, "/invites/" + invite.Token + "/join", alice.Token, "", http.StatusGone},
+		{http.MethodPost, hooks, bob.Token, `{"url":"https://bot.example.com/events","events":["message.new","member.joined"]}`, http.StatusOK},
+		{http.MethodPost, hooks, bob.Token, `{"url":"bot.example.com"}`, http.StatusBadRequest},
+		{http.MethodPost, hooks, carol.Token, `{"url":"https://bot.example.com/events"}`, http.StatusForbidden},
+		{http.MethodGet, hooks, bob.Token, "", http.StatusOK},
+		{http.MethodGet, hooks + "/" + webhook.WebhookID + "/deliveries", bob.Token, "", http.StatusOK},
+		{http.MethodGet, hooks + "/" + webhook.WebhookID + "/deliveries?limit=1", bob.Token, "", http.StatusOK},
+		{http.MethodGet, hooks + "/unknown/deliveries", bob.Token, "", http.StatusNotFound},
+		{http.MethodPost, hooks + "/" + webhook.WebhookID + "/deliveries/unknown/retry", bob.Token, "", http.StatusNotFound},
+		{http.MethodDelete, hooks + "/unknown", bob.Token, "", http.StatusNotFound},
+		{http.MethodGet, "/ws", alice.Token, "", http.StatusBadRequest},
+		{http.MethodGet, "/ws", "", "", http.StatusUnauthorized},
+		{http.MethodPut, "/user/privacy", bob.Token, `{"hideLastSeen":true}`, http.StatusOK},
+		{http.MethodPut, "/user/privacy", bob.Token, `not json`, http.StatusBadRequest},
+		{http.MethodGet, "/user/privacy", bob.Token, "", http.StatusOK},
+		{http.MethodGet, "/user/privacy", "", "", http.StatusUnauthorized},
+		{http.MethodPost, "/user/push-subscriptions", alice.Token, `{"endpoint":"https://push.example/alice",` + keys + `}`, http.StatusOK},
+		{http.MethodPost, "/user/push-subscriptions", alice.Token, `{"endpoint":"push.example",` + keys + `}`, http.StatusBadRequest},
+		{http.MethodGet, "/user/push-subscriptions", bob.Token, "", http.StatusOK},
+		{http.MethodDelete, "/user/push-subscriptions/" + subscription.SubscriptionID, alice.Token, "", http.StatusNotFound},
+		{http.MethodDelete, "/user/push-subscriptions/" + subscription.SubscriptionID, bob.Token, "", http.StatusNoContent},
+		{http.MethodGet, "/push/vapid-key", bob.Token, "", http.StatusNotFound},
+		{http.MethodGet, "/users/" + bob.UserID + "/presence", alice.Token, "", http.StatusOK},
+		{http.MethodGet, "/users/" + alice.UserID + "/presence", alice.Token, "", http.StatusOK},
+		{http.MethodGet, "/users/unknown/presence", alice.Token, "", http.StatusNotFound},
+		{http.MethodGet, "/user/export", alice.Token, "", http.StatusOK},
+		{http.MethodGet, "/user/export", "", "", http.StatusUnauthorized},
+		{http.MethodDelete, "/user", "", "", http.StatusUnauthorized},
+		{http.MethodDelete, "/user", carol.Token, "", http.StatusNoContent},
+		{http.MethodPost, "/admin/backup", "", "", http.StatusUnauthorized},
+		{http.MethodPost, "/admin/backup", alice.Token, "", http.StatusUnauthorized},
+		{http.MethodPost, "/admin/backup", "admin-secret", "", http.StatusOK},
+		{http.MethodPost, "/admin/bots", "admin-secret", `{"username":"alice"}`, http.StatusConflict},
+		{http.MethodGet, "/admin/bots", "admin-secret", "", http.StatusOK},
+		{http.MethodPost, "/admin/bots/" + bot.Bot.UserID + "/keys", "admin-secret", "", http.StatusOK},
+		{http.MethodGet, "/admin/bots/" + bot.Bot.UserID + "/keys", "admin-secret", "", http.StatusOK},
+		{http.MethodGet, "/admin/bots/" + alice.UserID + "/keys", "admin-secret", "", http.StatusNotFound},
+		{http.MethodGet, "/conversations/" + group.ConversationID, bot.APIKey.Key, "", http.StatusOK},
+		{http.MethodGet, "/conversations", bot.Bot.UserID, "", http.StatusUnauthorized},
+		{http.MethodGet, "/conversations/" + group.ConversationID + "/commands", bot.APIKey.Key, "", http.StatusOK},
+		{http.MethodGet, "/conversations/" + group.ConversationID + "/incoming-webhooks", bot.APIKey.Key, "", http.StatusOK},
+		{http.MethodGet, "/conversations/" + group.ConversationID + "/incoming-webhooks", alice.Token, "", http.StatusForbidden},
+		{http.MethodPost, "/incoming-webhooks/" + incoming.Token, "", `{"content":"Build #42 passed"}`, http.StatusOK},
+		{http.MethodPost, "/incoming-webhooks/" + incoming.Token, "", `{"content":""}`, http.StatusBadRequest},
+		{http.MethodDelete, "/conversations/" + group.ConversationID + "/incoming-webhooks/" + incoming.Token, bot.APIKey.Key, "", http.StatusNoContent},
+		{http.MethodPost, "/incoming-webhooks/" + incoming.Token, "", `{"content":"hi"}`, http.StatusNotFound},
+		{http.MethodPost, "/conversations/" + group.ConversationID + "/polls", bot.APIKey.Key, `{"question":"Deploy today?"}`, http.StatusBadRequest},
+		{http.MethodPut, votes, bot.APIKey.Key, `{"options":[0]}`, http.StatusOK},
+		{http.MethodPut, votes, bot.APIKey.Key, `{"options":[0,1]}`, http.StatusBadRequest},
+		{http.MethodGet, "/conversations/" + group.ConversationID + "/messages", bot.APIKey.Key, "", http.StatusOK},
+		{http.MethodDelete, votes, bot.APIKey.Key, "", http.StatusNoContent},
+		{http.MethodPost, "/conversations/" + group.ConversationID + "/messages/" + poll.MessageID + "/close", bot.APIKey.Key, "", http.StatusOK},
+		{http.MethodPut, votes, bot.APIKey.Key, `{"options":[1]}`, http.StatusConflict},
+		{http.MethodDelete, "/admin/bots/" + bot.Bot.UserID + "/keys/" + bot.APIKey.KeyID, "admin-secret", "", http.StatusNoContent},
+		{http.MethodGet, "/conversations", bot.APIKey.Key, "", http.StatusUnauthorized},
+	} {
+		resp := do(tc.method, tc.path, tc.token, tc.body)
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s: expected status %d, got %d", tc.method, tc.path, tc.status, resp.StatusCode)
+		}
+	}
+
+	// Dead deliveries can be retried once
+	deliveries, _, err := rt.db.GetWebhookDeliveries(webhook.WebhookID, database.Page{})
+	if err != nil || len(deliveries) == 0 {
+		t.Fatalf("expected the membership changes to be queued: %v, %+v", err, deliveries)
+	}
+	dead := deliveries[0]
+	dead.Status, dead.Attempts, dead.NextAttemptAt, dead.LastError = models.DeliveryDead, webhooks.DefaultMaxAttempts, nil, "timeout"
+	if err := rt.db.UpdateWebhookDelivery(dead); err != nil {
+		t.Fatal(err)
+	}
+	retry := hooks + "/" + webhook.WebhookID + "/deliveries/" + dead.DeliveryID + "/retry"
+	for _, status := range []int{http.StatusOK, http.StatusConflict} {
+		if resp := do(http.MethodPost, retry, bob.Token, ""); resp.StatusCode != status {
+			t.Errorf("retrying the delivery: expected status %d, got %d", status, resp.StatusCode)
+		}
+	}
+	if resp := do(http.MethodDelete, hooks+"/"+webhook.WebhookID, bob.Token, ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("deleting the webhook: expected status 204, got %d", resp.StatusCode)
+	}
+
+	rt.webhooks = nil
+	if resp := do(http.MethodGet, hooks, bob.Token, ""); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("webhooks not configured: expected status 503, got %d", resp.StatusCode)
+	}
+}
+
+// TestValidationBodyLimit checks that the validation middleware does not buffer bodies of any size.
+func TestValidationBodyLimit(t *testing.T) {
+	srv := newValidatingServer(t, newTestRouter(t))
+
+	body := bytes.NewReader(make([]byte, openapi.MaxBodySize+1))
+	resp, err := http.Post(srv.URL+"/conversation/import", "application/json", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413, got %d", resp.StatusCode)
+	}
+}
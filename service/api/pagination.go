@@ -0,0 +1,69 @@
+package api
+
+import (
+	"AlChats/service/database"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// listResponse is the envelope of every list endpoint. Cursors are opaque to clients: they should be passed back as
+// they are in the `cursor` query parameter to get the next (or previous) page.
+type listResponse struct {
+	Items      interface{} `json:"items"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+	PrevCursor string      `json:"prevCursor,omitempty"`
+}
+
+// Cursor prefixes, used to encode the page direction in the cursor
+const (
+	cursorAfter  = "a:"
+	cursorBefore = "b:"
+)
+
+// errInvalidCursor is returned by parsePage when the `cursor` query parameter cannot be decoded
+var errInvalidCursor = errors.New("invalid cursor")
+
+// parsePage reads the `limit` and `cursor` query parameters of a list request.
+func parsePage(r *http.Request) (database.Page, error) {
+	var page database.Page
+
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > database.MaxPageLimit {
+			return page, errors.New("invalid limit")
+		}
+		page.Limit = n
+	}
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return page, errInvalidCursor
+		}
+		switch value := string(raw); {
+		case strings.HasPrefix(value, cursorAfter):
+			page.After = strings.TrimPrefix(value, cursorAfter)
+		case strings.HasPrefix(value, cursorBefore):
+			page.Before = strings.TrimPrefix(value, cursorBefore)
+		default:
+			return page, errInvalidCursor
+		}
+	}
+
+	return page, nil
+}
+
+// newListResponse wraps a page of items in the list envelope, encoding the page keys as cursors.
+func newListResponse(items interface{}, info database.PageInfo) listResponse {
+	var resp = listResponse{Items: items}
+	if info.NextKey != "" {
+		resp.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(cursorAfter + info.NextKey))
+	}
+	if info.PrevKey != "" {
+		resp.PrevCursor = base64.RawURLEncoding.EncodeToString([]byte(cursorBefore + info.PrevKey))
+	}
+	return resp
+}
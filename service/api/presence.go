@@ -0,0 +1,130 @@
+package api
+
+import (
+	"AlChats/service/globaltime"
+	"sort"
+	"sync"
+	"time"
+)
+
+// typingTimeout is how long a typing signal lasts, unless it is renewed by the client. Clients should send a typing
+// frame every few seconds while the user is typing.
+const typingTimeout = 6 * time.Second
+
+// presenceTracker keeps the ephemeral presence state of the users in memory: how many realtime connections they have
+// open, when they were last active, and where they are typing. Nothing is persisted, so it resets when the server
+// restarts. Times come from globaltime, to control the expiry in tests.
+type presenceTracker struct {
+	mu          sync.Mutex
+	connections map[string]int
+	lastSeen    map[string]time.Time
+
+	// typing maps conversation -> user -> expiry of the signal
+	typing map[string]map[string]time.Time
+}
+
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{
+		connections: make(map[string]int),
+		lastSeen:    make(map[string]time.Time),
+		typing:      make(map[string]map[string]time.Time),
+	}
+}
+
+// touch records an activity of the user.
+func (p *presenceTracker) touch(userID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastSeen[userID] = globaltime.Now()
+}
+
+// connect records a new realtime connection of the user, returning true if the user was offline.
+func (p *presenceTracker) connect(userID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.connections[userID]++
+	p.lastSeen[userID] = globaltime.Now()
+	return p.connections[userID] == 1
+}
+
+// disconnect records the end of a realtime connection of the user, returning true if the user is now offline. The
+// typing signals of users going offline are dropped.
+func (p *presenceTracker) disconnect(userID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastSeen[userID] = globaltime.Now()
+	p.connections[userID]--
+	if p.connections[userID] > 0 {
+		return false
+	}
+	delete(p.connections, userID)
+	for conversationID, users := range p.typing {
+		delete(users, userID)
+		if len(users) == 0 {
+			delete(p.typing, conversationID)
+		}
+	}
+	return true
+}
+
+// forget removes every trace of the user (e.g., when the account is deleted).
+func (p *presenceTracker) forget(userID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.lastSeen, userID)
+	for conversationID, users := range p.typing {
+		delete(users, userID)
+		if len(users) == 0 {
+			delete(p.typing, conversationID)
+		}
+	}
+}
+
+// setTyping starts (or renews) the typing signal of the user in the conversation, or stops it. It returns the expiry
+// of the signal.
+func (p *presenceTracker) setTyping(conversationID, userID string, typing bool) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := globaltime.Now()
+	p.lastSeen[userID] = now
+
+	if !typing {
+		delete(p.typing[conversationID], userID)
+		if len(p.typing[conversationID]) == 0 {
+			delete(p.typing, conversationID)
+		}
+		return now
+	}
+
+	if p.typing[conversationID] == nil {
+		p.typing[conversationID] = make(map[string]time.Time)
+	}
+	expiry := now.Add(typingTimeout)
+	p.typing[conversationID][userID] = expiry
+	return expiry
+}
+
+// get returns whether the user is online, when they were last seen (zero if never), and the conversations where they
+// are typing, sorted. Expired typing signals are removed.
+func (p *presenceTracker) get(userID string) (online bool, lastSeen time.Time, typingIn []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := globaltime.Now()
+
+	for conversationID, users := range p.typing {
+		expiry, ok := users[userID]
+		switch {
+		case !ok:
+		case !expiry.After(now):
+			delete(users, userID)
+			if len(users) == 0 {
+				delete(p.typing, conversationID)
+			}
+		default:
+			typingIn = append(typingIn, conversationID)
+		}
+	}
+	sort.Strings(typingIn)
+
+	return p.connections[userID] > 0, p.lastSeen[userID], typingIn
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/globaltime"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPresence(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	globaltime.FixedTime = start
+	defer func() { globaltime.FixedTime = time.Time{} }()
+
+	rt := newTestRouter(t)
+	handler := rt.Handler()
+
+	alice, _ := rt.db.SetUser("alice")
+	bob, _ := rt.db.SetUser("bob")
+	carol, _ := rt.db.SetUser("carol")
+	aliceToken, bobToken, carolToken := newSession(t, rt.db, alice.UserID), newSession(t, rt.db, bob.UserID), newSession(t, rt.db, carol.UserID)
+	conversation, err := rt.db.SetConversation([]string{alice.UserID, bob.UserID}, false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(userID, token string) models.Presence {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/users/"+userID+"/presence", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var presence models.Presence
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &presence) != nil {
+			t.Fatalf("getting the presence: %d %s", w.Code, w.Body)
+		}
+		return presence
+	}
+
+	// Users never seen have no last seen time
+	if p := get(carol.UserID, aliceToken); p.Online || p.LastSeen != nil {
+		t.Errorf("expected no presence for carol, got %+v", p)
+	}
+
+	rt.presence.connect(bob.UserID)
+	rt.presence.setTyping(conversation.ConversationID, bob.UserID, true)
+
+	p := get(bob.UserID, aliceToken)
+	if !p.Online || p.LastSeen == nil || !p.LastSeen.Equal(start) {
+		t.Errorf("expected bob online, seen at %v, got %+v", start, p)
+	}
+	if len(p.TypingIn) != 1 || p.TypingIn[0] != conversation.ConversationID {
+		t.Errorf("expected bob typing in the conversation, got %v", p.TypingIn)
+	}
+
+	// Only the members of the conversation know about the typing
+	if p := get(bob.UserID, carolToken); len(p.TypingIn) != 0 {
+		t.Errorf("expected carol not to see the typing, got %v", p.TypingIn)
+	}
+
+	// Typing signals expire
+	globaltime.FixedTime = start.Add(typingTimeout)
+	if p := get(bob.UserID, aliceToken); len(p.TypingIn) != 0 {
+		t.Errorf("expected the typing signal to expire, got %v", p.TypingIn)
+	}
+
+	// The last seen time can be hidden, except to the user themselves
+	if err := rt.db.SetPrivacy(bob.UserID, models.Privacy{HideLastSeen: true}); err != nil {
+		t.Fatal(err)
+	}
+	rt.presence.disconnect(bob.UserID)
+	if p := get(bob.UserID, aliceToken); p.Online || p.LastSeen != nil {
+		t.Errorf("expected bob offline with hidden last seen, got %+v", p)
+	}
+	if p := get(bob.UserID, bobToken); p.LastSeen == nil || !p.LastSeen.Equal(globaltime.FixedTime) {
+		t.Errorf("expected bob to see their own last seen time, got %+v", p)
+	}
+}
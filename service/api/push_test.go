@@ -0,0 +1,142 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/notify"
+	"context"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// recordingNotifier passes the notifications to another notifier, and records them.
+type recordingNotifier struct {
+	notify.Notifier
+	sent chan notify.Notification
+}
+
+func (n recordingNotifier) Notify(ctx context.Context, notification notify.Notification, subscriptions []models.PushSubscription) error {
+	n.sent <- notification
+	return n.Notifier.Notify(ctx, notification, subscriptions)
+}
+
+// testPushKeys returns the keys of a new push subscription.
+func testPushKeys(t *testing.T) models.PushKeys {
+	t.Helper()
+	_, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	if _, err := rand.Read(auth); err != nil {
+		t.Fatal(err)
+	}
+	return models.PushKeys{
+		P256dh: base64.RawURLEncoding.EncodeToString(elliptic.Marshal(elliptic.P256(), x, y)),
+		Auth:   base64.RawURLEncoding.EncodeToString(auth),
+	}
+}
+
+func TestPushNotifications(t *testing.T) {
+	// The push service stand-in knows the /ok subscriptions only
+	delivered := make(chan *http.Request, 10)
+	pushService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		delivered <- r
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer pushService.Close()
+
+	privateKey, publicKey, err := notify.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The push service listens on the loopback address, which the default client refuses
+	webpush, err := notify.NewWebPush(notify.WebPushConfig{PrivateKey: privateKey, Subject: "mailto:admin@example.com", Client: pushService.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier := recordingNotifier{Notifier: webpush, sent: make(chan notify.Notification, 10)}
+
+	rt := newTestRouter(t)
+	rt.notifier = notifier
+	srv := newValidatingServer(t, rt)
+
+	alice, _ := rt.db.SetUser("alice")
+	bob, _ := rt.db.SetUser("bob")
+	carol, _ := rt.db.SetUser("carol")
+	dave, _ := rt.db.SetUser("dave")
+	group, err := rt.db.SetConversation([]string{alice.UserID, bob.UserID, carol.UserID, dave.UserID}, true, "climbing", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bob is offline, with a valid and an expired subscription; Carol is offline, but muted the group; Dave is online
+	now := time.Now()
+	ok, _ := rt.db.AddPushSubscription(bob.UserID, models.PushSubscription{Endpoint: pushService.URL + "/ok", Keys: testPushKeys(t), CreatedAt: now})
+	_, _ = rt.db.AddPushSubscription(bob.UserID, models.PushSubscription{Endpoint: pushService.URL + "/expired", Keys: testPushKeys(t), CreatedAt: now.Add(time.Second)})
+	_, _ = rt.db.AddPushSubscription(carol.UserID, models.PushSubscription{Endpoint: pushService.URL + "/carol", Keys: testPushKeys(t), CreatedAt: now})
+	later := now.Add(time.Hour)
+	if err := rt.db.SetMemberSettings(group.ConversationID, carol.UserID, models.ConversationSettings{MutedUntil: &later}); err != nil {
+		t.Fatal(err)
+	}
+	aliceConn := dialWS(t, srv, newSession(t, rt.db, alice.UserID))
+	_ = dialWS(t, srv, newSession(t, rt.db, dave.UserID))
+
+	writeFrame(t, aliceConn, `{"v":1,"type":"message.send","id":"1","data":{"conversationId":"`+group.ConversationID+`","content":"rock tonight?"}}`)
+	for f := readFrame(t, aliceConn); f.Type != "ack"; f = readFrame(t, aliceConn) {
+		if f.Type != framePresence {
+			t.Fatalf("expected the ack, got %+v", f)
+		}
+	}
+
+	select {
+	case n := <-notifier.sent:
+		if n.UserID != bob.UserID || n.Title != "alice in climbing" || n.Body != "rock tonight?" || n.SenderID != alice.UserID {
+			t.Errorf("unexpected notification %+v", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("bob was not notified")
+	}
+	rt.notifying.Wait()
+	if len(notifier.sent) != 0 {
+		t.Errorf("expected only bob to be notified, got %+v", <-notifier.sent)
+	}
+
+	select {
+	case r := <-delivered:
+		if r.Header.Get("Content-Encoding") != "aes128gcm" || r.Header.Get("Authorization") == "" {
+			t.Errorf("unexpected push request headers %v", r.Header)
+		}
+	default:
+		t.Error("the push service did not receive the notification")
+	}
+
+	// The expired subscription is deleted
+	subscriptions, err := rt.db.GetPushSubscriptions(bob.UserID)
+	if err != nil || len(subscriptions) != 1 || subscriptions[0].SubscriptionID != ok.SubscriptionID {
+		t.Errorf("expected only the valid subscription to be kept: %v, %+v", err, subscriptions)
+	}
+
+	// Clients subscribe with the public key of the server
+	rt.notifier = webpush
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/push/vapid-key", nil)
+	req.Header.Set("Authorization", "Bearer "+newSession(t, rt.db, bob.UserID))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var key VAPIDKey
+	if err := json.NewDecoder(resp.Body).Decode(&key); err != nil || key.PublicKey != publicKey {
+		t.Errorf("expected the public key %s, got %v, %+v", publicKey, err, key)
+	}
+}
@@ -1,6 +1,10 @@
 package api
 
 // Close should close everything opened in the lifecycle of the `_router`; for example, background goroutines.
+// WebSocket connections are closed here, as http.Server.Shutdown does not track hijacked connections. The
+// notifications being sent are waited for.
 func (rt *_router) Close() error {
-	return nil
+	err := rt.hub.Close()
+	rt.notifying.Wait()
+	return err
 }
@@ -0,0 +1,307 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/commands"
+	"AlChats/service/database"
+	"AlChats/service/globaltime"
+	"AlChats/service/realtime"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// wsCommandResult is the data of the acknowledgement of a command sent with a `message.send` frame
+type wsCommandResult struct {
+	Command string `json:"command"`
+	Reply   string `json:"reply,omitempty"`
+
+	// Message is the message sent on behalf of the caller, if the command sent one
+	Message *models.Message `json:"message,omitempty"`
+}
+
+// wsBotCommand is a command registered by a bot with a `commands.register` frame
+type wsBotCommand struct {
+	Name        string `json:"name"`
+	Usage       string `json:"usage,omitempty"`
+	Description string `json:"description"`
+
+	// AdminOnly reserves the command to the admins and the owner of the groups
+	AdminOnly bool `json:"adminOnly,omitempty"`
+}
+
+// wsBotCommandsData is the data of the `commands.register` frames
+type wsBotCommandsData struct {
+	Commands []wsBotCommand `json:"commands"`
+}
+
+// wsCommandData is the data of the `command` events sent to a bot when a member runs one of its commands
+type wsCommandData struct {
+	ConversationID string `json:"conversationId"`
+	UserID         string `json:"userId"`
+	Command        string `json:"command"`
+	Args           string `json:"args"`
+}
+
+// registerCommands registers the built-in slash commands.
+func (rt *_router) registerCommands() error {
+	for _, cmd := range []commands.Command{
+		{
+			Name:        "me",
+			Usage:       "<action>",
+			Description: "Send an action in the third person, like \"* alice waves\"",
+			Handler:     meCommand,
+		},
+		{
+			Name:        "poll",
+			Usage:       "<question> | <option> | <option>...",
+			Description: "Create a poll with a single choice",
+			Handler:     rt.pollCommand,
+		},
+		{
+			Name:        "rename",
+			Usage:       "<name>",
+			Description: "Rename the group",
+			GroupOnly:   true,
+			Allowed:     func(caller models.Member) bool { return can(caller, actionEditGroup, models.Member{}) },
+			Handler:     rt.renameCommand,
+		},
+		{
+			Name:        "invite",
+			Usage:       "@user...",
+			Description: "Add users to the group",
+			GroupOnly:   true,
+			Allowed:     func(caller models.Member) bool { return can(caller, actionAddMembers, models.Member{}) },
+			Handler:     rt.inviteCommand,
+		},
+	} {
+		if err := rt.commands.Register(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// meCommand sends the action as a message of the caller.
+func meCommand(call commands.Call) (commands.Result, error) {
+	if call.Args == "" {
+		return commands.Result{}, commands.Errorf("usage: /me <action>")
+	}
+	return commands.Result{Content: fmt.Sprintf("* %s %s", call.Caller.Username, call.Args)}, nil
+}
+
+// pollCommand sends a poll, like `POST /conversations/:id/polls`: the question and the options are separated by `|`.
+func (rt *_router) pollCommand(call commands.Call) (commands.Result, error) {
+	parts := strings.Split(call.Args, "|")
+	poll, err := CreatePollRequest{Question: parts[0], Options: parts[1:]}.poll()
+	if err != nil {
+		return commands.Result{}, commands.Errorf("%v (usage: /poll <question> | <option> | <option>...)", err)
+	}
+
+	members := make([]models.User, 0, len(call.Members))
+	for _, member := range call.Members {
+		members = append(members, member.User)
+	}
+	_, err = rt.createPoll(call.Conversation.ConversationID, members, call.Caller.UserID, poll)
+	return commands.Result{}, err
+}
+
+// renameCommand renames the group, like `PATCH /conversations/:id`.
+func (rt *_router) renameCommand(call commands.Call) (commands.Result, error) {
+	if call.Args == "" {
+		return commands.Result{}, commands.Errorf("usage: /rename <name>")
+	}
+	if call.Args == call.Conversation.GroupName {
+		return commands.Result{Reply: "the group already has this name"}, nil
+	}
+
+	updated, err := rt.db.UpdateConversation(call.Conversation.ConversationID, call.Args, call.Conversation.GroupPhoto)
+	if err != nil {
+		return commands.Result{}, err
+	}
+	rt.addSystemMessage(updated.ConversationID,
+		models.SystemEvent{Action: models.SystemGroupRenamed, ActorID: call.Caller.UserID, Target: updated.GroupName})
+	return commands.Result{}, nil
+}
+
+// inviteCommand adds the users to the group, like `POST /conversations/:id/members`. Nobody is added if a username is
+// wrong or already a member.
+func (rt *_router) inviteCommand(call commands.Call) (commands.Result, error) {
+	usernames := strings.Fields(call.Args)
+	if len(usernames) == 0 {
+		return commands.Result{}, commands.Errorf("usage: /invite @user...")
+	}
+
+	var userIDs []string
+	for _, username := range usernames {
+		username = strings.TrimPrefix(username, "@")
+		user, err := rt.db.GetUserByUsername(username)
+		if errors.Is(err, database.ErrUserNotFound) {
+			return commands.Result{}, commands.Errorf("user @%s not found", username)
+		} else if err != nil {
+			return commands.Result{}, err
+		}
+		if _, ok := findMember(call.Members, user.UserID); ok {
+			return commands.Result{}, commands.Errorf("@%s is already a member", username)
+		}
+		userIDs = append(userIDs, user.UserID)
+	}
+
+	err := rt.db.AddConversationMembers(call.Conversation.ConversationID, userIDs)
+	if errors.Is(err, database.ErrAlreadyMember) {
+		return commands.Result{}, commands.Errorf("a user is already a member")
+	} else if err != nil {
+		return commands.Result{}, err
+	}
+	for _, userID := range userIDs {
+		rt.addSystemMessage(call.Conversation.ConversationID,
+			models.SystemEvent{Action: models.SystemMemberAdded, ActorID: call.Caller.UserID, Target: userID})
+	}
+	return commands.Result{}, nil
+}
+
+// botCommand returns the handler of the commands of the bot: it forwards the command to the connections of the bot,
+// which answers as any member would, e.g. with a message.
+func (rt *_router) botCommand(bot models.User) commands.Handler {
+	return func(call commands.Call) (commands.Result, error) {
+		if !rt.hub.Connected(bot.UserID) {
+			return commands.Result{}, commands.Errorf("@%s is offline", bot.Username)
+		}
+		rt.publish([]string{bot.UserID}, frameCommand, wsCommandData{
+			ConversationID: call.Conversation.ConversationID,
+			UserID:         call.Caller.UserID,
+			Command:        call.Name,
+			Args:           call.Args,
+		}, nil)
+		return commands.Result{}, nil
+	}
+}
+
+// runCommand runs a command sent by the client in a `message.send` frame, and acknowledges the frame with the
+// result. The message sent by the command, if any, is delivered like the other messages.
+func (rt *_router) runCommand(c *realtime.Client, f realtime.Frame, members []models.User, conversationID, name, args string) {
+	logger := rt.baseLogger.WithField("conversation", conversationID).WithField("command", name)
+
+	conversation, err := rt.db.GetConversationByID(conversationID)
+	if err != nil {
+		logger.WithError(err).Error("reading the conversation of a command")
+		c.SendError(f.ID, "cannot run the command")
+		return
+	}
+	roles, err := rt.db.GetConversationMemberRoles(conversationID)
+	if err != nil {
+		logger.WithError(err).Error("reading the members of the conversation of a command")
+		c.SendError(f.ID, "cannot run the command")
+		return
+	}
+	caller, _ := findMember(roles, c.UserID())
+
+	result, err := rt.commands.Run(commands.Call{
+		Name:         name,
+		Args:         args,
+		Conversation: conversation,
+		Members:      roles,
+		Caller:       caller,
+	})
+	if commands.IsCallerError(err) {
+		c.SendError(f.ID, err.Error())
+		return
+	} else if err != nil {
+		logger.WithError(err).Error("running a command")
+		c.SendError(f.ID, "cannot run the command")
+		return
+	}
+
+	ack := wsCommandResult{Command: name, Reply: result.Reply}
+	if result.Content != "" {
+		saved, err := rt.db.AddMessages(conversationID, []models.Message{{
+			SenderID:  c.UserID(),
+			Content:   result.Content,
+			CreatedAt: globaltime.Now(),
+		}})
+		if err != nil {
+			logger.WithError(err).Error("saving the message of a command")
+			c.SendError(f.ID, "cannot save the message")
+			return
+		}
+		ack.Message = &saved[0]
+		rt.presence.setTyping(conversationID, c.UserID(), false)
+		c.SendAck(f.ID, ack)
+		rt.deliverMessage(members, saved[0], c)
+		return
+	}
+	c.SendAck(f.ID, ack)
+}
+
+// registerBotCommands replaces the commands of the bot of the connection with the ones of a `commands.register`
+// frame. They last until the last connection of the bot closes.
+func (rt *_router) registerBotCommands(c *realtime.Client, f realtime.Frame) {
+	bot, err := rt.db.GetUserByID(c.UserID())
+	if err != nil {
+		c.SendError(f.ID, "cannot read the user")
+		return
+	}
+	if !bot.IsBot {
+		c.SendError(f.ID, "only bots register commands")
+		return
+	}
+
+	var data wsBotCommandsData
+	if err := json.Unmarshal(f.Data, &data); err != nil {
+		c.SendError(f.ID, "data.commands is required")
+		return
+	}
+	var cmds []commands.Command
+	for _, registered := range data.Commands {
+		cmd := commands.Command{
+			Name:        registered.Name,
+			Usage:       registered.Usage,
+			Description: registered.Description,
+			Handler:     rt.botCommand(bot),
+		}
+		if registered.AdminOnly {
+			cmd.Allowed = func(caller models.Member) bool { return can(caller, actionRunAdminCommands, models.Member{}) }
+		}
+		cmds = append(cmds, cmd)
+	}
+	if err := rt.commands.ReplaceBotCommands(bot.UserID, cmds); err != nil {
+		c.SendError(f.ID, err.Error())
+		return
+	}
+	c.SendAck(f.ID, nil)
+}
+
+// getCommandsHandler returns the commands that the authenticated user can run in a conversation, e.g. to suggest them
+// while typing.
+func (rt *_router) getCommandsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json")
+
+	user, ok := rt.authenticate(w, r)
+	if !ok {
+		return
+	}
+	conversation, _, ok := rt.memberConversation(w, user, ps.ByName("id"))
+	if !ok {
+		return
+	}
+
+	members, err := rt.db.GetConversationMemberRoles(conversation.ConversationID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"%v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	self, _ := findMember(members, user.UserID)
+
+	available := rt.commands.Available(commands.Call{Conversation: conversation, Members: members, Caller: self})
+	if available == nil {
+		available = []commands.Command{}
+	}
+
+	if err := json.NewEncoder(w).Encode(newListResponse(available, database.PageInfo{})); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"failed to encode response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
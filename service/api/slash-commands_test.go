@@ -0,0 +1,104 @@
+package api
+
+import (
+	"AlChats/service/globaltime"
+	"AlChats/service/realtime"
+	"AlChats/service/websocket"
+	"strings"
+	"testing"
+)
+
+// readFrameOfType reads frames until one of the type, skipping the others (e.g., presence events).
+func readFrameOfType(t *testing.T, conn *websocket.Conn, frameTypes ...string) realtime.Frame {
+	t.Helper()
+	for {
+		f := readFrame(t, conn)
+		for _, frameType := range frameTypes {
+			if f.Type == frameType {
+				return f
+			}
+		}
+	}
+}
+
+func TestSlashCommands(t *testing.T) {
+	rt := newTestRouter(t)
+	srv := newValidatingServer(t, rt)
+
+	alice, _ := rt.db.SetUser("alice")
+	bob, _ := rt.db.SetUser("bob")
+	bot, err := rt.db.CreateBot("deployer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := rt.db.CreateAPIKey(bot.UserID, globaltime.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Alice is the owner, Bob a member
+	group, err := rt.db.SetConversation([]string{alice.UserID, bob.UserID, bot.UserID}, true, "climbing", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aliceConn := dialWS(t, srv, newSession(t, rt.db, alice.UserID))
+	bobConn := dialWS(t, srv, newSession(t, rt.db, bob.UserID))
+	botConn := dialWS(t, srv, key.Key)
+
+	send := func(conn *websocket.Conn, id, content string) realtime.Frame {
+		t.Helper()
+		writeFrame(t, conn, `{"v":1,"type":"message.send","id":"`+id+`","data":{"conversationId":"`+group.ConversationID+`","content":"`+content+`"}}`)
+		return readFrameOfType(t, conn, realtime.TypeAck, realtime.TypeError)
+	}
+
+	// The message of /me is sent to the members on behalf of the caller
+	if f := send(aliceConn, "1", "/me waves"); f.Type != realtime.TypeAck || !strings.Contains(string(f.Data), `"command":"me"`) ||
+		!strings.Contains(string(f.Data), `"content":"* alice waves"`) {
+		t.Errorf("unexpected answer to /me: %+v", f)
+	}
+	if f := readFrameOfType(t, bobConn, frameNewMessage); !strings.Contains(string(f.Data), `"content":"* alice waves"`) {
+		t.Errorf("unexpected message %s", f.Data)
+	}
+
+	// Only the admins rename the group
+	if f := send(bobConn, "2", "/rename Bouldering"); f.Type != realtime.TypeError || !strings.Contains(string(f.Data), "not allowed") {
+		t.Errorf("expected an error, got %+v", f)
+	}
+	if f := send(aliceConn, "3", "/rename Bouldering"); f.Type != realtime.TypeAck {
+		t.Errorf("unexpected answer to /rename: %+v", f)
+	}
+	if conversation, _ := rt.db.GetConversationByID(group.ConversationID); conversation.GroupName != "Bouldering" {
+		t.Errorf("expected the group to be renamed, got %q", conversation.GroupName)
+	}
+
+	if f := send(aliceConn, "4", "/help"); !strings.Contains(string(f.Data), "Rename the group") {
+		t.Errorf("unexpected help %s", f.Data)
+	}
+	if f := send(bobConn, "5", "/unknown"); f.Type != realtime.TypeError || !strings.Contains(string(f.Data), "unknown command") {
+		t.Errorf("expected an error, got %+v", f)
+	}
+
+	// A leading double slash sends the message as it is
+	if f := send(bobConn, "6", "//help"); f.Type != realtime.TypeAck || !strings.Contains(string(f.Data), `"content":"/help"`) {
+		t.Errorf("unexpected answer to //help: %+v", f)
+	}
+
+	// Bots register commands while they are connected, and receive the invocations
+	writeFrame(t, aliceConn, `{"v":1,"type":"commands.register","id":"7","data":{"commands":[]}}`)
+	if f := readFrameOfType(t, aliceConn, realtime.TypeAck, realtime.TypeError); f.Type != realtime.TypeError {
+		t.Errorf("only bots should register commands, got %+v", f)
+	}
+	writeFrame(t, botConn, `{"v":1,"type":"commands.register","id":"8","data":{"commands":[{"name":"deploy","usage":"<env>","description":"Deploy","adminOnly":true}]}}`)
+	if f := readFrameOfType(t, botConn, realtime.TypeAck, realtime.TypeError); f.Type != realtime.TypeAck {
+		t.Fatalf("unexpected answer to commands.register: %+v", f)
+	}
+	if f := send(bobConn, "9", "/deploy prod"); f.Type != realtime.TypeError {
+		t.Errorf("expected an error, got %+v", f)
+	}
+	if f := send(aliceConn, "10", "/deploy prod"); f.Type != realtime.TypeAck {
+		t.Errorf("unexpected answer to /deploy: %+v", f)
+	}
+	if f := readFrameOfType(t, botConn, frameCommand); !strings.Contains(string(f.Data), `"userId":"`+alice.UserID+`","command":"deploy","args":"prod"`) {
+		t.Errorf("unexpected command %s", f.Data)
+	}
+}
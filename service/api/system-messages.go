@@ -0,0 +1,69 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/globaltime"
+	"fmt"
+)
+
+// addSystemMessage appends a system message about a change to the group, and sends it to the members as a new
+// message. The membership changes are sent to the webhooks too. The change already happened, so errors are only
+// logged.
+func (rt *_router) addSystemMessage(conversationID string, event models.SystemEvent) {
+	logger := rt.baseLogger.WithField("conversation", conversationID).WithField("action", event.Action)
+
+	saved, err := rt.db.AddMessages(conversationID, []models.Message{{
+		Content:   rt.describeSystemEvent(event),
+		CreatedAt: globaltime.Now(),
+		System:    &event,
+	}})
+	if err != nil {
+		logger.WithError(err).Error("saving system message")
+		return
+	}
+
+	members, err := rt.db.GetConversationMembers(conversationID)
+	if err != nil {
+		logger.WithError(err).Error("reading the members of the conversation")
+		return
+	}
+	rt.publish(memberIDs(members, ""), frameNewMessage, saved[0], nil)
+
+	switch event.Action {
+	case models.SystemMemberAdded, models.SystemMemberRemoved:
+		rt.emitWebhookEvent(conversationID, event.Action, models.MemberEvent{ConversationID: conversationID, UserID: event.Target, ActorID: event.ActorID})
+	case models.SystemMemberJoined, models.SystemMemberLeft:
+		rt.emitWebhookEvent(conversationID, event.Action, models.MemberEvent{ConversationID: conversationID, UserID: event.ActorID})
+	}
+}
+
+// describeSystemEvent returns the plain text content of a system message, with the usernames at the time of the change.
+func (rt *_router) describeSystemEvent(event models.SystemEvent) string {
+	actor := rt.username(event.ActorID)
+	switch event.Action {
+	case models.SystemGroupCreated:
+		return fmt.Sprintf("%s created the group", actor)
+	case models.SystemGroupRenamed:
+		return fmt.Sprintf("%s renamed the group to %q", actor, event.Target)
+	case models.SystemPhotoChanged:
+		return fmt.Sprintf("%s changed the group photo", actor)
+	case models.SystemMemberAdded:
+		return fmt.Sprintf("%s added %s", actor, rt.username(event.Target))
+	case models.SystemMemberJoined:
+		return fmt.Sprintf("%s joined with an invite link", actor)
+	case models.SystemMemberLeft:
+		return fmt.Sprintf("%s left", actor)
+	case models.SystemMemberRemoved:
+		return fmt.Sprintf("%s removed %s", actor, rt.username(event.Target))
+	}
+	return event.Action
+}
+
+// username returns the username of the user, or "someone" if it cannot be read.
+func (rt *_router) username(userID string) string {
+	user, err := rt.db.GetUserByID(userID)
+	if err != nil {
+		return "someone"
+	}
+	return user.Username
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/webhooks"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookEvents(t *testing.T) {
+	received := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	rt := newTestRouter(t)
+	// The receiver listens on the loopback address, which the default client refuses
+	dispatcher, err := webhooks.New(webhooks.Config{Database: rt.db, Logger: rt.baseLogger, Client: &http.Client{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt.webhooks = dispatcher
+	srv := newValidatingServer(t, rt)
+
+	alice, _ := rt.db.SetUser("alice")
+	bob, _ := rt.db.SetUser("bob")
+	conversation, err := rt.db.SetConversation([]string{alice.UserID, bob.UserID}, false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook, err := rt.db.CreateWebhook(models.Webhook{ConversationID: conversation.ConversationID, URL: receiver.URL, Events: []string{models.EventMessageNew}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := dialWS(t, srv, newSession(t, rt.db, alice.UserID))
+	writeFrame(t, conn, `{"v":1,"type":"message.send","id":"1","data":{"conversationId":"`+conversation.ConversationID+`","content":"build is green"}}`)
+	for f := readFrame(t, conn); f.Type != "ack"; f = readFrame(t, conn) {
+		if f.Type != framePresence {
+			t.Fatalf("expected the ack, got %+v", f)
+		}
+	}
+
+	if n, err := dispatcher.DeliverDue(context.Background()); err != nil || n != 1 {
+		t.Fatalf("expected a delivery: %v, %d", err, n)
+	}
+	r, body := <-received, <-bodies
+	if r.Header.Get(webhooks.HeaderEvent) != models.EventMessageNew || r.Header.Get(webhooks.HeaderSignature) != webhooks.Sign(webhook.Secret, body) {
+		t.Errorf("unexpected headers %v", r.Header)
+	}
+	var payload struct {
+		webhooks.Payload
+		Data models.Message `json:"data"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != models.EventMessageNew || payload.Data.Content != "build is green" || payload.Data.SenderID != alice.UserID {
+		t.Errorf("unexpected payload %s", body)
+	}
+}
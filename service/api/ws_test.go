@@ -0,0 +1,225 @@
+package api
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"AlChats/service/realtime"
+	"AlChats/service/websocket"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialWS opens a WebSocket connection to the test server as the user, and reads the hello frame.
+func dialWS(t *testing.T, srv *httptest.Server, token string) *websocket.Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, srv.URL+"/ws?access_token="+token, nil)
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	if f := readFrame(t, conn); f.Type != realtime.TypeHello {
+		t.Fatalf("expected hello, got %+v", f)
+	}
+	return conn
+}
+
+// readFrame reads the next frame, failing the test after a few seconds.
+func readFrame(t *testing.T, conn *websocket.Conn) realtime.Frame {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	var f realtime.Frame
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatalf("decoding frame %s: %v", data, err)
+	}
+	return f
+}
+
+// writeFrame sends a raw frame.
+func writeFrame(t *testing.T, conn *websocket.Conn, frame string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		t.Fatalf("writing frame: %v", err)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	rt := newTestRouter(t)
+	srv := newValidatingServer(t, rt)
+
+	alice, _ := rt.db.SetUser("alice")
+	bob, _ := rt.db.SetUser("bob")
+	carol, _ := rt.db.SetUser("carol")
+	conversation, err := rt.db.SetConversation([]string{alice.UserID, bob.UserID}, false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unauthenticated handshakes are refused before upgrading
+	if _, resp, err := websocket.Dial(context.Background(), srv.URL+"/ws", nil); !errors.Is(err, websocket.ErrBadHandshake) ||
+		resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %v", err)
+	}
+
+	aliceToken, bobToken := newSession(t, rt.db, alice.UserID), newSession(t, rt.db, bob.UserID)
+	aliceConn := dialWS(t, srv, aliceToken)
+	bobConn := dialWS(t, srv, bobToken)
+	carolConn := dialWS(t, srv, newSession(t, rt.db, carol.UserID))
+
+	// Alice is told that Bob is online; Carol shares no conversation with them
+	if f := readFrame(t, aliceConn); f.Type != framePresence || !strings.Contains(string(f.Data), `"userId":"`+bob.UserID+`","online":true`) {
+		t.Errorf("unexpected presence event %+v", f)
+	}
+
+	// Send a message: the sender gets the ack, the other member the event
+	writeFrame(t, aliceConn, `{"v":1,"type":"message.send","id":"1","data":{"conversationId":"`+conversation.ConversationID+`","content":"hi bob"}}`)
+	ack := readFrame(t, aliceConn)
+	if ack.Type != realtime.TypeAck || ack.ID != "1" || !strings.Contains(string(ack.Data), `"content":"hi bob"`) {
+		t.Errorf("unexpected ack %+v", ack)
+	}
+	event := readFrame(t, bobConn)
+	if event.Type != frameNewMessage || !strings.Contains(string(event.Data), `"senderId":"`+alice.UserID+`"`) {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if f := readFrame(t, bobConn); f.Type != frameNotification || !strings.Contains(string(f.Data), `"content":"hi bob"`) {
+		t.Errorf("unexpected notification %+v", f)
+	}
+
+	// Muted conversations keep sending the messages, but not the notifications (the next frame of Bob is the ack of
+	// the typing signal below)
+	req, err := http.NewRequest(http.MethodPatch, srv.URL+"/conversations/"+conversation.ConversationID+"/settings",
+		strings.NewReader(`{"muted_until":"`+time.Now().Add(time.Hour).UTC().Format(time.RFC3339)+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+bobToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("muting the conversation: %v, %v", err, resp)
+	}
+	_ = resp.Body.Close()
+	writeFrame(t, aliceConn, `{"v":1,"type":"message.send","id":"1","data":{"conversationId":"`+conversation.ConversationID+`","content":"are you there?"}}`)
+	if f := readFrame(t, aliceConn); f.Type != realtime.TypeAck {
+		t.Errorf("unexpected ack %+v", f)
+	}
+	if f := readFrame(t, bobConn); f.Type != frameNewMessage || !strings.Contains(string(f.Data), `"content":"are you there?"`) {
+		t.Errorf("unexpected event %+v", f)
+	}
+
+	// Typing and read notifications reach the other member only
+	writeFrame(t, bobConn, `{"v":1,"type":"typing","id":"2","data":{"conversationId":"`+conversation.ConversationID+`"}}`)
+	if f := readFrame(t, bobConn); f.Type != realtime.TypeAck || f.ID != "2" {
+		t.Errorf("unexpected typing ack %+v", f)
+	}
+	if f := readFrame(t, aliceConn); f.Type != frameTyping || !strings.Contains(string(f.Data), `"typing":true`) {
+		t.Errorf("unexpected typing event %+v", f)
+	}
+	var sent models.Message
+	if err := json.Unmarshal(ack.Data, &sent); err != nil {
+		t.Fatal(err)
+	}
+	writeFrame(t, bobConn, `{"v":1,"type":"read","id":"3","data":{"conversationId":"`+conversation.ConversationID+`","messageId":"`+sent.MessageID+`"}}`)
+	if f := readFrame(t, bobConn); f.Type != realtime.TypeAck || f.ID != "3" {
+		t.Errorf("unexpected read ack %+v", f)
+	}
+	if f := readFrame(t, aliceConn); f.Type != frameRead || !strings.Contains(string(f.Data), `"messageId":"`+sent.MessageID+`"`) {
+		t.Errorf("unexpected read event %+v", f)
+	}
+
+	// Errors are reported to the client with the request id
+	for _, frame := range []string{
+		`{"v":2,"type":"typing","id":"3","data":{"conversationId":"` + conversation.ConversationID + `"}}`,
+		`{"v":1,"type":"unknown","id":"3"}`,
+		`{"v":1,"type":"message.send","id":"3","data":{"conversationId":"` + conversation.ConversationID + `","content":"  "}}`,
+		`{"v":1,"type":"read","id":"3","data":{"conversationId":"` + conversation.ConversationID + `","messageId":"unknown"}}`,
+	} {
+		writeFrame(t, aliceConn, frame)
+		if f := readFrame(t, aliceConn); f.Type != realtime.TypeError || f.ID != "3" {
+			t.Errorf("%s: expected an error, got %+v", frame, f)
+		}
+	}
+	writeFrame(t, carolConn, `{"v":1,"type":"message.send","id":"4","data":{"conversationId":"`+conversation.ConversationID+`","content":"hi"}}`)
+	if f := readFrame(t, carolConn); f.Type != realtime.TypeError || !strings.Contains(string(f.Data), "not a member") {
+		t.Errorf("expected an error for a non member, got %+v", f)
+	}
+
+	// The message was saved
+	messages, _, err := rt.db.GetConversationMessages(conversation.ConversationID, database.Page{})
+	if err != nil || len(messages) != 2 || messages[0].Content != "hi bob" {
+		t.Fatalf("expected the message to be saved: %v, %+v", err, messages)
+	}
+
+	// Edits reach every member
+	req, err = http.NewRequest(http.MethodPatch, srv.URL+"/conversations/"+conversation.ConversationID+"/messages/"+messages[0].MessageID,
+		strings.NewReader(`{"content":"hi Bob"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+aliceToken)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("editing the message: %v, %v", err, resp)
+	}
+	_ = resp.Body.Close()
+	for _, conn := range []*websocket.Conn{aliceConn, bobConn} {
+		if f := readFrame(t, conn); f.Type != frameEditedMessage || !strings.Contains(string(f.Data), `"content":"hi Bob"`) {
+			t.Errorf("unexpected edit event %+v", f)
+		}
+	}
+
+	// Deletions for everyone reach every member too
+	req, err = http.NewRequest(http.MethodDelete, srv.URL+"/conversations/"+conversation.ConversationID+"/messages/"+messages[0].MessageID+"?for=everyone", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+aliceToken)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("deleting the message: %v, %v", err, resp)
+	}
+	_ = resp.Body.Close()
+	for _, conn := range []*websocket.Conn{aliceConn, bobConn} {
+		if f := readFrame(t, conn); f.Type != frameDeletedMessage || !strings.Contains(string(f.Data), `"forEveryone":true`) {
+			t.Errorf("unexpected deletion event %+v", f)
+		}
+	}
+
+	// Closing the router closes the connections with "going away"
+	done := make(chan error, 1)
+	go func() { done <- rt.Close() }()
+	_ = aliceConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = aliceConn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Errorf("expected a going away close, got %v", err)
+	}
+	for _, conn := range []*websocket.Conn{bobConn, carolConn} {
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				break
+			}
+		}
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("closing the router: %v", err)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("closing the router did not return")
+	}
+}
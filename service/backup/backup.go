@@ -0,0 +1,289 @@
+/*
+Package backup creates, verifies and restores backups of the chat database.
+
+Backups are consistent copies of the live database (see database.AppDatabase.Backup), saved in a directory as
+`alchat-<UTC timestamp>.db` files. Each backup has a `.sha256` file next to it, in the `sha256sum` format, so that it
+can be verified before being restored. Only the most recent backups are kept.
+
+To use this package, create a Manager with New() and call Manager.Run() whenever a backup is needed; Restore() is meant
+to be used while the server is stopped (e.g., by the `alchatctl restore` command).
+*/
+package backup
+
+import (
+	"AlChats/service/database"
+	"AlChats/service/globaltime"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backup file names are filePrefix + timestamp (in timeLayout) + fileSuffix
+const (
+	filePrefix     = "alchat-"
+	fileSuffix     = ".db"
+	checksumSuffix = ".sha256"
+	timeLayout     = "20060102T150405.000000000Z"
+)
+
+// Config is used to provide dependencies and configuration to the New function.
+type Config struct {
+	// Database is the database to back up
+	Database database.AppDatabase
+
+	// Dir is the directory where backups are saved. It is created if it does not exist.
+	Dir string
+
+	// Retain is the number of backups to keep; older backups are deleted after each new backup
+	Retain int
+}
+
+// Info describes a backup file
+type Info struct {
+	File      string    `json:"file"`
+	CreatedAt time.Time `json:"createdAt"`
+	SizeBytes int64     `json:"sizeBytes"`
+	SHA256    string    `json:"sha256"`
+}
+
+// Manager creates backups and applies the retention policy. It is safe for concurrent use: backups are serialized.
+type Manager struct {
+	db     database.AppDatabase
+	dir    string
+	retain int
+
+	mu sync.Mutex
+}
+
+// New returns a new Manager instance
+func New(cfg Config) (*Manager, error) {
+	if cfg.Database == nil {
+		return nil, errors.New("database is required")
+	}
+	if cfg.Dir == "" {
+		return nil, errors.New("backup directory is required")
+	}
+	if cfg.Retain < 1 {
+		return nil, errors.New("at least one backup must be retained")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("creating backup directory: %w", err)
+	}
+	return &Manager{db: cfg.Database, dir: cfg.Dir, retain: cfg.Retain}, nil
+}
+
+// Run creates a new backup, writes its checksum, and deletes the backups exceeding the retention.
+func (m *Manager) Run() (Info, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	createdAt := globaltime.Now().UTC()
+	name := filePrefix + createdAt.Format(timeLayout) + fileSuffix
+	path := filepath.Join(m.dir, name)
+
+	// Write the backup in a temporary file, so that an incomplete backup is never mistaken for a good one
+	tmp := path + ".tmp"
+	_ = os.Remove(tmp)
+	if err := m.db.Backup(tmp); err != nil {
+		_ = os.Remove(tmp)
+		return Info{}, err
+	}
+
+	sum, size, err := checksum(tmp)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return Info{}, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return Info{}, fmt.Errorf("saving backup: %w", err)
+	}
+	if err := os.WriteFile(path+checksumSuffix, []byte(sum+"  "+name+"\n"), 0o640); err != nil {
+		return Info{}, fmt.Errorf("saving backup checksum: %w", err)
+	}
+
+	if err := m.prune(); err != nil {
+		return Info{}, err
+	}
+
+	return Info{File: name, CreatedAt: createdAt, SizeBytes: size, SHA256: sum}, nil
+}
+
+// List returns the backups in the directory, the most recent first.
+func (m *Manager) List() ([]Info, error) {
+	names, err := backupFiles(m.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []Info
+	for i := len(names) - 1; i >= 0; i-- {
+		info, err := Verify(filepath.Join(m.dir, names[i]))
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, info)
+	}
+	return backups, nil
+}
+
+// prune deletes the oldest backups exceeding the retention.
+func (m *Manager) prune() error {
+	names, err := backupFiles(m.dir)
+	if err != nil {
+		return err
+	}
+	for len(names) > m.retain {
+		path := filepath.Join(m.dir, names[0])
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("deleting old backup: %w", err)
+		}
+		if err := os.Remove(path + checksumSuffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("deleting old backup checksum: %w", err)
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// backupFiles returns the names of the backup files in dir, the oldest first.
+func backupFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("listing backups: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix) {
+			names = append(names, name)
+		}
+	}
+	// The timestamp format sorts lexicographically
+	sort.Strings(names)
+	return names, nil
+}
+
+// Verify checks the backup file at `path` against its checksum file.
+func Verify(path string) (Info, error) {
+	name := filepath.Base(path)
+	info := Info{File: name}
+	if ts := strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix); ts != name {
+		info.CreatedAt, _ = time.Parse(timeLayout, ts)
+	}
+
+	expected, err := os.ReadFile(path + checksumSuffix)
+	if err != nil {
+		return info, fmt.Errorf("reading checksum of %s: %w", name, err)
+	}
+	fields := strings.Fields(string(expected))
+	if len(fields) == 0 {
+		return info, fmt.Errorf("checksum file of %s is empty", name)
+	}
+
+	info.SHA256, info.SizeBytes, err = checksum(path)
+	if err != nil {
+		return info, err
+	}
+	if info.SHA256 != fields[0] {
+		return info, fmt.Errorf("checksum mismatch for %s: the file is damaged", name)
+	}
+	return info, nil
+}
+
+// Restore replaces the database file at `dbPath` with the backup at `backupPath`. The backup checksum, its integrity
+// and its schema version are checked before touching the database; the replaced database is kept as
+// `<dbPath>.pre-restore`, together with its journal files (e.g. `<dbPath>-wal.pre-restore`), so that it can be put
+// back. The server must not be running.
+func Restore(backupPath, dbPath string) (Info, error) {
+	info, err := Verify(backupPath)
+	if err != nil {
+		return info, err
+	}
+
+	version, err := database.CheckFile(backupPath)
+	if err != nil {
+		return info, err
+	}
+	if version > database.SchemaVersion() {
+		return info, fmt.Errorf("backup schema version %d is newer than the supported version %d",
+			version, database.SchemaVersion())
+	}
+
+	// Copy the backup next to the database, then swap the files with renames
+	tmp := dbPath + ".restore"
+	if err := copyFile(backupPath, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return info, err
+	}
+	if _, err := os.Stat(dbPath); err == nil {
+		if err := os.Rename(dbPath, dbPath+".pre-restore"); err != nil {
+			_ = os.Remove(tmp)
+			return info, fmt.Errorf("moving the current database away: %w", err)
+		}
+	}
+	// Journal files of the replaced database must not be applied to the restored one, but they hold its latest
+	// changes: they are kept with it. The ones of an earlier restore are removed, as they belong to another database.
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		side := dbPath + suffix
+		if err := os.Remove(side + ".pre-restore"); err != nil && !os.IsNotExist(err) {
+			_ = os.Remove(tmp)
+			return info, fmt.Errorf("removing %s: %w", side+".pre-restore", err)
+		}
+		if err := os.Rename(side, side+".pre-restore"); err != nil && !os.IsNotExist(err) {
+			_ = os.Remove(tmp)
+			return info, fmt.Errorf("moving %s away: %w", side, err)
+		}
+	}
+	if err := os.Rename(tmp, dbPath); err != nil {
+		return info, fmt.Errorf("swapping the database file: %w", err)
+	}
+	return info, nil
+}
+
+// checksum returns the SHA-256 of the file in hex, and its size.
+func checksum(path string) (string, int64, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer fp.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, fp)
+	if err != nil {
+		return "", 0, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// copyFile copies src to dst, syncing dst to disk.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", dst, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("copying %s: %w", src, err)
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("syncing %s: %w", dst, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,117 @@
+package backup
+
+import (
+	"AlChats/service/database"
+	"AlChats/service/globaltime"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openDatabase opens (or creates) the database file at path.
+func openDatabase(t *testing.T, path string) database.AppDatabase {
+	t.Helper()
+	dbconn, err := sql.Open("sqlite3", database.SQLiteDSN(path))
+	if err != nil {
+		t.Fatalf("opening SQLite: %v", err)
+	}
+	t.Cleanup(func() { _ = dbconn.Close() })
+	db, err := database.New(dbconn)
+	if err != nil {
+		t.Fatalf("creating AppDatabase: %v", err)
+	}
+	return db
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	db := openDatabase(t, filepath.Join(dir, "live.db"))
+	if _, err := db.SetUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(Config{Database: db, Dir: filepath.Join(dir, "backups"), Retain: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { globaltime.FixedTime = time.Time{} }()
+	var infos []Info
+	for i := 0; i < 3; i++ {
+		globaltime.FixedTime = time.Date(2024, 1, 1, 12, i, 0, 0, time.UTC)
+		info, err := m.Run()
+		if err != nil {
+			t.Fatalf("running backup: %v", err)
+		}
+		infos = append(infos, info)
+	}
+
+	// Only the two most recent backups are retained
+	list, err := m.List()
+	if err != nil {
+		t.Fatalf("listing backups: %v", err)
+	}
+	if len(list) != 2 || list[0].File != infos[2].File || list[1].File != infos[1].File {
+		t.Fatalf("unexpected retained backups: %+v", list)
+	}
+	if !list[0].CreatedAt.Equal(infos[2].CreatedAt) {
+		t.Errorf("expected creation time %v, got %v", infos[2].CreatedAt, list[0].CreatedAt)
+	}
+
+	// Restore the latest backup over a different database
+	target := filepath.Join(dir, "restored.db")
+	for _, file := range []string{target, target + "-wal"} {
+		if err := os.WriteFile(file, []byte("previous content"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Restore(filepath.Join(m.dir, infos[2].File), target); err != nil {
+		t.Fatalf("restoring backup: %v", err)
+	}
+	for _, file := range []string{target, target + "-wal"} {
+		if previous, err := os.ReadFile(file + ".pre-restore"); err != nil || string(previous) != "previous content" {
+			t.Errorf("expected %s to be kept, got %q, %v", file, previous, err)
+		}
+	}
+	if _, err := os.Stat(target + "-wal"); !os.IsNotExist(err) {
+		t.Errorf("expected the journal of the replaced database to be moved away, got %v", err)
+	}
+	restored := openDatabase(t, target)
+	if _, err := restored.GetUserByUsername("alice"); err != nil {
+		t.Errorf("expected the restored database to contain the user: %v", err)
+	}
+}
+
+func TestRestoreRejectsDamagedBackups(t *testing.T) {
+	dir := t.TempDir()
+	db := openDatabase(t, filepath.Join(dir, "live.db"))
+	m, err := New(Config{Database: db, Dir: dir, Retain: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := m.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, info.File)
+
+	fp, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = fp.WriteString("garbage")
+	_ = fp.Close()
+
+	target := filepath.Join(dir, "target.db")
+	if _, err := Restore(path, target); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected a checksum mismatch, got %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("expected the target to be untouched")
+	}
+}
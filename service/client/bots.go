@@ -0,0 +1,58 @@
+package client
+
+import (
+	"AlChats/service/api/models"
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// CreateIncomingWebhook creates an incoming webhook posting as the authenticated bot into a conversation
+// (`POST /conversations/{id}/incoming-webhooks`). Only bots can do it: their client uses an API key as token.
+func (c *Client) CreateIncomingWebhook(ctx context.Context, conversationID string) (models.IncomingWebhook, error) {
+	var webhook models.IncomingWebhook
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/incoming-webhooks",
+		auth:   true,
+	}, &webhook)
+	return webhook, err
+}
+
+// ListIncomingWebhooks returns the incoming webhooks of the authenticated bot in a conversation, oldest first
+// (`GET /conversations/{id}/incoming-webhooks`).
+func (c *Client) ListIncomingWebhooks(ctx context.Context, conversationID string) ([]models.IncomingWebhook, error) {
+	var webhooks struct {
+		Items []models.IncomingWebhook `json:"items"`
+	}
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/incoming-webhooks",
+		auth:   true,
+	}, &webhooks)
+	return webhooks.Items, err
+}
+
+// DeleteIncomingWebhook deletes an incoming webhook of the authenticated bot
+// (`DELETE /conversations/{id}/incoming-webhooks/{token}`).
+func (c *Client) DeleteIncomingWebhook(ctx context.Context, conversationID, token string) error {
+	return c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/incoming-webhooks/" + url.PathEscape(token),
+		auth:   true,
+	}, nil)
+}
+
+// PostIncomingMessage posts a message through an incoming webhook (`POST /incoming-webhooks/{token}`). The token is
+// the only credential, so any client can do it.
+func (c *Client) PostIncomingMessage(ctx context.Context, token, content string) (models.Message, error) {
+	var message models.Message
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/incoming-webhooks/" + url.PathEscape(token),
+		body: struct {
+			Content string `json:"content"`
+		}{content},
+	}, &message)
+	return message, err
+}
@@ -0,0 +1,200 @@
+/*
+Package client is a Go client for the AlChats web API (see `doc/api.yaml`). Every method maps to an operation of the
+API, takes a context.Context and returns the types from `service/api/models`.
+
+To use this package, create a new Client with New() passing a valid Config. Authenticated operations need a token: it
+can be set in the Config, or obtained by creating a session with CreateSession (the client keeps it for the following
+requests).
+
+Example:
+
+	c, err := client.New(client.Config{BaseURL: "http://localhost:3000"})
+	if err != nil {
+		return err
+	}
+	me, err := c.CreateSession(ctx, "john_doe")
+	if err != nil {
+		return err
+	}
+	page, err := c.ListConversations(ctx, client.PageRequest{Limit: 20})
+
+Errors returned by the API are *APIError values, and can be matched with errors.Is against ErrNotFound, ErrConflict
+and the other sentinel errors of this package.
+*/
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is the timeout applied to requests whose context has no deadline, when Config.Timeout is not set
+const DefaultTimeout = 10 * time.Second
+
+// Config is used to provide dependencies and configuration to the New function.
+type Config struct {
+	// BaseURL is the URL of the API server (e.g., http://localhost:3000)
+	BaseURL string
+
+	// Token is the bearer token for authenticated operations (optional, see Client.CreateSession), or the API key of
+	// a bot
+	Token string
+
+	// HTTPClient is the HTTP client used for requests (optional, http.DefaultClient by default)
+	HTTPClient *http.Client
+
+	// Timeout is applied to requests whose context has no deadline (optional, DefaultTimeout by default)
+	Timeout time.Duration
+}
+
+// Client is a client for the AlChats web API. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	timeout    time.Duration
+
+	mu    sync.RWMutex
+	token string
+}
+
+// New returns a new Client instance
+func New(cfg Config) (*Client, error) {
+	if cfg.BaseURL == "" {
+		return nil, errors.New("base URL is required")
+	}
+	baseURL, err := url.Parse(strings.TrimSuffix(cfg.BaseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing base URL: %w", err)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+
+	return &Client{
+		baseURL:    baseURL,
+		httpClient: cfg.HTTPClient,
+		timeout:    cfg.Timeout,
+		token:      cfg.Token,
+	}, nil
+}
+
+// Token returns the bearer token used for authenticated operations.
+func (c *Client) Token() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token
+}
+
+// SetToken changes the bearer token used for authenticated operations.
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+// PageRequest selects a page of a list operation. The zero value requests the first page with the default size.
+type PageRequest struct {
+	// Limit is the maximum number of items (optional)
+	Limit int
+
+	// Cursor is the NextCursor or PrevCursor of a previous page (optional)
+	Cursor string
+}
+
+func (p PageRequest) query() url.Values {
+	var q = url.Values{}
+	if p.Limit > 0 {
+		q.Set("limit", fmt.Sprint(p.Limit))
+	}
+	if p.Cursor != "" {
+		q.Set("cursor", p.Cursor)
+	}
+	return q
+}
+
+// request describes an API call
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{}
+	auth   bool
+
+	// download receives the response body as it is, in place of decoding it as JSON
+	download io.Writer
+}
+
+// do sends the request and decodes the JSON response in `out` (if not nil).
+func (c *Client) do(ctx context.Context, req request, out interface{}) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	u, err := url.Parse(c.baseURL.String() + req.path)
+	if err != nil {
+		return fmt.Errorf("building request URL: %w", err)
+	}
+	u.RawQuery = req.query.Encode()
+
+	var body io.Reader
+	if req.body != nil {
+		buf, err := json.Marshal(req.body)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+		body = bytes.NewReader(buf)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), body)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if req.auth {
+		token := c.Token()
+		if token == "" {
+			return ErrNoToken
+		}
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", req.method, req.path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newAPIError(resp)
+	}
+	if req.download != nil {
+		if _, err := io.Copy(req.download, resp.Body); err != nil {
+			return fmt.Errorf("%s %s: reading response: %w", req.method, req.path, err)
+		}
+		return nil
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: decoding response: %w", req.method, req.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,434 @@
+package client
+
+import (
+	"AlChats/service/api"
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"AlChats/service/netguard"
+	"AlChats/service/webhooks"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+)
+
+// TestMain resolves all the hosts to a public address, so that the webhooks and the push subscriptions of the tests
+// can be registered without a DNS server.
+func TestMain(m *testing.M) {
+	netguard.LookupIPAddr = func(context.Context, string) ([]net.IPAddr, error) {
+		return []net.IPAddr{{IP: net.IPv4(192, 0, 2, 1)}}, nil
+	}
+	os.Exit(m.Run())
+}
+
+// newTestClient starts an API server backed by a new SQLite database, and returns a client for it.
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+	c, err := New(Config{BaseURL: newTestServer(t, nil)})
+	if err != nil {
+		t.Fatalf("creating the client: %v", err)
+	}
+	return c
+}
+
+// newTestServer starts the API on a new SQLite database, and returns its URL. The database is passed to `seed` first,
+// if it is not nil.
+func newTestServer(t *testing.T, seed func(db database.AppDatabase)) string {
+	t.Helper()
+
+	dbconn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatalf("opening SQLite: %v", err)
+	}
+	t.Cleanup(func() { _ = dbconn.Close() })
+	db, err := database.New(dbconn)
+	if err != nil {
+		t.Fatalf("creating AppDatabase: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	dispatcher, err := webhooks.New(webhooks.Config{Database: db, Logger: logger})
+	if err != nil {
+		t.Fatalf("creating the webhook dispatcher: %v", err)
+	}
+	router, err := api.New(api.Config{Logger: logger, Database: db, Webhooks: dispatcher})
+	if err != nil {
+		t.Fatalf("creating the API router: %v", err)
+	}
+	if seed != nil {
+		seed(db)
+	}
+	srv := httptest.NewServer(router.Handler())
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t)
+
+	if _, err := c.ListConversations(ctx, PageRequest{}); !errors.Is(err, ErrNoToken) {
+		t.Errorf("expected ErrNoToken without a session, got %v", err)
+	}
+
+	alice, err := c.CreateSession(ctx, "alice")
+	if err != nil {
+		t.Fatalf("creating session: %v", err)
+	}
+	if c.Token() == "" || c.Token() == alice.UserID {
+		t.Errorf("expected the session token to be kept, got %q", c.Token())
+	}
+	if _, err := c.CreateSession(ctx, "alice"); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for a duplicate username, got %v", err)
+	}
+
+	// Create the other users with a second client, so the first one keeps its token
+	other, err := New(Config{BaseURL: c.baseURL.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := other.CreateSession(ctx, "bob")
+	if err != nil {
+		t.Fatalf("creating session: %v", err)
+	}
+	carol, err := other.CreateSession(ctx, "carol")
+	if err != nil {
+		t.Fatalf("creating session: %v", err)
+	}
+
+	renamed, err := c.UpdateUsername(ctx, bob.UserID, "robert")
+	if err != nil || renamed.Username != "robert" {
+		t.Errorf("renaming user: %v, %+v", err, renamed)
+	}
+
+	user, err := c.GetUser(ctx, alice.UserID)
+	if err != nil || user != alice {
+		t.Errorf("getting user: %v, %+v", err, user)
+	}
+	var apiErr *APIError
+	if _, err := c.GetUser(ctx, "unknown"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected a 404 APIError for an unknown user, got %v", err)
+	}
+
+	// Walk all users two at a time
+	var seen int
+	page := PageRequest{Limit: 2}
+	for {
+		users, err := c.ListUsers(ctx, page)
+		if err != nil {
+			t.Fatalf("listing users: %v", err)
+		}
+		seen += len(users.Items)
+		if users.NextCursor == "" {
+			break
+		}
+		page.Cursor = users.NextCursor
+	}
+	if seen != 3 {
+		t.Errorf("expected 3 users, got %d", seen)
+	}
+
+	conversation, err := c.CreateConversation(ctx, NewConversation{UserIDs: []string{alice.UserID, bob.UserID}})
+	if err != nil {
+		t.Fatalf("creating conversation: %v", err)
+	}
+	if _, err := c.CreateConversation(ctx, NewConversation{UserIDs: []string{alice.UserID}}); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("expected ErrBadRequest for a conversation with one user, got %v", err)
+	}
+
+	details, err := c.GetConversation(ctx, conversation.ConversationID)
+	if err != nil || len(details.Members) != 2 {
+		t.Errorf("getting conversation: %v, %+v", err, details)
+	}
+	if _, err := other.GetConversation(ctx, conversation.ConversationID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden for a non member (%s), got %v", carol.Username, err)
+	}
+
+	conversations, err := c.ListConversations(ctx, PageRequest{})
+	if err != nil || len(conversations.Items) != 1 {
+		t.Errorf("listing conversations: %v, %+v", err, conversations)
+	}
+
+	group, err := c.CreateConversation(ctx, NewConversation{UserIDs: []string{alice.UserID, bob.UserID}, IsGroup: true, GroupName: "friends"})
+	if err != nil {
+		t.Fatalf("creating group: %v", err)
+	}
+	name := "best friends"
+	if details, err := c.UpdateGroup(ctx, group.ConversationID, GroupUpdate{GroupName: &name}); err != nil || details.GroupName != name {
+		t.Errorf("renaming group: %v, %+v", err, details)
+	}
+	if details, err := c.AddMembers(ctx, group.ConversationID, carol.UserID); err != nil || len(details.Members) != 3 {
+		t.Errorf("adding members: %v, %+v", err, details)
+	}
+	if _, err := other.AddMembers(ctx, group.ConversationID, bob.UserID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden adding members as a member, got %v", err)
+	}
+	details, err = c.SetMemberRole(ctx, group.ConversationID, carol.UserID, models.RoleOwner)
+	if err != nil {
+		t.Errorf("transferring the ownership: %v", err)
+	}
+	for _, member := range details.Members {
+		if (member.UserID == carol.UserID) != (member.Role == models.RoleOwner) {
+			t.Errorf("unexpected role after the transfer %+v", member)
+		}
+	}
+	if err := c.RemoveMember(ctx, group.ConversationID, alice.UserID); err != nil {
+		t.Errorf("leaving group: %v", err)
+	}
+
+	// The new owner invites alice back, once
+	invite, err := other.CreateInvite(ctx, group.ConversationID, InviteOptions{MaxUses: 1})
+	if err != nil || invite.Token == "" || invite.MaxUses != 1 {
+		t.Fatalf("creating invite: %v, %+v", err, invite)
+	}
+	if details, err := c.JoinWithInvite(ctx, invite.Token); err != nil || len(details.Members) != 3 {
+		t.Errorf("joining with invite: %v, %+v", err, details)
+	}
+	if _, err := c.JoinWithInvite(ctx, invite.Token); !errors.Is(err, ErrGone) {
+		t.Errorf("expected ErrGone for a used up invite, got %v", err)
+	}
+	if joins, err := other.GetInviteJoins(ctx, group.ConversationID, invite.Token); err != nil || len(joins) != 1 || joins[0].UserID != alice.UserID {
+		t.Errorf("getting joins: %v, %+v", err, joins)
+	}
+	if err := other.RevokeInvite(ctx, group.ConversationID, invite.Token); err != nil {
+		t.Errorf("revoking invite: %v", err)
+	}
+	if invites, err := other.ListInvites(ctx, group.ConversationID); err != nil || len(invites) != 1 || invites[0].RevokedAt == nil {
+		t.Errorf("listing invites: %v, %+v", err, invites)
+	}
+	if _, err := c.ListInvites(ctx, group.ConversationID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden listing invites as a member, got %v", err)
+	}
+
+	// Any member of a direct conversation manages its webhooks
+	webhook, err := c.CreateWebhook(ctx, conversation.ConversationID, "https://ci.example.com/hooks", models.EventMessageNew)
+	if err != nil || webhook.Secret == "" || len(webhook.Events) != 1 {
+		t.Fatalf("creating webhook: %v, %+v", err, webhook)
+	}
+	if _, err := c.CreateWebhook(ctx, conversation.ConversationID, "https://ci.example.com/hooks", "message.read"); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("expected ErrBadRequest for an unknown event, got %v", err)
+	}
+	if _, err := other.CreateWebhook(ctx, conversation.ConversationID, "https://ci.example.com/hooks"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden creating a webhook as a non member, got %v", err)
+	}
+	if list, err := c.ListWebhooks(ctx, conversation.ConversationID); err != nil || len(list) != 1 || list[0].Secret != "" {
+		t.Errorf("listing webhooks: %v, %+v", err, list)
+	}
+	if deliveries, err := c.ListWebhookDeliveries(ctx, conversation.ConversationID, webhook.WebhookID, PageRequest{}); err != nil || len(deliveries.Items) != 0 {
+		t.Errorf("listing deliveries: %v, %+v", err, deliveries)
+	}
+	if _, err := c.RetryWebhookDelivery(ctx, conversation.ConversationID, webhook.WebhookID, "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound retrying an unknown delivery, got %v", err)
+	}
+	if err := c.DeleteWebhook(ctx, conversation.ConversationID, webhook.WebhookID); err != nil {
+		t.Errorf("deleting webhook: %v", err)
+	}
+
+	// Archiving hides the group from the list, until the archived conversations are requested
+	archived, until := true, time.Now().Add(time.Hour)
+	settings, err := c.UpdateSettings(ctx, group.ConversationID, SettingsUpdate{Archived: &archived, MutedUntil: &until})
+	if err != nil || !settings.Archived || settings.MutedUntil == nil {
+		t.Errorf("archiving group: %v, %+v", err, settings)
+	}
+	if settings, err := c.UpdateSettings(ctx, group.ConversationID, SettingsUpdate{Unmute: true}); err != nil || settings.MutedUntil != nil || !settings.Archived {
+		t.Errorf("unmuting group: %v, %+v", err, settings)
+	}
+	if conversations, err := c.ListConversations(ctx, PageRequest{}); err != nil || len(conversations.Items) != 1 {
+		t.Errorf("expected the group to be hidden: %v, %+v", err, conversations)
+	}
+	if conversations, err := c.ListConversationsIncludingArchived(ctx, PageRequest{}); err != nil || len(conversations.Items) != 2 {
+		t.Errorf("expected the archived group: %v, %+v", err, conversations)
+	}
+
+	imported, err := c.ImportWhatsApp(ctx, WhatsAppImport{
+		Chat: "31/12/2020, 21:41 - Alice: Happy new year!\n01/01/2021, 00:02 - Bob: Same to you\n",
+		Self: "Alice",
+	})
+	if err != nil || imported.Messages != 2 || imported.Conversation.IsGroup {
+		t.Fatalf("importing chat: %v, %+v", err, imported)
+	}
+	messages, err := c.ListMessages(ctx, imported.Conversation.ConversationID, PageRequest{Limit: 1})
+	if err != nil || len(messages.Items) != 1 || messages.Items[0].Content != "Happy new year!" || messages.NextCursor == "" {
+		t.Errorf("listing messages: %v, %+v", err, messages)
+	}
+	if len(messages.Items) == 1 {
+		// Imported messages are older than the edit window
+		old := messages.Items[0]
+		if _, err := c.EditMessage(ctx, old.ConversationID, old.MessageID, "Happy 2021!"); !errors.Is(err, ErrForbidden) {
+			t.Errorf("expected ErrForbidden editing an old message, got %v", err)
+		}
+		if history, err := c.GetMessageHistory(ctx, old.ConversationID, old.MessageID); err != nil || len(history) != 0 {
+			t.Errorf("expected no history: %v, %+v", err, history)
+		}
+		if err := c.DeleteMessage(ctx, old.ConversationID, old.MessageID, true); !errors.Is(err, ErrForbidden) {
+			t.Errorf("expected ErrForbidden deleting an old message for everyone, got %v", err)
+		}
+		if err := c.DeleteMessage(ctx, old.ConversationID, old.MessageID, false); err != nil {
+			t.Errorf("deleting a message for oneself: %v", err)
+		}
+	}
+	sent, err := c.SendMessage(ctx, imported.Conversation.ConversationID, "Happy 2021!")
+	if err != nil || sent.SenderID != alice.UserID || sent.Content != "Happy 2021!" {
+		t.Fatalf("sending message: %v, %+v", err, sent)
+	}
+	if edited, err := c.EditMessage(ctx, sent.ConversationID, sent.MessageID, "Happy 2021 to all!"); err != nil || edited.EditedAt == nil {
+		t.Errorf("editing a new message: %v, %+v", err, edited)
+	}
+	if _, err := c.SendMessage(ctx, imported.Conversation.ConversationID, " "); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("expected ErrBadRequest for an empty message, got %v", err)
+	}
+
+	if privacy, err := c.SetPrivacy(ctx, models.Privacy{HideLastSeen: true}); err != nil || !privacy.HideLastSeen {
+		t.Errorf("setting privacy: %v, %+v", err, privacy)
+	}
+	if presence, err := other.GetPresence(ctx, alice.UserID); err != nil || presence.Online || presence.LastSeen != nil {
+		t.Errorf("expected the last seen time of alice to be hidden: %v, %+v", err, presence)
+	}
+
+	keys := models.PushKeys{
+		P256dh: "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4",
+		Auth:   "BTBZMqHH6r4Tts7J_aSIgg",
+	}
+	subscription, err := c.AddPushSubscription(ctx, "https://push.example/alice", keys)
+	if err != nil || subscription.SubscriptionID == "" || subscription.Keys != keys {
+		t.Fatalf("subscribing: %v, %+v", err, subscription)
+	}
+	if _, err := c.AddPushSubscription(ctx, "not a url", keys); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("expected ErrBadRequest for an invalid endpoint, got %v", err)
+	}
+	if subscriptions, err := c.ListPushSubscriptions(ctx); err != nil || len(subscriptions) != 1 {
+		t.Errorf("listing the subscriptions: %v, %+v", err, subscriptions)
+	}
+	if err := c.DeletePushSubscription(ctx, subscription.SubscriptionID); err != nil {
+		t.Errorf("unsubscribing: %v", err)
+	}
+	if err := c.DeletePushSubscription(ctx, subscription.SubscriptionID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound unsubscribing twice, got %v", err)
+	}
+	if _, err := c.GetVAPIDKey(ctx); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound without Web Push, got %v", err)
+	}
+
+	var archive bytes.Buffer
+	if err := c.ExportAccount(ctx, &archive); err != nil || !bytes.HasPrefix(archive.Bytes(), []byte("PK")) {
+		t.Errorf("exporting account: %v (%d bytes)", err, archive.Len())
+	}
+
+	token := c.Token()
+	if err := c.DeleteAccount(ctx); err != nil {
+		t.Fatalf("deleting account: %v", err)
+	}
+	c.SetToken(token)
+	if _, err := c.ListConversations(ctx, PageRequest{}); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized after deleting the account, got %v", err)
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	c, err := New(Config{BaseURL: srv.URL, Timeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetUser(context.Background(), "any"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a deadline exceeded error, got %v", err)
+	}
+}
+
+func TestBotClient(t *testing.T) {
+	ctx := context.Background()
+	var key models.APIKey
+	var group models.Conversation
+	url := newTestServer(t, func(db database.AppDatabase) {
+		alice, err := db.SetUser("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		bot, err := db.CreateBot("ci")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key, err = db.CreateAPIKey(bot.UserID, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if group, err = db.SetConversation([]string{alice.UserID, bot.UserID}, true, "builds", ""); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	c, err := New(Config{BaseURL: url, Token: key.Key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Members run /help, /me and /poll, the admins also /invite and /rename
+	if list, err := c.ListCommands(ctx, group.ConversationID); err != nil || len(list) != 3 || list[0].Name != "help" {
+		t.Errorf("listing commands: %v, %+v", err, list)
+	}
+
+	poll, err := c.CreatePoll(ctx, group.ConversationID, NewPoll{Question: "Deploy today?", Options: []string{"Yes", "No"}})
+	if err != nil || poll.Poll == nil || len(poll.Poll.Options) != 2 {
+		t.Fatalf("creating poll: %v, %+v", err, poll)
+	}
+	if tally, err := c.Vote(ctx, group.ConversationID, poll.MessageID, 1); err != nil || tally.Options[1].Votes != 1 {
+		t.Errorf("voting: %v, %+v", err, tally)
+	}
+	if _, err := c.Vote(ctx, group.ConversationID, poll.MessageID, 0, 1); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("expected ErrBadRequest voting twice in a single-choice poll, got %v", err)
+	}
+	if err := c.RetractVote(ctx, group.ConversationID, poll.MessageID); err != nil {
+		t.Errorf("retracting vote: %v", err)
+	}
+	if tally, err := c.ClosePoll(ctx, group.ConversationID, poll.MessageID); err != nil || tally.ClosedAt == nil || tally.Voters != 0 {
+		t.Errorf("closing poll: %v, %+v", err, tally)
+	}
+	if _, err := c.Vote(ctx, group.ConversationID, poll.MessageID, 0); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict voting in a closed poll, got %v", err)
+	}
+
+	webhook, err := c.CreateIncomingWebhook(ctx, group.ConversationID)
+	if err != nil || webhook.Token == "" || webhook.UserID != key.UserID {
+		t.Fatalf("creating incoming webhook: %v, %+v", err, webhook)
+	}
+	if list, err := c.ListIncomingWebhooks(ctx, group.ConversationID); err != nil || len(list) != 1 || list[0].Token != webhook.Token {
+		t.Errorf("listing incoming webhooks: %v, %+v", err, list)
+	}
+
+	// Posting needs no token
+	anonymous, err := New(Config{BaseURL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := anonymous.PostIncomingMessage(ctx, webhook.Token, "build is green")
+	if err != nil || message.SenderID != key.UserID || message.Content != "build is green" {
+		t.Errorf("posting message: %v, %+v", err, message)
+	}
+	if _, err := anonymous.PostIncomingMessage(ctx, webhook.Token, ""); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("expected ErrBadRequest for an empty message, got %v", err)
+	}
+
+	if err := c.DeleteIncomingWebhook(ctx, group.ConversationID, webhook.Token); err != nil {
+		t.Errorf("deleting incoming webhook: %v", err)
+	}
+	if _, err := anonymous.PostIncomingMessage(ctx, webhook.Token, "hi"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after deleting the incoming webhook, got %v", err)
+	}
+}
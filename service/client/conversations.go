@@ -0,0 +1,162 @@
+package client
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/commands"
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ConversationPage is a page of conversations
+type ConversationPage struct {
+	Items      []models.Conversation `json:"items"`
+	NextCursor string                `json:"nextCursor,omitempty"`
+	PrevCursor string                `json:"prevCursor,omitempty"`
+}
+
+// NewConversation describes a conversation to be created
+type NewConversation struct {
+	UserIDs    []string `json:"user_ids"`
+	IsGroup    bool     `json:"is_group"`
+	GroupName  string   `json:"group_name,omitempty"`
+	GroupPhoto string   `json:"group_photo,omitempty"`
+}
+
+// CreateConversation creates a 1:1 or group conversation (`POST /conversation`). The authenticated user must be one of
+// the members, and is the owner of the group.
+func (c *Client) CreateConversation(ctx context.Context, conversation NewConversation) (models.Conversation, error) {
+	var created models.Conversation
+	err := c.do(ctx, request{method: http.MethodPost, path: "/conversation", body: conversation, auth: true}, &created)
+	return created, err
+}
+
+// ListConversations returns a page of the conversations of the authenticated user (`GET /conversations`).
+func (c *Client) ListConversations(ctx context.Context, page PageRequest) (ConversationPage, error) {
+	var conversations ConversationPage
+	err := c.do(ctx, request{method: http.MethodGet, path: "/conversations", query: page.query(), auth: true}, &conversations)
+	return conversations, err
+}
+
+// ListConversationsIncludingArchived is like ListConversations, with the conversations the user archived too.
+func (c *Client) ListConversationsIncludingArchived(ctx context.Context, page PageRequest) (ConversationPage, error) {
+	query := page.query()
+	query.Set("include_archived", "true")
+	var conversations ConversationPage
+	err := c.do(ctx, request{method: http.MethodGet, path: "/conversations", query: query, auth: true}, &conversations)
+	return conversations, err
+}
+
+// GetConversation returns a conversation with its members (`GET /conversations/{id}`).
+func (c *Client) GetConversation(ctx context.Context, conversationID string) (models.ConversationDetails, error) {
+	var details models.ConversationDetails
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/conversations/" + url.PathEscape(conversationID),
+		auth:   true,
+	}, &details)
+	return details, err
+}
+
+// GroupUpdate describes the changes to a group: the nil fields are not changed
+type GroupUpdate struct {
+	GroupName  *string `json:"group_name,omitempty"`
+	GroupPhoto *string `json:"group_photo,omitempty"`
+}
+
+// UpdateGroup renames a group or changes its photo (`PATCH /conversations/{id}`). Only admins can do it.
+func (c *Client) UpdateGroup(ctx context.Context, conversationID string, update GroupUpdate) (models.ConversationDetails, error) {
+	var details models.ConversationDetails
+	err := c.do(ctx, request{
+		method: http.MethodPatch,
+		path:   "/conversations/" + url.PathEscape(conversationID),
+		body:   update,
+		auth:   true,
+	}, &details)
+	return details, err
+}
+
+// AddMembers adds users to a group (`POST /conversations/{id}/members`). Only admins can do it.
+func (c *Client) AddMembers(ctx context.Context, conversationID string, userIDs ...string) (models.ConversationDetails, error) {
+	var details models.ConversationDetails
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/members",
+		body:   map[string][]string{"user_ids": userIDs},
+		auth:   true,
+	}, &details)
+	return details, err
+}
+
+// RemoveMember removes a member from a group, or leaves it if userID is the authenticated user
+// (`DELETE /conversations/{id}/members/{uid}`).
+func (c *Client) RemoveMember(ctx context.Context, conversationID, userID string) error {
+	return c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/members/" + url.PathEscape(userID),
+		auth:   true,
+	}, nil)
+}
+
+// SetMemberRole changes the role of a member of a group (`PUT /conversations/{id}/members/{uid}/role`). Giving the
+// models.RoleOwner role transfers the ownership.
+func (c *Client) SetMemberRole(ctx context.Context, conversationID, userID, role string) (models.ConversationDetails, error) {
+	var details models.ConversationDetails
+	err := c.do(ctx, request{
+		method: http.MethodPut,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/members/" + url.PathEscape(userID) + "/role",
+		body:   map[string]string{"role": role},
+		auth:   true,
+	}, &details)
+	return details, err
+}
+
+// SettingsUpdate describes the changes to the settings of the user for a conversation: the nil fields are not changed.
+// Unmute clears the mute, and takes precedence over MutedUntil.
+type SettingsUpdate struct {
+	Pinned     *bool
+	MutedUntil *time.Time
+	Unmute     bool
+	Archived   *bool
+}
+
+// UpdateSettings pins, mutes or archives a conversation for the authenticated user
+// (`PATCH /conversations/{id}/settings`), and returns the new settings.
+func (c *Client) UpdateSettings(ctx context.Context, conversationID string, update SettingsUpdate) (models.ConversationSettings, error) {
+	body := map[string]interface{}{}
+	if update.Pinned != nil {
+		body["pinned"] = *update.Pinned
+	}
+	if update.Archived != nil {
+		body["archived"] = *update.Archived
+	}
+	if update.Unmute {
+		body["muted_until"] = nil
+	} else if update.MutedUntil != nil {
+		body["muted_until"] = update.MutedUntil
+	}
+
+	var settings models.ConversationSettings
+	err := c.do(ctx, request{
+		method: http.MethodPatch,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/settings",
+		body:   body,
+		auth:   true,
+	}, &settings)
+	return settings, err
+}
+
+// ListCommands returns the slash commands that the authenticated user can run in a conversation, sorted by name
+// (`GET /conversations/{id}/commands`). They are run by sending a message starting with `/`.
+func (c *Client) ListCommands(ctx context.Context, conversationID string) ([]commands.Command, error) {
+	var list struct {
+		Items []commands.Command `json:"items"`
+	}
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/commands",
+		auth:   true,
+	}, &list)
+	return list.Items, err
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"AlChats/service/api/models"
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// InviteOptions describes an invite to be created: the zero value never expires and has no limit of uses
+type InviteOptions struct {
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxUses   int        `json:"max_uses,omitempty"`
+}
+
+// CreateInvite creates an invite to a group (`POST /conversations/{id}/invites`). Only admins can do it.
+func (c *Client) CreateInvite(ctx context.Context, conversationID string, options InviteOptions) (models.Invite, error) {
+	var invite models.Invite
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/invites",
+		body:   options,
+		auth:   true,
+	}, &invite)
+	return invite, err
+}
+
+// ListInvites returns the invites of a group, oldest first (`GET /conversations/{id}/invites`). Only admins can do
+// it.
+func (c *Client) ListInvites(ctx context.Context, conversationID string) ([]models.Invite, error) {
+	var invites struct {
+		Items []models.Invite `json:"items"`
+	}
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/invites",
+		auth:   true,
+	}, &invites)
+	return invites.Items, err
+}
+
+// RevokeInvite stops an invite from working (`DELETE /conversations/{id}/invites/{token}`). Only admins can do it.
+func (c *Client) RevokeInvite(ctx context.Context, conversationID, token string) error {
+	return c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/invites/" + url.PathEscape(token),
+		auth:   true,
+	}, nil)
+}
+
+// GetInviteJoins returns who joined a group with an invite, earliest first
+// (`GET /conversations/{id}/invites/{token}/joins`). Only admins can do it.
+func (c *Client) GetInviteJoins(ctx context.Context, conversationID, token string) ([]models.InviteJoin, error) {
+	var joins struct {
+		Items []models.InviteJoin `json:"items"`
+	}
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/invites/" + url.PathEscape(token) + "/joins",
+		auth:   true,
+	}, &joins)
+	return joins.Items, err
+}
+
+// JoinWithInvite adds the authenticated user to the group of an invite (`POST /invites/{token}/join`). Invites that
+// expired, were used up or revoked report ErrGone.
+func (c *Client) JoinWithInvite(ctx context.Context, token string) (models.ConversationDetails, error) {
+	var details models.ConversationDetails
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/invites/" + url.PathEscape(token) + "/join",
+		auth:   true,
+	}, &details)
+	return details, err
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"AlChats/service/api/models"
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// MessagePage is a page of messages
+type MessagePage struct {
+	Items      []models.Message `json:"items"`
+	NextCursor string           `json:"nextCursor,omitempty"`
+	PrevCursor string           `json:"prevCursor,omitempty"`
+}
+
+// WhatsAppImport describes a WhatsApp chat export to be imported
+type WhatsAppImport struct {
+	Chat         string            `json:"chat"`
+	Self         string            `json:"self,omitempty"`
+	Participants map[string]string `json:"participants,omitempty"`
+	GroupName    string            `json:"group_name,omitempty"`
+	Timezone     string            `json:"timezone,omitempty"`
+	DateOrder    string            `json:"date_order,omitempty"`
+}
+
+// NewPoll describes a poll to send
+type NewPoll struct {
+	Question       string   `json:"question"`
+	Options        []string `json:"options"`
+	MultipleChoice bool     `json:"multiple_choice,omitempty"`
+	Anonymous      bool     `json:"anonymous,omitempty"`
+}
+
+// ImportResult describes an imported chat
+type ImportResult struct {
+	Conversation models.Conversation `json:"conversation"`
+	Messages     int                 `json:"messages"`
+	Skipped      int                 `json:"skipped"`
+	Placeholders []models.User       `json:"placeholders"`
+}
+
+// ListMessages returns a page of the messages of a conversation, oldest first
+// (`GET /conversations/{id}/messages`).
+func (c *Client) ListMessages(ctx context.Context, conversationID string, page PageRequest) (MessagePage, error) {
+	var messages MessagePage
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/messages",
+		query:  page.query(),
+		auth:   true,
+	}, &messages)
+	return messages, err
+}
+
+// SendMessage sends a message of the authenticated user to a conversation, without a WebSocket connection
+// (`POST /conversations/{id}/messages`). Slash commands are not run.
+func (c *Client) SendMessage(ctx context.Context, conversationID, content string) (models.Message, error) {
+	var message models.Message
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/messages",
+		body:   map[string]string{"content": content},
+		auth:   true,
+	}, &message)
+	return message, err
+}
+
+// EditMessage replaces the content of a message of the authenticated user
+// (`PATCH /conversations/{id}/messages/{mid}`).
+func (c *Client) EditMessage(ctx context.Context, conversationID, messageID, content string) (models.Message, error) {
+	var message models.Message
+	err := c.do(ctx, request{
+		method: http.MethodPatch,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/messages/" + url.PathEscape(messageID),
+		body:   map[string]string{"content": content},
+		auth:   true,
+	}, &message)
+	return message, err
+}
+
+// DeleteMessage deletes a message for the authenticated user only, or for every member of the conversation
+// (`DELETE /conversations/{id}/messages/{mid}`).
+func (c *Client) DeleteMessage(ctx context.Context, conversationID, messageID string, forEveryone bool) error {
+	query := url.Values{"for": {"me"}}
+	if forEveryone {
+		query.Set("for", "everyone")
+	}
+	return c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/messages/" + url.PathEscape(messageID),
+		query:  query,
+		auth:   true,
+	}, nil)
+}
+
+// GetMessageHistory returns the previous versions of a message, oldest first
+// (`GET /conversations/{id}/messages/{mid}/history`).
+func (c *Client) GetMessageHistory(ctx context.Context, conversationID, messageID string) ([]models.MessageVersion, error) {
+	var history struct {
+		Items []models.MessageVersion `json:"items"`
+	}
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/messages/" + url.PathEscape(messageID) + "/history",
+		auth:   true,
+	}, &history)
+	return history.Items, err
+}
+
+// CreatePoll sends a poll to a conversation as a message of the authenticated user
+// (`POST /conversations/{id}/polls`).
+func (c *Client) CreatePoll(ctx context.Context, conversationID string, poll NewPoll) (models.Message, error) {
+	var message models.Message
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/polls",
+		body:   poll,
+		auth:   true,
+	}, &message)
+	return message, err
+}
+
+// Vote replaces the choices of the authenticated user in a poll with the options, by index, and returns the new tally
+// (`PUT /conversations/{id}/messages/{mid}/votes`).
+func (c *Client) Vote(ctx context.Context, conversationID, messageID string, options ...int) (models.Poll, error) {
+	var poll models.Poll
+	err := c.do(ctx, request{
+		method: http.MethodPut,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/messages/" + url.PathEscape(messageID) + "/votes",
+		body:   map[string][]int{"options": options},
+		auth:   true,
+	}, &poll)
+	return poll, err
+}
+
+// RetractVote deletes the choices of the authenticated user in a poll
+// (`DELETE /conversations/{id}/messages/{mid}/votes`).
+func (c *Client) RetractVote(ctx context.Context, conversationID, messageID string) error {
+	return c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/messages/" + url.PathEscape(messageID) + "/votes",
+		auth:   true,
+	}, nil)
+}
+
+// ClosePoll stops the voting in a poll of the authenticated user, and returns the final tally
+// (`POST /conversations/{id}/messages/{mid}/close`).
+func (c *Client) ClosePoll(ctx context.Context, conversationID, messageID string) (models.Poll, error) {
+	var poll models.Poll
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/messages/" + url.PathEscape(messageID) + "/close",
+		auth:   true,
+	}, &poll)
+	return poll, err
+}
+
+// ImportWhatsApp imports a WhatsApp chat export as a new conversation of the authenticated user
+// (`POST /conversation/import`).
+func (c *Client) ImportWhatsApp(ctx context.Context, chat WhatsAppImport) (ImportResult, error) {
+	var result ImportResult
+	err := c.do(ctx, request{method: http.MethodPost, path: "/conversation/import", body: chat, auth: true}, &result)
+	return result, err
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"AlChats/service/api/models"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// UserPage is a page of users
+type UserPage struct {
+	Items      []models.User `json:"items"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+	PrevCursor string        `json:"prevCursor,omitempty"`
+}
+
+// CreateSession creates a new user with the given username (`POST /user/session`). The client keeps the session
+// token for the following authenticated operations (see Token).
+func (c *Client) CreateSession(ctx context.Context, username string) (models.User, error) {
+	var session models.Session
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/user/session",
+		query:  url.Values{"username": {username}},
+	}, &session)
+	if err != nil {
+		return session.User, err
+	}
+	c.SetToken(session.Token)
+	return session.User, nil
+}
+
+// UpdateUsername changes the username of a user (`POST /user`).
+func (c *Client) UpdateUsername(ctx context.Context, userID, newUsername string) (models.User, error) {
+	var user models.User
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/user",
+		query:  url.Values{"userId": {userID}, "newUsername": {newUsername}},
+	}, &user)
+	return user, err
+}
+
+// DeleteAccount deletes the authenticated user (`DELETE /user`). The token is cleared, as it is not valid anymore.
+func (c *Client) DeleteAccount(ctx context.Context) error {
+	err := c.do(ctx, request{method: http.MethodDelete, path: "/user", auth: true}, nil)
+	if err != nil {
+		return err
+	}
+	c.SetToken("")
+	return nil
+}
+
+// ExportAccount writes the data export (a ZIP archive) of the authenticated user to w (`GET /user/export`). The
+// archive is streamed: on errors, w may have received part of it.
+func (c *Client) ExportAccount(ctx context.Context, w io.Writer) error {
+	return c.do(ctx, request{method: http.MethodGet, path: "/user/export", auth: true, download: w}, nil)
+}
+
+// ListUsers returns a page of users (`GET /users`).
+func (c *Client) ListUsers(ctx context.Context, page PageRequest) (UserPage, error) {
+	var users UserPage
+	err := c.do(ctx, request{method: http.MethodGet, path: "/users", query: page.query()}, &users)
+	return users, err
+}
+
+// GetUser returns the public profile of a user (`GET /users/{id}`).
+func (c *Client) GetUser(ctx context.Context, userID string) (models.User, error) {
+	var user models.User
+	err := c.do(ctx, request{method: http.MethodGet, path: "/users/" + url.PathEscape(userID)}, &user)
+	return user, err
+}
+
+// GetPresence returns the online status of a user (`GET /users/{id}/presence`).
+func (c *Client) GetPresence(ctx context.Context, userID string) (models.Presence, error) {
+	var presence models.Presence
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/users/" + url.PathEscape(userID) + "/presence",
+		auth:   true,
+	}, &presence)
+	return presence, err
+}
+
+// GetPrivacy returns the privacy settings of the authenticated user (`GET /user/privacy`).
+func (c *Client) GetPrivacy(ctx context.Context) (models.Privacy, error) {
+	var privacy models.Privacy
+	err := c.do(ctx, request{method: http.MethodGet, path: "/user/privacy", auth: true}, &privacy)
+	return privacy, err
+}
+
+// SetPrivacy replaces the privacy settings of the authenticated user (`PUT /user/privacy`).
+func (c *Client) SetPrivacy(ctx context.Context, privacy models.Privacy) (models.Privacy, error) {
+	var saved models.Privacy
+	err := c.do(ctx, request{method: http.MethodPut, path: "/user/privacy", body: privacy, auth: true}, &saved)
+	return saved, err
+}
+
+// AddPushSubscription registers a Web Push subscription of the authenticated user (`POST /user/push-subscriptions`):
+// the new messages are notified to it while the user is not connected.
+func (c *Client) AddPushSubscription(ctx context.Context, endpoint string, keys models.PushKeys) (models.PushSubscription, error) {
+	var subscription models.PushSubscription
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/user/push-subscriptions",
+		body:   map[string]interface{}{"endpoint": endpoint, "keys": keys},
+		auth:   true,
+	}, &subscription)
+	return subscription, err
+}
+
+// ListPushSubscriptions returns the push subscriptions of the authenticated user, oldest first
+// (`GET /user/push-subscriptions`).
+func (c *Client) ListPushSubscriptions(ctx context.Context) ([]models.PushSubscription, error) {
+	var subscriptions struct {
+		Items []models.PushSubscription `json:"items"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/user/push-subscriptions", auth: true}, &subscriptions)
+	return subscriptions.Items, err
+}
+
+// DeletePushSubscription stops the notifications to a push subscription of the authenticated user
+// (`DELETE /user/push-subscriptions/{id}`).
+func (c *Client) DeletePushSubscription(ctx context.Context, subscriptionID string) error {
+	return c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   "/user/push-subscriptions/" + url.PathEscape(subscriptionID),
+		auth:   true,
+	}, nil)
+}
+
+// GetVAPIDKey returns the public key that browsers subscribe with (`GET /push/vapid-key`). It reports ErrNotFound if
+// the server does not send the notifications with Web Push.
+func (c *Client) GetVAPIDKey(ctx context.Context) (string, error) {
+	var key struct {
+		PublicKey string `json:"publicKey"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/push/vapid-key", auth: true}, &key)
+	return key.PublicKey, err
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"AlChats/service/api/models"
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// WebhookDeliveryPage is a page of the delivery log of a webhook
+type WebhookDeliveryPage struct {
+	Items      []models.WebhookDelivery `json:"items"`
+	NextCursor string                   `json:"nextCursor,omitempty"`
+	PrevCursor string                   `json:"prevCursor,omitempty"`
+}
+
+// CreateWebhook subscribes a URL to the events of a conversation, all of them if `events` is empty
+// (`POST /conversations/{id}/webhooks`). The returned webhook is the only one including the secret of the signatures.
+// In groups, only admins can do it.
+func (c *Client) CreateWebhook(ctx context.Context, conversationID, webhookURL string, events ...string) (models.Webhook, error) {
+	var webhook models.Webhook
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/webhooks",
+		body: struct {
+			URL    string   `json:"url"`
+			Events []string `json:"events,omitempty"`
+		}{webhookURL, events},
+		auth: true,
+	}, &webhook)
+	return webhook, err
+}
+
+// ListWebhooks returns the webhooks of a conversation, oldest first, without their secrets
+// (`GET /conversations/{id}/webhooks`). In groups, only admins can do it.
+func (c *Client) ListWebhooks(ctx context.Context, conversationID string) ([]models.Webhook, error) {
+	var webhooks struct {
+		Items []models.Webhook `json:"items"`
+	}
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/webhooks",
+		auth:   true,
+	}, &webhooks)
+	return webhooks.Items, err
+}
+
+// DeleteWebhook deletes a webhook with its delivery log (`DELETE /conversations/{id}/webhooks/{wid}`). In groups, only
+// admins can do it.
+func (c *Client) DeleteWebhook(ctx context.Context, conversationID, webhookID string) error {
+	return c.do(ctx, request{
+		method: http.MethodDelete,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/webhooks/" + url.PathEscape(webhookID),
+		auth:   true,
+	}, nil)
+}
+
+// ListWebhookDeliveries returns a page of the delivery log of a webhook, oldest first
+// (`GET /conversations/{id}/webhooks/{wid}/deliveries`). In groups, only admins can do it.
+func (c *Client) ListWebhookDeliveries(ctx context.Context, conversationID, webhookID string, page PageRequest) (WebhookDeliveryPage, error) {
+	var deliveries WebhookDeliveryPage
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/conversations/" + url.PathEscape(conversationID) + "/webhooks/" + url.PathEscape(webhookID) + "/deliveries",
+		query:  page.query(),
+		auth:   true,
+	}, &deliveries)
+	return deliveries, err
+}
+
+// RetryWebhookDelivery queues a dead delivery again
+// (`POST /conversations/{id}/webhooks/{wid}/deliveries/{did}/retry`). Deliveries that are not dead report ErrConflict.
+func (c *Client) RetryWebhookDelivery(ctx context.Context, conversationID, webhookID, deliveryID string) (models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path: "/conversations/" + url.PathEscape(conversationID) + "/webhooks/" + url.PathEscape(webhookID) +
+			"/deliveries/" + url.PathEscape(deliveryID) + "/retry",
+		auth: true,
+	}, &delivery)
+	return delivery, err
+}
@@ -0,0 +1,271 @@
+/*
+Package commands runs the slash commands: messages starting with `/`, like `/rename Climbing club`, which the server
+executes instead of sending them to the conversation.
+
+A Registry keeps the commands by name. The API registers the built-in ones, and bots register their own while they
+are connected (see Command.BotID). Before running a command, the registry checks that it is available in the
+conversation (Command.GroupOnly, and the bot of a bot command must be a member) and that the caller is allowed to run
+it (Command.Allowed), so the handlers only deal with their arguments. The `/help` command, listing the commands the
+caller can run, is always registered.
+
+For example:
+
+	registry := commands.NewRegistry()
+	err := registry.Register(commands.Command{
+		Name:        "shrug",
+		Description: "Send a shrug",
+		Handler: func(call commands.Call) (commands.Result, error) {
+			return commands.Result{Content: strings.TrimSpace(call.Args + ` ¯\_(ツ)_/¯`)}, nil
+		},
+	})
+
+	if name, args, ok := commands.Parse(content); ok {
+		result, err := registry.Run(commands.Call{Name: name, Args: args, ...})
+	}
+
+A message starting with `//` is not a command: it is sent with the first slash removed (see Unescape), so that users
+can still send messages starting with a slash.
+*/
+package commands
+
+import (
+	"AlChats/service/api/models"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Errors of Registry.Run. Both are errors of the caller, like the Error ones.
+var (
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrNotAllowed     = errors.New("not allowed to run this command")
+)
+
+// Error is a mistake of the caller, e.g. wrong arguments: its message is shown to them as it is. Other errors of the
+// handlers are internal.
+type Error struct {
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Errorf returns an Error with the formatted message.
+func Errorf(format string, args ...interface{}) error {
+	return &Error{Message: fmt.Sprintf(format, args...)}
+}
+
+// IsCallerError reports whether err is a mistake of the caller, which can be shown to them, rather than an internal
+// error.
+func IsCallerError(err error) bool {
+	var e *Error
+	return errors.As(err, &e) || errors.Is(err, ErrUnknownCommand) || errors.Is(err, ErrNotAllowed)
+}
+
+// nameRe matches the names of the commands
+var nameRe = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+// Call is a command sent by a member of a conversation
+type Call struct {
+	Name string // Name of the command, without the slash
+	Args string // Text after the name, trimmed
+
+	Conversation models.Conversation
+	Members      []models.Member // Members of the conversation, with their roles
+	Caller       models.Member   // Member who sent the command; the members of 1:1 conversations are all RoleMember
+}
+
+// Result is the outcome of a command
+type Result struct {
+	// Content, if not empty, is sent to the conversation as a message of the caller, in place of the command
+	Content string `json:"content,omitempty"`
+
+	// Reply, if not empty, is shown only to the caller (e.g., the help)
+	Reply string `json:"reply,omitempty"`
+}
+
+// Handler runs a command, whose availability and permissions were already checked. Mistakes of the caller are
+// returned as Error.
+type Handler func(call Call) (Result, error)
+
+// Command is a slash command
+type Command struct {
+	Name        string `json:"name"`                // Name, without the slash: a lowercase letter, then letters, digits or _
+	Usage       string `json:"usage,omitempty"`     // Arguments, for the help (e.g., "<name>")
+	Description string `json:"description"`         // What the command does, in one line
+	GroupOnly   bool   `json:"groupOnly,omitempty"` // The command can only be run in groups
+
+	// Allowed reports whether the member can run the command, e.g. depending on their role. Nil allows everyone.
+	Allowed func(caller models.Member) bool `json:"-"`
+
+	// BotID is the bot that registered the command, empty for the built-in ones. Bot commands are only available in
+	// the conversations of the bot.
+	BotID string `json:"botId,omitempty"`
+
+	Handler Handler `json:"-"`
+}
+
+// Registry keeps the commands by name. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.RWMutex
+	commands map[string]Command
+}
+
+// NewRegistry returns a registry with only the `/help` command.
+func NewRegistry() *Registry {
+	r := &Registry{commands: make(map[string]Command)}
+	r.commands["help"] = Command{
+		Name:        "help",
+		Description: "List the commands you can run here",
+		Handler: func(call Call) (Result, error) {
+			return Result{Reply: r.Help(call)}, nil
+		},
+	}
+	return r
+}
+
+// Register adds a command. Names are unique: a command cannot replace another one.
+func (r *Registry) Register(cmd Command) error {
+	if !nameRe.MatchString(cmd.Name) {
+		return fmt.Errorf("invalid command name %q", cmd.Name)
+	}
+	if cmd.Handler == nil {
+		return fmt.Errorf("command %q has no handler", cmd.Name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.commands[cmd.Name]; ok {
+		return fmt.Errorf("command /%s already exists", cmd.Name)
+	}
+	r.commands[cmd.Name] = cmd
+	return nil
+}
+
+// ReplaceBotCommands replaces the commands of the bot with `cmds` (their BotID is set to the bot), or removes them
+// if `cmds` is empty. Nothing changes if a command is not valid, or is taken by a built-in command or another bot.
+func (r *Registry) ReplaceBotCommands(botID string, cmds []Command) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make(map[string]bool, len(cmds))
+	for _, cmd := range cmds {
+		switch existing, taken := r.commands[cmd.Name]; {
+		case !nameRe.MatchString(cmd.Name):
+			return fmt.Errorf("invalid command name %q", cmd.Name)
+		case cmd.Handler == nil:
+			return fmt.Errorf("command %q has no handler", cmd.Name)
+		case names[cmd.Name]:
+			return fmt.Errorf("command /%s is repeated", cmd.Name)
+		case taken && existing.BotID != botID:
+			return fmt.Errorf("command /%s already exists", cmd.Name)
+		}
+		names[cmd.Name] = true
+	}
+
+	for name, cmd := range r.commands {
+		if cmd.BotID == botID {
+			delete(r.commands, name)
+		}
+	}
+	for _, cmd := range cmds {
+		cmd.BotID = botID
+		r.commands[cmd.Name] = cmd
+	}
+	return nil
+}
+
+// Available returns the commands that the caller of `call` can run in its conversation, sorted by name. The name and
+// the arguments of `call` are ignored.
+func (r *Registry) Available(call Call) []Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var available []Command
+	for _, cmd := range r.commands {
+		if cmd.availableTo(call) {
+			available = append(available, cmd)
+		}
+	}
+	sort.Slice(available, func(i, j int) bool { return available[i].Name < available[j].Name })
+	return available
+}
+
+// Help returns the help text of the commands that the caller of `call` can run in its conversation: one line for
+// each command.
+func (r *Registry) Help(call Call) string {
+	var b strings.Builder
+	for i, cmd := range r.Available(call) {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("/" + cmd.Name)
+		if cmd.Usage != "" {
+			b.WriteString(" " + cmd.Usage)
+		}
+		b.WriteString(" - " + cmd.Description)
+	}
+	return b.String()
+}
+
+// Run runs the command of the call, after checking that it exists in the conversation (ErrUnknownCommand) and that
+// the caller can run it (ErrNotAllowed).
+func (r *Registry) Run(call Call) (Result, error) {
+	r.mu.RLock()
+	cmd, ok := r.commands[call.Name]
+	r.mu.RUnlock()
+	if !ok || !cmd.inConversation(call) {
+		return Result{}, fmt.Errorf("/%s: %w", call.Name, ErrUnknownCommand)
+	}
+	if cmd.Allowed != nil && !cmd.Allowed(call.Caller) {
+		return Result{}, fmt.Errorf("/%s: %w", call.Name, ErrNotAllowed)
+	}
+	return cmd.Handler(call)
+}
+
+// inConversation reports whether the command exists in the conversation of the call.
+func (cmd Command) inConversation(call Call) bool {
+	if cmd.GroupOnly && !call.Conversation.IsGroup {
+		return false
+	}
+	if cmd.BotID == "" {
+		return true
+	}
+	for _, member := range call.Members {
+		if member.UserID == cmd.BotID {
+			return true
+		}
+	}
+	return false
+}
+
+// availableTo reports whether the caller of the call can run the command in its conversation.
+func (cmd Command) availableTo(call Call) bool {
+	return cmd.inConversation(call) && (cmd.Allowed == nil || cmd.Allowed(call.Caller))
+}
+
+// Parse returns the name and the arguments of the command in the content of a message. ok is false if the content
+// is not a command: it does not start with a slash followed by a valid name, or it starts with `//`.
+func Parse(content string) (name, args string, ok bool) {
+	if !strings.HasPrefix(content, "/") {
+		return "", "", false
+	}
+	name = content[1:]
+	if i := strings.IndexAny(name, " \t\n"); i >= 0 {
+		name, args = name[:i], strings.TrimSpace(name[i:])
+	}
+	if !nameRe.MatchString(name) {
+		return "", "", false
+	}
+	return name, args, true
+}
+
+// Unescape returns the content of a message that is not a command: a leading `//` is sent as a single slash.
+func Unescape(content string) string {
+	if strings.HasPrefix(content, "//") {
+		return content[1:]
+	}
+	return content
+}
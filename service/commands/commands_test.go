@@ -0,0 +1,123 @@
+package commands
+
+import (
+	"AlChats/service/api/models"
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		content, name, args string
+		ok                  bool
+	}{
+		{"/help", "help", "", true},
+		{"/rename  Climbing club ", "rename", "Climbing club", true},
+		{"/invite\n@bob @carol", "invite", "@bob @carol", true},
+		{"hello /help", "", "", false},
+		{"//help", "", "", false},
+		{"/", "", "", false},
+		{"/Rename x", "", "", false},
+		{"/path/to/file", "", "", false},
+	} {
+		name, args, ok := Parse(tc.content)
+		if name != tc.name || args != tc.args || ok != tc.ok {
+			t.Errorf("Parse(%q) = %q, %q, %v; expected %q, %q, %v", tc.content, name, args, ok, tc.name, tc.args, tc.ok)
+		}
+	}
+
+	if got := Unescape("//help"); got != "/help" {
+		t.Errorf("expected /help, got %q", got)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	echo := func(call Call) (Result, error) { return Result{Content: call.Args}, nil }
+	adminOnly := func(caller models.Member) bool { return caller.Role != models.RoleMember }
+
+	r := NewRegistry()
+	for _, cmd := range []Command{
+		{Name: "echo", Description: "Repeat", Handler: echo},
+		{Name: "kick", Usage: "@user", Description: "Kick", GroupOnly: true, Allowed: adminOnly, Handler: echo},
+	} {
+		if err := r.Register(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Register(Command{Name: "echo", Handler: echo}); err == nil {
+		t.Error("expected an error registering a command twice")
+	}
+	if err := r.Register(Command{Name: "Bad name", Handler: echo}); err == nil {
+		t.Error("expected an error registering an invalid name")
+	}
+
+	admin := models.Member{User: models.User{UserID: "1"}, Role: models.RoleAdmin}
+	member := models.Member{User: models.User{UserID: "2"}, Role: models.RoleMember}
+	group := Call{Conversation: models.Conversation{IsGroup: true}, Members: []models.Member{admin, member}}
+	direct := Call{Conversation: models.Conversation{}, Members: []models.Member{admin, member}}
+
+	call := group
+	call.Caller, call.Name, call.Args = admin, "kick", "@bob"
+	if result, err := r.Run(call); err != nil || result.Content != "@bob" {
+		t.Errorf("unexpected result %+v, %v", result, err)
+	}
+	call.Caller = member
+	if _, err := r.Run(call); !errors.Is(err, ErrNotAllowed) || !IsCallerError(err) {
+		t.Errorf("expected ErrNotAllowed, got %v", err)
+	}
+
+	call = direct
+	call.Caller, call.Name = admin, "kick"
+	if _, err := r.Run(call); !errors.Is(err, ErrUnknownCommand) {
+		t.Errorf("group commands should not exist in 1:1 conversations, got %v", err)
+	}
+
+	call = group
+	call.Caller, call.Name = member, "help"
+	if result, err := r.Run(call); err != nil || result.Reply != "/echo - Repeat\n/help - List the commands you can run here" {
+		t.Errorf("unexpected help %+v, %v", result, err)
+	}
+	call.Caller = admin
+	if result, _ := r.Run(call); result.Reply != "/echo - Repeat\n/help - List the commands you can run here\n/kick @user - Kick" {
+		t.Errorf("unexpected help %q", result.Reply)
+	}
+}
+
+func TestBotCommands(t *testing.T) {
+	echo := func(call Call) (Result, error) { return Result{Content: call.Args}, nil }
+	bot := models.Member{User: models.User{UserID: "9", IsBot: true}, Role: models.RoleMember}
+	user := models.Member{User: models.User{UserID: "1"}, Role: models.RoleMember}
+
+	r := NewRegistry()
+	if err := r.ReplaceBotCommands(bot.UserID, []Command{{Name: "help", Handler: echo}}); err == nil {
+		t.Error("bots should not replace the built-in commands")
+	}
+	if err := r.ReplaceBotCommands(bot.UserID, []Command{{Name: "deploy", Handler: echo}, {Name: "status", Handler: echo}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReplaceBotCommands("8", []Command{{Name: "deploy", Handler: echo}}); err == nil {
+		t.Error("bots should not replace the commands of other bots")
+	}
+
+	withBot := Call{Caller: user, Members: []models.Member{user, bot}, Name: "deploy"}
+	withoutBot := Call{Caller: user, Members: []models.Member{user}, Name: "deploy"}
+	if _, err := r.Run(withBot); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := r.Run(withoutBot); !errors.Is(err, ErrUnknownCommand) {
+		t.Errorf("bot commands should exist only in the conversations of the bot, got %v", err)
+	}
+
+	if err := r.ReplaceBotCommands(bot.UserID, []Command{{Name: "status", Handler: echo}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(r.Available(withBot)); got != 2 {
+		t.Errorf("expected /help and /status, got %d commands", got)
+	}
+	if err := r.ReplaceBotCommands(bot.UserID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(r.Available(withBot)); got != 1 {
+		t.Errorf("expected only /help, got %d commands", got)
+	}
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Backup copies the whole database into a new SQLite file at `path`, using the SQLite online backup API: the copy is
+// consistent, and the database can be used while the backup is running. The file must not exist. Other databases
+// return ErrNotSupported.
+func (db *appdbimpl) Backup(path string) error {
+	if _, ok := db.d.(sqliteDialect); !ok {
+		return fmt.Errorf("backup (use the tools of the database instead): %w", ErrNotSupported)
+	}
+	ctx := context.Background()
+
+	src, err := db.c.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("backup: getting a connection: %w", err)
+	}
+	defer src.Close()
+
+	destDB, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("backup: opening %s: %w", path, err)
+	}
+	defer destDB.Close()
+	dest, err := destDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("backup: opening %s: %w", path, err)
+	}
+	defer dest.Close()
+
+	return dest.Raw(func(destRaw interface{}) error {
+		return src.Raw(func(srcRaw interface{}) error {
+			destConn, ok := destRaw.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("backup: the destination is not a SQLite connection")
+			}
+			srcConn, ok := srcRaw.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("backup: the database is not a SQLite connection")
+			}
+
+			b, err := destConn.Backup("main", srcConn, "main")
+			if err != nil {
+				return fmt.Errorf("backup: %w", err)
+			}
+			// Copy all pages in a single step, so that the copy is a snapshot of the database
+			if _, err := b.Step(-1); err != nil {
+				_ = b.Finish()
+				return fmt.Errorf("backup: %w", err)
+			}
+			if err := b.Finish(); err != nil {
+				return fmt.Errorf("backup: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+// CheckFile opens the SQLite file at `path` read-only, checks its integrity, and returns its schema version. It is
+// used to validate a database file (e.g., a backup) before using it.
+func CheckFile(path string) (int, error) {
+	dsn := "file:" + path + "?mode=ro"
+	if strings.Contains(path, "?") {
+		return 0, fmt.Errorf("invalid database path %q", path)
+	}
+	conn, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return 0, fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer conn.Close()
+
+	var result string
+	if err := conn.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return 0, fmt.Errorf("checking %s: %w", path, err)
+	}
+	if result != "ok" {
+		return 0, fmt.Errorf("%s is corrupted: %s", path, result)
+	}
+
+	version, err := CurrentSchemaVersion(conn)
+	if err != nil {
+		return 0, err
+	}
+	if version == 0 {
+		return 0, fmt.Errorf("%s is not an AlChats database", path)
+	}
+	return version, nil
+}
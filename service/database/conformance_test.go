@@ -0,0 +1,139 @@
+package database_test
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"AlChats/service/database/dbtest"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// defaultPostgresDSN is the local instance used by the tests when ALCHATS_TEST_POSTGRES is not set
+const defaultPostgresDSN = "postgres://postgres@localhost:5432/postgres?sslmode=disable&connect_timeout=2"
+
+func TestSQLite(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) database.AppDatabase {
+		dbconn, err := database.Open(database.DriverSQLite, filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("opening SQLite: %v", err)
+		}
+		t.Cleanup(func() { _ = dbconn.Close() })
+
+		db, err := database.New(dbconn)
+		if err != nil {
+			t.Fatalf("creating AppDatabase: %v", err)
+		}
+		return db
+	})
+}
+
+func TestMemory(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) database.AppDatabase {
+		return database.NewMemory()
+	})
+}
+
+func TestSequentialMemory(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T) database.AppDatabase {
+		return database.NewSequentialMemory()
+	})
+}
+
+// TestMemoryConcurrency is meant to be run with the race detector.
+func TestMemoryConcurrency(t *testing.T) {
+	db := database.NewMemory()
+	alice, _ := db.SetUser("alice")
+	bob, _ := db.SetUser("bob")
+	conversation, err := db.SetConversation([]string{alice.UserID, bob.UserID}, false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_, _ = db.SetUser(fmt.Sprintf("user-%d-%d", i, j))
+				_, _ = db.AddMessages(conversation.ConversationID, []models.Message{{SenderID: alice.UserID, Content: "hi"}})
+				_, _, _ = db.GetAllUsers(database.Page{Limit: 10})
+				_, _, _ = db.GetConversationMessages(conversation.ConversationID, database.Page{Limit: 10})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if stats, err := db.Stats(); err != nil || stats.Users != 2+8*50 || stats.Messages != 8*50 {
+		t.Errorf("unexpected stats %v, %+v", err, stats)
+	}
+}
+
+// TestPostgres runs the suite against the PostgreSQL instance in ALCHATS_TEST_POSTGRES (a connection string), or
+// against a local instance with the default settings. It is skipped if no instance is available, unless
+// ALCHATS_TEST_POSTGRES is set. Every test runs in a new schema, dropped at the end.
+func TestPostgres(t *testing.T) {
+	dsn, required := os.LookupEnv("ALCHATS_TEST_POSTGRES")
+	if !required {
+		dsn = defaultPostgresDSN
+	}
+
+	admin, err := database.Open(database.DriverPostgres, dsn)
+	if err == nil {
+		err = admin.Ping()
+	}
+	if err != nil {
+		if required {
+			t.Fatalf("connecting to PostgreSQL: %v", err)
+		}
+		t.Skipf("no PostgreSQL instance available: %v", err)
+	}
+	defer admin.Close()
+
+	dbtest.Run(t, func(t *testing.T) database.AppDatabase {
+		var suffix [8]byte
+		if _, err := rand.Read(suffix[:]); err != nil {
+			t.Fatal(err)
+		}
+		schema := "alchats_test_" + hex.EncodeToString(suffix[:])
+		if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+			t.Fatalf("creating schema: %v", err)
+		}
+		t.Cleanup(func() { _, _ = admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+		dbconn, err := sql.Open(database.DriverPostgres, withSearchPath(t, dsn, schema))
+		if err != nil {
+			t.Fatalf("opening PostgreSQL: %v", err)
+		}
+		t.Cleanup(func() { _ = dbconn.Close() })
+
+		db, err := database.New(dbconn)
+		if err != nil {
+			t.Fatalf("creating AppDatabase: %v", err)
+		}
+		return db
+	})
+}
+
+// withSearchPath adds the search_path parameter to the connection string (a URL or key=value pairs).
+func withSearchPath(t *testing.T, dsn, schema string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("invalid connection string: %v", err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -16,7 +16,7 @@ This is an example on how to migrate the DB and connect to it:
 
 	// Start Database
 	logger.Println("initializing database support")
-	db, err := sql.Open("sqlite3", "./foo.db")
+	db, err := sql.Open("sqlite3", "./foo.db?_foreign_keys=on")
 	if err != nil {
 		logger.WithError(err).Error("error opening SQLite DB")
 		return fmt.Errorf("opening SQLite: %w", err)
@@ -26,6 +26,16 @@ This is an example on how to migrate the DB and connect to it:
 		_ = db.Close()
 	}()
 
+Foreign keys must be enabled on every connection of the pool (hence the `_foreign_keys=on` parameter in the data source
+name), otherwise the cascades declared in the schema would never fire. New refuses connections where they are disabled.
+
+PostgreSQL is supported too: open the connection with the "postgres" driver (see Open), and New picks the
+implementation by the driver of the connection. Queries are shared between the databases, and written in the SQL both
+understand; the rest (schema, migrations, maintenance) is implemented by a dialect for each of them.
+
+For tests, NewMemory returns an AppDatabase kept in memory, which behaves like the SQL ones (the dbtest package checks
+all implementations against the same suite).
+
 Then you can initialize the AppDatabase and pass it to the api package.
 */
 package database
@@ -34,121 +44,125 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	api "AlChats/service/api/models"
-
-	"os"
-
-	"github.com/sirupsen/logrus"
 )
 
 // AppDatabase is the high level interface for the DB
 type AppDatabase interface {
 	GetUserByID(userID string) (api.User, error)
+	GetUserByUsername(username string) (api.User, error)
 	SetUser(username string) (api.User, error)
-	GetAllUsers() ([]api.User, error)
+	GetAllUsers(page Page) ([]api.User, PageInfo, error)
 	DeleteUserByID(userID string) error
 	UpdateUsername(userId string, newUsername string) (api.User, error)
+	GetPrivacy(userID string) (api.Privacy, error)
+	SetPrivacy(userID string, privacy api.Privacy) error
+	CreateSession(userID string, createdAt time.Time) (string, error)
+	GetUserBySession(token string) (api.User, error)
 
 	SetConversation(userIDs []string, isGroup bool, groupName, groupPhoto string) (api.Conversation, error)
-	GetAllConversations() ([]api.Conversation, error)
-	GetAllConversationsByMember(userID string) ([]api.Conversation, error)
+	ImportChat(chat ChatImport) (api.Conversation, []api.User, error)
+	GetConversationByID(conversationID string) (api.Conversation, error)
+	GetAllConversations(page Page) ([]api.Conversation, PageInfo, error)
+	GetAllConversationsByMember(userID string, page Page) ([]api.Conversation, PageInfo, error)
+	GetConversationsForMember(userID string, includeArchived bool, page Page) ([]api.Conversation, PageInfo, error)
 	GetConversationMembers(conversationID string) ([]api.User, error)
+	GetConversationMemberRoles(conversationID string) ([]api.Member, error)
+	UpdateConversation(conversationID, groupName, groupPhoto string) (api.Conversation, error)
+	AddConversationMembers(conversationID string, userIDs []string) error
+	RemoveConversationMember(conversationID, userID string) error
+	SetMemberRole(conversationID, userID, role string) error
+	GetMemberSettings(conversationID, userID string) (api.ConversationSettings, error)
+	SetMemberSettings(conversationID, userID string, settings api.ConversationSettings) error
+	GetMutedMembers(conversationID string, at time.Time) ([]string, error)
+
+	CreateInvite(invite api.Invite) (api.Invite, error)
+	GetConversationInvites(conversationID string) ([]api.Invite, error)
+	RevokeInvite(conversationID, token string, revokedAt time.Time) (api.Invite, error)
+	JoinWithInvite(token, userID string, joinedAt time.Time) (api.Invite, error)
+	GetInviteJoins(conversationID, token string) ([]api.InviteJoin, error)
+
+	AddPushSubscription(userID string, subscription api.PushSubscription) (api.PushSubscription, error)
+	GetPushSubscriptions(userID string) ([]api.PushSubscription, error)
+	DeletePushSubscription(userID, subscriptionID string) error
+
+	CreateWebhook(webhook api.Webhook) (api.Webhook, error)
+	GetConversationWebhooks(conversationID string) ([]api.Webhook, error)
+	GetWebhook(webhookID string) (api.Webhook, error)
+	DeleteWebhook(conversationID, webhookID string) error
+	EnqueueWebhookEvent(conversationID, event string, data []byte, at time.Time) (int, error)
+	GetDueWebhookDeliveries(now time.Time, limit int) ([]api.WebhookDelivery, error)
+	GetWebhookDelivery(webhookID, deliveryID string) (api.WebhookDelivery, error)
+	GetWebhookDeliveries(webhookID string, page Page) ([]api.WebhookDelivery, PageInfo, error)
+	UpdateWebhookDelivery(delivery api.WebhookDelivery) error
+
+	CreateBot(username string) (api.User, error)
+	GetBots() ([]api.User, error)
+	CreateAPIKey(userID string, createdAt time.Time) (api.APIKey, error)
+	GetAPIKeys(userID string) ([]api.APIKey, error)
+	DeleteAPIKey(userID, keyID string) error
+	GetUserByAPIKey(key string, usedAt time.Time) (api.User, error)
+	CreateIncomingWebhook(conversationID, userID string, createdAt time.Time) (api.IncomingWebhook, error)
+	GetIncomingWebhook(token string) (api.IncomingWebhook, error)
+	GetIncomingWebhooks(conversationID, userID string) ([]api.IncomingWebhook, error)
+	DeleteIncomingWebhook(conversationID, userID, token string) error
+
+	AddMessages(conversationID string, messages []api.Message) ([]api.Message, error)
+	GetConversationMessages(conversationID string, page Page) ([]api.Message, PageInfo, error)
+	GetConversationMessagesForUser(conversationID, userID string, page Page) ([]api.Message, PageInfo, error)
+	GetMessage(conversationID, messageID string) (api.Message, error)
+	EditMessage(conversationID, messageID, content string, editedAt time.Time) (api.Message, error)
+	GetMessageHistory(conversationID, messageID string) ([]api.MessageVersion, error)
+	DeleteMessageForEveryone(conversationID, messageID string, deletedAt time.Time) (api.Message, error)
+	HideMessage(conversationID, messageID, userID string) error
+
+	GetPoll(conversationID, messageID, userID string) (api.Poll, error)
+	Vote(conversationID, messageID, userID string, options []int, votedAt time.Time) (api.Poll, error)
+	RetractVote(conversationID, messageID, userID string) (api.Poll, error)
+	ClosePoll(conversationID, messageID string, closedAt time.Time) (api.Poll, error)
 
 	Ping() error
+	Vacuum() error
+	IntegrityCheck() ([]string, error)
+	Stats() (Stats, error)
+	Backup(path string) error
 }
 
 type appdbimpl struct {
 	c *sql.DB
+
+	// d is the dialect of the database: queries must go through d.rebind
+	d dialect
 }
 
-// New returns a new instance of AppDatabase based on the SQLite connection `db`.
+// New returns a new instance of AppDatabase based on the SQLite or PostgreSQL connection `db` (the implementation is
+// chosen by the driver of the connection).
 // `db` is required - an error will be returned if `db` is `nil`.
 func New(db *sql.DB) (AppDatabase, error) {
 	if db == nil {
 		return nil, errors.New("database is required when building a AppDatabase")
 	}
 
-	if err := initializeDatabase(db); err != nil {
-		return nil, errors.New("failed to initialize database")
+	d, err := dialectOf(db)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkConnection(db); err != nil {
+		return nil, err
+	}
+
+	if _, _, err := Migrate(db); err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
 
 	return &appdbimpl{
 		c: db,
+		d: d,
 	}, nil
 }
 
 func (db *appdbimpl) Ping() error {
 	return db.c.Ping()
 }
-
-func initializeDatabase(db *sql.DB) error {
-	// User table schema
-	user_table_schema := `
-		CREATE TABLE user_table (
-			UserID TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), 
-			Username TEXT NOT NULL UNIQUE, 
-			Photo TEXT 
-		);
-	`
-
-	// Conversation table schema
-	conversation_table_schema := `
-		CREATE TABLE conversation_table (
-			ConversationID TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), -- Generate a UUID
-			IsGroup BOOLEAN NOT NULL CHECK (IsGroup IN (0, 1)), -- Boolean value for group indicator
-			GroupName TEXT, -- Optional group name
-			GroupPhoto TEXT -- Optional group photo
-		);
-	`
-
-	user_conversation_table_schema := `
-		CREATE TABLE user_conversation_table (
-			UserID TEXT NOT NULL,                       -- User ID
-			ConversationID TEXT NOT NULL,               -- Conversation ID
-			PRIMARY KEY (UserID, ConversationID),       -- Composite primary key
-			FOREIGN KEY (UserID) REFERENCES user_table(UserID) ON DELETE CASCADE,  -- Link to user_table
-			FOREIGN KEY (ConversationID) REFERENCES conversation_table(ConversationID) ON DELETE CASCADE -- Link to conversation_table
-		);
-	`
-
-	// Initialize TABLES
-	if err := ensureTableExists(db, "user_table", user_table_schema); err != nil {
-		return err
-	}
-
-	if err := ensureTableExists(db, "conversation_table", conversation_table_schema); err != nil {
-		return err
-	}
-
-	if err := ensureTableExists(db, "user_conversation_table", user_conversation_table_schema); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func ensureTableExists(db *sql.DB, tableName, createStmt string) error {
-
-	// Init logging
-	logger := logrus.New()
-	logger.SetOutput(os.Stdout)
-	logger.SetLevel(logrus.DebugLevel)
-	// logger.SetLevel(logrus.InfoLevel)
-
-	logger.Infof("ensureTableExists %s", tableName)
-
-	var existingTable string
-	err := db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name=?;`, tableName).Scan(&existingTable)
-	if errors.Is(err, sql.ErrNoRows) {
-		logger.Infof("do not exists %s", tableName)
-		_, err := db.Exec(createStmt)
-		logger.Infof("ddl command result %w", err)
-		if err != nil {
-			return fmt.Errorf("error creating table %s: %w", tableName, err)
-		}
-	} else if err != nil {
-		return fmt.Errorf("error checking for table %s: %w", tableName, err)
-	}
-	return nil
-}
@@ -0,0 +1,1663 @@
+/*
+Package dbtest is the conformance test suite of database.AppDatabase: every implementation must pass it, so that they
+can be swapped without changes in the rest of the code.
+
+The suite checks the behavior the API relies on, including the errors (sentinel errors and, where the handlers look at
+the message, its text). Run it from a test of the implementation:
+
+	func TestConformance(t *testing.T) {
+		dbtest.Run(t, func(t *testing.T) database.AppDatabase {
+			return newEmptyDatabase(t)
+		})
+	}
+*/
+package dbtest
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Opener returns a new, empty database for a test. It should register the cleanup with t.Cleanup.
+type Opener func(t *testing.T) database.AppDatabase
+
+// Run runs the conformance suite, opening a new database for each test.
+func Run(t *testing.T, open Opener) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, db database.AppDatabase)
+	}{
+		{"Users", testUsers},
+		{"UserPages", testUserPages},
+		{"Privacy", testPrivacy},
+		{"Sessions", testSessions},
+		{"Conversations", testConversations},
+		{"ConversationPages", testConversationPages},
+		{"DeleteUser", testDeleteUser},
+		{"MemberRoles", testMemberRoles},
+		{"Invites", testInvites},
+		{"MemberSettings", testMemberSettings},
+		{"PushSubscriptions", testPushSubscriptions},
+		{"Webhooks", testWebhooks},
+		{"WebhookDeliveries", testWebhookDeliveries},
+		{"Bots", testBots},
+		{"IncomingWebhooks", testIncomingWebhooks},
+		{"Messages", testMessages},
+		{"ImportChat", testImportChat},
+		{"MessagePages", testMessagePages},
+		{"MessageEdits", testMessageEdits},
+		{"MessageDeletions", testMessageDeletions},
+		{"SystemMessages", testSystemMessages},
+		{"Polls", testPolls},
+		{"Maintenance", testMaintenance},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, open(t))
+		})
+	}
+}
+
+// mustUser creates a user, failing the test on errors.
+func mustUser(t *testing.T, db database.AppDatabase, username string) models.User {
+	t.Helper()
+	user, err := db.SetUser(username)
+	if err != nil {
+		t.Fatalf("creating user %s: %v", username, err)
+	}
+	return user
+}
+
+// mustConversation creates a conversation, failing the test on errors.
+func mustConversation(t *testing.T, db database.AppDatabase, isGroup bool, users ...models.User) models.Conversation {
+	t.Helper()
+	var ids []string
+	for _, user := range users {
+		ids = append(ids, user.UserID)
+	}
+	name := ""
+	if isGroup {
+		name = "group"
+	}
+	conversation, err := db.SetConversation(ids, isGroup, name, "")
+	if err != nil {
+		t.Fatalf("creating conversation: %v", err)
+	}
+	return conversation
+}
+
+// memberIDs returns the sorted IDs of the members of the conversation.
+func memberIDs(t *testing.T, db database.AppDatabase, conversationID string) []string {
+	t.Helper()
+	members, err := db.GetConversationMembers(conversationID)
+	if err != nil {
+		t.Fatalf("getting members: %v", err)
+	}
+	var ids []string
+	for _, member := range members {
+		ids = append(ids, member.UserID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// sortedIDs returns the sorted IDs of the users.
+func sortedIDs(users ...models.User) []string {
+	var ids []string
+	for _, user := range users {
+		ids = append(ids, user.UserID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testUsers(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	if alice.UserID == "" || alice.UserID == bob.UserID || alice.Username != "alice" {
+		t.Errorf("unexpected users %+v, %+v", alice, bob)
+	}
+
+	if _, err := db.SetUser("alice"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected an \"already exists\" error for a duplicate username, got %v", err)
+	}
+
+	if user, err := db.GetUserByID(alice.UserID); err != nil || user != alice {
+		t.Errorf("getting user by ID: %v, %+v", err, user)
+	}
+	if user, err := db.GetUserByUsername("bob"); err != nil || user != bob {
+		t.Errorf("getting user by username: %v, %+v", err, user)
+	}
+	if _, err := db.GetUserByID("unknown"); !errors.Is(err, database.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound for an unknown ID, got %v", err)
+	}
+	if _, err := db.GetUserByUsername("unknown"); !errors.Is(err, database.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound for an unknown username, got %v", err)
+	}
+
+	renamed, err := db.UpdateUsername(bob.UserID, "robert")
+	if err != nil || renamed.UserID != bob.UserID || renamed.Username != "robert" {
+		t.Errorf("renaming user: %v, %+v", err, renamed)
+	}
+	if _, err := db.UpdateUsername(bob.UserID, "robert"); err != nil {
+		t.Errorf("keeping the same username: %v", err)
+	}
+	if _, err := db.UpdateUsername(bob.UserID, "alice"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected an \"already exists\" error when taking a username, got %v", err)
+	}
+	if _, err := db.UpdateUsername("unknown", "someone"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected a \"not found\" error for an unknown user, got %v", err)
+	}
+	if _, err := db.GetUserByUsername("bob"); !errors.Is(err, database.ErrUserNotFound) {
+		t.Errorf("expected the old username to be free, got %v", err)
+	}
+}
+
+func testUserPages(t *testing.T, db database.AppDatabase) {
+	var users []models.User
+	for _, name := range []string{"u1", "u2", "u3", "u4", "u5"} {
+		users = append(users, mustUser(t, db, name))
+	}
+	expected := sortedIDs(users...)
+
+	// Forward, two at a time: users are sorted by ID
+	var ids []string
+	var keys []database.PageInfo
+	page := database.Page{Limit: 2}
+	for i := 0; i < 10; i++ {
+		items, info, err := db.GetAllUsers(page)
+		if err != nil {
+			t.Fatalf("listing users: %v", err)
+		}
+		for _, user := range items {
+			ids = append(ids, user.UserID)
+		}
+		keys = append(keys, info)
+		if info.NextKey == "" {
+			break
+		}
+		page.After = info.NextKey
+	}
+	if !equal(ids, expected) || len(keys) != 3 {
+		t.Fatalf("expected %v in 3 pages, got %v in %d pages", expected, ids, len(keys))
+	}
+	if keys[0].PrevKey != "" || keys[1].PrevKey == "" {
+		t.Errorf("unexpected previous keys %+v", keys)
+	}
+
+	// Backward from the last page
+	items, info, err := db.GetAllUsers(database.Page{Limit: 2, Before: keys[2].PrevKey})
+	if err != nil || len(items) != 2 || items[0].UserID != expected[2] || items[1].UserID != expected[3] {
+		t.Errorf("expected %v before the last page, got %v, %+v", expected[2:4], err, items)
+	}
+	if info.PrevKey != expected[2] || info.NextKey != expected[3] {
+		t.Errorf("unexpected page info %+v", info)
+	}
+	if items, info, err := db.GetAllUsers(database.Page{Limit: 2, Before: expected[2]}); err != nil || len(items) != 2 || info.PrevKey != "" {
+		t.Errorf("expected the first page, got %v, %+v, %+v", err, items, info)
+	}
+}
+
+func testPrivacy(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+
+	if privacy, err := db.GetPrivacy(alice.UserID); err != nil || privacy.HideLastSeen {
+		t.Errorf("expected the default privacy settings, got %v, %+v", err, privacy)
+	}
+	if err := db.SetPrivacy(alice.UserID, models.Privacy{HideLastSeen: true}); err != nil {
+		t.Fatalf("setting privacy: %v", err)
+	}
+	if privacy, err := db.GetPrivacy(alice.UserID); err != nil || !privacy.HideLastSeen {
+		t.Errorf("expected hidden last seen, got %v, %+v", err, privacy)
+	}
+
+	if _, err := db.GetPrivacy("unknown"); !errors.Is(err, database.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+	if err := db.SetPrivacy("unknown", models.Privacy{}); !errors.Is(err, database.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func testSessions(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if _, err := db.CreateSession("unknown", now); !errors.Is(err, database.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound for an unknown user, got %v", err)
+	}
+	first, err := db.CreateSession(alice.UserID, now)
+	if err != nil || first == "" {
+		t.Fatalf("unexpected session %v, %q", err, first)
+	}
+	second, err := db.CreateSession(alice.UserID, now.Add(time.Minute))
+	if err != nil || second == first {
+		t.Fatalf("unexpected session %v, %q", err, second)
+	}
+	bobToken, err := db.CreateSession(bob.UserID, now)
+	if err != nil {
+		t.Fatalf("creating session: %v", err)
+	}
+
+	// Every token authenticates its user; the user IDs are not tokens
+	for _, token := range []string{first, second} {
+		if got, err := db.GetUserBySession(token); err != nil || got != alice {
+			t.Errorf("unexpected user %v, %+v", err, got)
+		}
+	}
+	for _, token := range []string{alice.UserID, "unknown", ""} {
+		if _, err := db.GetUserBySession(token); !errors.Is(err, database.ErrSessionNotFound) {
+			t.Errorf("expected ErrSessionNotFound for %q, got %v", token, err)
+		}
+	}
+
+	// Deleting the user ends their sessions, not the ones of the others
+	if err := db.DeleteUserByID(alice.UserID); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{first, second} {
+		if _, err := db.GetUserBySession(token); !errors.Is(err, database.ErrSessionNotFound) {
+			t.Errorf("expected ErrSessionNotFound after deleting the user, got %v", err)
+		}
+	}
+	if got, err := db.GetUserBySession(bobToken); err != nil || got.UserID != bob.UserID {
+		t.Errorf("expected the session of bob to survive: %v, %+v", err, got)
+	}
+}
+
+func testConversations(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	carol := mustUser(t, db, "carol")
+
+	if _, err := db.SetConversation([]string{alice.UserID}, false, "", ""); err == nil ||
+		!strings.Contains(err.Error(), "cannot create a conversation with only one user") {
+		t.Errorf("expected an error for a single user, got %v", err)
+	}
+	if _, err := db.SetConversation([]string{alice.UserID, bob.UserID, carol.UserID}, false, "", ""); err == nil ||
+		!strings.Contains(err.Error(), "cannot create a group conversation") {
+		t.Errorf("expected an error for three users without group, got %v", err)
+	}
+	if _, err := db.SetConversation([]string{alice.UserID, "unknown"}, false, "", ""); err == nil ||
+		!strings.Contains(err.Error(), "user with UserID") {
+		t.Errorf("expected an error for an unknown user, got %v", err)
+	}
+
+	direct := mustConversation(t, db, false, alice, bob)
+	group, err := db.SetConversation([]string{alice.UserID, bob.UserID, carol.UserID}, true, "friends", "photo.jpg")
+	if err != nil {
+		t.Fatalf("creating group: %v", err)
+	}
+	if direct.IsGroup || !group.IsGroup || group.GroupName != "friends" || group.GroupPhoto != "photo.jpg" ||
+		direct.ConversationID == group.ConversationID {
+		t.Errorf("unexpected conversations %+v, %+v", direct, group)
+	}
+
+	if c, err := db.GetConversationByID(group.ConversationID); err != nil || c != group {
+		t.Errorf("getting conversation: %v, %+v", err, c)
+	}
+	if _, err := db.GetConversationByID("unknown"); !errors.Is(err, database.ErrConversationNotFound) {
+		t.Errorf("expected ErrConversationNotFound, got %v", err)
+	}
+
+	if ids := memberIDs(t, db, group.ConversationID); !equal(ids, sortedIDs(alice, bob, carol)) {
+		t.Errorf("unexpected group members %v", ids)
+	}
+	if ids := memberIDs(t, db, direct.ConversationID); !equal(ids, sortedIDs(alice, bob)) {
+		t.Errorf("unexpected members %v", ids)
+	}
+	if members, err := db.GetConversationMembers("unknown"); err != nil || len(members) != 0 {
+		t.Errorf("expected no members for an unknown conversation, got %v, %+v", err, members)
+	}
+
+	conversations, _, err := db.GetAllConversationsByMember(carol.UserID, database.Page{})
+	if err != nil || len(conversations) != 1 || conversations[0] != group {
+		t.Errorf("expected carol in the group only, got %v, %+v", err, conversations)
+	}
+	conversations, _, err = db.GetAllConversationsByMember(alice.UserID, database.Page{})
+	if err != nil || len(conversations) != 2 {
+		t.Errorf("expected alice in two conversations, got %v, %+v", err, conversations)
+	}
+	if conversations, _, err := db.GetAllConversationsByMember("unknown", database.Page{}); err != nil || len(conversations) != 0 {
+		t.Errorf("expected no conversations for an unknown user, got %v, %+v", err, conversations)
+	}
+}
+
+func testConversationPages(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	carol := mustUser(t, db, "carol")
+
+	var expected []string
+	for i := 0; i < 3; i++ {
+		expected = append(expected, mustConversation(t, db, true, alice, bob).ConversationID)
+	}
+	other := mustConversation(t, db, false, bob, carol)
+	sort.Strings(expected)
+
+	// Conversations are sorted by ID, both for all and for the ones of a member
+	collect := func(list func(database.Page) ([]models.Conversation, database.PageInfo, error)) []string {
+		var ids []string
+		page := database.Page{Limit: 2}
+		for i := 0; i < 10; i++ {
+			items, info, err := list(page)
+			if err != nil {
+				t.Fatalf("listing conversations: %v", err)
+			}
+			for _, c := range items {
+				ids = append(ids, c.ConversationID)
+			}
+			if info.NextKey == "" {
+				break
+			}
+			page.After = info.NextKey
+		}
+		return ids
+	}
+
+	mine := collect(func(p database.Page) ([]models.Conversation, database.PageInfo, error) {
+		return db.GetAllConversationsByMember(alice.UserID, p)
+	})
+	if !equal(mine, expected) {
+		t.Errorf("expected %v, got %v", expected, mine)
+	}
+
+	all := collect(db.GetAllConversations)
+	expectedAll := append(append([]string{}, expected...), other.ConversationID)
+	sort.Strings(expectedAll)
+	if !equal(all, expectedAll) {
+		t.Errorf("expected %v, got %v", expectedAll, all)
+	}
+}
+
+func testDeleteUser(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	carol := mustUser(t, db, "carol")
+
+	direct := mustConversation(t, db, false, alice, bob)
+	group := mustConversation(t, db, true, alice, bob, carol)
+	lonely := mustConversation(t, db, true, alice, carol)
+	untouched := mustConversation(t, db, false, bob, carol)
+	chat := mustConversation(t, db, false, alice, carol)
+
+	for _, conversation := range []models.Conversation{group, chat} {
+		if _, err := db.AddMessages(conversation.ConversationID, []models.Message{
+			{SenderID: alice.UserID, Content: "bye", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		}); err != nil {
+			t.Fatalf("adding message: %v", err)
+		}
+	}
+
+	if err := db.DeleteUserByID(alice.UserID); err != nil {
+		t.Fatalf("deleting user: %v", err)
+	}
+	if _, err := db.GetUserByID(alice.UserID); !errors.Is(err, database.ErrUserNotFound) {
+		t.Errorf("expected the user to be deleted, got %v", err)
+	}
+
+	// Empty 1:1 conversations are deleted, the others and the groups lose the member
+	if _, err := db.GetConversationByID(direct.ConversationID); !errors.Is(err, database.ErrConversationNotFound) {
+		t.Errorf("expected the empty 1:1 conversation to be deleted, got %v", err)
+	}
+	if ids := memberIDs(t, db, chat.ConversationID); !equal(ids, sortedIDs(carol)) {
+		t.Errorf("unexpected 1:1 conversation members after deletion %v", ids)
+	}
+	if ids := memberIDs(t, db, group.ConversationID); !equal(ids, sortedIDs(bob, carol)) {
+		t.Errorf("unexpected group members after deletion %v", ids)
+	}
+	if ids := memberIDs(t, db, lonely.ConversationID); !equal(ids, sortedIDs(carol)) {
+		t.Errorf("unexpected group members after deletion %v", ids)
+	}
+	if _, err := db.GetConversationByID(untouched.ConversationID); err != nil {
+		t.Errorf("expected the other conversations to be kept, got %v", err)
+	}
+
+	// Messages are kept without sender
+	for _, conversation := range []models.Conversation{group, chat} {
+		messages, _, err := db.GetConversationMessages(conversation.ConversationID, database.Page{})
+		if err != nil || len(messages) != 1 || messages[0].SenderID != "" || messages[0].Content != "bye" {
+			t.Errorf("expected the message without sender, got %v, %+v", err, messages)
+		}
+	}
+
+	// Conversations left without members are deleted
+	if err := db.DeleteUserByID(carol.UserID); err != nil {
+		t.Fatalf("deleting user: %v", err)
+	}
+	for _, conversation := range []models.Conversation{lonely, chat} {
+		if _, err := db.GetConversationByID(conversation.ConversationID); !errors.Is(err, database.ErrConversationNotFound) {
+			t.Errorf("expected the conversation without members to be deleted, got %v", err)
+		}
+	}
+
+	if err := db.DeleteUserByID("unknown"); err == nil || !strings.Contains(err.Error(), "no user found") {
+		t.Errorf("expected a \"no user found\" error, got %v", err)
+	}
+}
+
+// roles returns the roles of the members of the conversation, by user ID.
+func roles(t *testing.T, db database.AppDatabase, conversationID string) map[string]string {
+	t.Helper()
+	members, err := db.GetConversationMemberRoles(conversationID)
+	if err != nil {
+		t.Fatalf("getting member roles: %v", err)
+	}
+	var roles = make(map[string]string)
+	for _, member := range members {
+		roles[member.UserID] = member.Role
+	}
+	return roles
+}
+
+func testMemberRoles(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	carol := mustUser(t, db, "carol")
+	dave := mustUser(t, db, "dave")
+
+	// The creator of a group is its owner, the members of 1:1 conversations are all members
+	group := mustConversation(t, db, true, alice, bob)
+	if r := roles(t, db, group.ConversationID); r[alice.UserID] != models.RoleOwner || r[bob.UserID] != models.RoleMember {
+		t.Errorf("unexpected roles of a new group %v", r)
+	}
+	direct := mustConversation(t, db, false, alice, bob)
+	if r := roles(t, db, direct.ConversationID); r[alice.UserID] != models.RoleMember || r[bob.UserID] != models.RoleMember {
+		t.Errorf("unexpected roles of a 1:1 conversation %v", r)
+	}
+
+	updated, err := db.UpdateConversation(group.ConversationID, "renamed", "photo.jpg")
+	if err != nil || updated.GroupName != "renamed" || updated.GroupPhoto != "photo.jpg" {
+		t.Errorf("unexpected update %v, %+v", err, updated)
+	}
+	if _, err := db.UpdateConversation("unknown", "x", ""); !errors.Is(err, database.ErrConversationNotFound) {
+		t.Errorf("expected ErrConversationNotFound, got %v", err)
+	}
+
+	// Added users are members; adding is all or nothing
+	if err := db.AddConversationMembers(group.ConversationID, []string{carol.UserID, dave.UserID}); err != nil {
+		t.Fatalf("adding members: %v", err)
+	}
+	if r := roles(t, db, group.ConversationID); len(r) != 4 || r[carol.UserID] != models.RoleMember {
+		t.Errorf("unexpected roles after adding members %v", r)
+	}
+	if err := db.AddConversationMembers(group.ConversationID, []string{bob.UserID}); !errors.Is(err, database.ErrAlreadyMember) {
+		t.Errorf("expected ErrAlreadyMember, got %v", err)
+	}
+	if err := db.RemoveConversationMember(group.ConversationID, dave.UserID); err != nil {
+		t.Fatalf("removing member: %v", err)
+	}
+	if err := db.AddConversationMembers(group.ConversationID, []string{dave.UserID, "unknown"}); !errors.Is(err, database.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+	if ids := memberIDs(t, db, group.ConversationID); !equal(ids, sortedIDs(alice, bob, carol)) {
+		t.Errorf("expected no member added on errors, got %v", ids)
+	}
+	if err := db.RemoveConversationMember(group.ConversationID, dave.UserID); !errors.Is(err, database.ErrNotMember) {
+		t.Errorf("expected ErrNotMember, got %v", err)
+	}
+
+	// Transferring the ownership makes the previous owner an admin
+	if err := db.SetMemberRole(group.ConversationID, bob.UserID, models.RoleAdmin); err != nil {
+		t.Fatalf("promoting: %v", err)
+	}
+	if err := db.SetMemberRole(group.ConversationID, carol.UserID, models.RoleOwner); err != nil {
+		t.Fatalf("transferring the ownership: %v", err)
+	}
+	if r := roles(t, db, group.ConversationID); r[alice.UserID] != models.RoleAdmin || r[bob.UserID] != models.RoleAdmin || r[carol.UserID] != models.RoleOwner {
+		t.Errorf("unexpected roles after the transfer %v", r)
+	}
+	if err := db.SetMemberRole(group.ConversationID, alice.UserID, "king"); err == nil {
+		t.Error("expected an error for an invalid role")
+	}
+	if err := db.SetMemberRole(group.ConversationID, dave.UserID, models.RoleAdmin); !errors.Is(err, database.ErrNotMember) {
+		t.Errorf("expected ErrNotMember, got %v", err)
+	}
+
+	// When the owner leaves, an admin becomes the owner
+	if err := db.SetMemberRole(group.ConversationID, alice.UserID, models.RoleMember); err != nil {
+		t.Fatalf("demoting: %v", err)
+	}
+	if err := db.RemoveConversationMember(group.ConversationID, carol.UserID); err != nil {
+		t.Fatalf("leaving: %v", err)
+	}
+	if r := roles(t, db, group.ConversationID); len(r) != 2 || r[bob.UserID] != models.RoleOwner || r[alice.UserID] != models.RoleMember {
+		t.Errorf("expected the admin to become the owner, got %v", r)
+	}
+
+	// Without admins, a member becomes the owner, also when the owner deletes their account
+	if err := db.DeleteUserByID(bob.UserID); err != nil {
+		t.Fatalf("deleting user: %v", err)
+	}
+	if r := roles(t, db, group.ConversationID); len(r) != 1 || r[alice.UserID] != models.RoleOwner {
+		t.Errorf("expected the member to become the owner, got %v", r)
+	}
+
+	// The group is deleted when the last member leaves
+	if err := db.RemoveConversationMember(group.ConversationID, alice.UserID); err != nil {
+		t.Fatalf("leaving: %v", err)
+	}
+	if _, err := db.GetConversationByID(group.ConversationID); !errors.Is(err, database.ErrConversationNotFound) {
+		t.Errorf("expected the empty group to be deleted, got %v", err)
+	}
+}
+
+func testInvites(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	carol := mustUser(t, db, "carol")
+	dave := mustUser(t, db, "dave")
+	erin := mustUser(t, db, "erin")
+	group := mustConversation(t, db, true, alice, erin)
+	other := mustConversation(t, db, true, bob, erin)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	expiry := now.Add(time.Hour)
+	limited, err := db.CreateInvite(models.Invite{ConversationID: group.ConversationID, CreatedBy: alice.UserID, CreatedAt: now,
+		ExpiresAt: &expiry, MaxUses: 2})
+	if err != nil {
+		t.Fatalf("creating invite: %v", err)
+	}
+	if limited.Token == "" || limited.CreatedBy != alice.UserID || !limited.CreatedAt.Equal(now) ||
+		limited.ExpiresAt == nil || !limited.ExpiresAt.Equal(expiry) || limited.MaxUses != 2 || limited.Uses != 0 {
+		t.Errorf("unexpected invite %+v", limited)
+	}
+	unlimited, err := db.CreateInvite(models.Invite{ConversationID: group.ConversationID, CreatedBy: alice.UserID,
+		CreatedAt: now.Add(time.Minute)})
+	if err != nil || unlimited.ExpiresAt != nil || unlimited.MaxUses != 0 {
+		t.Fatalf("creating invite: %v, %+v", err, unlimited)
+	}
+	if _, err := db.CreateInvite(models.Invite{ConversationID: "unknown", CreatedAt: now}); !errors.Is(err, database.ErrConversationNotFound) {
+		t.Errorf("expected ErrConversationNotFound, got %v", err)
+	}
+
+	invites, err := db.GetConversationInvites(group.ConversationID)
+	if err != nil || len(invites) != 2 || invites[0].Token != limited.Token || invites[1].Token != unlimited.Token {
+		t.Errorf("unexpected invites %v, %+v", err, invites)
+	}
+
+	// Joining counts the use and records who joined
+	joined, err := db.JoinWithInvite(limited.Token, bob.UserID, now.Add(time.Minute))
+	if err != nil || joined.Uses != 1 || joined.ConversationID != group.ConversationID {
+		t.Fatalf("joining: %v, %+v", err, joined)
+	}
+	if ids := memberIDs(t, db, group.ConversationID); !equal(ids, sortedIDs(alice, bob, erin)) {
+		t.Errorf("unexpected members after joining %v", ids)
+	}
+	if r := roles(t, db, group.ConversationID); r[bob.UserID] != models.RoleMember {
+		t.Errorf("expected the user to join as a member, got %v", r)
+	}
+	if _, err := db.JoinWithInvite(limited.Token, bob.UserID, now.Add(time.Minute)); !errors.Is(err, database.ErrAlreadyMember) {
+		t.Errorf("expected ErrAlreadyMember, got %v", err)
+	}
+	if _, err := db.JoinWithInvite(limited.Token, "unknown", now.Add(time.Minute)); !errors.Is(err, database.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+	if _, err := db.JoinWithInvite("unknown", carol.UserID, now); !errors.Is(err, database.ErrInviteNotFound) {
+		t.Errorf("expected ErrInviteNotFound, got %v", err)
+	}
+
+	// Failed joins are not counted
+	if _, err := db.JoinWithInvite(limited.Token, carol.UserID, now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("joining: %v", err)
+	}
+	if _, err := db.JoinWithInvite(limited.Token, dave.UserID, now.Add(3*time.Minute)); !errors.Is(err, database.ErrInviteUsedUp) {
+		t.Errorf("expected ErrInviteUsedUp, got %v", err)
+	}
+	if _, err := db.JoinWithInvite(unlimited.Token, dave.UserID, expiry); err != nil {
+		t.Errorf("expected invites without expiry to work, got %v", err)
+	}
+
+	joins, err := db.GetInviteJoins(group.ConversationID, limited.Token)
+	if err != nil || len(joins) != 2 || joins[0].UserID != bob.UserID || joins[1].UserID != carol.UserID ||
+		!joins[0].JoinedAt.Equal(now.Add(time.Minute)) || joins[0].Token != limited.Token {
+		t.Errorf("unexpected joins %v, %+v", err, joins)
+	}
+	if _, err := db.GetInviteJoins(other.ConversationID, limited.Token); !errors.Is(err, database.ErrInviteNotFound) {
+		t.Errorf("expected ErrInviteNotFound for an invite of another conversation, got %v", err)
+	}
+
+	// Expired and revoked invites cannot be used
+	if err := db.RemoveConversationMember(group.ConversationID, dave.UserID); err != nil {
+		t.Fatalf("removing member: %v", err)
+	}
+	expiring, err := db.CreateInvite(models.Invite{ConversationID: group.ConversationID, CreatedBy: alice.UserID, CreatedAt: now,
+		ExpiresAt: &expiry})
+	if err != nil {
+		t.Fatalf("creating invite: %v", err)
+	}
+	if _, err := db.JoinWithInvite(expiring.Token, dave.UserID, expiry); !errors.Is(err, database.ErrInviteExpired) {
+		t.Errorf("expected ErrInviteExpired, got %v", err)
+	}
+	revoked, err := db.RevokeInvite(group.ConversationID, unlimited.Token, now.Add(time.Hour))
+	if err != nil || revoked.RevokedAt == nil || !revoked.RevokedAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("revoking: %v, %+v", err, revoked)
+	}
+	if again, err := db.RevokeInvite(group.ConversationID, unlimited.Token, now.Add(2*time.Hour)); err != nil || !again.RevokedAt.Equal(*revoked.RevokedAt) {
+		t.Errorf("expected revoking again to keep the time: %v, %+v", err, again)
+	}
+	if _, err := db.RevokeInvite(other.ConversationID, unlimited.Token, now); !errors.Is(err, database.ErrInviteNotFound) {
+		t.Errorf("expected ErrInviteNotFound for an invite of another conversation, got %v", err)
+	}
+	if _, err := db.JoinWithInvite(unlimited.Token, dave.UserID, now); !errors.Is(err, database.ErrInviteRevoked) {
+		t.Errorf("expected ErrInviteRevoked, got %v", err)
+	}
+
+	// Deleting users removes their joins, deleting the group its invites
+	if err := db.DeleteUserByID(bob.UserID); err != nil {
+		t.Fatalf("deleting user: %v", err)
+	}
+	if joins, err := db.GetInviteJoins(group.ConversationID, limited.Token); err != nil || len(joins) != 1 {
+		t.Errorf("expected the joins of the deleted user to be deleted: %v, %+v", err, joins)
+	}
+	if err := db.DeleteUserByID(alice.UserID); err != nil {
+		t.Fatalf("deleting user: %v", err)
+	}
+	if invites, err := db.GetConversationInvites(group.ConversationID); err != nil || len(invites) != 3 || invites[0].CreatedBy != "" {
+		t.Errorf("expected the invites without creator: %v, %+v", err, invites)
+	}
+	for _, user := range []models.User{carol, erin} {
+		if err := db.RemoveConversationMember(group.ConversationID, user.UserID); err != nil {
+			t.Fatalf("removing member: %v", err)
+		}
+	}
+	if _, err := db.JoinWithInvite(limited.Token, dave.UserID, now); !errors.Is(err, database.ErrInviteNotFound) {
+		t.Errorf("expected the invites of the deleted group to be deleted, got %v", err)
+	}
+}
+
+func testMemberSettings(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	carol := mustUser(t, db, "carol")
+	var sorted []string
+	for i := 0; i < 4; i++ {
+		sorted = append(sorted, mustConversation(t, db, true, alice, bob, carol).ConversationID)
+	}
+	sort.Strings(sorted)
+
+	// Nothing is pinned, muted or archived by default
+	settings, err := db.GetMemberSettings(sorted[0], alice.UserID)
+	if err != nil || settings != (models.ConversationSettings{}) {
+		t.Fatalf("expected the default settings: %v, %+v", err, settings)
+	}
+	if _, err := db.GetMemberSettings(sorted[0], "unknown"); !errors.Is(err, database.ErrNotMember) {
+		t.Errorf("expected ErrNotMember, got %v", err)
+	}
+	if err := db.SetMemberSettings(sorted[0], "unknown", models.ConversationSettings{Pinned: true}); !errors.Is(err, database.ErrNotMember) {
+		t.Errorf("expected ErrNotMember, got %v", err)
+	}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+	pinned, archived := sorted[3], sorted[1]
+	for conversationID, settings := range map[string]models.ConversationSettings{
+		pinned:   {Pinned: true},
+		archived: {Archived: true, MutedUntil: &later},
+	} {
+		if err := db.SetMemberSettings(conversationID, alice.UserID, settings); err != nil {
+			t.Fatalf("saving settings: %v", err)
+		}
+	}
+	settings, err = db.GetMemberSettings(archived, alice.UserID)
+	if err != nil || !settings.Archived || settings.Pinned || settings.MutedUntil == nil || !settings.MutedUntil.Equal(later) {
+		t.Errorf("unexpected settings %v, %+v", err, settings)
+	}
+
+	// The pinned conversations come first, the archived ones only when requested
+	list := func(includeArchived bool, page database.Page) []string {
+		t.Helper()
+		conversations, _, err := db.GetConversationsForMember(alice.UserID, includeArchived, page)
+		if err != nil {
+			t.Fatalf("listing conversations: %v", err)
+		}
+		var ids []string
+		for _, conversation := range conversations {
+			if conversation.Settings == nil {
+				t.Fatalf("expected the settings in %+v", conversation)
+			}
+			ids = append(ids, conversation.ConversationID)
+		}
+		return ids
+	}
+	if got := list(false, database.Page{}); !equal(got, []string{pinned, sorted[0], sorted[2]}) {
+		t.Errorf("unexpected list %v", got)
+	}
+	if got := list(true, database.Page{}); !equal(got, []string{pinned, sorted[0], sorted[1], sorted[2]}) {
+		t.Errorf("unexpected list with the archived conversations %v", got)
+	}
+
+	// The pages follow the same order
+	first, info, err := db.GetConversationsForMember(alice.UserID, true, database.Page{Limit: 2})
+	if err != nil || len(first) != 2 || first[0].ConversationID != pinned || info.NextKey == "" {
+		t.Fatalf("unexpected first page %v, %+v, %+v", err, first, info)
+	}
+	if got := list(true, database.Page{After: info.NextKey}); !equal(got, []string{sorted[1], sorted[2]}) {
+		t.Errorf("unexpected second page %v", got)
+	}
+
+	// The settings are per member
+	if got, _, err := db.GetConversationsForMember(bob.UserID, false, database.Page{}); err != nil || len(got) != 4 ||
+		got[0].ConversationID != sorted[0] || got[0].Settings.Pinned {
+		t.Errorf("expected the conversations of bob by ID: %v, %+v", err, got)
+	}
+
+	// Muting lasts until the given time
+	if muted, err := db.GetMutedMembers(archived, now); err != nil || !equal(muted, []string{alice.UserID}) {
+		t.Errorf("expected alice muted: %v, %v", err, muted)
+	}
+	if muted, err := db.GetMutedMembers(archived, later); err != nil || len(muted) != 0 {
+		t.Errorf("expected nobody muted after the mute expired: %v, %v", err, muted)
+	}
+
+	// Leaving the conversation forgets the settings
+	if err := db.RemoveConversationMember(pinned, alice.UserID); err != nil {
+		t.Fatalf("leaving: %v", err)
+	}
+	if err := db.AddConversationMembers(pinned, []string{alice.UserID}); err != nil {
+		t.Fatalf("joining again: %v", err)
+	}
+	if settings, err := db.GetMemberSettings(pinned, alice.UserID); err != nil || settings.Pinned {
+		t.Errorf("expected the default settings after joining again: %v, %+v", err, settings)
+	}
+}
+
+func testPushSubscriptions(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	subscription := func(endpoint string, at time.Time) models.PushSubscription {
+		return models.PushSubscription{
+			Endpoint:  endpoint,
+			Keys:      models.PushKeys{P256dh: "key-" + endpoint, Auth: "auth-" + endpoint},
+			CreatedAt: at,
+		}
+	}
+	endpoints := func(userID string) []string {
+		t.Helper()
+		subscriptions, err := db.GetPushSubscriptions(userID)
+		if err != nil {
+			t.Fatalf("listing subscriptions: %v", err)
+		}
+		var got []string
+		for _, s := range subscriptions {
+			got = append(got, s.Endpoint)
+		}
+		return got
+	}
+
+	if got := endpoints(alice.UserID); len(got) != 0 {
+		t.Errorf("expected no subscriptions, got %v", got)
+	}
+	first, err := db.AddPushSubscription(alice.UserID, subscription("https://push.example/1", now))
+	if err != nil || first.SubscriptionID == "" || !first.CreatedAt.Equal(now) {
+		t.Fatalf("unexpected subscription %v, %+v", err, first)
+	}
+	second, err := db.AddPushSubscription(alice.UserID, subscription("https://push.example/2", now.Add(time.Minute)))
+	if err != nil || second.SubscriptionID == first.SubscriptionID {
+		t.Fatalf("unexpected subscription %v, %+v", err, second)
+	}
+	if _, err := db.AddPushSubscription("unknown", subscription("https://push.example/3", now)); !errors.Is(err, database.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+
+	subscriptions, err := db.GetPushSubscriptions(alice.UserID)
+	if err != nil || len(subscriptions) != 2 || subscriptions[0] != first || subscriptions[1] != second {
+		t.Fatalf("unexpected subscriptions %v, %+v", err, subscriptions)
+	}
+
+	// The same endpoint replaces the subscription, even for another user
+	if _, err := db.AddPushSubscription(bob.UserID, subscription("https://push.example/1", now.Add(time.Hour))); err != nil {
+		t.Fatalf("subscribing again: %v", err)
+	}
+	if got := endpoints(alice.UserID); !equal(got, []string{"https://push.example/2"}) {
+		t.Errorf("expected the subscription moved to bob, alice has %v", got)
+	}
+	if got := endpoints(bob.UserID); !equal(got, []string{"https://push.example/1"}) {
+		t.Errorf("unexpected subscriptions of bob %v", got)
+	}
+
+	// Only the owner deletes a subscription
+	if err := db.DeletePushSubscription(bob.UserID, second.SubscriptionID); !errors.Is(err, database.ErrPushSubscriptionNotFound) {
+		t.Errorf("expected ErrPushSubscriptionNotFound, got %v", err)
+	}
+	if err := db.DeletePushSubscription(alice.UserID, second.SubscriptionID); err != nil {
+		t.Fatalf("deleting: %v", err)
+	}
+	if err := db.DeletePushSubscription(alice.UserID, second.SubscriptionID); !errors.Is(err, database.ErrPushSubscriptionNotFound) {
+		t.Errorf("expected ErrPushSubscriptionNotFound, got %v", err)
+	}
+	if got := endpoints(alice.UserID); len(got) != 0 {
+		t.Errorf("expected no subscriptions, got %v", got)
+	}
+
+	// The subscriptions are deleted with the user
+	if err := db.DeleteUserByID(bob.UserID); err != nil {
+		t.Fatalf("deleting bob: %v", err)
+	}
+	if got := endpoints(bob.UserID); len(got) != 0 {
+		t.Errorf("expected the subscriptions deleted with the user, got %v", got)
+	}
+	if _, err := db.AddPushSubscription(alice.UserID, subscription("https://push.example/1", now)); err != nil {
+		t.Errorf("expected the endpoint free again: %v", err)
+	}
+}
+
+func testWebhooks(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	conversation := mustConversation(t, db, true, alice, bob)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if _, err := db.CreateWebhook(models.Webhook{ConversationID: "unknown", URL: "https://ci.example", CreatedAt: now}); !errors.Is(err, database.ErrConversationNotFound) {
+		t.Errorf("expected ErrConversationNotFound, got %v", err)
+	}
+	all, err := db.CreateWebhook(models.Webhook{
+		ConversationID: conversation.ConversationID,
+		URL:            "https://ci.example/all",
+		CreatedBy:      bob.UserID,
+		CreatedAt:      now,
+	})
+	if err != nil || all.WebhookID == "" || len(all.Secret) != 64 || len(all.Events) != 0 || !all.CreatedAt.Equal(now) {
+		t.Fatalf("unexpected webhook %v, %+v", err, all)
+	}
+	messages, err := db.CreateWebhook(models.Webhook{
+		ConversationID: conversation.ConversationID,
+		URL:            "https://ci.example/messages",
+		Events:         []string{models.EventMessageNew, models.EventMessageEdited},
+		CreatedBy:      alice.UserID,
+		CreatedAt:      now.Add(time.Minute),
+	})
+	if err != nil || !equal(messages.Events, []string{models.EventMessageNew, models.EventMessageEdited}) || messages.Secret == all.Secret {
+		t.Fatalf("unexpected webhook %v, %+v", err, messages)
+	}
+
+	webhooks, err := db.GetConversationWebhooks(conversation.ConversationID)
+	if err != nil || len(webhooks) != 2 || webhooks[0].WebhookID != all.WebhookID || webhooks[1].WebhookID != messages.WebhookID {
+		t.Fatalf("unexpected webhooks %v, %+v", err, webhooks)
+	}
+	if got, err := db.GetWebhook(messages.WebhookID); err != nil || got.URL != messages.URL || got.Secret != messages.Secret {
+		t.Errorf("unexpected webhook %v, %+v", err, got)
+	}
+	if _, err := db.GetWebhook("unknown"); !errors.Is(err, database.ErrWebhookNotFound) {
+		t.Errorf("expected ErrWebhookNotFound, got %v", err)
+	}
+
+	// The events are queued for the webhooks subscribed to them
+	if n, err := db.EnqueueWebhookEvent(conversation.ConversationID, models.EventMemberAdded, []byte(`{}`), now); err != nil || n != 1 {
+		t.Errorf("expected one delivery of member.added: %v, %d", err, n)
+	}
+	if n, err := db.EnqueueWebhookEvent(conversation.ConversationID, models.EventMessageNew, []byte(`{}`), now); err != nil || n != 2 {
+		t.Errorf("expected two deliveries of message.new: %v, %d", err, n)
+	}
+
+	// The creator is forgotten with their account
+	if err := db.DeleteUserByID(bob.UserID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := db.GetWebhook(all.WebhookID); err != nil || got.CreatedBy != "" {
+		t.Errorf("expected the creator to be forgotten: %v, %+v", err, got)
+	}
+
+	// Deleting a webhook deletes its deliveries
+	if err := db.DeleteWebhook("unknown", all.WebhookID); !errors.Is(err, database.ErrWebhookNotFound) {
+		t.Errorf("expected ErrWebhookNotFound, got %v", err)
+	}
+	if err := db.DeleteWebhook(conversation.ConversationID, all.WebhookID); err != nil {
+		t.Fatalf("deleting webhook: %v", err)
+	}
+	if deliveries, _, err := db.GetWebhookDeliveries(all.WebhookID, database.Page{}); err != nil || len(deliveries) != 0 {
+		t.Errorf("expected no deliveries: %v, %+v", err, deliveries)
+	}
+	if due, err := db.GetDueWebhookDeliveries(now, 10); err != nil || len(due) != 1 || due[0].WebhookID != messages.WebhookID {
+		t.Errorf("expected only the delivery to the other webhook: %v, %+v", err, due)
+	}
+}
+
+func testWebhookDeliveries(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	conversation := mustConversation(t, db, false, alice, bob)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	webhook, err := db.CreateWebhook(models.Webhook{ConversationID: conversation.ConversationID, URL: "https://ci.example", CreatedAt: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		data := []byte(fmt.Sprintf(`{"n":%d}`, i))
+		if _, err := db.EnqueueWebhookEvent(conversation.ConversationID, models.EventMessageNew, data, now.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliveries, _, err := db.GetWebhookDeliveries(webhook.WebhookID, database.Page{})
+	if err != nil || len(deliveries) != 3 {
+		t.Fatalf("unexpected deliveries %v, %+v", err, deliveries)
+	}
+	first := deliveries[0]
+	if first.Status != models.DeliveryPending || first.Attempts != 0 || string(first.Data) != `{"n":0}` ||
+		first.NextAttemptAt == nil || !first.NextAttemptAt.Equal(now) || first.Event != models.EventMessageNew {
+		t.Errorf("unexpected queued delivery %+v", first)
+	}
+
+	// Only the deliveries due are returned, the longest overdue first
+	if due, err := db.GetDueWebhookDeliveries(now.Add(time.Second), 10); err != nil || len(due) != 2 ||
+		due[0].DeliveryID != first.DeliveryID || due[1].DeliveryID != deliveries[1].DeliveryID {
+		t.Errorf("unexpected due deliveries %v, %+v", err, due)
+	}
+	if due, err := db.GetDueWebhookDeliveries(now.Add(time.Hour), 1); err != nil || len(due) != 1 {
+		t.Errorf("expected the limit to be applied: %v, %+v", err, due)
+	}
+
+	// A failed attempt is retried later, a successful one is done
+	retryAt := now.Add(time.Minute)
+	first.Attempts, first.NextAttemptAt, first.LastStatusCode, first.LastError = 1, &retryAt, 500, "webhook answered 500"
+	if err := db.UpdateWebhookDelivery(first); err != nil {
+		t.Fatalf("updating delivery: %v", err)
+	}
+	deliveredAt := now.Add(2 * time.Second)
+	second := deliveries[1]
+	second.Status, second.Attempts, second.NextAttemptAt, second.DeliveredAt = models.DeliveryDelivered, 1, nil, &deliveredAt
+	second.LastStatusCode = 204
+	if err := db.UpdateWebhookDelivery(second); err != nil {
+		t.Fatalf("updating delivery: %v", err)
+	}
+	third := deliveries[2]
+	third.Status, third.Attempts, third.NextAttemptAt, third.LastError = models.DeliveryDead, 8, nil, "connection refused"
+	if err := db.UpdateWebhookDelivery(third); err != nil {
+		t.Fatalf("updating delivery: %v", err)
+	}
+	if due, err := db.GetDueWebhookDeliveries(now.Add(30*time.Second), 10); err != nil || len(due) != 0 {
+		t.Errorf("expected nothing due before the retry: %v, %+v", err, due)
+	}
+	if due, err := db.GetDueWebhookDeliveries(retryAt, 10); err != nil || len(due) != 1 || due[0].Attempts != 1 ||
+		due[0].LastError != "webhook answered 500" || due[0].LastStatusCode != 500 {
+		t.Errorf("expected the retry to be due: %v, %+v", err, due)
+	}
+
+	got, err := db.GetWebhookDelivery(webhook.WebhookID, second.DeliveryID)
+	if err != nil || got.Status != models.DeliveryDelivered || got.DeliveredAt == nil || !got.DeliveredAt.Equal(deliveredAt) ||
+		got.NextAttemptAt != nil || got.LastStatusCode != 204 {
+		t.Errorf("unexpected delivered delivery %v, %+v", err, got)
+	}
+	if got, err := db.GetWebhookDelivery(webhook.WebhookID, third.DeliveryID); err != nil || got.Status != models.DeliveryDead {
+		t.Errorf("unexpected dead delivery %v, %+v", err, got)
+	}
+	if _, err := db.GetWebhookDelivery("unknown", third.DeliveryID); !errors.Is(err, database.ErrDeliveryNotFound) {
+		t.Errorf("expected ErrDeliveryNotFound, got %v", err)
+	}
+	unknown := third
+	unknown.DeliveryID = "unknown"
+	if err := db.UpdateWebhookDelivery(unknown); !errors.Is(err, database.ErrDeliveryNotFound) {
+		t.Errorf("expected ErrDeliveryNotFound, got %v", err)
+	}
+
+	// The log is paginated in the order of the events
+	page, info, err := db.GetWebhookDeliveries(webhook.WebhookID, database.Page{Limit: 2})
+	if err != nil || len(page) != 2 || page[0].DeliveryID != first.DeliveryID || info.NextKey == "" {
+		t.Fatalf("unexpected first page %v, %+v, %+v", err, page, info)
+	}
+	page, _, err = db.GetWebhookDeliveries(webhook.WebhookID, database.Page{After: info.NextKey})
+	if err != nil || len(page) != 1 || page[0].DeliveryID != third.DeliveryID {
+		t.Errorf("unexpected second page %v, %+v", err, page)
+	}
+}
+
+func testBots(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	bot, err := db.CreateBot("ci")
+	if err != nil || bot.UserID == "" || bot.Username != "ci" || !bot.IsBot {
+		t.Fatalf("unexpected bot %v, %+v", err, bot)
+	}
+	if _, err := db.CreateBot("alice"); err == nil {
+		t.Error("expected the username of a user to be taken")
+	}
+	if got, err := db.GetUserByUsername("ci"); err != nil || !got.IsBot {
+		t.Errorf("expected the bot to be flagged: %v, %+v", err, got)
+	}
+	if got, err := db.GetUserByID(alice.UserID); err != nil || got.IsBot {
+		t.Errorf("expected a user not to be a bot: %v, %+v", err, got)
+	}
+	if bots, err := db.GetBots(); err != nil || len(bots) != 1 || bots[0].UserID != bot.UserID {
+		t.Errorf("unexpected bots %v, %+v", err, bots)
+	}
+
+	// Only bots have API keys
+	if _, err := db.CreateAPIKey(alice.UserID, now); !errors.Is(err, database.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound for a user, got %v", err)
+	}
+	first, err := db.CreateAPIKey(bot.UserID, now)
+	if err != nil || first.KeyID == "" || !strings.HasPrefix(first.Key, models.APIKeyPrefix) || first.UserID != bot.UserID ||
+		!first.CreatedAt.Equal(now) || first.LastUsedAt != nil {
+		t.Fatalf("unexpected API key %v, %+v", err, first)
+	}
+	second, err := db.CreateAPIKey(bot.UserID, now.Add(time.Minute))
+	if err != nil || second.Key == first.Key {
+		t.Fatalf("unexpected API key %v, %+v", err, second)
+	}
+
+	// The key authenticates the bot, and its use is recorded
+	if got, err := db.GetUserByAPIKey(first.Key, now.Add(time.Hour)); err != nil || got.UserID != bot.UserID || !got.IsBot {
+		t.Errorf("unexpected user %v, %+v", err, got)
+	}
+	if _, err := db.GetUserByAPIKey(models.APIKeyPrefix+"unknown", now); !errors.Is(err, database.ErrAPIKeyNotFound) {
+		t.Errorf("expected ErrAPIKeyNotFound, got %v", err)
+	}
+	keys, err := db.GetAPIKeys(bot.UserID)
+	if err != nil || len(keys) != 2 || keys[0].KeyID != first.KeyID || keys[1].KeyID != second.KeyID {
+		t.Fatalf("unexpected API keys %v, %+v", err, keys)
+	}
+	if keys[0].Key != "" || keys[0].LastUsedAt == nil || !keys[0].LastUsedAt.Equal(now.Add(time.Hour)) || keys[1].LastUsedAt != nil {
+		t.Errorf("unexpected API keys %+v", keys)
+	}
+
+	// A revoked key does not authenticate anymore
+	if err := db.DeleteAPIKey(alice.UserID, first.KeyID); !errors.Is(err, database.ErrAPIKeyNotFound) {
+		t.Errorf("expected ErrAPIKeyNotFound, got %v", err)
+	}
+	if err := db.DeleteAPIKey(bot.UserID, first.KeyID); err != nil {
+		t.Fatalf("deleting API key: %v", err)
+	}
+	if _, err := db.GetUserByAPIKey(first.Key, now); !errors.Is(err, database.ErrAPIKeyNotFound) {
+		t.Errorf("expected the revoked key not to authenticate, got %v", err)
+	}
+
+	// The keys go with the bot
+	if err := db.DeleteUserByID(bot.UserID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetUserByAPIKey(second.Key, now); !errors.Is(err, database.ErrAPIKeyNotFound) {
+		t.Errorf("expected the key of a deleted bot not to authenticate, got %v", err)
+	}
+}
+
+func testIncomingWebhooks(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	bot, err := db.CreateBot("ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversation := mustConversation(t, db, true, alice, bob, bot)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if _, err := db.CreateIncomingWebhook("unknown", bot.UserID, now); !errors.Is(err, database.ErrConversationNotFound) {
+		t.Errorf("expected ErrConversationNotFound, got %v", err)
+	}
+	first, err := db.CreateIncomingWebhook(conversation.ConversationID, bot.UserID, now)
+	if err != nil || len(first.Token) != 32 || first.ConversationID != conversation.ConversationID || first.UserID != bot.UserID ||
+		!first.CreatedAt.Equal(now) {
+		t.Fatalf("unexpected incoming webhook %v, %+v", err, first)
+	}
+	second, err := db.CreateIncomingWebhook(conversation.ConversationID, bot.UserID, now.Add(time.Minute))
+	if err != nil || second.Token == first.Token {
+		t.Fatalf("unexpected incoming webhook %v, %+v", err, second)
+	}
+
+	if got, err := db.GetIncomingWebhook(second.Token); err != nil || got != second {
+		t.Errorf("unexpected incoming webhook %v, %+v", err, got)
+	}
+	if _, err := db.GetIncomingWebhook("unknown"); !errors.Is(err, database.ErrIncomingWebhookNotFound) {
+		t.Errorf("expected ErrIncomingWebhookNotFound, got %v", err)
+	}
+	webhooks, err := db.GetIncomingWebhooks(conversation.ConversationID, bot.UserID)
+	if err != nil || len(webhooks) != 2 || webhooks[0].Token != first.Token || webhooks[1].Token != second.Token {
+		t.Errorf("unexpected incoming webhooks %v, %+v", err, webhooks)
+	}
+	if webhooks, err := db.GetIncomingWebhooks(conversation.ConversationID, alice.UserID); err != nil || len(webhooks) != 0 {
+		t.Errorf("expected no incoming webhooks of another user: %v, %+v", err, webhooks)
+	}
+
+	if err := db.DeleteIncomingWebhook(conversation.ConversationID, alice.UserID, first.Token); !errors.Is(err, database.ErrIncomingWebhookNotFound) {
+		t.Errorf("expected ErrIncomingWebhookNotFound, got %v", err)
+	}
+	if err := db.DeleteIncomingWebhook(conversation.ConversationID, bot.UserID, first.Token); err != nil {
+		t.Fatalf("deleting incoming webhook: %v", err)
+	}
+	if _, err := db.GetIncomingWebhook(first.Token); !errors.Is(err, database.ErrIncomingWebhookNotFound) {
+		t.Errorf("expected the incoming webhook to be deleted, got %v", err)
+	}
+
+	// The incoming webhooks go with the bot
+	if err := db.DeleteUserByID(bot.UserID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetIncomingWebhook(second.Token); !errors.Is(err, database.ErrIncomingWebhookNotFound) {
+		t.Errorf("expected the incoming webhook of a deleted bot to be deleted, got %v", err)
+	}
+}
+
+func testImportChat(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	mustUser(t, db, "bob")
+	start := time.Date(2020, 12, 31, 21, 41, 0, 0, time.UTC)
+	messages := []models.Message{{Content: "Happy new year!", CreatedAt: start}, {Content: "Cheers", CreatedAt: start.Add(time.Minute)}}
+
+	// An unknown member fails the import, and the users are not created
+	_, _, err := db.ImportChat(database.ChatImport{
+		IsGroup: true, GroupName: "Friends", MemberIDs: []string{alice.UserID, "unknown"}, NewUsernames: []string{"carol"},
+		Messages: messages, Senders: []int{0, 2},
+	})
+	if err == nil {
+		t.Fatal("expected an error importing an unknown member")
+	}
+	if _, err := db.GetUserByUsername("carol"); !errors.Is(err, database.ErrUserNotFound) {
+		t.Errorf("expected the failed import to leave no users behind, got %v", err)
+	}
+	if conversations, _, err := db.GetAllConversationsByMember(alice.UserID, database.Page{}); err != nil || len(conversations) != 0 {
+		t.Errorf("expected the failed import to leave no conversations behind, got %v, %+v", err, conversations)
+	}
+
+	conversation, users, err := db.ImportChat(database.ChatImport{
+		IsGroup: true, GroupName: "Friends", MemberIDs: []string{alice.UserID}, NewUsernames: []string{"carol", "dave"},
+		Messages: messages, Senders: []int{0, 2},
+	})
+	if err != nil || len(users) != 2 || users[0].Username != "carol" || users[1].Username != "dave" {
+		t.Fatalf("importing: %v, %+v", err, users)
+	}
+
+	// Taken usernames, in the database or in the same import, get a suffix
+	if _, others, err := db.ImportChat(database.ChatImport{
+		IsGroup: true, GroupName: "Others", MemberIDs: []string{alice.UserID}, NewUsernames: []string{"bob", "erin", "erin"},
+		Messages: messages, Senders: []int{0, 1},
+	}); err != nil || len(others) != 3 || others[0].Username != "bob-2" || others[1].Username != "erin" ||
+		others[2].Username != "erin-2" {
+		t.Errorf("expected suffixed usernames: %v, %+v", err, others)
+	}
+	if ids := memberIDs(t, db, conversation.ConversationID); !equal(ids, sortedIDs(alice, users[0], users[1])) {
+		t.Errorf("unexpected members %v", ids)
+	}
+	if r := roles(t, db, conversation.ConversationID); r[alice.UserID] != models.RoleOwner {
+		t.Errorf("expected the first member to be the owner, got %v", r)
+	}
+	saved, _, err := db.GetConversationMessages(conversation.ConversationID, database.Page{})
+	if err != nil || len(saved) != 2 || saved[0].SenderID != alice.UserID || saved[1].SenderID != users[1].UserID {
+		t.Errorf("unexpected messages %v, %+v", err, saved)
+	}
+}
+
+func testMessages(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	conversation := mustConversation(t, db, false, alice, bob)
+
+	if _, err := db.AddMessages("unknown", []models.Message{{SenderID: alice.UserID, Content: "hi"}}); !errors.Is(err, database.ErrConversationNotFound) {
+		t.Errorf("expected ErrConversationNotFound, got %v", err)
+	}
+
+	// Times are kept with nanoseconds, converted to UTC
+	base := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.FixedZone("CEST", 2*60*60))
+	saved, err := db.AddMessages(conversation.ConversationID, []models.Message{
+		{SenderID: alice.UserID, Content: "second", CreatedAt: base.Add(time.Minute)},
+		{SenderID: bob.UserID, Content: "first", CreatedAt: base},
+		{Content: "system", CreatedAt: base.Add(2 * time.Minute)},
+	})
+	if err != nil {
+		t.Fatalf("adding messages: %v", err)
+	}
+	if len(saved) != 3 || saved[0].MessageID == "" || saved[0].MessageID == saved[1].MessageID ||
+		saved[0].ConversationID != conversation.ConversationID {
+		t.Fatalf("unexpected saved messages %+v", saved)
+	}
+
+	messages, info, err := db.GetConversationMessages(conversation.ConversationID, database.Page{})
+	if err != nil || len(messages) != 3 || info.NextKey != "" {
+		t.Fatalf("listing messages: %v, %+v", err, messages)
+	}
+	for i, content := range []string{"first", "second", "system"} {
+		if messages[i].Content != content {
+			t.Errorf("expected %q at %d, got %q", content, i, messages[i].Content)
+		}
+	}
+	if !messages[0].CreatedAt.Equal(base) || messages[0].CreatedAt.Location() != time.UTC || messages[0].SenderID != bob.UserID {
+		t.Errorf("unexpected first message %+v", messages[0])
+	}
+	if messages[2].SenderID != "" {
+		t.Errorf("expected no sender, got %q", messages[2].SenderID)
+	}
+
+	// Either all messages are saved, or none
+	if _, err := db.AddMessages(conversation.ConversationID, []models.Message{
+		{SenderID: alice.UserID, Content: "ok", CreatedAt: base},
+		{SenderID: "unknown", Content: "invalid sender", CreatedAt: base},
+	}); err == nil {
+		t.Error("expected an error for an unknown sender")
+	}
+	if messages, _, err := db.GetConversationMessages(conversation.ConversationID, database.Page{}); err != nil || len(messages) != 3 {
+		t.Errorf("expected no message to be saved, got %v, %d messages", err, len(messages))
+	}
+
+	if messages, _, err := db.GetConversationMessages("unknown", database.Page{}); err != nil || len(messages) != 0 {
+		t.Errorf("expected no messages for an unknown conversation, got %v, %+v", err, messages)
+	}
+}
+
+func testMessagePages(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	conversation := mustConversation(t, db, false, alice, bob)
+
+	// Messages sent in the same instant are sorted by ID
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var batch []models.Message
+	for i := 0; i < 5; i++ {
+		batch = append(batch, models.Message{SenderID: alice.UserID, Content: "same time", CreatedAt: base})
+	}
+	batch = append(batch, models.Message{SenderID: bob.UserID, Content: "later", CreatedAt: base.Add(time.Second)})
+	saved, err := db.AddMessages(conversation.ConversationID, batch)
+	if err != nil {
+		t.Fatalf("adding messages: %v", err)
+	}
+	var expected []string
+	for _, message := range saved[:5] {
+		expected = append(expected, message.MessageID)
+	}
+	sort.Strings(expected)
+	expected = append(expected, saved[5].MessageID)
+
+	var ids []string
+	var last database.PageInfo
+	page := database.Page{Limit: 4}
+	for i := 0; i < 10; i++ {
+		messages, info, err := db.GetConversationMessages(conversation.ConversationID, page)
+		if err != nil {
+			t.Fatalf("listing messages: %v", err)
+		}
+		for _, message := range messages {
+			ids = append(ids, message.MessageID)
+		}
+		last = info
+		if info.NextKey == "" {
+			break
+		}
+		page.After = info.NextKey
+	}
+	if !equal(ids, expected) {
+		t.Fatalf("expected %v, got %v", expected, ids)
+	}
+
+	// Back to the first page
+	messages, info, err := db.GetConversationMessages(conversation.ConversationID, database.Page{Limit: 4, Before: last.PrevKey})
+	if err != nil || len(messages) != 4 || messages[0].MessageID != expected[0] || info.PrevKey != "" {
+		t.Errorf("expected the first page, got %v, %+v, %+v", err, messages, info)
+	}
+}
+
+func testMessageEdits(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	conversation := mustConversation(t, db, false, alice, bob)
+	other := mustConversation(t, db, true, alice, bob)
+
+	sent := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	saved, err := db.AddMessages(conversation.ConversationID, []models.Message{{SenderID: alice.UserID, Content: "helo", CreatedAt: sent}})
+	if err != nil {
+		t.Fatalf("adding messages: %v", err)
+	}
+	messageID := saved[0].MessageID
+
+	if m, err := db.GetMessage(conversation.ConversationID, messageID); err != nil || m.Content != "helo" || m.EditedAt != nil {
+		t.Errorf("unexpected message %v, %+v", err, m)
+	}
+	if _, err := db.GetMessage(other.ConversationID, messageID); !errors.Is(err, database.ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound in another conversation, got %v", err)
+	}
+	if _, err := db.EditMessage(conversation.ConversationID, "unknown", "hello", sent); !errors.Is(err, database.ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound, got %v", err)
+	}
+	if _, err := db.GetMessageHistory(conversation.ConversationID, "unknown"); !errors.Is(err, database.ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound, got %v", err)
+	}
+	if history, err := db.GetMessageHistory(conversation.ConversationID, messageID); err != nil || len(history) != 0 {
+		t.Errorf("expected no history, got %v, %+v", err, history)
+	}
+
+	first, second := sent.Add(time.Minute), sent.Add(2*time.Minute)
+	edited, err := db.EditMessage(conversation.ConversationID, messageID, "hello", first)
+	if err != nil || edited.Content != "hello" || edited.EditedAt == nil || !edited.EditedAt.Equal(first) {
+		t.Fatalf("unexpected edited message %v, %+v", err, edited)
+	}
+	if _, err := db.EditMessage(conversation.ConversationID, messageID, "hello bob", second); err != nil {
+		t.Fatalf("editing again: %v", err)
+	}
+
+	messages, _, err := db.GetConversationMessages(conversation.ConversationID, database.Page{})
+	if err != nil || len(messages) != 1 || messages[0].Content != "hello bob" || messages[0].EditedAt == nil ||
+		!messages[0].EditedAt.Equal(second) || !messages[0].CreatedAt.Equal(sent) {
+		t.Fatalf("unexpected messages %v, %+v", err, messages)
+	}
+
+	history, err := db.GetMessageHistory(conversation.ConversationID, messageID)
+	if err != nil || len(history) != 2 {
+		t.Fatalf("unexpected history %v, %+v", err, history)
+	}
+	for i, want := range []models.MessageVersion{
+		{Version: 1, Content: "helo", CreatedAt: sent, ReplacedAt: first},
+		{Version: 2, Content: "hello", CreatedAt: first, ReplacedAt: second},
+	} {
+		got := history[i]
+		if got.Version != want.Version || got.Content != want.Content || !got.CreatedAt.Equal(want.CreatedAt) ||
+			!got.ReplacedAt.Equal(want.ReplacedAt) {
+			t.Errorf("expected version %+v, got %+v", want, got)
+		}
+	}
+}
+
+func testMessageDeletions(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	conversation := mustConversation(t, db, false, alice, bob)
+
+	sent := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	saved, err := db.AddMessages(conversation.ConversationID, []models.Message{
+		{SenderID: alice.UserID, Content: "one", CreatedAt: sent},
+		{SenderID: alice.UserID, Content: "two", CreatedAt: sent.Add(time.Minute)},
+		{SenderID: alice.UserID, Content: "three", CreatedAt: sent.Add(2 * time.Minute)},
+	})
+	if err != nil {
+		t.Fatalf("adding messages: %v", err)
+	}
+
+	// Deleting for oneself hides the message from that user only
+	if err := db.HideMessage(conversation.ConversationID, "unknown", bob.UserID); !errors.Is(err, database.ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := db.HideMessage(conversation.ConversationID, saved[0].MessageID, bob.UserID); err != nil {
+			t.Fatalf("hiding message: %v", err)
+		}
+	}
+	contents := func(userID string) []string {
+		t.Helper()
+		var contents []string
+		page := database.Page{Limit: 1}
+		for {
+			messages, info, err := db.GetConversationMessagesForUser(conversation.ConversationID, userID, page)
+			if err != nil {
+				t.Fatalf("listing messages: %v", err)
+			}
+			for _, m := range messages {
+				contents = append(contents, m.Content)
+			}
+			if info.NextKey == "" {
+				return contents
+			}
+			page.After = info.NextKey
+		}
+	}
+	if got := contents(bob.UserID); !equal(got, []string{"two", "three"}) {
+		t.Errorf("expected bob not to see the hidden message, got %v", got)
+	}
+	if got := contents(alice.UserID); !equal(got, []string{"one", "two", "three"}) {
+		t.Errorf("expected alice to see every message, got %v", got)
+	}
+	if messages, _, err := db.GetConversationMessages(conversation.ConversationID, database.Page{}); err != nil || len(messages) != 3 {
+		t.Errorf("expected every message without user, got %v, %d messages", err, len(messages))
+	}
+
+	// Deleting for everyone leaves a tombstone, without content nor history
+	if _, err := db.DeleteMessageForEveryone(conversation.ConversationID, "unknown", sent); !errors.Is(err, database.ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound, got %v", err)
+	}
+	if _, err := db.EditMessage(conversation.ConversationID, saved[1].MessageID, "two!", sent.Add(3*time.Minute)); err != nil {
+		t.Fatalf("editing message: %v", err)
+	}
+	deletedAt := sent.Add(4 * time.Minute)
+	tombstone, err := db.DeleteMessageForEveryone(conversation.ConversationID, saved[1].MessageID, deletedAt)
+	if err != nil || tombstone.Content != "" || tombstone.EditedAt != nil || tombstone.DeletedAt == nil ||
+		!tombstone.DeletedAt.Equal(deletedAt) {
+		t.Fatalf("unexpected tombstone %v, %+v", err, tombstone)
+	}
+	if again, err := db.DeleteMessageForEveryone(conversation.ConversationID, saved[1].MessageID, deletedAt.Add(time.Hour)); err != nil ||
+		again.DeletedAt == nil || !again.DeletedAt.Equal(deletedAt) {
+		t.Errorf("expected deleting again to keep the tombstone, got %v, %+v", err, again)
+	}
+	if _, err := db.EditMessage(conversation.ConversationID, saved[1].MessageID, "two?", deletedAt.Add(time.Minute)); !errors.Is(err, database.ErrMessageDeleted) {
+		t.Errorf("expected ErrMessageDeleted editing a tombstone, got %v", err)
+	}
+	if history, err := db.GetMessageHistory(conversation.ConversationID, saved[1].MessageID); err != nil || len(history) != 0 {
+		t.Errorf("expected the history to be deleted, got %v, %+v", err, history)
+	}
+	if got := contents(alice.UserID); !equal(got, []string{"one", "", "three"}) {
+		t.Errorf("expected the tombstone in the listing, got %v", got)
+	}
+	if m, err := db.GetMessage(conversation.ConversationID, saved[1].MessageID); err != nil || m.DeletedAt == nil ||
+		m.EditedAt != nil || m.SenderID != alice.UserID {
+		t.Errorf("unexpected tombstone %v, %+v", err, m)
+	}
+}
+
+func testSystemMessages(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	group := mustConversation(t, db, true, alice, bob)
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	saved, err := db.AddMessages(group.ConversationID, []models.Message{
+		{Content: "alice renamed the group", CreatedAt: created,
+			System: &models.SystemEvent{Action: models.SystemGroupRenamed, ActorID: alice.UserID, Target: "climbing"}},
+		{SenderID: bob.UserID, Content: "nice name", CreatedAt: created.Add(time.Minute)},
+	})
+	if err != nil {
+		t.Fatalf("adding messages: %v", err)
+	}
+	if saved[0].System == nil || saved[0].SenderID != "" || saved[1].System != nil {
+		t.Errorf("unexpected saved messages %+v", saved)
+	}
+
+	messages, _, err := db.GetConversationMessages(group.ConversationID, database.Page{})
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("getting messages: %v, %+v", err, messages)
+	}
+	if system := messages[0].System; system == nil || *system != (models.SystemEvent{Action: models.SystemGroupRenamed,
+		ActorID: alice.UserID, Target: "climbing"}) || messages[0].SenderID != "" {
+		t.Errorf("unexpected system message %+v", messages[0])
+	}
+	if messages[1].System != nil {
+		t.Errorf("expected a user message, got %+v", messages[1])
+	}
+
+	if _, err := db.AddMessages(group.ConversationID, []models.Message{{Content: "x", CreatedAt: created,
+		System: &models.SystemEvent{Action: models.SystemMemberLeft, ActorID: "unknown"}}}); err == nil {
+		t.Error("expected an error for an unknown actor")
+	}
+
+	// The actor is cleared when their account is deleted
+	if err := db.DeleteUserByID(alice.UserID); err != nil {
+		t.Fatalf("deleting user: %v", err)
+	}
+	message, err := db.GetMessage(group.ConversationID, saved[0].MessageID)
+	if err != nil || message.System == nil || message.System.ActorID != "" || message.System.Target != "climbing" {
+		t.Errorf("expected the system message without actor: %v, %+v", err, message)
+	}
+}
+
+func testPolls(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	carol := mustUser(t, db, "carol")
+	conversation := mustConversation(t, db, true, alice, bob, carol)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	options := func(texts ...string) []models.PollOption {
+		var options []models.PollOption
+		for _, text := range texts {
+			options = append(options, models.PollOption{Text: text})
+		}
+		return options
+	}
+	saved, err := db.AddMessages(conversation.ConversationID, []models.Message{
+		{SenderID: alice.UserID, Content: "Where?", CreatedAt: now, Poll: &models.Poll{Question: "Where?", Options: options("Arco", "Finale", "Kalymnos")}},
+		{SenderID: alice.UserID, Content: "When?", CreatedAt: now, Poll: &models.Poll{
+			Question: "When?", Options: options("May", "June", "July"), MultipleChoice: true, Anonymous: true,
+			// Only the question, the options and the kind are saved
+			Voters: 3, ClosedAt: &now,
+		}},
+		{SenderID: bob.UserID, Content: "not a poll", CreatedAt: now},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	where, when, text := saved[0].MessageID, saved[1].MessageID, saved[2].MessageID
+	if poll := saved[1].Poll; poll == nil || poll.Question != "When?" || len(poll.Options) != 3 || !poll.MultipleChoice ||
+		!poll.Anonymous || poll.Voters != 0 || poll.ClosedAt != nil {
+		t.Fatalf("unexpected saved poll %+v", poll)
+	}
+
+	for _, tc := range []struct {
+		messageID string
+		options   []int
+		expected  error
+	}{
+		{"unknown", []int{0}, database.ErrMessageNotFound},
+		{text, []int{0}, database.ErrPollNotFound},
+		{where, nil, database.ErrInvalidVote},
+		{where, []int{3}, database.ErrInvalidVote},
+		{where, []int{0, 1}, database.ErrInvalidVote},
+		{when, []int{1, 1}, database.ErrInvalidVote},
+	} {
+		if _, err := db.Vote(conversation.ConversationID, tc.messageID, bob.UserID, tc.options, now); !errors.Is(err, tc.expected) {
+			t.Errorf("vote %v on %s: expected %v, got %v", tc.options, tc.messageID, tc.expected, err)
+		}
+	}
+
+	// Voting again replaces the previous choice
+	for i, vote := range []struct {
+		user   models.User
+		option int
+	}{{bob, 1}, {alice, 1}, {carol, 0}, {bob, 2}} {
+		if _, err := db.Vote(conversation.ConversationID, where, vote.user.UserID, []int{vote.option}, now.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	poll, err := db.GetPoll(conversation.ConversationID, where, bob.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(poll.Voters, poll.MyVotes, poll.Options); got != fmt.Sprintf("3 [2] [{Arco 1 [%s]} {Finale 1 [%s]} {Kalymnos 1 [%s]}]", carol.UserID, alice.UserID, bob.UserID) {
+		t.Errorf("unexpected tally %s", got)
+	}
+
+	// The voters of anonymous polls are not listed
+	poll, err = db.Vote(conversation.ConversationID, when, alice.UserID, []int{2, 0}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(poll.Voters, poll.MyVotes, poll.Options); got != "1 [0 2] [{May 1 []} {June 0 []} {July 1 []}]" {
+		t.Errorf("unexpected tally %s", got)
+	}
+
+	// Listings include the tallies, with the votes of the user
+	messages, _, err := db.GetConversationMessagesForUser(conversation.ConversationID, alice.UserID, database.Page{})
+	if err != nil || len(messages) != 3 {
+		t.Fatalf("unexpected messages %v, %+v", err, messages)
+	}
+	for _, message := range messages {
+		switch message.MessageID {
+		case where:
+			if message.Poll == nil || message.Poll.Voters != 3 || fmt.Sprint(message.Poll.MyVotes) != "[1]" {
+				t.Errorf("unexpected poll %+v", message.Poll)
+			}
+		case when:
+			if message.Poll == nil || fmt.Sprint(message.Poll.MyVotes) != "[0 2]" {
+				t.Errorf("unexpected poll %+v", message.Poll)
+			}
+		case text:
+			if message.Poll != nil {
+				t.Errorf("unexpected poll %+v", message.Poll)
+			}
+		}
+	}
+	if message, err := db.GetMessage(conversation.ConversationID, where); err != nil || message.Poll == nil || message.Poll.MyVotes != nil {
+		t.Errorf("unexpected message %v, %+v", err, message)
+	}
+
+	if poll, err := db.RetractVote(conversation.ConversationID, where, carol.UserID); err != nil || poll.Voters != 2 ||
+		poll.Options[0].Votes != 0 || poll.MyVotes != nil {
+		t.Errorf("unexpected poll after retracting %v, %+v", err, poll)
+	}
+
+	// Closed polls keep their tally
+	poll, err = db.ClosePoll(conversation.ConversationID, where, now.Add(time.Hour))
+	if err != nil || poll.ClosedAt == nil || !poll.ClosedAt.Equal(now.Add(time.Hour)) || poll.Voters != 2 {
+		t.Fatalf("unexpected closed poll %v, %+v", err, poll)
+	}
+	if _, err := db.Vote(conversation.ConversationID, where, carol.UserID, []int{0}, now); !errors.Is(err, database.ErrPollClosed) {
+		t.Errorf("expected ErrPollClosed, got %v", err)
+	}
+	if _, err := db.RetractVote(conversation.ConversationID, where, bob.UserID); !errors.Is(err, database.ErrPollClosed) {
+		t.Errorf("expected ErrPollClosed, got %v", err)
+	}
+	if poll, err := db.ClosePoll(conversation.ConversationID, where, now.Add(2*time.Hour)); err != nil || !poll.ClosedAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("closing again should not change the poll: %v, %+v", err, poll)
+	}
+
+	// The votes of deleted users are deleted
+	if err := db.DeleteUserByID(bob.UserID); err != nil {
+		t.Fatal(err)
+	}
+	if poll, err := db.GetPoll(conversation.ConversationID, where, ""); err != nil || poll.Voters != 1 || poll.Options[2].Votes != 0 {
+		t.Errorf("unexpected poll after deleting a voter %v, %+v", err, poll)
+	}
+
+	// Deleting the message for everyone deletes the poll
+	deleted, err := db.DeleteMessageForEveryone(conversation.ConversationID, when, now)
+	if err != nil || deleted.Poll != nil {
+		t.Errorf("unexpected deleted message %v, %+v", err, deleted)
+	}
+	if _, err := db.GetPoll(conversation.ConversationID, when, ""); !errors.Is(err, database.ErrPollNotFound) {
+		t.Errorf("expected ErrPollNotFound, got %v", err)
+	}
+}
+
+func testMaintenance(t *testing.T, db database.AppDatabase) {
+	alice := mustUser(t, db, "alice")
+	bob := mustUser(t, db, "bob")
+	carol := mustUser(t, db, "carol")
+	mustConversation(t, db, false, alice, bob)
+	group := mustConversation(t, db, true, alice, bob, carol)
+	if _, err := db.AddMessages(group.ConversationID, []models.Message{{SenderID: bob.UserID, Content: "hi"}}); err != nil {
+		t.Fatalf("adding message: %v", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		t.Errorf("ping: %v", err)
+	}
+	if err := db.Vacuum(); err != nil {
+		t.Errorf("vacuum: %v", err)
+	}
+	if problems, err := db.IntegrityCheck(); err != nil || len(problems) != 0 {
+		t.Errorf("integrity check: %v, %v", err, problems)
+	}
+
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats.SchemaVersion != database.SchemaVersion() || stats.Users != 3 || stats.Conversations != 2 ||
+		stats.Groups != 1 || stats.Memberships != 5 || stats.Messages != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,263 @@
+package database
+
+import (
+	api "AlChats/service/api/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors about the bots
+var (
+	ErrAPIKeyNotFound          = errors.New("API key not found")
+	ErrIncomingWebhookNotFound = errors.New("incoming webhook not found")
+)
+
+// newAPIKey returns a new random API key.
+func newAPIKey() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return api.APIKeyPrefix + hex.EncodeToString(b[:]), nil
+}
+
+// hashAPIKey returns what is stored of the API key: the hex SHA-256 of the key. The keys are random and long, so a
+// fast hash is enough.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateBot saves a new bot account with the username, which must not be taken by a user or another bot.
+func (db *appdbimpl) CreateBot(username string) (api.User, error) {
+	var user api.User
+	err := db.c.QueryRow(db.d.rebind(`
+		INSERT INTO user_table (Username, IsBot)
+		VALUES (?, ?)
+		RETURNING UserID, Username, COALESCE(Photo, ''), IsBot
+	`), username, true).Scan(&user.UserID, &user.Username, &user.Photo, &user.IsBot)
+	if err != nil {
+		if db.d.isUniqueViolation(err) {
+			return user, fmt.Errorf("username %q already exists", username)
+		}
+		return user, err
+	}
+	return user, nil
+}
+
+// GetBots returns all the bot accounts, sorted by ID.
+func (db *appdbimpl) GetBots() ([]api.User, error) {
+	rows, err := db.c.Query(db.d.rebind(`
+		SELECT UserID, Username, COALESCE(Photo, ''), IsBot FROM user_table
+		WHERE IsBot
+		ORDER BY UserID
+	`))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the bots: %w", err)
+	}
+	defer rows.Close()
+
+	var bots []api.User
+	for rows.Next() {
+		var bot api.User
+		if err := rows.Scan(&bot.UserID, &bot.Username, &bot.Photo, &bot.IsBot); err != nil {
+			return nil, fmt.Errorf("failed to scan bot row: %w", err)
+		}
+		bots = append(bots, bot)
+	}
+	return bots, rows.Err()
+}
+
+// apiKeyColumns are the columns read by scanAPIKey.
+const apiKeyColumns = `KeyID, UserID, CreatedAt, COALESCE(LastUsedAt, '')`
+
+// scanAPIKey reads an API key selected with apiKeyColumns.
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (key api.APIKey, err error) {
+	var createdAt, lastUsedAt string
+	if err = row.Scan(&key.KeyID, &key.UserID, &createdAt, &lastUsedAt); err != nil {
+		return key, err
+	}
+	if key.CreatedAt, err = time.Parse(messageTimeLayout, createdAt); err != nil {
+		return key, fmt.Errorf("invalid creation time of API key %s: %w", key.KeyID, err)
+	}
+	if key.LastUsedAt, err = parseOptionalTime(lastUsedAt); err != nil {
+		return key, fmt.Errorf("invalid last use time of API key %s: %w", key.KeyID, err)
+	}
+	return key, nil
+}
+
+// CreateAPIKey saves a new API key of the bot, created at the time `createdAt`. The returned key is the only one
+// including the key itself: only its hash is stored.
+func (db *appdbimpl) CreateAPIKey(userID string, createdAt time.Time) (api.APIKey, error) {
+	var isBot bool
+	err := db.c.QueryRow(db.d.rebind(`SELECT IsBot FROM user_table WHERE UserID = ?`), userID).Scan(&isBot)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !isBot) {
+		return api.APIKey{}, fmt.Errorf("bot with ID %q: %w", userID, ErrUserNotFound)
+	} else if err != nil {
+		return api.APIKey{}, fmt.Errorf("failed to read user %s: %w", userID, err)
+	}
+
+	secret, err := newAPIKey()
+	if err != nil {
+		return api.APIKey{}, fmt.Errorf("failed to generate the API key: %w", err)
+	}
+	key, err := scanAPIKey(db.c.QueryRow(db.d.rebind(`
+		INSERT INTO api_key_table (UserID, KeyHash, CreatedAt)
+		VALUES (?, ?, ?)
+		RETURNING `+apiKeyColumns),
+		userID, hashAPIKey(secret), createdAt.UTC().Format(messageTimeLayout)))
+	if err != nil {
+		return key, fmt.Errorf("failed to create API key: %w", err)
+	}
+	key.Key = secret
+	return key, nil
+}
+
+// GetAPIKeys returns the API keys of the bot, the oldest first, without the keys themselves.
+func (db *appdbimpl) GetAPIKeys(userID string) ([]api.APIKey, error) {
+	rows, err := db.c.Query(db.d.rebind(`
+		SELECT `+apiKeyColumns+` FROM api_key_table
+		WHERE UserID = ?
+		ORDER BY CreatedAt, KeyID
+	`), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the API keys of %s: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var keys []api.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key row: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// DeleteAPIKey revokes the API key of the bot: it does not authenticate anymore.
+func (db *appdbimpl) DeleteAPIKey(userID, keyID string) error {
+	result, err := db.c.Exec(db.d.rebind(`DELETE FROM api_key_table WHERE UserID = ? AND KeyID = ?`), userID, keyID)
+	if err != nil {
+		return fmt.Errorf("failed to delete API key %s: %w", keyID, err)
+	}
+	if deleted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return fmt.Errorf("API key %s of user %s: %w", keyID, userID, ErrAPIKeyNotFound)
+	}
+	return nil
+}
+
+// GetUserByAPIKey returns the bot authenticated by the API key, and records that the key was used at the time
+// `usedAt`.
+func (db *appdbimpl) GetUserByAPIKey(key string, usedAt time.Time) (api.User, error) {
+	var userID string
+	err := db.c.QueryRow(db.d.rebind(`
+		UPDATE api_key_table SET LastUsedAt = ?
+		WHERE KeyHash = ?
+		RETURNING UserID
+	`), usedAt.UTC().Format(messageTimeLayout), hashAPIKey(key)).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return api.User{}, ErrAPIKeyNotFound
+	} else if err != nil {
+		return api.User{}, fmt.Errorf("failed to read the API key: %w", err)
+	}
+	return db.GetUserByID(userID)
+}
+
+// incomingWebhookColumns are the columns read by scanIncomingWebhook.
+const incomingWebhookColumns = `Token, ConversationID, UserID, CreatedAt`
+
+// scanIncomingWebhook reads an incoming webhook selected with incomingWebhookColumns.
+func scanIncomingWebhook(row interface{ Scan(...interface{}) error }) (webhook api.IncomingWebhook, err error) {
+	var createdAt string
+	if err = row.Scan(&webhook.Token, &webhook.ConversationID, &webhook.UserID, &createdAt); err != nil {
+		return webhook, err
+	}
+	if webhook.CreatedAt, err = time.Parse(messageTimeLayout, createdAt); err != nil {
+		return webhook, fmt.Errorf("invalid creation time of incoming webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+// CreateIncomingWebhook saves a new incoming webhook posting as the bot into the conversation, created at the time
+// `createdAt`. It returns the saved webhook, with its new random token.
+func (db *appdbimpl) CreateIncomingWebhook(conversationID, userID string, createdAt time.Time) (api.IncomingWebhook, error) {
+	var exists bool
+	err := db.c.QueryRow(db.d.rebind(`SELECT EXISTS(SELECT 1 FROM conversation_table WHERE ConversationID = ?)`), conversationID).Scan(&exists)
+	if err != nil {
+		return api.IncomingWebhook{}, fmt.Errorf("failed to check if conversation exists: %w", err)
+	}
+	if !exists {
+		return api.IncomingWebhook{}, fmt.Errorf("conversation with ID %q: %w", conversationID, ErrConversationNotFound)
+	}
+
+	webhook, err := scanIncomingWebhook(db.c.QueryRow(db.d.rebind(`
+		INSERT INTO incoming_webhook_table (ConversationID, UserID, CreatedAt)
+		VALUES (?, ?, ?)
+		RETURNING `+incomingWebhookColumns),
+		conversationID, userID, createdAt.UTC().Format(messageTimeLayout)))
+	if err != nil {
+		return webhook, fmt.Errorf("failed to create incoming webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+// GetIncomingWebhook returns the incoming webhook with the token.
+func (db *appdbimpl) GetIncomingWebhook(token string) (api.IncomingWebhook, error) {
+	webhook, err := scanIncomingWebhook(db.c.QueryRow(db.d.rebind(`
+		SELECT `+incomingWebhookColumns+` FROM incoming_webhook_table WHERE Token = ?
+	`), token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return webhook, ErrIncomingWebhookNotFound
+	} else if err != nil {
+		return webhook, fmt.Errorf("failed to read the incoming webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+// GetIncomingWebhooks returns the incoming webhooks of the bot in the conversation, the oldest first.
+func (db *appdbimpl) GetIncomingWebhooks(conversationID, userID string) ([]api.IncomingWebhook, error) {
+	rows, err := db.c.Query(db.d.rebind(`
+		SELECT `+incomingWebhookColumns+` FROM incoming_webhook_table
+		WHERE ConversationID = ? AND UserID = ?
+		ORDER BY CreatedAt, Token
+	`), conversationID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the incoming webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var webhooks []api.IncomingWebhook
+	for rows.Next() {
+		webhook, err := scanIncomingWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan incoming webhook row: %w", err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// DeleteIncomingWebhook deletes the incoming webhook of the bot in the conversation.
+func (db *appdbimpl) DeleteIncomingWebhook(conversationID, userID, token string) error {
+	result, err := db.c.Exec(db.d.rebind(`
+		DELETE FROM incoming_webhook_table WHERE ConversationID = ? AND UserID = ? AND Token = ?
+	`), conversationID, userID, token)
+	if err != nil {
+		return fmt.Errorf("failed to delete the incoming webhook: %w", err)
+	}
+	if deleted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return fmt.Errorf("incoming webhook of user %s in conversation %s: %w", userID, conversationID, ErrIncomingWebhookNotFound)
+	}
+	return nil
+}
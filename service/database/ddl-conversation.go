@@ -2,9 +2,37 @@ package database
 
 import (
 	api "AlChats/service/api/models"
+	"database/sql"
+	"errors"
 	"fmt"
 )
 
+// ErrConversationNotFound is returned when the requested conversation does not exist
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// Errors about the members of a conversation
+var (
+	ErrNotMember     = errors.New("not a member of the conversation")
+	ErrAlreadyMember = errors.New("already a member of the conversation")
+)
+
+// promoteOwners gives an owner to the groups left without one (e.g., because the owner left): their admin with the
+// lowest ID, or their member with the lowest ID if there are no admins.
+const promoteOwners = `
+	UPDATE user_conversation_table SET Role = 'owner'
+	WHERE ConversationID IN (SELECT ConversationID FROM conversation_table WHERE IsGroup)
+	AND NOT EXISTS (
+		SELECT 1 FROM user_conversation_table o
+		WHERE o.ConversationID = user_conversation_table.ConversationID AND o.Role = 'owner'
+	)
+	AND UserID = (
+		SELECT s.UserID FROM user_conversation_table s
+		WHERE s.ConversationID = user_conversation_table.ConversationID
+		ORDER BY CASE s.Role WHEN 'admin' THEN 0 ELSE 1 END, s.UserID
+		LIMIT 1
+	)
+`
+
 func (db *appdbimpl) SetConversation(userIDs []string, isGroup bool, groupName, groupPhoto string) (api.Conversation, error) {
 	var conversation api.Conversation
 
@@ -18,6 +46,27 @@ func (db *appdbimpl) SetConversation(userIDs []string, isGroup bool, groupName,
 		return conversation, fmt.Errorf("cannot create a group conversation with more than two users without setting isGroup to true")
 	}
 
+	// The conversation and its members are created together: nothing is left behind if a user does not exist
+	tx, err := db.c.Begin()
+	if err != nil {
+		return conversation, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if conversation, err = insertConversation(tx, db.d, userIDs, isGroup, groupName, groupPhoto); err != nil {
+		return conversation, err
+	}
+	if err := tx.Commit(); err != nil {
+		return conversation, fmt.Errorf("failed to create conversation: %w", err)
+	}
+	return conversation, nil
+}
+
+// insertConversation saves a new conversation with its members in the transaction. The first user is the owner of a
+// group.
+func insertConversation(tx *sql.Tx, d dialect, userIDs []string, isGroup bool, groupName, groupPhoto string) (api.Conversation, error) {
+	var conversation api.Conversation
+
 	// SQL to insert a new conversation and retrieve the generated ConversationID and other fields
 	query := `
 		INSERT INTO conversation_table (IsGroup, GroupName, GroupPhoto) 
@@ -30,19 +79,20 @@ func (db *appdbimpl) SetConversation(userIDs []string, isGroup bool, groupName,
 	`
 
 	// Insert the conversation and fetch the generated fields
-	err := db.c.QueryRow(query, isGroup, groupName, groupPhoto).
+	err := tx.QueryRow(d.rebind(query), isGroup, groupName, groupPhoto).
 		Scan(&conversation.ConversationID, &conversation.IsGroup, &conversation.GroupName, &conversation.GroupPhoto)
 	if err != nil {
 		// Handle any database error
 		return conversation, fmt.Errorf("failed to create conversation: %w", err)
 	}
 
-	// Insert user-conversation relationships into the user_conversation_table
-	for _, userID := range userIDs {
+	// Insert user-conversation relationships into the user_conversation_table. The creator of a group (the first
+	// user) is its owner.
+	for i, userID := range userIDs {
 		// Check if the UserID exists in the user_table
 		var exists bool
 		checkUserQuery := `SELECT EXISTS(SELECT 1 FROM user_table WHERE UserID = ?)`
-		err := db.c.QueryRow(checkUserQuery, userID).Scan(&exists)
+		err := tx.QueryRow(d.rebind(checkUserQuery), userID).Scan(&exists)
 		if err != nil {
 			return conversation, fmt.Errorf("failed to check if user exists: %w", err)
 		}
@@ -54,25 +104,29 @@ func (db *appdbimpl) SetConversation(userIDs []string, isGroup bool, groupName,
 
 		// SQL to insert the relationship between user and conversation
 		relationshipQuery := `
-			INSERT INTO user_conversation_table (UserID, ConversationID)
-			VALUES (?, ?)
+			INSERT INTO user_conversation_table (UserID, ConversationID, Role)
+			VALUES (?, ?, ?)
 		`
 
+		role := api.RoleMember
+		if isGroup && i == 0 {
+			role = api.RoleOwner
+		}
+
 		// Insert the user-conversation relationship
-		_, err = db.c.Exec(relationshipQuery, userID, conversation.ConversationID)
+		_, err = tx.Exec(d.rebind(relationshipQuery), userID, conversation.ConversationID, role)
 		if err != nil {
 			// If an error occurs, return the error
 			return conversation, fmt.Errorf("failed to create user-conversation relationship: %w", err)
 		}
 	}
-
 	return conversation, nil
 }
 
-func (db *appdbimpl) GetAllConversations() ([]api.Conversation, error) {
-	var conversations []api.Conversation
+func (db *appdbimpl) GetConversationByID(conversationID string) (api.Conversation, error) {
+	var conversation api.Conversation
 
-	// SQL to select all conversations from the conversation_table
+	// SQL to select a single conversation by its ID
 	query := `
 		SELECT 
 			ConversationID, 
@@ -80,37 +134,39 @@ func (db *appdbimpl) GetAllConversations() ([]api.Conversation, error) {
 			COALESCE(GroupName, ''), 
 			COALESCE(GroupPhoto, '') 
 		FROM conversation_table
+		WHERE ConversationID = ?
 	`
 
-	// Execute the query and iterate through the results
-	rows, err := db.c.Query(query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve conversations: %w", err)
+	err := db.c.QueryRow(db.d.rebind(query), conversationID).
+		Scan(&conversation.ConversationID, &conversation.IsGroup, &conversation.GroupName, &conversation.GroupPhoto)
+	if errors.Is(err, sql.ErrNoRows) {
+		return conversation, fmt.Errorf("conversation with ID %q: %w", conversationID, ErrConversationNotFound)
+	} else if err != nil {
+		return conversation, fmt.Errorf("failed to retrieve conversation %s: %w", conversationID, err)
 	}
-	defer rows.Close()
 
-	// Loop through the rows and map them to the Conversation struct
-	for rows.Next() {
-		var conversation api.Conversation
-		err := rows.Scan(&conversation.ConversationID, &conversation.IsGroup, &conversation.GroupName, &conversation.GroupPhoto)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan conversation row: %w", err)
-		}
-		conversations = append(conversations, conversation)
-	}
+	return conversation, nil
+}
 
-	// Check for any error encountered during iteration
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("failed to iterate over conversation rows: %w", err)
-	}
+func (db *appdbimpl) GetAllConversations(page Page) ([]api.Conversation, PageInfo, error) {
+	// SQL to select a page of conversations from the conversation_table
+	where, orderLimit, args := page.keysetClause("ConversationID")
+	query := `
+		SELECT 
+			ConversationID, 
+			IsGroup, 
+			COALESCE(GroupName, ''), 
+			COALESCE(GroupPhoto, '') 
+		FROM conversation_table
+		WHERE ` + where + `
+		` + orderLimit
 
-	return conversations, nil
+	return db.queryConversationsPage(page, query, args...)
 }
 
-func (db *appdbimpl) GetAllConversationsByMember(userID string) ([]api.Conversation, error) {
-	var conversations []api.Conversation
-
-	// SQL to select all conversations for a user by joining conversation_table and user_conversation_table
+func (db *appdbimpl) GetAllConversationsByMember(userID string, page Page) ([]api.Conversation, PageInfo, error) {
+	// SQL to select a page of conversations for a user by joining conversation_table and user_conversation_table
+	where, orderLimit, args := page.keysetClause("c.ConversationID")
 	query := `
 		SELECT 
 			c.ConversationID, 
@@ -119,13 +175,24 @@ func (db *appdbimpl) GetAllConversationsByMember(userID string) ([]api.Conversat
 			COALESCE(c.GroupPhoto, '') 
 		FROM conversation_table c
 		JOIN user_conversation_table uc ON c.ConversationID = uc.ConversationID
-		WHERE uc.UserID = ?
-	`
+		WHERE uc.UserID = ? AND ` + where + `
+		` + orderLimit
 
-	// Execute the query
-	rows, err := db.c.Query(query, userID)
+	conversations, info, err := db.queryConversationsPage(page, query, append([]interface{}{userID}, args...)...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve conversations for user %s: %w", userID, err)
+		return nil, PageInfo{}, fmt.Errorf("failed to retrieve conversations for user %s: %w", userID, err)
+	}
+	return conversations, info, nil
+}
+
+// queryConversationsPage runs a query built with Page.keysetClause and returns the resulting page of conversations.
+func (db *appdbimpl) queryConversationsPage(page Page, query string, args ...interface{}) ([]api.Conversation, PageInfo, error) {
+	var conversations []api.Conversation
+
+	// Execute the query and iterate through the results
+	rows, err := db.c.Query(db.d.rebind(query), args...)
+	if err != nil {
+		return nil, PageInfo{}, fmt.Errorf("failed to retrieve conversations: %w", err)
 	}
 	defer rows.Close()
 
@@ -134,17 +201,20 @@ func (db *appdbimpl) GetAllConversationsByMember(userID string) ([]api.Conversat
 		var conversation api.Conversation
 		err := rows.Scan(&conversation.ConversationID, &conversation.IsGroup, &conversation.GroupName, &conversation.GroupPhoto)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan conversation row: %w", err)
+			return nil, PageInfo{}, fmt.Errorf("failed to scan conversation row: %w", err)
 		}
 		conversations = append(conversations, conversation)
 	}
 
 	// Check for any error encountered during iteration
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("failed to iterate over conversation rows: %w", err)
+		return nil, PageInfo{}, fmt.Errorf("failed to iterate over conversation rows: %w", err)
 	}
 
-	return conversations, nil
+	size, info := page.pageResult(len(conversations),
+		func(i, j int) { conversations[i], conversations[j] = conversations[j], conversations[i] },
+		func(i int) string { return conversations[i].ConversationID })
+	return conversations[:size], info, nil
 }
 
 func (db *appdbimpl) GetConversationMembers(conversationID string) ([]api.User, error) {
@@ -155,14 +225,15 @@ func (db *appdbimpl) GetConversationMembers(conversationID string) ([]api.User,
 		SELECT 
 			u.UserID, 
 			u.Username, 
-			COALESCE(u.Photo, '') 
+			COALESCE(u.Photo, ''),
+			u.IsBot
 		FROM user_table u
 		JOIN user_conversation_table uc ON u.UserID = uc.UserID
 		WHERE uc.ConversationID = ?
 	`
 
 	// Execute the query
-	rows, err := db.c.Query(query, conversationID)
+	rows, err := db.c.Query(db.d.rebind(query), conversationID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve members for conversation %s: %w", conversationID, err)
 	}
@@ -171,7 +242,7 @@ func (db *appdbimpl) GetConversationMembers(conversationID string) ([]api.User,
 	// Iterate through the rows and map them to the User struct
 	for rows.Next() {
 		var user api.User
-		err := rows.Scan(&user.UserID, &user.Username, &user.Photo)
+		err := rows.Scan(&user.UserID, &user.Username, &user.Photo, &user.IsBot)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan user row: %w", err)
 		}
@@ -185,3 +256,157 @@ func (db *appdbimpl) GetConversationMembers(conversationID string) ([]api.User,
 
 	return members, nil
 }
+
+// GetConversationMemberRoles returns the members of the conversation with their roles, sorted by ID.
+func (db *appdbimpl) GetConversationMemberRoles(conversationID string) ([]api.Member, error) {
+	rows, err := db.c.Query(db.d.rebind(`
+		SELECT u.UserID, u.Username, COALESCE(u.Photo, ''), u.IsBot, uc.Role
+		FROM user_table u
+		JOIN user_conversation_table uc ON u.UserID = uc.UserID
+		WHERE uc.ConversationID = ?
+		ORDER BY u.UserID
+	`), conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve members for conversation %s: %w", conversationID, err)
+	}
+	defer rows.Close()
+
+	var members []api.Member
+	for rows.Next() {
+		var member api.Member
+		if err := rows.Scan(&member.UserID, &member.Username, &member.Photo, &member.IsBot, &member.Role); err != nil {
+			return nil, fmt.Errorf("failed to scan member row: %w", err)
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over member rows: %w", err)
+	}
+	return members, nil
+}
+
+// UpdateConversation replaces the name and the photo of the conversation.
+func (db *appdbimpl) UpdateConversation(conversationID, groupName, groupPhoto string) (api.Conversation, error) {
+	var conversation api.Conversation
+	err := db.c.QueryRow(db.d.rebind(`
+		UPDATE conversation_table SET GroupName = ?, GroupPhoto = ?
+		WHERE ConversationID = ?
+		RETURNING ConversationID, IsGroup, COALESCE(GroupName, ''), COALESCE(GroupPhoto, '')
+	`), groupName, groupPhoto, conversationID).
+		Scan(&conversation.ConversationID, &conversation.IsGroup, &conversation.GroupName, &conversation.GroupPhoto)
+	if errors.Is(err, sql.ErrNoRows) {
+		return conversation, fmt.Errorf("conversation with ID %q: %w", conversationID, ErrConversationNotFound)
+	} else if err != nil {
+		return conversation, fmt.Errorf("failed to update conversation %s: %w", conversationID, err)
+	}
+	return conversation, nil
+}
+
+// AddConversationMembers adds the users to the conversation, as members. Either all of them are added or none is.
+func (db *appdbimpl) AddConversationMembers(conversationID string, userIDs []string) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var exists bool
+	err = tx.QueryRow(db.d.rebind(`SELECT EXISTS(SELECT 1 FROM conversation_table WHERE ConversationID = ?)`), conversationID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check if conversation exists: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("conversation with ID %q: %w", conversationID, ErrConversationNotFound)
+	}
+
+	for _, userID := range userIDs {
+		err := tx.QueryRow(db.d.rebind(`SELECT EXISTS(SELECT 1 FROM user_table WHERE UserID = ?)`), userID).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to check if user exists: %w", err)
+		}
+		if !exists {
+			return fmt.Errorf("user with UserID %s: %w", userID, ErrUserNotFound)
+		}
+
+		_, err = tx.Exec(db.d.rebind(`INSERT INTO user_conversation_table (UserID, ConversationID, Role) VALUES (?, ?, ?)`),
+			userID, conversationID, api.RoleMember)
+		if db.d.isUniqueViolation(err) {
+			return fmt.Errorf("user %s: %w", userID, ErrAlreadyMember)
+		} else if err != nil {
+			return fmt.Errorf("failed to add member %s: %w", userID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RemoveConversationMember removes the user from the conversation. If they were the owner of a group, the ownership
+// passes to another member (see promoteOwners); groups left without members are deleted.
+func (db *appdbimpl) RemoveConversationMember(conversationID, userID string) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.Exec(db.d.rebind(`DELETE FROM user_conversation_table WHERE ConversationID = ? AND UserID = ?`),
+		conversationID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to remove member %s: %w", userID, err)
+	}
+	if removed, err := result.RowsAffected(); err != nil {
+		return err
+	} else if removed == 0 {
+		return fmt.Errorf("user %s in conversation %s: %w", userID, conversationID, ErrNotMember)
+	}
+
+	if _, err := tx.Exec(promoteOwners); err != nil {
+		return fmt.Errorf("failed to transfer the ownership: %w", err)
+	}
+	_, err = tx.Exec(db.d.rebind(`
+		DELETE FROM conversation_table
+		WHERE ConversationID = ? AND NOT EXISTS (
+			SELECT 1 FROM user_conversation_table uc WHERE uc.ConversationID = conversation_table.ConversationID
+		)
+	`), conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to delete empty conversation: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// SetMemberRole changes the role of the member. Making them the owner transfers the ownership: the previous owner
+// becomes an admin.
+func (db *appdbimpl) SetMemberRole(conversationID, userID, role string) error {
+	if role != api.RoleOwner && role != api.RoleAdmin && role != api.RoleMember {
+		return fmt.Errorf("invalid role %q", role)
+	}
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if role == api.RoleOwner {
+		_, err := tx.Exec(db.d.rebind(`UPDATE user_conversation_table SET Role = ? WHERE ConversationID = ? AND Role = ? AND UserID <> ?`),
+			api.RoleAdmin, conversationID, api.RoleOwner, userID)
+		if err != nil {
+			return fmt.Errorf("failed to demote the owner: %w", err)
+		}
+	}
+
+	result, err := tx.Exec(db.d.rebind(`UPDATE user_conversation_table SET Role = ? WHERE ConversationID = ? AND UserID = ?`),
+		role, conversationID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to change the role of %s: %w", userID, err)
+	}
+	if updated, err := result.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return fmt.Errorf("user %s in conversation %s: %w", userID, conversationID, ErrNotMember)
+	}
+
+	return tx.Commit()
+}
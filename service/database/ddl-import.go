@@ -0,0 +1,132 @@
+package database
+
+import (
+	api "AlChats/service/api/models"
+	"errors"
+	"fmt"
+)
+
+// importAttempts is how many times ImportChat runs its transaction when a username it chose is taken in the meantime
+const importAttempts = 3
+
+// ChatImport is a conversation created together with its history, e.g. imported from another messaging app (see
+// ImportChat)
+type ChatImport struct {
+	IsGroup   bool
+	GroupName string
+
+	// MemberIDs are the existing users who are members; the first one is the owner of a group
+	MemberIDs []string
+
+	// NewUsernames are the users to create for the conversation, e.g. placeholders for the authors without an
+	// account. They are members after MemberIDs. A username already taken gets the first free numeric suffix (e.g.
+	// `bob-2`), so the users created may have other usernames.
+	NewUsernames []string
+
+	// Messages are the messages to add, without sender: Senders[i] is the author of Messages[i], as an index in the
+	// members (MemberIDs followed by NewUsernames)
+	Messages []api.Message
+	Senders  []int
+}
+
+// check returns why the import is not valid, before anything is written.
+func (chat ChatImport) check() error {
+	if len(chat.Senders) != len(chat.Messages) {
+		return fmt.Errorf("%d senders for %d messages", len(chat.Senders), len(chat.Messages))
+	}
+	members := len(chat.MemberIDs) + len(chat.NewUsernames)
+	if members < 2 {
+		return fmt.Errorf("cannot create a conversation with only one user")
+	}
+	if members > 2 && !chat.IsGroup {
+		return fmt.Errorf("cannot create a group conversation with more than two users without setting isGroup to true")
+	}
+	for _, sender := range chat.Senders {
+		if sender < 0 || sender >= members {
+			return fmt.Errorf("sender %d is not a member", sender)
+		}
+	}
+	return nil
+}
+
+// ImportChat creates the new users, the conversation and its messages in a single transaction: nothing is left behind
+// if any of them fails. It returns the conversation and the new users, in the order of NewUsernames.
+func (db *appdbimpl) ImportChat(chat ChatImport) (api.Conversation, []api.User, error) {
+	if err := chat.check(); err != nil {
+		return api.Conversation{}, nil, err
+	}
+
+	// The free usernames are chosen in the transaction, but another one can still take them before it inserts them:
+	// the next attempt sees them as taken.
+	for attempt := 1; ; attempt++ {
+		conversation, users, err := db.importChat(chat)
+		var taken usernameTakenError
+		if !errors.As(err, &taken) || attempt == importAttempts {
+			return conversation, users, err
+		}
+	}
+}
+
+// importChat runs the transaction of ImportChat.
+func (db *appdbimpl) importChat(chat ChatImport) (api.Conversation, []api.User, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return api.Conversation{}, nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	memberIDs := append([]string{}, chat.MemberIDs...)
+	newUsers := make([]api.User, 0, len(chat.NewUsernames))
+	chosen := make(map[string]bool)
+	for _, wanted := range chat.NewUsernames {
+		username, err := freeUsername(tx, db.d, wanted, chosen)
+		if err != nil {
+			return api.Conversation{}, nil, err
+		}
+		user, err := insertUser(tx, db.d, username)
+		if err != nil {
+			return api.Conversation{}, nil, err
+		}
+		newUsers = append(newUsers, user)
+		memberIDs = append(memberIDs, user.UserID)
+	}
+
+	conversation, err := insertConversation(tx, db.d, memberIDs, chat.IsGroup, chat.GroupName, "")
+	if err != nil {
+		return api.Conversation{}, nil, err
+	}
+
+	messages := make([]api.Message, len(chat.Messages))
+	for i, message := range chat.Messages {
+		message.SenderID = memberIDs[chat.Senders[i]]
+		messages[i] = message
+	}
+	if _, err := insertMessages(tx, db.d, conversation.ConversationID, messages); err != nil {
+		return api.Conversation{}, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return api.Conversation{}, nil, err
+	}
+	return conversation, newUsers, nil
+}
+
+// freeUsername returns the first username among `wanted`, `wanted-2`, `wanted-3`... that is neither in the database
+// nor in `chosen`, and adds it to `chosen`.
+func freeUsername(q queryer, d dialect, wanted string, chosen map[string]bool) (string, error) {
+	username := wanted
+	for n := 2; ; n++ {
+		if !chosen[username] {
+			var exists bool
+			err := q.QueryRow(d.rebind(`SELECT EXISTS(SELECT 1 FROM user_table WHERE Username = ?)`), username).Scan(&exists)
+			if err != nil {
+				return "", fmt.Errorf("failed to look for username %q: %w", username, err)
+			}
+			if !exists {
+				chosen[username] = true
+				return username, nil
+			}
+		}
+		username = fmt.Sprintf("%s-%d", wanted, n)
+	}
+}
@@ -0,0 +1,241 @@
+package database
+
+import (
+	api "AlChats/service/api/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors about the invites. An invite that cannot be used anymore reports ErrInviteRevoked, ErrInviteExpired or
+// ErrInviteUsedUp, in this order.
+var (
+	ErrInviteNotFound = errors.New("invite not found")
+	ErrInviteRevoked  = errors.New("the invite was revoked")
+	ErrInviteExpired  = errors.New("the invite expired")
+	ErrInviteUsedUp   = errors.New("the invite reached its maximum number of uses")
+)
+
+// inviteColumns are the columns read by scanInvite. The times use messageTimeLayout, like the messages.
+const inviteColumns = `Token, ConversationID, COALESCE(CreatedBy, ''), CreatedAt, COALESCE(ExpiresAt, ''), MaxUses, Uses,
+	COALESCE(RevokedAt, '')`
+
+// scanInvite reads an invite selected with inviteColumns.
+func scanInvite(row interface{ Scan(...interface{}) error }) (invite api.Invite, err error) {
+	var createdAt, expiresAt, revokedAt string
+	err = row.Scan(&invite.Token, &invite.ConversationID, &invite.CreatedBy, &createdAt, &expiresAt, &invite.MaxUses,
+		&invite.Uses, &revokedAt)
+	if err != nil {
+		return invite, err
+	}
+	if invite.CreatedAt, err = time.Parse(messageTimeLayout, createdAt); err != nil {
+		return invite, fmt.Errorf("invalid creation time of invite %s: %w", invite.Token, err)
+	}
+	if expiresAt != "" {
+		t, err := time.Parse(messageTimeLayout, expiresAt)
+		if err != nil {
+			return invite, fmt.Errorf("invalid expiry time of invite %s: %w", invite.Token, err)
+		}
+		invite.ExpiresAt = &t
+	}
+	if revokedAt != "" {
+		t, err := time.Parse(messageTimeLayout, revokedAt)
+		if err != nil {
+			return invite, fmt.Errorf("invalid revocation time of invite %s: %w", invite.Token, err)
+		}
+		invite.RevokedAt = &t
+	}
+	return invite, nil
+}
+
+// formatOptionalTime formats t with messageTimeLayout, or returns nil (NULL) if t is nil.
+func formatOptionalTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(messageTimeLayout)
+}
+
+// parseOptionalTime parses a time formatted with messageTimeLayout, or returns nil if it is empty.
+func parseOptionalTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(messageTimeLayout, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// inviteError reports why the invite cannot be used at the time `now`, or nil if it can.
+func inviteError(invite api.Invite, now time.Time) error {
+	switch {
+	case invite.RevokedAt != nil:
+		return ErrInviteRevoked
+	case invite.ExpiresAt != nil && !now.Before(*invite.ExpiresAt):
+		return ErrInviteExpired
+	case invite.MaxUses > 0 && invite.Uses >= invite.MaxUses:
+		return ErrInviteUsedUp
+	}
+	return nil
+}
+
+// CreateInvite saves a new invite to the conversation, with the creator, the creation and expiry times and the maximum
+// number of uses of `invite`. It returns the saved invite, with its new token.
+func (db *appdbimpl) CreateInvite(invite api.Invite) (api.Invite, error) {
+	var exists bool
+	err := db.c.QueryRow(db.d.rebind(`SELECT EXISTS(SELECT 1 FROM conversation_table WHERE ConversationID = ?)`), invite.ConversationID).Scan(&exists)
+	if err != nil {
+		return api.Invite{}, fmt.Errorf("failed to check if conversation exists: %w", err)
+	}
+	if !exists {
+		return api.Invite{}, fmt.Errorf("conversation with ID %q: %w", invite.ConversationID, ErrConversationNotFound)
+	}
+
+	created, err := scanInvite(db.c.QueryRow(db.d.rebind(`
+		INSERT INTO invite_table (ConversationID, CreatedBy, CreatedAt, ExpiresAt, MaxUses)
+		VALUES (?, NULLIF(?, ''), ?, ?, ?)
+		RETURNING `+inviteColumns),
+		invite.ConversationID, invite.CreatedBy, invite.CreatedAt.UTC().Format(messageTimeLayout),
+		formatOptionalTime(invite.ExpiresAt), invite.MaxUses))
+	if err != nil {
+		return created, fmt.Errorf("failed to create invite: %w", err)
+	}
+	return created, nil
+}
+
+// GetConversationInvites returns the invites of the conversation, the oldest first, including the ones that cannot be
+// used anymore.
+func (db *appdbimpl) GetConversationInvites(conversationID string) ([]api.Invite, error) {
+	rows, err := db.c.Query(db.d.rebind(`
+		SELECT `+inviteColumns+` FROM invite_table
+		WHERE ConversationID = ?
+		ORDER BY CreatedAt, Token
+	`), conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query invites: %w", err)
+	}
+	defer rows.Close()
+
+	var invites []api.Invite
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan invite row: %w", err)
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+// RevokeInvite stops the invite of the conversation from working. Revoking an invite again keeps the first revocation
+// time.
+func (db *appdbimpl) RevokeInvite(conversationID, token string, revokedAt time.Time) (api.Invite, error) {
+	invite, err := scanInvite(db.c.QueryRow(db.d.rebind(`
+		UPDATE invite_table SET RevokedAt = COALESCE(RevokedAt, ?)
+		WHERE ConversationID = ? AND Token = ?
+		RETURNING `+inviteColumns),
+		revokedAt.UTC().Format(messageTimeLayout), conversationID, token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return invite, fmt.Errorf("invite %s in conversation %s: %w", token, conversationID, ErrInviteNotFound)
+	} else if err != nil {
+		return invite, fmt.Errorf("failed to revoke invite %s: %w", token, err)
+	}
+	return invite, nil
+}
+
+// JoinWithInvite adds the user to the conversation of the invite, as a member, and records the join. It returns the
+// invite, with the use counted. The use is counted in the same statement checking that the invite can be used, so
+// concurrent joins cannot exceed its maximum number of uses.
+func (db *appdbimpl) JoinWithInvite(token, userID string, joinedAt time.Time) (api.Invite, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return api.Invite{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	now := joinedAt.UTC().Format(messageTimeLayout)
+	invite, err := scanInvite(tx.QueryRow(db.d.rebind(`
+		UPDATE invite_table SET Uses = Uses + 1
+		WHERE Token = ? AND RevokedAt IS NULL AND (ExpiresAt IS NULL OR ExpiresAt > ?) AND (MaxUses = 0 OR Uses < MaxUses)
+		RETURNING `+inviteColumns), token, now))
+	if errors.Is(err, sql.ErrNoRows) {
+		// Tell why the invite cannot be used
+		invite, err := scanInvite(tx.QueryRow(db.d.rebind(`SELECT `+inviteColumns+` FROM invite_table WHERE Token = ?`), token))
+		if errors.Is(err, sql.ErrNoRows) {
+			return invite, fmt.Errorf("invite %s: %w", token, ErrInviteNotFound)
+		} else if err != nil {
+			return invite, fmt.Errorf("failed to read invite %s: %w", token, err)
+		}
+		if reason := inviteError(invite, joinedAt); reason != nil {
+			return invite, fmt.Errorf("invite %s: %w", token, reason)
+		}
+		return invite, fmt.Errorf("invite %s changed while joining", token)
+	} else if err != nil {
+		return invite, fmt.Errorf("failed to use invite %s: %w", token, err)
+	}
+
+	var exists bool
+	err = tx.QueryRow(db.d.rebind(`SELECT EXISTS(SELECT 1 FROM user_table WHERE UserID = ?)`), userID).Scan(&exists)
+	if err != nil {
+		return invite, fmt.Errorf("failed to check if user exists: %w", err)
+	}
+	if !exists {
+		return invite, fmt.Errorf("user with UserID %s: %w", userID, ErrUserNotFound)
+	}
+
+	_, err = tx.Exec(db.d.rebind(`INSERT INTO user_conversation_table (UserID, ConversationID, Role) VALUES (?, ?, ?)`),
+		userID, invite.ConversationID, api.RoleMember)
+	if db.d.isUniqueViolation(err) {
+		return invite, fmt.Errorf("user %s: %w", userID, ErrAlreadyMember)
+	} else if err != nil {
+		return invite, fmt.Errorf("failed to add member %s: %w", userID, err)
+	}
+
+	_, err = tx.Exec(db.d.rebind(`INSERT INTO invite_join_table (Token, UserID, JoinedAt) VALUES (?, ?, ?)`), token, userID, now)
+	if err != nil {
+		return invite, fmt.Errorf("failed to record the join: %w", err)
+	}
+
+	return invite, tx.Commit()
+}
+
+// GetInviteJoins returns who joined the conversation with the invite, the earliest first. The joins of deleted users
+// are deleted with them.
+func (db *appdbimpl) GetInviteJoins(conversationID, token string) ([]api.InviteJoin, error) {
+	var exists bool
+	err := db.c.QueryRow(db.d.rebind(`SELECT EXISTS(SELECT 1 FROM invite_table WHERE ConversationID = ? AND Token = ?)`),
+		conversationID, token).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if invite exists: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("invite %s in conversation %s: %w", token, conversationID, ErrInviteNotFound)
+	}
+
+	rows, err := db.c.Query(db.d.rebind(`
+		SELECT Token, UserID, JoinedAt FROM invite_join_table
+		WHERE Token = ?
+		ORDER BY JoinedAt, UserID
+	`), token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query joins: %w", err)
+	}
+	defer rows.Close()
+
+	var joins []api.InviteJoin
+	for rows.Next() {
+		var join api.InviteJoin
+		var joinedAt string
+		if err := rows.Scan(&join.Token, &join.UserID, &joinedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan join row: %w", err)
+		}
+		if join.JoinedAt, err = time.Parse(messageTimeLayout, joinedAt); err != nil {
+			return nil, fmt.Errorf("invalid time of join of %s: %w", join.UserID, err)
+		}
+		joins = append(joins, join)
+	}
+	return joins, rows.Err()
+}
@@ -0,0 +1,364 @@
+package database
+
+import (
+	api "AlChats/service/api/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrMessageNotFound is returned when the message does not exist in the conversation
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrMessageDeleted is returned when editing a message that was deleted for everyone
+	ErrMessageDeleted = errors.New("the message was deleted")
+)
+
+// messageTimeLayout is the format of message_table.CreatedAt: fixed width and in UTC, so that it sorts chronologically
+const messageTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// messageKey is the sort key of the messages: the creation time, then the ID for messages sent in the same instant
+const messageKey = "m.CreatedAt || m.MessageID"
+
+// messageColumns are the columns read by scanMessage
+const messageColumns = `m.MessageID, m.ConversationID, COALESCE(m.SenderID, ''), m.Content, m.CreatedAt, COALESCE(m.EditedAt, ''),
+	COALESCE(m.DeletedAt, ''), COALESCE(m.SystemAction, ''), COALESCE(m.SystemActorID, ''), COALESCE(m.SystemTarget, '')`
+
+// scanMessage reads a message selected with messageColumns. It returns its sort key too (see messageKey).
+func scanMessage(row interface{ Scan(...interface{}) error }) (message api.Message, key string, err error) {
+	var createdAt, editedAt, deletedAt string
+	var system api.SystemEvent
+	err = row.Scan(&message.MessageID, &message.ConversationID, &message.SenderID, &message.Content, &createdAt, &editedAt,
+		&deletedAt, &system.Action, &system.ActorID, &system.Target)
+	if err != nil {
+		return message, "", fmt.Errorf("failed to scan message row: %w", err)
+	}
+	message.CreatedAt, err = time.Parse(messageTimeLayout, createdAt)
+	if err != nil {
+		return message, "", fmt.Errorf("invalid time of message %s: %w", message.MessageID, err)
+	}
+	if editedAt != "" {
+		t, err := time.Parse(messageTimeLayout, editedAt)
+		if err != nil {
+			return message, "", fmt.Errorf("invalid edit time of message %s: %w", message.MessageID, err)
+		}
+		message.EditedAt = &t
+	}
+	if deletedAt != "" {
+		t, err := time.Parse(messageTimeLayout, deletedAt)
+		if err != nil {
+			return message, "", fmt.Errorf("invalid deletion time of message %s: %w", message.MessageID, err)
+		}
+		message.DeletedAt = &t
+	}
+	if system.Action != "" {
+		message.System = &system
+	}
+	return message, createdAt + message.MessageID, nil
+}
+
+// AddMessages saves the messages in the conversation, keeping their CreatedAt (so that they can be backdated, e.g.
+// when importing a chat from another service), with their polls (without votes). Either all messages are saved or
+// none is. It returns the saved messages, with their new IDs.
+func (db *appdbimpl) AddMessages(conversationID string, messages []api.Message) ([]api.Message, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	saved, err := insertMessages(tx, db.d, conversationID, messages)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// insertMessages saves the messages of the conversation in the transaction, and returns them as saved.
+func insertMessages(tx *sql.Tx, d dialect, conversationID string, messages []api.Message) ([]api.Message, error) {
+	var exists bool
+	err := tx.QueryRow(d.rebind(`SELECT EXISTS(SELECT 1 FROM conversation_table WHERE ConversationID = ?)`), conversationID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if conversation exists: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("conversation with ID %q: %w", conversationID, ErrConversationNotFound)
+	}
+
+	stmt, err := tx.Prepare(d.rebind(`
+		INSERT INTO message_table (ConversationID, SenderID, Content, CreatedAt, SystemAction, SystemActorID, SystemTarget)
+		VALUES (?, NULLIF(?, ''), ?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''))
+		RETURNING MessageID
+	`))
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	saved := make([]api.Message, 0, len(messages))
+	for _, message := range messages {
+		message.ConversationID = conversationID
+		message.CreatedAt = message.CreatedAt.UTC()
+		var system api.SystemEvent
+		if message.System != nil {
+			system = *message.System
+		}
+		err := stmt.QueryRow(conversationID, message.SenderID, message.Content, message.CreatedAt.Format(messageTimeLayout),
+			system.Action, system.ActorID, system.Target).
+			Scan(&message.MessageID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save message: %w", err)
+		}
+		if message.Poll != nil {
+			poll, err := addPoll(tx, d, message.MessageID, *message.Poll)
+			if err != nil {
+				return nil, err
+			}
+			message.Poll = &poll
+		}
+		saved = append(saved, message)
+	}
+	return saved, nil
+}
+
+// GetConversationMessages returns a page of the messages of the conversation, in chronological order.
+func (db *appdbimpl) GetConversationMessages(conversationID string, page Page) ([]api.Message, PageInfo, error) {
+	return db.conversationMessages(conversationID, "", page)
+}
+
+// GetConversationMessagesForUser is like GetConversationMessages, without the messages the user deleted for themselves.
+func (db *appdbimpl) GetConversationMessagesForUser(conversationID, userID string, page Page) ([]api.Message, PageInfo, error) {
+	return db.conversationMessages(conversationID, userID, page)
+}
+
+// conversationMessages returns a page of the messages of the conversation, without the ones hidden by the user (if
+// not empty).
+func (db *appdbimpl) conversationMessages(conversationID, userID string, page Page) ([]api.Message, PageInfo, error) {
+	where, orderLimit, args := page.keysetClause(messageKey)
+	query := `
+		SELECT ` + messageColumns + `
+		FROM message_table m
+		WHERE m.ConversationID = ? AND ` + where + `
+			AND NOT EXISTS (SELECT 1 FROM message_hidden_table h WHERE h.MessageID = m.MessageID AND h.UserID = ?)
+		` + orderLimit
+	args = append(append([]interface{}{conversationID}, args...), userID)
+
+	rows, err := db.c.Query(db.d.rebind(query), args...)
+	if err != nil {
+		return nil, PageInfo{}, fmt.Errorf("failed to retrieve messages of conversation %s: %w", conversationID, err)
+	}
+	defer rows.Close()
+
+	var messages []api.Message
+	var keys []string
+	for rows.Next() {
+		message, key, err := scanMessage(rows)
+		if err != nil {
+			return nil, PageInfo{}, err
+		}
+		messages = append(messages, message)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, fmt.Errorf("failed to iterate over message rows: %w", err)
+	}
+
+	size, info := page.pageResult(len(messages),
+		func(i, j int) {
+			messages[i], messages[j] = messages[j], messages[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		},
+		func(i int) string { return keys[i] })
+	messages = messages[:size]
+	if err := attachPolls(db.c, db.d, messages, userID); err != nil {
+		return nil, PageInfo{}, err
+	}
+	return messages, info, nil
+}
+
+// attachPolls sets the polls of the messages that have one, tallied for `userID` (see tallyPoll).
+func attachPolls(q queryer, d dialect, messages []api.Message, userID string) error {
+	ids := make([]string, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.MessageID)
+	}
+	polls, err := getPolls(q, d, ids, userID)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		messages[i].Poll = polls[messages[i].MessageID]
+	}
+	return nil
+}
+
+// GetMessage returns the message of the conversation.
+func (db *appdbimpl) GetMessage(conversationID, messageID string) (api.Message, error) {
+	return getMessage(db.c, db.d, conversationID, messageID)
+}
+
+// getMessage is GetMessage on a connection or a transaction.
+func getMessage(q queryer, d dialect, conversationID, messageID string) (api.Message, error) {
+	row := q.QueryRow(d.rebind(`
+		SELECT `+messageColumns+`
+		FROM message_table m
+		WHERE m.ConversationID = ? AND m.MessageID = ?
+	`), conversationID, messageID)
+	message, _, err := scanMessage(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return message, fmt.Errorf("message with ID %q: %w", messageID, ErrMessageNotFound)
+	} else if err != nil {
+		return message, err
+	}
+	messages := []api.Message{message}
+	if err := attachPolls(q, d, messages, ""); err != nil {
+		return message, err
+	}
+	return messages[0], nil
+}
+
+// EditMessage replaces the content of the message, keeping the previous one in its history. It returns the edited
+// message, or ErrMessageDeleted if it was deleted for everyone, even by a concurrent transaction.
+func (db *appdbimpl) EditMessage(conversationID, messageID, content string, editedAt time.Time) (api.Message, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return api.Message{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	message, err := getMessage(tx, db.d, conversationID, messageID)
+	if err != nil {
+		return api.Message{}, err
+	}
+	if message.DeletedAt != nil {
+		return api.Message{}, ErrMessageDeleted
+	}
+
+	// The condition on DeletedAt is checked again by the UPDATE, in case the message was deleted since it was read
+	editedAt = editedAt.UTC()
+	res, err := tx.Exec(db.d.rebind(`
+		UPDATE message_table SET Content = ?, EditedAt = ? WHERE MessageID = ? AND DeletedAt IS NULL
+	`), content, editedAt.Format(messageTimeLayout), messageID)
+	if err != nil {
+		return api.Message{}, fmt.Errorf("failed to edit message %s: %w", messageID, err)
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return api.Message{}, fmt.Errorf("failed to edit message %s: %w", messageID, err)
+	} else if affected == 0 {
+		return api.Message{}, ErrMessageDeleted
+	}
+
+	// The replaced version was written when the message was sent, or at its last edit
+	var version int
+	err = tx.QueryRow(db.d.rebind(`SELECT COUNT(*) + 1 FROM message_history_table WHERE MessageID = ?`), messageID).Scan(&version)
+	if err != nil {
+		return api.Message{}, fmt.Errorf("failed to count the versions of message %s: %w", messageID, err)
+	}
+	written := message.CreatedAt
+	if message.EditedAt != nil {
+		written = *message.EditedAt
+	}
+	_, err = tx.Exec(db.d.rebind(`
+		INSERT INTO message_history_table (MessageID, Version, Content, CreatedAt, ReplacedAt) VALUES (?, ?, ?, ?, ?)
+	`), messageID, version, message.Content, written.Format(messageTimeLayout), editedAt.Format(messageTimeLayout))
+	if err != nil {
+		return api.Message{}, fmt.Errorf("failed to save the history of message %s: %w", messageID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return api.Message{}, err
+	}
+	message.Content, message.EditedAt = content, &editedAt
+	return message, nil
+}
+
+// GetMessageHistory returns the previous versions of the message, oldest first (none if it was never edited).
+func (db *appdbimpl) GetMessageHistory(conversationID, messageID string) ([]api.MessageVersion, error) {
+	if _, err := db.GetMessage(conversationID, messageID); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.c.Query(db.d.rebind(`
+		SELECT Version, Content, CreatedAt, ReplacedAt
+		FROM message_history_table
+		WHERE MessageID = ?
+		ORDER BY Version
+	`), messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve the history of message %s: %w", messageID, err)
+	}
+	defer rows.Close()
+
+	var versions []api.MessageVersion
+	for rows.Next() {
+		var version api.MessageVersion
+		var createdAt, replacedAt string
+		if err := rows.Scan(&version.Version, &version.Content, &createdAt, &replacedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message version row: %w", err)
+		}
+		if version.CreatedAt, err = time.Parse(messageTimeLayout, createdAt); err != nil {
+			return nil, fmt.Errorf("invalid time of message version: %w", err)
+		}
+		if version.ReplacedAt, err = time.Parse(messageTimeLayout, replacedAt); err != nil {
+			return nil, fmt.Errorf("invalid time of message version: %w", err)
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over message version rows: %w", err)
+	}
+	return versions, nil
+}
+
+// DeleteMessageForEveryone replaces the message with a tombstone: its content, its edit time, its history and its poll
+// are deleted, and it gets a DeletedAt time. It returns the tombstone. Deleting a message again does not change it.
+func (db *appdbimpl) DeleteMessageForEveryone(conversationID, messageID string, deletedAt time.Time) (api.Message, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return api.Message{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	message, err := getMessage(tx, db.d, conversationID, messageID)
+	if err != nil || message.DeletedAt != nil {
+		return message, err
+	}
+
+	if _, err := tx.Exec(db.d.rebind(`DELETE FROM message_history_table WHERE MessageID = ?`), messageID); err != nil {
+		return api.Message{}, fmt.Errorf("failed to delete the history of message %s: %w", messageID, err)
+	}
+	if _, err := tx.Exec(db.d.rebind(`DELETE FROM poll_table WHERE MessageID = ?`), messageID); err != nil {
+		return api.Message{}, fmt.Errorf("failed to delete the poll of message %s: %w", messageID, err)
+	}
+	deletedAt = deletedAt.UTC()
+	_, err = tx.Exec(db.d.rebind(`UPDATE message_table SET Content = '', EditedAt = NULL, DeletedAt = ? WHERE MessageID = ?`),
+		deletedAt.Format(messageTimeLayout), messageID)
+	if err != nil {
+		return api.Message{}, fmt.Errorf("failed to delete message %s: %w", messageID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return api.Message{}, err
+	}
+	message.Content, message.EditedAt, message.DeletedAt, message.Poll = "", nil, &deletedAt, nil
+	return message, nil
+}
+
+// HideMessage deletes the message for the user only: it is left out of GetConversationMessagesForUser.
+func (db *appdbimpl) HideMessage(conversationID, messageID, userID string) error {
+	if _, err := db.GetMessage(conversationID, messageID); err != nil {
+		return err
+	}
+	_, err := db.c.Exec(db.d.rebind(`
+		INSERT INTO message_hidden_table (MessageID, UserID) VALUES (?, ?)
+		ON CONFLICT DO NOTHING
+	`), messageID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to hide message %s: %w", messageID, err)
+	}
+	return nil
+}
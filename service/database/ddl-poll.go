@@ -0,0 +1,300 @@
+package database
+
+import (
+	api "AlChats/service/api/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Errors about the polls
+var (
+	ErrPollNotFound = errors.New("the message is not a poll")
+	ErrPollClosed   = errors.New("the poll is closed")
+	ErrInvalidVote  = errors.New("invalid vote")
+)
+
+// pollVote is the choice of an option of a poll by a member
+type pollVote struct {
+	option int
+	userID string
+}
+
+// newPoll returns the poll to save for `poll`: only the question, the texts of the options and the kind are kept.
+func newPoll(poll api.Poll) api.Poll {
+	saved := api.Poll{Question: poll.Question, MultipleChoice: poll.MultipleChoice, Anonymous: poll.Anonymous}
+	for _, option := range poll.Options {
+		saved.Options = append(saved.Options, api.PollOption{Text: option.Text})
+	}
+	return saved
+}
+
+// tallyPoll counts the votes, sorted by time (then by user ID), in a copy of the poll without votes. The voters are
+// listed unless the poll is anonymous, and the options chosen by `userID`, if not empty, are in MyVotes.
+func tallyPoll(poll api.Poll, votes []pollVote, userID string) api.Poll {
+	poll.Options = append([]api.PollOption(nil), poll.Options...)
+	voters := make(map[string]bool)
+	for _, vote := range votes {
+		option := &poll.Options[vote.option]
+		option.Votes++
+		if !poll.Anonymous {
+			option.VoterIDs = append(option.VoterIDs, vote.userID)
+		}
+		voters[vote.userID] = true
+		if userID != "" && vote.userID == userID {
+			poll.MyVotes = append(poll.MyVotes, vote.option)
+		}
+	}
+	poll.Voters = len(voters)
+	sort.Ints(poll.MyVotes)
+	return poll
+}
+
+// checkVote returns why the options cannot be chosen in the poll, or nil if they can.
+func checkVote(poll api.Poll, options []int) error {
+	if poll.ClosedAt != nil {
+		return ErrPollClosed
+	}
+	if len(options) == 0 {
+		return fmt.Errorf("%w: no option chosen", ErrInvalidVote)
+	}
+	if len(options) > 1 && !poll.MultipleChoice {
+		return fmt.Errorf("%w: only one option can be chosen", ErrInvalidVote)
+	}
+	chosen := make(map[int]bool, len(options))
+	for _, option := range options {
+		if option < 0 || option >= len(poll.Options) {
+			return fmt.Errorf("%w: option %d does not exist", ErrInvalidVote, option)
+		}
+		if chosen[option] {
+			return fmt.Errorf("%w: option %d is repeated", ErrInvalidVote, option)
+		}
+		chosen[option] = true
+	}
+	return nil
+}
+
+// addPoll saves the poll of the new message, and returns it as saved (see newPoll).
+func addPoll(tx *sql.Tx, d dialect, messageID string, poll api.Poll) (api.Poll, error) {
+	poll = newPoll(poll)
+	_, err := tx.Exec(d.rebind(`INSERT INTO poll_table (MessageID, Question, MultipleChoice, Anonymous) VALUES (?, ?, ?, ?)`),
+		messageID, poll.Question, poll.MultipleChoice, poll.Anonymous)
+	if err != nil {
+		return poll, fmt.Errorf("failed to save the poll: %w", err)
+	}
+	for i, option := range poll.Options {
+		_, err := tx.Exec(d.rebind(`INSERT INTO poll_option_table (MessageID, Position, Text) VALUES (?, ?, ?)`),
+			messageID, i, option.Text)
+		if err != nil {
+			return poll, fmt.Errorf("failed to save the options of the poll: %w", err)
+		}
+	}
+	return tallyPoll(poll, nil, ""), nil
+}
+
+// getPolls returns the polls of the messages that have one, by message ID, tallied for `userID` (see tallyPoll).
+func getPolls(q queryer, d dialect, messageIDs []string, userID string) (map[string]*api.Poll, error) {
+	polls := make(map[string]*api.Poll)
+	if len(messageIDs) == 0 {
+		return polls, nil
+	}
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(messageIDs)), ", ")
+	args := make([]interface{}, 0, len(messageIDs))
+	for _, id := range messageIDs {
+		args = append(args, id)
+	}
+
+	rows, err := q.Query(d.rebind(`
+		SELECT MessageID, Question, MultipleChoice, Anonymous, COALESCE(ClosedAt, '')
+		FROM poll_table WHERE MessageID IN (`+in+`)
+	`), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the polls: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var messageID, closedAt string
+		var poll api.Poll
+		if err := rows.Scan(&messageID, &poll.Question, &poll.MultipleChoice, &poll.Anonymous, &closedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan poll row: %w", err)
+		}
+		if poll.ClosedAt, err = parseOptionalTime(closedAt); err != nil {
+			return nil, fmt.Errorf("invalid closing time of poll %s: %w", messageID, err)
+		}
+		polls[messageID] = &poll
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over poll rows: %w", err)
+	}
+	if len(polls) == 0 {
+		return polls, nil
+	}
+
+	options, err := q.Query(d.rebind(`
+		SELECT MessageID, Text FROM poll_option_table
+		WHERE MessageID IN (`+in+`)
+		ORDER BY MessageID, Position
+	`), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the options of the polls: %w", err)
+	}
+	defer options.Close()
+	for options.Next() {
+		var messageID string
+		var option api.PollOption
+		if err := options.Scan(&messageID, &option.Text); err != nil {
+			return nil, fmt.Errorf("failed to scan poll option row: %w", err)
+		}
+		polls[messageID].Options = append(polls[messageID].Options, option)
+	}
+	if err := options.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over poll option rows: %w", err)
+	}
+
+	votes, err := q.Query(d.rebind(`
+		SELECT MessageID, Position, UserID FROM poll_vote_table
+		WHERE MessageID IN (`+in+`)
+		ORDER BY VotedAt, UserID, Position
+	`), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the votes of the polls: %w", err)
+	}
+	defer votes.Close()
+	pollVotes := make(map[string][]pollVote)
+	for votes.Next() {
+		var messageID string
+		var vote pollVote
+		if err := votes.Scan(&messageID, &vote.option, &vote.userID); err != nil {
+			return nil, fmt.Errorf("failed to scan poll vote row: %w", err)
+		}
+		pollVotes[messageID] = append(pollVotes[messageID], vote)
+	}
+	if err := votes.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over poll vote rows: %w", err)
+	}
+
+	for messageID, poll := range polls {
+		tallied := tallyPoll(*poll, pollVotes[messageID], userID)
+		polls[messageID] = &tallied
+	}
+	return polls, nil
+}
+
+// getPoll returns the poll of the message of the conversation, tallied for `userID` (see tallyPoll).
+func getPoll(q queryer, d dialect, conversationID, messageID, userID string) (api.Poll, error) {
+	var exists bool
+	err := q.QueryRow(d.rebind(`SELECT EXISTS(SELECT 1 FROM message_table WHERE ConversationID = ? AND MessageID = ?)`),
+		conversationID, messageID).Scan(&exists)
+	if err != nil {
+		return api.Poll{}, fmt.Errorf("failed to check if message exists: %w", err)
+	}
+	if !exists {
+		return api.Poll{}, fmt.Errorf("message with ID %q: %w", messageID, ErrMessageNotFound)
+	}
+
+	polls, err := getPolls(q, d, []string{messageID}, userID)
+	if err != nil {
+		return api.Poll{}, err
+	}
+	poll, ok := polls[messageID]
+	if !ok {
+		return api.Poll{}, fmt.Errorf("message with ID %q: %w", messageID, ErrPollNotFound)
+	}
+	return *poll, nil
+}
+
+// GetPoll returns the poll of the message, tallied for `userID`: their choices are in MyVotes.
+func (db *appdbimpl) GetPoll(conversationID, messageID, userID string) (api.Poll, error) {
+	return getPoll(db.c, db.d, conversationID, messageID, userID)
+}
+
+// Vote replaces the choices of the user in the poll of the message with `options`, at the time `votedAt`. It returns
+// the poll tallied for the user.
+func (db *appdbimpl) Vote(conversationID, messageID, userID string, options []int, votedAt time.Time) (api.Poll, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return api.Poll{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	poll, err := getPoll(tx, db.d, conversationID, messageID, "")
+	if err != nil {
+		return api.Poll{}, err
+	}
+	if err := checkVote(poll, options); err != nil {
+		return api.Poll{}, err
+	}
+
+	if _, err := tx.Exec(db.d.rebind(`DELETE FROM poll_vote_table WHERE MessageID = ? AND UserID = ?`), messageID, userID); err != nil {
+		return api.Poll{}, fmt.Errorf("failed to replace the votes of user %s: %w", userID, err)
+	}
+	for _, option := range options {
+		_, err := tx.Exec(db.d.rebind(`INSERT INTO poll_vote_table (MessageID, Position, UserID, VotedAt) VALUES (?, ?, ?, ?)`),
+			messageID, option, userID, votedAt.UTC().Format(messageTimeLayout))
+		if err != nil {
+			return api.Poll{}, fmt.Errorf("failed to save the vote of user %s: %w", userID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return api.Poll{}, err
+	}
+	return db.GetPoll(conversationID, messageID, userID)
+}
+
+// RetractVote deletes the choices of the user in the poll of the message, if any. It returns the poll tallied for the
+// user.
+func (db *appdbimpl) RetractVote(conversationID, messageID, userID string) (api.Poll, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return api.Poll{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	poll, err := getPoll(tx, db.d, conversationID, messageID, "")
+	if err != nil {
+		return api.Poll{}, err
+	}
+	if poll.ClosedAt != nil {
+		return api.Poll{}, ErrPollClosed
+	}
+
+	// The DELETE checks again that the poll is open: the votes of a poll closed in the meantime must not change
+	_, err = tx.Exec(db.d.rebind(`
+		DELETE FROM poll_vote_table
+		WHERE MessageID = ? AND UserID = ?
+			AND EXISTS (SELECT 1 FROM poll_table p WHERE p.MessageID = ? AND p.ClosedAt IS NULL)
+	`), messageID, userID, messageID)
+	if err != nil {
+		return api.Poll{}, fmt.Errorf("failed to retract the votes of user %s: %w", userID, err)
+	}
+	if poll, err = getPoll(tx, db.d, conversationID, messageID, ""); err != nil {
+		return api.Poll{}, err
+	} else if poll.ClosedAt != nil {
+		return api.Poll{}, ErrPollClosed
+	}
+
+	if err := tx.Commit(); err != nil {
+		return api.Poll{}, err
+	}
+	return db.GetPoll(conversationID, messageID, userID)
+}
+
+// ClosePoll stops the voting in the poll of the message, at the time `closedAt`, and returns the final tally. Closing a
+// poll again does not change it.
+func (db *appdbimpl) ClosePoll(conversationID, messageID string, closedAt time.Time) (api.Poll, error) {
+	poll, err := db.GetPoll(conversationID, messageID, "")
+	if err != nil || poll.ClosedAt != nil {
+		return poll, err
+	}
+
+	_, err = db.c.Exec(db.d.rebind(`UPDATE poll_table SET ClosedAt = ? WHERE MessageID = ? AND ClosedAt IS NULL`),
+		closedAt.UTC().Format(messageTimeLayout), messageID)
+	if err != nil {
+		return api.Poll{}, fmt.Errorf("failed to close poll %s: %w", messageID, err)
+	}
+	return db.GetPoll(conversationID, messageID, "")
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	api "AlChats/service/api/models"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrPushSubscriptionNotFound is returned when the user has no push subscription with the ID
+var ErrPushSubscriptionNotFound = errors.New("push subscription not found")
+
+// AddPushSubscription saves a push subscription of the user, with the creation time of `subscription`, and returns it
+// with its new ID. A subscription with the same endpoint replaces the existing one, even of another user: the browser
+// is now used by this user.
+func (db *appdbimpl) AddPushSubscription(userID string, subscription api.PushSubscription) (api.PushSubscription, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return subscription, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var exists bool
+	err = tx.QueryRow(db.d.rebind(`SELECT EXISTS(SELECT 1 FROM user_table WHERE UserID = ?)`), userID).Scan(&exists)
+	if err != nil {
+		return subscription, fmt.Errorf("failed to check if user exists: %w", err)
+	}
+	if !exists {
+		return subscription, fmt.Errorf("user with UserID %s: %w", userID, ErrUserNotFound)
+	}
+
+	if _, err := tx.Exec(db.d.rebind(`DELETE FROM push_subscription_table WHERE Endpoint = ?`), subscription.Endpoint); err != nil {
+		return subscription, fmt.Errorf("failed to replace the subscription: %w", err)
+	}
+	subscription.CreatedAt = subscription.CreatedAt.UTC()
+	err = tx.QueryRow(db.d.rebind(`
+		INSERT INTO push_subscription_table (UserID, Endpoint, P256dh, Auth, CreatedAt)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING SubscriptionID
+	`), userID, subscription.Endpoint, subscription.Keys.P256dh, subscription.Keys.Auth,
+		subscription.CreatedAt.Format(messageTimeLayout)).Scan(&subscription.SubscriptionID)
+	if err != nil {
+		return subscription, fmt.Errorf("failed to save the subscription: %w", err)
+	}
+
+	return subscription, tx.Commit()
+}
+
+// GetPushSubscriptions returns the push subscriptions of the user, the oldest first.
+func (db *appdbimpl) GetPushSubscriptions(userID string) ([]api.PushSubscription, error) {
+	rows, err := db.c.Query(db.d.rebind(`
+		SELECT SubscriptionID, Endpoint, P256dh, Auth, CreatedAt FROM push_subscription_table
+		WHERE UserID = ?
+		ORDER BY CreatedAt, SubscriptionID
+	`), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the subscriptions of %s: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var subscriptions []api.PushSubscription
+	for rows.Next() {
+		var subscription api.PushSubscription
+		var createdAt string
+		err := rows.Scan(&subscription.SubscriptionID, &subscription.Endpoint, &subscription.Keys.P256dh,
+			&subscription.Keys.Auth, &createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan subscription row: %w", err)
+		}
+		if subscription.CreatedAt, err = time.Parse(messageTimeLayout, createdAt); err != nil {
+			return nil, fmt.Errorf("invalid creation time of subscription %s: %w", subscription.SubscriptionID, err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+// DeletePushSubscription deletes a push subscription of the user.
+func (db *appdbimpl) DeletePushSubscription(userID, subscriptionID string) error {
+	result, err := db.c.Exec(db.d.rebind(`DELETE FROM push_subscription_table WHERE UserID = ? AND SubscriptionID = ?`),
+		userID, subscriptionID)
+	if err != nil {
+		return fmt.Errorf("failed to delete subscription %s: %w", subscriptionID, err)
+	}
+	if deleted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return fmt.Errorf("subscription %s of user %s: %w", subscriptionID, userID, ErrPushSubscriptionNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	api "AlChats/service/api/models"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSessionNotFound is returned for the tokens that do not belong to a session
+var ErrSessionNotFound = errors.New("session not found")
+
+// newSessionToken returns a new random session token.
+func newSessionToken() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// CreateSession saves a new session of the user, created at the time `createdAt`, and returns its token. Only the hash
+// of the token is stored (see hashAPIKey), so the returned token is the only copy.
+func (db *appdbimpl) CreateSession(userID string, createdAt time.Time) (string, error) {
+	if _, err := db.GetUserByID(userID); errors.Is(err, ErrUserNotFound) {
+		return "", fmt.Errorf("user with ID %q: %w", userID, ErrUserNotFound)
+	} else if err != nil {
+		return "", fmt.Errorf("failed to read user %s: %w", userID, err)
+	}
+
+	token, err := newSessionToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate the session token: %w", err)
+	}
+	_, err = db.c.Exec(db.d.rebind(`
+		INSERT INTO session_table (TokenHash, UserID, CreatedAt) VALUES (?, ?, ?)
+	`), hashAPIKey(token), userID, createdAt.UTC().Format(messageTimeLayout))
+	if err != nil {
+		return "", fmt.Errorf("failed to save the session of user %s: %w", userID, err)
+	}
+	return token, nil
+}
+
+// GetUserBySession returns the user authenticated by the session token.
+func (db *appdbimpl) GetUserBySession(token string) (api.User, error) {
+	var userID string
+	err := db.c.QueryRow(db.d.rebind(`SELECT UserID FROM session_table WHERE TokenHash = ?`), hashAPIKey(token)).
+		Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return api.User{}, ErrSessionNotFound
+	} else if err != nil {
+		return api.User{}, fmt.Errorf("failed to read the session: %w", err)
+	}
+	return db.GetUserByID(userID)
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	api "AlChats/service/api/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// memberConversationKey is the sort key of the conversations listed to a member: the pinned ones first, then by ID
+const memberConversationKey = "CASE WHEN uc.Pinned THEN '0' ELSE '1' END || c.ConversationID"
+
+// GetConversationsForMember returns a page of the conversations of the user as they are listed to them: the pinned
+// ones first, each with the settings of the user, and without the archived ones unless includeArchived is set.
+func (db *appdbimpl) GetConversationsForMember(userID string, includeArchived bool, page Page) ([]api.Conversation, PageInfo, error) {
+	where, orderLimit, args := page.keysetClause(memberConversationKey)
+	if !includeArchived {
+		where += " AND NOT uc.Archived"
+	}
+	query := `
+		SELECT c.ConversationID, c.IsGroup, COALESCE(c.GroupName, ''), COALESCE(c.GroupPhoto, ''),
+			uc.Pinned, COALESCE(uc.MutedUntil, ''), uc.Archived
+		FROM conversation_table c
+		JOIN user_conversation_table uc ON c.ConversationID = uc.ConversationID
+		WHERE uc.UserID = ? AND ` + where + `
+		` + orderLimit
+
+	rows, err := db.c.Query(db.d.rebind(query), append([]interface{}{userID}, args...)...)
+	if err != nil {
+		return nil, PageInfo{}, fmt.Errorf("failed to retrieve conversations for user %s: %w", userID, err)
+	}
+	defer rows.Close()
+
+	var conversations []api.Conversation
+	for rows.Next() {
+		var conversation api.Conversation
+		var settings api.ConversationSettings
+		var mutedUntil string
+		err := rows.Scan(&conversation.ConversationID, &conversation.IsGroup, &conversation.GroupName,
+			&conversation.GroupPhoto, &settings.Pinned, &mutedUntil, &settings.Archived)
+		if err != nil {
+			return nil, PageInfo{}, fmt.Errorf("failed to scan conversation row: %w", err)
+		}
+		if settings.MutedUntil, err = parseOptionalTime(mutedUntil); err != nil {
+			return nil, PageInfo{}, fmt.Errorf("invalid mute time of conversation %s: %w", conversation.ConversationID, err)
+		}
+		conversation.Settings = &settings
+		conversations = append(conversations, conversation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, fmt.Errorf("failed to iterate over conversation rows: %w", err)
+	}
+
+	size, info := page.pageResult(len(conversations),
+		func(i, j int) { conversations[i], conversations[j] = conversations[j], conversations[i] },
+		func(i int) string { return memberSortKey(conversations[i]) })
+	return conversations[:size], info, nil
+}
+
+// memberSortKey returns the sort key of a conversation listed to a member (see memberConversationKey).
+func memberSortKey(conversation api.Conversation) string {
+	if conversation.Settings != nil && conversation.Settings.Pinned {
+		return "0" + conversation.ConversationID
+	}
+	return "1" + conversation.ConversationID
+}
+
+// GetMemberSettings returns the settings of the member for the conversation.
+func (db *appdbimpl) GetMemberSettings(conversationID, userID string) (api.ConversationSettings, error) {
+	var settings api.ConversationSettings
+	var mutedUntil string
+	err := db.c.QueryRow(db.d.rebind(`
+		SELECT Pinned, COALESCE(MutedUntil, ''), Archived FROM user_conversation_table
+		WHERE ConversationID = ? AND UserID = ?
+	`), conversationID, userID).Scan(&settings.Pinned, &mutedUntil, &settings.Archived)
+	if errors.Is(err, sql.ErrNoRows) {
+		return settings, fmt.Errorf("user %s in conversation %s: %w", userID, conversationID, ErrNotMember)
+	} else if err != nil {
+		return settings, fmt.Errorf("failed to read the settings of %s: %w", userID, err)
+	}
+	if settings.MutedUntil, err = parseOptionalTime(mutedUntil); err != nil {
+		return settings, fmt.Errorf("invalid mute time of %s: %w", userID, err)
+	}
+	return settings, nil
+}
+
+// SetMemberSettings replaces the settings of the member for the conversation.
+func (db *appdbimpl) SetMemberSettings(conversationID, userID string, settings api.ConversationSettings) error {
+	result, err := db.c.Exec(db.d.rebind(`
+		UPDATE user_conversation_table SET Pinned = ?, MutedUntil = ?, Archived = ?
+		WHERE ConversationID = ? AND UserID = ?
+	`), settings.Pinned, formatOptionalTime(settings.MutedUntil), settings.Archived, conversationID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to save the settings of %s: %w", userID, err)
+	}
+	if updated, err := result.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return fmt.Errorf("user %s in conversation %s: %w", userID, conversationID, ErrNotMember)
+	}
+	return nil
+}
+
+// GetMutedMembers returns the IDs of the members who muted the conversation at the time `at`, sorted.
+func (db *appdbimpl) GetMutedMembers(conversationID string, at time.Time) ([]string, error) {
+	rows, err := db.c.Query(db.d.rebind(`
+		SELECT UserID FROM user_conversation_table
+		WHERE ConversationID = ? AND MutedUntil > ?
+		ORDER BY UserID
+	`), conversationID, at.UTC().Format(messageTimeLayout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve the muted members of conversation %s: %w", conversationID, err)
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan member row: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
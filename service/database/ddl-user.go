@@ -3,15 +3,30 @@ package database
 import (
 	api "AlChats/service/api/models"
 	"database/sql"
+	"errors"
 	"fmt"
-
-	"github.com/mattn/go-sqlite3"
 )
 
+// ErrUserNotFound is returned when the requested user does not exist
+var ErrUserNotFound = errors.New("user not found")
+
 func (db *appdbimpl) GetUserByID(userID string) (api.User, error) {
 	var user api.User
-	err := db.c.QueryRow("SELECT UserID, Username, Photo FROM user_table WHERE UserID = ?", userID).Scan(&user.UserID, &user.Username, &user.Photo)
-	if err != nil {
+	err := db.c.QueryRow(db.d.rebind("SELECT UserID, Username, COALESCE(Photo, ''), IsBot FROM user_table WHERE UserID = ?"), userID).Scan(&user.UserID, &user.Username, &user.Photo, &user.IsBot)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, fmt.Errorf("user with ID %q: %w", userID, ErrUserNotFound)
+	} else if err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
+func (db *appdbimpl) GetUserByUsername(username string) (api.User, error) {
+	var user api.User
+	err := db.c.QueryRow(db.d.rebind("SELECT UserID, Username, COALESCE(Photo, ''), IsBot FROM user_table WHERE Username = ?"), username).Scan(&user.UserID, &user.Username, &user.Photo, &user.IsBot)
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, fmt.Errorf("user with username %q: %w", username, ErrUserNotFound)
+	} else if err != nil {
 		return user, err
 	}
 	return user, nil
@@ -31,14 +46,14 @@ func (db *appdbimpl) UpdateUsername(userId string, newUsername string) (api.User
 			WHERE Username = ?
 			AND UserID != ?
 		)
-		RETURNING UserID, Username, COALESCE(Photo, '')
+		RETURNING UserID, Username, COALESCE(Photo, ''), IsBot
 	`
 
 	// Update the username and fetch the updated fields
-	err := db.c.QueryRow(query, newUsername, userId, newUsername, userId).Scan(&user.UserID, &user.Username, &user.Photo)
+	err := db.c.QueryRow(db.d.rebind(query), newUsername, userId, newUsername, userId).Scan(&user.UserID, &user.Username, &user.Photo, &user.IsBot)
 	if err != nil {
 		// Check if the error is a constraint violation
-		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+		if db.d.isUniqueViolation(err) {
 			return user, fmt.Errorf("username %q already exists", newUsername)
 		}
 
@@ -54,21 +69,35 @@ func (db *appdbimpl) UpdateUsername(userId string, newUsername string) (api.User
 }
 
 func (db *appdbimpl) SetUser(username string) (api.User, error) {
+	return insertUser(db.c, db.d, username)
+}
+
+// usernameTakenError is returned by insertUser when another user has the username
+type usernameTakenError struct {
+	username string
+}
+
+func (e usernameTakenError) Error() string {
+	return fmt.Sprintf("username %q already exists", e.username)
+}
+
+// insertUser saves a new user with the username, in the transaction or not.
+func insertUser(q queryer, d dialect, username string) (api.User, error) {
 	var user api.User
 
 	// SQL to insert a new user and retrieve the generated UserID and other fields
 	query := `
 		INSERT INTO user_table (Username) 
 		VALUES (?)
-		RETURNING UserID, Username, COALESCE(Photo, '')
+		RETURNING UserID, Username, COALESCE(Photo, ''), IsBot
 	`
 
 	// Insert the user and fetch the generated fields
-	err := db.c.QueryRow(query, username).Scan(&user.UserID, &user.Username, &user.Photo)
+	err := q.QueryRow(d.rebind(query), username).Scan(&user.UserID, &user.Username, &user.Photo, &user.IsBot)
 	if err != nil {
 		// Check if the error is a unique constraint violation
-		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
-			return user, fmt.Errorf("username %q already exists", username)
+		if d.isUniqueViolation(err) {
+			return user, usernameTakenError{username: username}
 		}
 		return user, err
 	}
@@ -76,40 +105,80 @@ func (db *appdbimpl) SetUser(username string) (api.User, error) {
 	return user, nil
 }
 
-func (db *appdbimpl) GetAllUsers() ([]api.User, error) {
+func (db *appdbimpl) GetAllUsers(page Page) ([]api.User, PageInfo, error) {
 	var users []api.User
 
-	// Query to select all users
-	rows, err := db.c.Query("SELECT UserID, Username, COALESCE(Photo, '') FROM user_table")
+	// Query to select a page of users, sorted by UserID
+	where, orderLimit, args := page.keysetClause("UserID")
+	rows, err := db.c.Query(db.d.rebind("SELECT UserID, Username, COALESCE(Photo, ''), IsBot FROM user_table WHERE "+where+" "+orderLimit), args...)
 	if err != nil {
-		return nil, err
+		return nil, PageInfo{}, err
 	}
 	defer rows.Close()
 
 	// Iterate through the rows and scan data into the users slice
 	for rows.Next() {
 		var user api.User
-		err := rows.Scan(&user.UserID, &user.Username, &user.Photo)
+		err := rows.Scan(&user.UserID, &user.Username, &user.Photo, &user.IsBot)
 		if err != nil {
-			return nil, err
+			return nil, PageInfo{}, err
 		}
 		users = append(users, user)
 	}
 
 	// Check for errors during iteration
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, PageInfo{}, err
 	}
 
-	return users, nil
+	size, info := page.pageResult(len(users),
+		func(i, j int) { users[i], users[j] = users[j], users[i] },
+		func(i int) string { return users[i].UserID })
+	return users[:size], info, nil
 }
 
+// DeleteUserByID removes the user and cleans up the data that only made sense with them around: their 1:1
+// conversations without messages are deleted, their other memberships are removed (their groups get a new owner, see
+// promoteOwners), and conversations left without members are deleted. Their messages are kept without sender, and their
+// sessions are revoked.
 func (db *appdbimpl) DeleteUserByID(userID string) error {
-	// SQL query to delete a user by UserID
-	query := "DELETE FROM user_table WHERE UserID = ?"
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Delete the empty 1:1 conversations of the user, as nobody can write in them anymore. The others keep the history
+	// of the other member.
+	_, err = tx.Exec(db.d.rebind(`
+		DELETE FROM conversation_table
+		WHERE NOT IsGroup
+		AND ConversationID IN (
+			SELECT ConversationID FROM user_conversation_table WHERE UserID = ?
+		)
+		AND NOT EXISTS (
+			SELECT 1 FROM message_table m WHERE m.ConversationID = conversation_table.ConversationID
+		)
+	`), userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete conversations of user %s: %w", userID, err)
+	}
 
-	// Execute the query
-	result, err := db.c.Exec(query, userID)
+	// Revoke the sessions, so that their tokens stop working (the foreign key cascade does the same, this is explicit
+	// for clarity)
+	_, err = tx.Exec(db.d.rebind("DELETE FROM session_table WHERE UserID = ?"), userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete sessions of user %s: %w", userID, err)
+	}
+
+	// Remove the remaining memberships (the foreign key cascade does the same, this is explicit for clarity)
+	_, err = tx.Exec(db.d.rebind("DELETE FROM user_conversation_table WHERE UserID = ?"), userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete memberships of user %s: %w", userID, err)
+	}
+
+	// SQL query to delete a user by UserID
+	result, err := tx.Exec(db.d.rebind("DELETE FROM user_table WHERE UserID = ?"), userID)
 	if err != nil {
 		return err
 	}
@@ -123,5 +192,47 @@ func (db *appdbimpl) DeleteUserByID(userID string) error {
 		return fmt.Errorf("no user found with UserID %q", userID)
 	}
 
+	// Give a new owner to the groups the user owned
+	if _, err := tx.Exec(promoteOwners); err != nil {
+		return fmt.Errorf("failed to transfer the ownership of the groups of user %s: %w", userID, err)
+	}
+
+	// Delete the conversations left without members
+	_, err = tx.Exec(db.d.rebind(`
+		DELETE FROM conversation_table
+		WHERE NOT EXISTS (
+			SELECT 1 FROM user_conversation_table uc WHERE uc.ConversationID = conversation_table.ConversationID
+		)
+	`))
+	if err != nil {
+		return fmt.Errorf("failed to delete empty conversations: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetPrivacy returns the privacy settings of the user.
+func (db *appdbimpl) GetPrivacy(userID string) (api.Privacy, error) {
+	var privacy api.Privacy
+	err := db.c.QueryRow(db.d.rebind("SELECT HideLastSeen FROM user_table WHERE UserID = ?"), userID).Scan(&privacy.HideLastSeen)
+	if errors.Is(err, sql.ErrNoRows) {
+		return privacy, fmt.Errorf("user with ID %q: %w", userID, ErrUserNotFound)
+	}
+	return privacy, err
+}
+
+// SetPrivacy replaces the privacy settings of the user.
+func (db *appdbimpl) SetPrivacy(userID string, privacy api.Privacy) error {
+	result, err := db.c.Exec(db.d.rebind("UPDATE user_table SET HideLastSeen = ? WHERE UserID = ?"), privacy.HideLastSeen, userID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("user with ID %q: %w", userID, ErrUserNotFound)
+	}
 	return nil
 }
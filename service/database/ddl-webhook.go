@@ -0,0 +1,269 @@
+package database
+
+import (
+	api "AlChats/service/api/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Errors about the webhooks
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+// webhookColumns are the columns read by scanWebhook. The events are stored separated by commas, empty for all.
+const webhookColumns = `WebhookID, ConversationID, URL, Secret, Events, COALESCE(CreatedBy, ''), CreatedAt`
+
+// scanWebhook reads a webhook selected with webhookColumns.
+func scanWebhook(row interface{ Scan(...interface{}) error }) (webhook api.Webhook, err error) {
+	var events, createdAt string
+	err = row.Scan(&webhook.WebhookID, &webhook.ConversationID, &webhook.URL, &webhook.Secret, &events,
+		&webhook.CreatedBy, &createdAt)
+	if err != nil {
+		return webhook, err
+	}
+	webhook.Events = []string{}
+	if events != "" {
+		webhook.Events = strings.Split(events, ",")
+	}
+	if webhook.CreatedAt, err = time.Parse(messageTimeLayout, createdAt); err != nil {
+		return webhook, fmt.Errorf("invalid creation time of webhook %s: %w", webhook.WebhookID, err)
+	}
+	return webhook, nil
+}
+
+// deliveryKey is the sort key of the deliveries: the time of the event, then the ID
+const deliveryKey = "CreatedAt || DeliveryID"
+
+// deliveryColumns are the columns read by scanDelivery.
+const deliveryColumns = `DeliveryID, WebhookID, Event, Data, Status, Attempts, COALESCE(NextAttemptAt, ''), LastStatusCode,
+	LastError, CreatedAt, COALESCE(DeliveredAt, '')`
+
+// scanDelivery reads a delivery selected with deliveryColumns. It returns its sort key too (see deliveryKey).
+func scanDelivery(row interface{ Scan(...interface{}) error }) (delivery api.WebhookDelivery, key string, err error) {
+	var data, nextAttemptAt, createdAt, deliveredAt string
+	err = row.Scan(&delivery.DeliveryID, &delivery.WebhookID, &delivery.Event, &data, &delivery.Status,
+		&delivery.Attempts, &nextAttemptAt, &delivery.LastStatusCode, &delivery.LastError, &createdAt, &deliveredAt)
+	if err != nil {
+		return delivery, "", err
+	}
+	delivery.Data = []byte(data)
+	if delivery.CreatedAt, err = time.Parse(messageTimeLayout, createdAt); err != nil {
+		return delivery, "", fmt.Errorf("invalid time of delivery %s: %w", delivery.DeliveryID, err)
+	}
+	if delivery.NextAttemptAt, err = parseOptionalTime(nextAttemptAt); err != nil {
+		return delivery, "", fmt.Errorf("invalid next attempt time of delivery %s: %w", delivery.DeliveryID, err)
+	}
+	if delivery.DeliveredAt, err = parseOptionalTime(deliveredAt); err != nil {
+		return delivery, "", fmt.Errorf("invalid delivery time of delivery %s: %w", delivery.DeliveryID, err)
+	}
+	return delivery, createdAt + delivery.DeliveryID, nil
+}
+
+// CreateWebhook saves a new webhook of the conversation, with the URL, events, creator and creation time of `webhook`.
+// It returns the saved webhook, with its new ID and a new random secret.
+func (db *appdbimpl) CreateWebhook(webhook api.Webhook) (api.Webhook, error) {
+	var exists bool
+	err := db.c.QueryRow(db.d.rebind(`SELECT EXISTS(SELECT 1 FROM conversation_table WHERE ConversationID = ?)`), webhook.ConversationID).Scan(&exists)
+	if err != nil {
+		return api.Webhook{}, fmt.Errorf("failed to check if conversation exists: %w", err)
+	}
+	if !exists {
+		return api.Webhook{}, fmt.Errorf("conversation with ID %q: %w", webhook.ConversationID, ErrConversationNotFound)
+	}
+
+	created, err := scanWebhook(db.c.QueryRow(db.d.rebind(`
+		INSERT INTO webhook_table (ConversationID, URL, Events, CreatedBy, CreatedAt)
+		VALUES (?, ?, ?, NULLIF(?, ''), ?)
+		RETURNING `+webhookColumns),
+		webhook.ConversationID, webhook.URL, strings.Join(webhook.Events, ","), webhook.CreatedBy,
+		webhook.CreatedAt.UTC().Format(messageTimeLayout)))
+	if err != nil {
+		return created, fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return created, nil
+}
+
+// GetConversationWebhooks returns the webhooks of the conversation, the oldest first.
+func (db *appdbimpl) GetConversationWebhooks(conversationID string) ([]api.Webhook, error) {
+	return db.conversationWebhooks(db.c, conversationID)
+}
+
+// conversationWebhooks is GetConversationWebhooks, in a transaction or not.
+func (db *appdbimpl) conversationWebhooks(q interface {
+	Query(string, ...interface{}) (*sql.Rows, error)
+}, conversationID string) ([]api.Webhook, error) {
+	rows, err := q.Query(db.d.rebind(`
+		SELECT `+webhookColumns+` FROM webhook_table
+		WHERE ConversationID = ?
+		ORDER BY CreatedAt, WebhookID
+	`), conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var webhooks []api.Webhook
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook row: %w", err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// GetWebhook returns the webhook with the ID.
+func (db *appdbimpl) GetWebhook(webhookID string) (api.Webhook, error) {
+	webhook, err := scanWebhook(db.c.QueryRow(db.d.rebind(`SELECT `+webhookColumns+` FROM webhook_table WHERE WebhookID = ?`), webhookID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return webhook, fmt.Errorf("webhook %s: %w", webhookID, ErrWebhookNotFound)
+	} else if err != nil {
+		return webhook, fmt.Errorf("failed to read webhook %s: %w", webhookID, err)
+	}
+	return webhook, nil
+}
+
+// DeleteWebhook deletes the webhook of the conversation, with its deliveries.
+func (db *appdbimpl) DeleteWebhook(conversationID, webhookID string) error {
+	result, err := db.c.Exec(db.d.rebind(`DELETE FROM webhook_table WHERE ConversationID = ? AND WebhookID = ?`),
+		conversationID, webhookID)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook %s: %w", webhookID, err)
+	}
+	if deleted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return fmt.Errorf("webhook %s in conversation %s: %w", webhookID, conversationID, ErrWebhookNotFound)
+	}
+	return nil
+}
+
+// EnqueueWebhookEvent queues a delivery of the event, which happened at the time `at`, for each webhook of the
+// conversation subscribed to it. The deliveries are due immediately. It returns how many were queued.
+func (db *appdbimpl) EnqueueWebhookEvent(conversationID, event string, data []byte, at time.Time) (int, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	webhooks, err := db.conversationWebhooks(tx, conversationID)
+	if err != nil {
+		return 0, err
+	}
+	now := at.UTC().Format(messageTimeLayout)
+	queued := 0
+	for _, webhook := range webhooks {
+		if !webhook.Subscribed(event) {
+			continue
+		}
+		_, err := tx.Exec(db.d.rebind(`
+			INSERT INTO webhook_delivery_table (WebhookID, Event, Data, NextAttemptAt, CreatedAt)
+			VALUES (?, ?, ?, ?, ?)
+		`), webhook.WebhookID, event, string(data), now, now)
+		if err != nil {
+			return 0, fmt.Errorf("failed to queue the delivery to webhook %s: %w", webhook.WebhookID, err)
+		}
+		queued++
+	}
+	return queued, tx.Commit()
+}
+
+// GetDueWebhookDeliveries returns at most `limit` pending deliveries whose next attempt is due at the time `now`, the
+// longest overdue first.
+func (db *appdbimpl) GetDueWebhookDeliveries(now time.Time, limit int) ([]api.WebhookDelivery, error) {
+	rows, err := db.c.Query(db.d.rebind(`
+		SELECT `+deliveryColumns+` FROM webhook_delivery_table
+		WHERE Status = ? AND NextAttemptAt <= ?
+		ORDER BY NextAttemptAt, DeliveryID
+		LIMIT ?
+	`), api.DeliveryPending, now.UTC().Format(messageTimeLayout), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the due deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []api.WebhookDelivery
+	for rows.Next() {
+		delivery, _, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan delivery row: %w", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// GetWebhookDelivery returns the delivery to the webhook.
+func (db *appdbimpl) GetWebhookDelivery(webhookID, deliveryID string) (api.WebhookDelivery, error) {
+	delivery, _, err := scanDelivery(db.c.QueryRow(db.d.rebind(`
+		SELECT `+deliveryColumns+` FROM webhook_delivery_table WHERE WebhookID = ? AND DeliveryID = ?
+	`), webhookID, deliveryID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return delivery, fmt.Errorf("delivery %s to webhook %s: %w", deliveryID, webhookID, ErrDeliveryNotFound)
+	} else if err != nil {
+		return delivery, fmt.Errorf("failed to read delivery %s: %w", deliveryID, err)
+	}
+	return delivery, nil
+}
+
+// GetWebhookDeliveries returns a page of the deliveries to the webhook, sorted by the time of their event.
+func (db *appdbimpl) GetWebhookDeliveries(webhookID string, page Page) ([]api.WebhookDelivery, PageInfo, error) {
+	where, orderLimit, args := page.keysetClause(deliveryKey)
+	rows, err := db.c.Query(db.d.rebind(`
+		SELECT `+deliveryColumns+` FROM webhook_delivery_table
+		WHERE WebhookID = ? AND `+where+`
+		`+orderLimit), append([]interface{}{webhookID}, args...)...)
+	if err != nil {
+		return nil, PageInfo{}, fmt.Errorf("failed to query the deliveries of webhook %s: %w", webhookID, err)
+	}
+	defer rows.Close()
+
+	var deliveries []api.WebhookDelivery
+	var keys []string
+	for rows.Next() {
+		delivery, key, err := scanDelivery(rows)
+		if err != nil {
+			return nil, PageInfo{}, fmt.Errorf("failed to scan delivery row: %w", err)
+		}
+		deliveries = append(deliveries, delivery)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, fmt.Errorf("failed to iterate over delivery rows: %w", err)
+	}
+
+	size, info := page.pageResult(len(deliveries),
+		func(i, j int) {
+			deliveries[i], deliveries[j] = deliveries[j], deliveries[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		},
+		func(i int) string { return keys[i] })
+	return deliveries[:size], info, nil
+}
+
+// UpdateWebhookDelivery saves the status, the attempts, the next attempt time, the outcome of the last attempt and the
+// delivery time of `delivery`.
+func (db *appdbimpl) UpdateWebhookDelivery(delivery api.WebhookDelivery) error {
+	result, err := db.c.Exec(db.d.rebind(`
+		UPDATE webhook_delivery_table
+		SET Status = ?, Attempts = ?, NextAttemptAt = ?, LastStatusCode = ?, LastError = ?, DeliveredAt = ?
+		WHERE WebhookID = ? AND DeliveryID = ?
+	`), delivery.Status, delivery.Attempts, formatOptionalTime(delivery.NextAttemptAt), delivery.LastStatusCode,
+		delivery.LastError, formatOptionalTime(delivery.DeliveredAt), delivery.WebhookID, delivery.DeliveryID)
+	if err != nil {
+		return fmt.Errorf("failed to update delivery %s: %w", delivery.DeliveryID, err)
+	}
+	if updated, err := result.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return fmt.Errorf("delivery %s to webhook %s: %w", delivery.DeliveryID, delivery.WebhookID, ErrDeliveryNotFound)
+	}
+	return nil
+}
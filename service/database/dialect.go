@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Names of the supported database/sql drivers, to be used in sql.Open
+const (
+	DriverSQLite   = "sqlite3"
+	DriverPostgres = "postgres"
+)
+
+// ErrNotSupported is returned by the operations that the database driver in use does not support (e.g., Backup on
+// PostgreSQL)
+var ErrNotSupported = errors.New("not supported by the database driver")
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// dialect hides the differences between the supported databases. Queries are written once, in the SQL understood by
+// both (with `?` placeholders); everything else (schema, versioning, maintenance, errors) is implemented for each
+// database.
+type dialect interface {
+	// rebind converts the `?` placeholders of the query to the syntax of the database.
+	rebind(query string) string
+
+	// isUniqueViolation reports whether err is caused by a UNIQUE constraint.
+	isUniqueViolation(err error) bool
+
+	// checkConnection verifies that the connection is configured as expected.
+	checkConnection(db *sql.DB) error
+
+	// schemaVersion returns the version of the schema, 0 for an empty database.
+	schemaVersion(db *sql.DB) (int, error)
+
+	// setSchemaVersion records the version of the schema.
+	setSchemaVersion(db execer, version int) error
+
+	// initialize creates the schema version 1.
+	initialize(db *sql.DB) error
+
+	// migrations are the upgrades from each version to the next one: there must be one per element of the SQLite
+	// `migrations`, doing the same changes.
+	migrations() []func(tx *sql.Tx) error
+
+	// size returns the size of the database in bytes.
+	size(db *sql.DB) (int64, error)
+
+	// integrityCheck returns the problems found in the database.
+	integrityCheck(db *sql.DB) ([]string, error)
+}
+
+// dialectOf returns the dialect of the database/sql driver of db.
+func dialectOf(db *sql.DB) (dialect, error) {
+	switch db.Driver().(type) {
+	case *sqlite3.SQLiteDriver:
+		return sqliteDialect{}, nil
+	case *pq.Driver:
+		return postgresDialect{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver %T", db.Driver())
+	}
+}
+
+// Open opens the database with the driver (DriverSQLite or DriverPostgres). `source` is the file name for SQLite (see
+// SQLiteDSN), and the connection string for PostgreSQL.
+func Open(driver, source string) (*sql.DB, error) {
+	switch driver {
+	case DriverSQLite:
+		return sql.Open(driver, SQLiteDSN(source))
+	case DriverPostgres:
+		return sql.Open(driver, source)
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", driver)
+	}
+}
+
+// rebindNumbered converts the `?` placeholders to `$1`, `$2`, ... Queries must not contain `?` elsewhere (e.g., in
+// string literals).
+func rebindNumbered(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
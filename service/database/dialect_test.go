@@ -0,0 +1,15 @@
+package database
+
+import "testing"
+
+func TestRebindNumbered(t *testing.T) {
+	for query, expected := range map[string]string{
+		"SELECT 1":                              "SELECT 1",
+		"SELECT * FROM t WHERE a = ?":           "SELECT * FROM t WHERE a = $1",
+		"UPDATE t SET a = ?, b = ? WHERE c = ?": "UPDATE t SET a = $1, b = $2 WHERE c = $3",
+	} {
+		if got := rebindNumbered(query); got != expected {
+			t.Errorf("%q: expected %q, got %q", query, expected, got)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package database
+
+import "fmt"
+
+// Stats is a summary of the content of the database
+type Stats struct {
+	SchemaVersion int   `json:"schemaVersion"`
+	Users         int   `json:"users"`
+	Conversations int   `json:"conversations"`
+	Groups        int   `json:"groups"`
+	Memberships   int   `json:"memberships"`
+	Messages      int   `json:"messages"`
+	SizeBytes     int64 `json:"sizeBytes"`
+}
+
+// Vacuum rebuilds the database file (or, on PostgreSQL, the tables), reclaiming unused space.
+func (db *appdbimpl) Vacuum() error {
+	if _, err := db.c.Exec("VACUUM"); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	return nil
+}
+
+// IntegrityCheck checks the database (for SQLite, with the integrity check and the foreign key check). It returns the
+// problems found, or nothing if the database is fine.
+func (db *appdbimpl) IntegrityCheck() ([]string, error) {
+	return db.d.integrityCheck(db.c)
+}
+
+// Stats returns the number of rows in each table, and the size of the database.
+func (db *appdbimpl) Stats() (Stats, error) {
+	var stats Stats
+
+	err := db.c.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM user_table),
+			(SELECT COUNT(*) FROM conversation_table),
+			(SELECT COUNT(*) FROM conversation_table WHERE IsGroup),
+			(SELECT COUNT(*) FROM user_conversation_table),
+			(SELECT COUNT(*) FROM message_table)
+	`).Scan(&stats.Users, &stats.Conversations, &stats.Groups, &stats.Memberships, &stats.Messages)
+	if err != nil {
+		return stats, fmt.Errorf("counting rows: %w", err)
+	}
+
+	stats.SizeBytes, err = db.d.size(db.c)
+	if err != nil {
+		return stats, err
+	}
+
+	stats.SchemaVersion, err = db.d.schemaVersion(db.c)
+	if err != nil {
+		return stats, err
+	}
+
+	return stats, nil
+}
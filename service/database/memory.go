@@ -0,0 +1,1492 @@
+package database
+
+import (
+	api "AlChats/service/api/models"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memdb is an AppDatabase kept in memory, safe for concurrent use. It behaves like the SQL implementations (it passes
+// the same conformance suite, see the dbtest package), including the errors, but nothing is persisted.
+type memdb struct {
+	mu sync.RWMutex
+
+	users     map[string]*memUser
+	usernames map[string]string
+
+	// sessions are the IDs of the users, by hash of their session tokens
+	sessions map[string]string
+
+	conversations map[string]*memConversation
+
+	// sequence is the last ID given, if the IDs are sequential (see NewSequentialMemory)
+	sequence   uint64
+	sequential bool
+}
+
+type memUser struct {
+	user    api.User
+	privacy api.Privacy
+
+	// push are the push subscriptions, in the order they were added
+	push []api.PushSubscription
+
+	// keys are the API keys of a bot, in the order they were created, without the keys themselves
+	keys []memAPIKey
+}
+
+type memAPIKey struct {
+	key  api.APIKey
+	hash string
+}
+
+type memConversation struct {
+	conversation api.Conversation
+
+	// members are in the order they were added
+	members []string
+
+	// roles are the roles of the members
+	roles map[string]string
+
+	// settings are the settings of the members who changed them
+	settings map[string]api.ConversationSettings
+
+	// messages are sorted by key (see messageKey)
+	messages []memMessage
+
+	// invites are in the order they were created
+	invites []*memInvite
+
+	// webhooks are in the order they were created
+	webhooks []*memWebhook
+
+	// incoming are the incoming webhooks, in the order they were created
+	incoming []api.IncomingWebhook
+}
+
+type memWebhook struct {
+	webhook api.Webhook
+
+	// deliveries are sorted by key (see deliveryKey), like keys
+	deliveries []api.WebhookDelivery
+	keys       []string
+}
+
+type memInvite struct {
+	invite api.Invite
+
+	// joins are in the order they happened
+	joins []api.InviteJoin
+}
+
+type memMessage struct {
+	key     string
+	message api.Message
+
+	// history are the previous versions, oldest first
+	history []api.MessageVersion
+
+	// hiddenFor are the users who deleted the message for themselves
+	hiddenFor map[string]bool
+
+	// votes are the votes of the poll of the message (message.Poll has no tally), in the order they were given
+	votes []memVote
+}
+
+type memVote struct {
+	pollVote
+	votedAt string
+}
+
+// view returns the message, with the poll tallied for `userID` (see tallyPoll).
+func (m *memMessage) view(userID string) api.Message {
+	message := m.message
+	if message.Poll != nil {
+		votes := append([]memVote(nil), m.votes...)
+		sort.SliceStable(votes, func(i, j int) bool {
+			if votes[i].votedAt != votes[j].votedAt {
+				return votes[i].votedAt < votes[j].votedAt
+			}
+			if votes[i].userID != votes[j].userID {
+				return votes[i].userID < votes[j].userID
+			}
+			return votes[i].option < votes[j].option
+		})
+		tally := make([]pollVote, 0, len(votes))
+		for _, vote := range votes {
+			tally = append(tally, vote.pollVote)
+		}
+		poll := tallyPoll(*message.Poll, tally, userID)
+		message.Poll = &poll
+	}
+	return message
+}
+
+// removeVotes deletes the votes of the user.
+func (m *memMessage) removeVotes(userID string) {
+	votes := m.votes[:0]
+	for _, vote := range m.votes {
+		if vote.userID != userID {
+			votes = append(votes, vote)
+		}
+	}
+	m.votes = votes
+}
+
+// NewMemory returns a new, empty AppDatabase kept in memory. It is meant for tests: handlers can be tested without
+// creating database files.
+func NewMemory() AppDatabase {
+	return &memdb{
+		users:         make(map[string]*memUser),
+		usernames:     make(map[string]string),
+		sessions:      make(map[string]string),
+		conversations: make(map[string]*memConversation),
+	}
+}
+
+// NewSequentialMemory is like NewMemory, but the IDs are given in sequence (1, 2, 3, ... in the same format as the
+// random ones), so that the content of the database is reproducible (e.g., for golden files).
+func NewSequentialMemory() AppDatabase {
+	db := NewMemory().(*memdb)
+	db.sequential = true
+	return db
+}
+
+// newID returns a new ID, in the same format as the SQL implementations. It must be called with the write lock held.
+func (db *memdb) newID() string {
+	var b [16]byte
+	if db.sequential {
+		db.sequence++
+		binary.BigEndian.PutUint64(b[8:], db.sequence)
+		return hex.EncodeToString(b[:])
+	}
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// memPage returns the page of the sorted keys, with the same semantics as Page.keysetClause and Page.pageResult: the
+// returned indexes are in ascending order.
+func memPage(keys []string, page Page) ([]int, PageInfo) {
+	// Select the rows as the query would: ascending after the key, descending before the key
+	var rows []int
+	switch {
+	case page.Before != "":
+		for i := sort.SearchStrings(keys, page.Before) - 1; i >= 0 && len(rows) <= page.limit(); i-- {
+			rows = append(rows, i)
+		}
+	default:
+		start := 0
+		if page.After != "" {
+			start = sort.Search(len(keys), func(i int) bool { return keys[i] > page.After })
+		}
+		for i := start; i < len(keys) && len(rows) <= page.limit(); i++ {
+			rows = append(rows, i)
+		}
+	}
+
+	size, info := page.pageResult(len(rows),
+		func(i, j int) { rows[i], rows[j] = rows[j], rows[i] },
+		func(i int) string { return keys[rows[i]] })
+	return rows[:size], info
+}
+
+func (db *memdb) GetUserByID(userID string) (api.User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	u, ok := db.users[userID]
+	if !ok {
+		return api.User{}, fmt.Errorf("user with ID %q: %w", userID, ErrUserNotFound)
+	}
+	return u.user, nil
+}
+
+func (db *memdb) GetUserByUsername(username string) (api.User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	userID, ok := db.usernames[username]
+	if !ok {
+		return api.User{}, fmt.Errorf("user with username %q: %w", username, ErrUserNotFound)
+	}
+	return db.users[userID].user, nil
+}
+
+func (db *memdb) SetUser(username string) (api.User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.addUser(username)
+}
+
+// addUser saves a new user. It must be called with the write lock held.
+func (db *memdb) addUser(username string) (api.User, error) {
+	if _, ok := db.usernames[username]; ok {
+		return api.User{}, fmt.Errorf("username %q already exists", username)
+	}
+	user := api.User{UserID: db.newID(), Username: username}
+	db.users[user.UserID] = &memUser{user: user}
+	db.usernames[username] = user.UserID
+	return user, nil
+}
+
+func (db *memdb) GetAllUsers(page Page) ([]api.User, PageInfo, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	keys := make([]string, 0, len(db.users))
+	for userID := range db.users {
+		keys = append(keys, userID)
+	}
+	sort.Strings(keys)
+
+	rows, info := memPage(keys, page)
+	users := make([]api.User, 0, len(rows))
+	for _, i := range rows {
+		users = append(users, db.users[keys[i]].user)
+	}
+	return users, info, nil
+}
+
+// DeleteUserByID removes the user with the same cleanup as the SQL implementations: their 1:1 conversations without
+// messages are deleted, their other memberships are removed, conversations left without members are deleted, and
+// their messages are kept without sender.
+func (db *memdb) DeleteUserByID(userID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	u, ok := db.users[userID]
+	if !ok {
+		return fmt.Errorf("no user found with UserID %q", userID)
+	}
+	delete(db.users, userID)
+	delete(db.usernames, u.user.Username)
+	for hash, sessionUserID := range db.sessions {
+		if sessionUserID == userID {
+			delete(db.sessions, hash)
+		}
+	}
+
+	for conversationID, c := range db.conversations {
+		member := c.removeMember(userID)
+		if (member && !c.conversation.IsGroup && len(c.messages) == 0) || len(c.members) == 0 {
+			delete(db.conversations, conversationID)
+			continue
+		}
+		for i := range c.messages {
+			if c.messages[i].message.SenderID == userID {
+				c.messages[i].message.SenderID = ""
+			}
+			if system := c.messages[i].message.System; system != nil && system.ActorID == userID {
+				c.messages[i].message.System = &api.SystemEvent{Action: system.Action, Target: system.Target}
+			}
+			delete(c.messages[i].hiddenFor, userID)
+			c.messages[i].removeVotes(userID)
+		}
+		for _, inv := range c.invites {
+			if inv.invite.CreatedBy == userID {
+				inv.invite.CreatedBy = ""
+			}
+			joins := inv.joins[:0]
+			for _, join := range inv.joins {
+				if join.UserID != userID {
+					joins = append(joins, join)
+				}
+			}
+			inv.joins = joins
+		}
+		for _, w := range c.webhooks {
+			if w.webhook.CreatedBy == userID {
+				w.webhook.CreatedBy = ""
+			}
+		}
+		incoming := c.incoming[:0]
+		for _, webhook := range c.incoming {
+			if webhook.UserID != userID {
+				incoming = append(incoming, webhook)
+			}
+		}
+		c.incoming = incoming
+	}
+	return nil
+}
+
+func (db *memdb) UpdateUsername(userId string, newUsername string) (api.User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	u, ok := db.users[userId]
+	if owner, taken := db.usernames[newUsername]; !ok || (taken && owner != userId) {
+		return api.User{}, fmt.Errorf("user with ID %q not found or username %q already exists", userId, newUsername)
+	}
+	delete(db.usernames, u.user.Username)
+	u.user.Username = newUsername
+	db.usernames[newUsername] = userId
+	return u.user, nil
+}
+
+func (db *memdb) GetPrivacy(userID string) (api.Privacy, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	u, ok := db.users[userID]
+	if !ok {
+		return api.Privacy{}, fmt.Errorf("user with ID %q: %w", userID, ErrUserNotFound)
+	}
+	return u.privacy, nil
+}
+
+func (db *memdb) SetPrivacy(userID string, privacy api.Privacy) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	u, ok := db.users[userID]
+	if !ok {
+		return fmt.Errorf("user with ID %q: %w", userID, ErrUserNotFound)
+	}
+	u.privacy = privacy
+	return nil
+}
+
+func (db *memdb) CreateSession(userID string, createdAt time.Time) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.users[userID]; !ok {
+		return "", fmt.Errorf("user with ID %q: %w", userID, ErrUserNotFound)
+	}
+	token := db.newID() + db.newID()
+	db.sessions[hashAPIKey(token)] = userID
+	return token, nil
+}
+
+func (db *memdb) GetUserBySession(token string) (api.User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	userID, ok := db.sessions[hashAPIKey(token)]
+	if !ok {
+		return api.User{}, ErrSessionNotFound
+	}
+	return db.users[userID].user, nil
+}
+
+func (db *memdb) SetConversation(userIDs []string, isGroup bool, groupName, groupPhoto string) (api.Conversation, error) {
+	if len(userIDs) == 1 {
+		return api.Conversation{}, fmt.Errorf("cannot create a conversation with only one user")
+	}
+	if len(userIDs) > 2 && !isGroup {
+		return api.Conversation{}, fmt.Errorf("cannot create a group conversation with more than two users without setting isGroup to true")
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.addConversation(userIDs, isGroup, groupName, groupPhoto)
+}
+
+// addConversation saves a new conversation with its members, the first one is the owner of a group. It must be called
+// with the write lock held.
+func (db *memdb) addConversation(userIDs []string, isGroup bool, groupName, groupPhoto string) (api.Conversation, error) {
+	seen := make(map[string]bool)
+	for _, userID := range userIDs {
+		if _, ok := db.users[userID]; !ok {
+			return api.Conversation{}, fmt.Errorf("user with UserID %s does not exist", userID)
+		}
+		if seen[userID] {
+			return api.Conversation{}, fmt.Errorf("failed to create user-conversation relationship: user %s is already a member", userID)
+		}
+		seen[userID] = true
+	}
+
+	c := &memConversation{
+		conversation: api.Conversation{
+			ConversationID: db.newID(),
+			IsGroup:        isGroup,
+			GroupName:      groupName,
+			GroupPhoto:     groupPhoto,
+		},
+		members: append([]string{}, userIDs...),
+		roles:   make(map[string]string),
+	}
+	for i, userID := range userIDs {
+		c.roles[userID] = api.RoleMember
+		if isGroup && i == 0 {
+			c.roles[userID] = api.RoleOwner
+		}
+	}
+	db.conversations[c.conversation.ConversationID] = c
+	return c.conversation, nil
+}
+
+// ImportChat is like the SQL implementations: everything is checked before anything is saved, so that a failed import
+// leaves nothing behind.
+func (db *memdb) ImportChat(chat ChatImport) (api.Conversation, []api.User, error) {
+	if err := chat.check(); err != nil {
+		return api.Conversation{}, nil, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for _, userID := range chat.MemberIDs {
+		if _, ok := db.users[userID]; !ok {
+			return api.Conversation{}, nil, fmt.Errorf("user with UserID %s does not exist", userID)
+		}
+		if seen[userID] {
+			return api.Conversation{}, nil, fmt.Errorf("failed to create user-conversation relationship: user %s is already a member", userID)
+		}
+		seen[userID] = true
+	}
+
+	memberIDs := append([]string{}, chat.MemberIDs...)
+	newUsers := make([]api.User, 0, len(chat.NewUsernames))
+	for _, wanted := range chat.NewUsernames {
+		username := wanted
+		for n := 2; db.usernames[username] != ""; n++ {
+			username = fmt.Sprintf("%s-%d", wanted, n)
+		}
+		user, _ := db.addUser(username)
+		newUsers = append(newUsers, user)
+		memberIDs = append(memberIDs, user.UserID)
+	}
+	conversation, _ := db.addConversation(memberIDs, chat.IsGroup, chat.GroupName, "")
+
+	messages := make([]api.Message, len(chat.Messages))
+	for i, message := range chat.Messages {
+		message.SenderID = memberIDs[chat.Senders[i]]
+		messages[i] = message
+	}
+	if _, err := db.addMessages(conversation.ConversationID, messages); err != nil {
+		return api.Conversation{}, nil, err
+	}
+	return conversation, newUsers, nil
+}
+
+func (db *memdb) GetConversationByID(conversationID string) (api.Conversation, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return api.Conversation{}, fmt.Errorf("conversation with ID %q: %w", conversationID, ErrConversationNotFound)
+	}
+	return c.conversation, nil
+}
+
+func (db *memdb) GetAllConversations(page Page) ([]api.Conversation, PageInfo, error) {
+	return db.conversationsPage(page, func(*memConversation) bool { return true })
+}
+
+func (db *memdb) GetAllConversationsByMember(userID string, page Page) ([]api.Conversation, PageInfo, error) {
+	return db.conversationsPage(page, func(c *memConversation) bool {
+		for _, id := range c.members {
+			if id == userID {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (db *memdb) GetConversationsForMember(userID string, includeArchived bool, page Page) ([]api.Conversation, PageInfo, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var keys []string
+	byKey := make(map[string]api.Conversation)
+	for _, c := range db.conversations {
+		if _, member := c.roles[userID]; !member {
+			continue
+		}
+		settings := c.settings[userID]
+		if settings.Archived && !includeArchived {
+			continue
+		}
+		conversation := c.conversation
+		conversation.Settings = &settings
+		key := memberSortKey(conversation)
+		keys = append(keys, key)
+		byKey[key] = conversation
+	}
+	sort.Strings(keys)
+
+	rows, info := memPage(keys, page)
+	conversations := make([]api.Conversation, 0, len(rows))
+	for _, i := range rows {
+		conversations = append(conversations, byKey[keys[i]])
+	}
+	return conversations, info, nil
+}
+
+// conversationsPage returns a page of the conversations selected by the filter, sorted by ID.
+func (db *memdb) conversationsPage(page Page, filter func(*memConversation) bool) ([]api.Conversation, PageInfo, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var keys []string
+	for conversationID, c := range db.conversations {
+		if filter(c) {
+			keys = append(keys, conversationID)
+		}
+	}
+	sort.Strings(keys)
+
+	rows, info := memPage(keys, page)
+	conversations := make([]api.Conversation, 0, len(rows))
+	for _, i := range rows {
+		conversations = append(conversations, db.conversations[keys[i]].conversation)
+	}
+	return conversations, info, nil
+}
+
+func (db *memdb) GetConversationMembers(conversationID string) ([]api.User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return nil, nil
+	}
+	members := make([]api.User, 0, len(c.members))
+	for _, userID := range c.members {
+		members = append(members, db.users[userID].user)
+	}
+	return members, nil
+}
+
+func (db *memdb) GetConversationMemberRoles(conversationID string) ([]api.Member, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return nil, nil
+	}
+	members := make([]api.Member, 0, len(c.members))
+	for _, userID := range c.members {
+		members = append(members, api.Member{User: db.users[userID].user, Role: c.roles[userID]})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].UserID < members[j].UserID })
+	return members, nil
+}
+
+func (db *memdb) UpdateConversation(conversationID, groupName, groupPhoto string) (api.Conversation, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return api.Conversation{}, fmt.Errorf("conversation with ID %q: %w", conversationID, ErrConversationNotFound)
+	}
+	c.conversation.GroupName, c.conversation.GroupPhoto = groupName, groupPhoto
+	return c.conversation, nil
+}
+
+func (db *memdb) AddConversationMembers(conversationID string, userIDs []string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return fmt.Errorf("conversation with ID %q: %w", conversationID, ErrConversationNotFound)
+	}
+
+	seen := make(map[string]bool)
+	for _, userID := range userIDs {
+		if _, ok := db.users[userID]; !ok {
+			return fmt.Errorf("user with UserID %s: %w", userID, ErrUserNotFound)
+		}
+		if _, member := c.roles[userID]; member || seen[userID] {
+			return fmt.Errorf("user %s: %w", userID, ErrAlreadyMember)
+		}
+		seen[userID] = true
+	}
+	for _, userID := range userIDs {
+		c.members = append(c.members, userID)
+		c.roles[userID] = api.RoleMember
+	}
+	return nil
+}
+
+func (db *memdb) RemoveConversationMember(conversationID, userID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, ok := db.conversations[conversationID]
+	if !ok || !c.removeMember(userID) {
+		return fmt.Errorf("user %s in conversation %s: %w", userID, conversationID, ErrNotMember)
+	}
+	if len(c.members) == 0 {
+		delete(db.conversations, conversationID)
+	}
+	return nil
+}
+
+func (db *memdb) SetMemberRole(conversationID, userID, role string) error {
+	if role != api.RoleOwner && role != api.RoleAdmin && role != api.RoleMember {
+		return fmt.Errorf("invalid role %q", role)
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return fmt.Errorf("user %s in conversation %s: %w", userID, conversationID, ErrNotMember)
+	}
+	if _, member := c.roles[userID]; !member {
+		return fmt.Errorf("user %s in conversation %s: %w", userID, conversationID, ErrNotMember)
+	}
+	if role == api.RoleOwner {
+		for id, r := range c.roles {
+			if r == api.RoleOwner && id != userID {
+				c.roles[id] = api.RoleAdmin
+			}
+		}
+	}
+	c.roles[userID] = role
+	return nil
+}
+
+func (db *memdb) GetMemberSettings(conversationID, userID string) (api.ConversationSettings, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return api.ConversationSettings{}, fmt.Errorf("user %s in conversation %s: %w", userID, conversationID, ErrNotMember)
+	}
+	if _, member := c.roles[userID]; !member {
+		return api.ConversationSettings{}, fmt.Errorf("user %s in conversation %s: %w", userID, conversationID, ErrNotMember)
+	}
+	return c.settings[userID], nil
+}
+
+func (db *memdb) SetMemberSettings(conversationID, userID string, settings api.ConversationSettings) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return fmt.Errorf("user %s in conversation %s: %w", userID, conversationID, ErrNotMember)
+	}
+	if _, member := c.roles[userID]; !member {
+		return fmt.Errorf("user %s in conversation %s: %w", userID, conversationID, ErrNotMember)
+	}
+	if settings.MutedUntil != nil {
+		// Stored in UTC, like the SQL implementations
+		t := settings.MutedUntil.UTC()
+		settings.MutedUntil = &t
+	}
+	if c.settings == nil {
+		c.settings = make(map[string]api.ConversationSettings)
+	}
+	c.settings[userID] = settings
+	return nil
+}
+
+func (db *memdb) GetMutedMembers(conversationID string, at time.Time) ([]string, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return nil, nil
+	}
+	var userIDs []string
+	for userID, settings := range c.settings {
+		if settings.Muted(at) {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	sort.Strings(userIDs)
+	return userIDs, nil
+}
+
+// removeMember removes the user from the members, reporting whether they were one. If they were the owner of the
+// group, the ownership passes to the admin with the lowest ID, or to the member with the lowest ID (as promoteOwners).
+func (c *memConversation) removeMember(userID string) bool {
+	role, member := c.roles[userID]
+	if !member {
+		return false
+	}
+	for i, id := range c.members {
+		if id == userID {
+			c.members = append(c.members[:i:i], c.members[i+1:]...)
+			break
+		}
+	}
+	delete(c.roles, userID)
+	delete(c.settings, userID)
+
+	if role == api.RoleOwner && len(c.members) > 0 {
+		candidates := append([]string{}, c.members...)
+		sort.Slice(candidates, func(i, j int) bool {
+			if ai, aj := c.roles[candidates[i]] == api.RoleAdmin, c.roles[candidates[j]] == api.RoleAdmin; ai != aj {
+				return ai
+			}
+			return candidates[i] < candidates[j]
+		})
+		c.roles[candidates[0]] = api.RoleOwner
+	}
+	return true
+}
+
+func (db *memdb) CreateInvite(invite api.Invite) (api.Invite, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, ok := db.conversations[invite.ConversationID]
+	if !ok {
+		return api.Invite{}, fmt.Errorf("conversation with ID %q: %w", invite.ConversationID, ErrConversationNotFound)
+	}
+	if _, ok := db.users[invite.CreatedBy]; invite.CreatedBy != "" && !ok {
+		return api.Invite{}, fmt.Errorf("failed to create invite: user %s does not exist", invite.CreatedBy)
+	}
+
+	invite.Token = db.newID()
+	invite.CreatedAt = invite.CreatedAt.UTC()
+	if invite.ExpiresAt != nil {
+		expiresAt := invite.ExpiresAt.UTC()
+		invite.ExpiresAt = &expiresAt
+	}
+	invite.Uses, invite.RevokedAt = 0, nil
+	c.invites = append(c.invites, &memInvite{invite: invite})
+	return invite, nil
+}
+
+func (db *memdb) GetConversationInvites(conversationID string) ([]api.Invite, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return nil, nil
+	}
+	var invites []api.Invite
+	for _, inv := range c.invites {
+		invites = append(invites, inv.invite)
+	}
+	sort.SliceStable(invites, func(i, j int) bool {
+		if !invites[i].CreatedAt.Equal(invites[j].CreatedAt) {
+			return invites[i].CreatedAt.Before(invites[j].CreatedAt)
+		}
+		return invites[i].Token < invites[j].Token
+	})
+	return invites, nil
+}
+
+func (db *memdb) RevokeInvite(conversationID, token string, revokedAt time.Time) (api.Invite, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	inv, c := db.invite(token)
+	if inv == nil || c.conversation.ConversationID != conversationID {
+		return api.Invite{}, fmt.Errorf("invite %s in conversation %s: %w", token, conversationID, ErrInviteNotFound)
+	}
+	if inv.invite.RevokedAt == nil {
+		revokedAt = revokedAt.UTC()
+		inv.invite.RevokedAt = &revokedAt
+	}
+	return inv.invite, nil
+}
+
+func (db *memdb) JoinWithInvite(token, userID string, joinedAt time.Time) (api.Invite, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	inv, c := db.invite(token)
+	if inv == nil {
+		return api.Invite{}, fmt.Errorf("invite %s: %w", token, ErrInviteNotFound)
+	}
+	if err := inviteError(inv.invite, joinedAt); err != nil {
+		return inv.invite, fmt.Errorf("invite %s: %w", token, err)
+	}
+	if _, ok := db.users[userID]; !ok {
+		return inv.invite, fmt.Errorf("user with UserID %s: %w", userID, ErrUserNotFound)
+	}
+	if _, member := c.roles[userID]; member {
+		return inv.invite, fmt.Errorf("user %s: %w", userID, ErrAlreadyMember)
+	}
+
+	c.members = append(c.members, userID)
+	c.roles[userID] = api.RoleMember
+	inv.invite.Uses++
+	inv.joins = append(inv.joins, api.InviteJoin{Token: token, UserID: userID, JoinedAt: joinedAt.UTC()})
+	return inv.invite, nil
+}
+
+func (db *memdb) GetInviteJoins(conversationID, token string) ([]api.InviteJoin, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	inv, c := db.invite(token)
+	if inv == nil || c.conversation.ConversationID != conversationID {
+		return nil, fmt.Errorf("invite %s in conversation %s: %w", token, conversationID, ErrInviteNotFound)
+	}
+	joins := append([]api.InviteJoin(nil), inv.joins...)
+	sort.SliceStable(joins, func(i, j int) bool {
+		if !joins[i].JoinedAt.Equal(joins[j].JoinedAt) {
+			return joins[i].JoinedAt.Before(joins[j].JoinedAt)
+		}
+		return joins[i].UserID < joins[j].UserID
+	})
+	return joins, nil
+}
+
+// invite returns the invite with the token and its conversation, or nil if there is none. It must be called with the
+// lock held.
+func (db *memdb) invite(token string) (*memInvite, *memConversation) {
+	for _, c := range db.conversations {
+		for _, inv := range c.invites {
+			if inv.invite.Token == token {
+				return inv, c
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (db *memdb) AddPushSubscription(userID string, subscription api.PushSubscription) (api.PushSubscription, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	u, ok := db.users[userID]
+	if !ok {
+		return subscription, fmt.Errorf("user with UserID %s: %w", userID, ErrUserNotFound)
+	}
+
+	for _, other := range db.users {
+		kept := other.push[:0]
+		for _, existing := range other.push {
+			if existing.Endpoint != subscription.Endpoint {
+				kept = append(kept, existing)
+			}
+		}
+		other.push = kept
+	}
+	subscription.SubscriptionID = db.newID()
+	subscription.CreatedAt = subscription.CreatedAt.UTC()
+	u.push = append(u.push, subscription)
+	return subscription, nil
+}
+
+func (db *memdb) GetPushSubscriptions(userID string) ([]api.PushSubscription, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	u, ok := db.users[userID]
+	if !ok || len(u.push) == 0 {
+		return nil, nil
+	}
+	return append([]api.PushSubscription{}, u.push...), nil
+}
+
+func (db *memdb) DeletePushSubscription(userID, subscriptionID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if u, ok := db.users[userID]; ok {
+		for i, subscription := range u.push {
+			if subscription.SubscriptionID == subscriptionID {
+				u.push = append(u.push[:i:i], u.push[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("subscription %s of user %s: %w", subscriptionID, userID, ErrPushSubscriptionNotFound)
+}
+
+func (db *memdb) CreateWebhook(webhook api.Webhook) (api.Webhook, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, ok := db.conversations[webhook.ConversationID]
+	if !ok {
+		return api.Webhook{}, fmt.Errorf("conversation with ID %q: %w", webhook.ConversationID, ErrConversationNotFound)
+	}
+	if _, ok := db.users[webhook.CreatedBy]; webhook.CreatedBy != "" && !ok {
+		return api.Webhook{}, fmt.Errorf("failed to create webhook: user %s does not exist", webhook.CreatedBy)
+	}
+
+	webhook.WebhookID = db.newID()
+	webhook.Secret = db.newID() + db.newID()
+	webhook.CreatedAt = webhook.CreatedAt.UTC()
+	webhook.Events = append([]string{}, webhook.Events...)
+	c.webhooks = append(c.webhooks, &memWebhook{webhook: webhook})
+	return webhook, nil
+}
+
+func (db *memdb) GetConversationWebhooks(conversationID string) ([]api.Webhook, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return nil, nil
+	}
+	var webhooks []api.Webhook
+	for _, w := range c.webhooks {
+		webhooks = append(webhooks, w.webhook)
+	}
+	sort.SliceStable(webhooks, func(i, j int) bool {
+		if !webhooks[i].CreatedAt.Equal(webhooks[j].CreatedAt) {
+			return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+		}
+		return webhooks[i].WebhookID < webhooks[j].WebhookID
+	})
+	return webhooks, nil
+}
+
+func (db *memdb) GetWebhook(webhookID string) (api.Webhook, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	w, _ := db.webhook(webhookID)
+	if w == nil {
+		return api.Webhook{}, fmt.Errorf("webhook %s: %w", webhookID, ErrWebhookNotFound)
+	}
+	return w.webhook, nil
+}
+
+func (db *memdb) DeleteWebhook(conversationID, webhookID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if c, ok := db.conversations[conversationID]; ok {
+		for i, w := range c.webhooks {
+			if w.webhook.WebhookID == webhookID {
+				c.webhooks = append(c.webhooks[:i:i], c.webhooks[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("webhook %s in conversation %s: %w", webhookID, conversationID, ErrWebhookNotFound)
+}
+
+func (db *memdb) EnqueueWebhookEvent(conversationID, event string, data []byte, at time.Time) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return 0, nil
+	}
+	at = at.UTC()
+	queued := 0
+	for _, w := range c.webhooks {
+		if !w.webhook.Subscribed(event) {
+			continue
+		}
+		next := at
+		delivery := api.WebhookDelivery{
+			DeliveryID:    db.newID(),
+			WebhookID:     w.webhook.WebhookID,
+			Event:         event,
+			Data:          append([]byte{}, data...),
+			Status:        api.DeliveryPending,
+			NextAttemptAt: &next,
+			CreatedAt:     at,
+		}
+		key := at.Format(messageTimeLayout) + delivery.DeliveryID
+		i := sort.SearchStrings(w.keys, key)
+		w.keys = append(w.keys[:i:i], append([]string{key}, w.keys[i:]...)...)
+		w.deliveries = append(w.deliveries[:i:i], append([]api.WebhookDelivery{delivery}, w.deliveries[i:]...)...)
+		queued++
+	}
+	return queued, nil
+}
+
+func (db *memdb) GetDueWebhookDeliveries(now time.Time, limit int) ([]api.WebhookDelivery, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var due []api.WebhookDelivery
+	for _, c := range db.conversations {
+		for _, w := range c.webhooks {
+			for _, delivery := range w.deliveries {
+				if delivery.Status == api.DeliveryPending && !delivery.NextAttemptAt.After(now) {
+					due = append(due, delivery)
+				}
+			}
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttemptAt.Equal(*due[j].NextAttemptAt) {
+			return due[i].NextAttemptAt.Before(*due[j].NextAttemptAt)
+		}
+		return due[i].DeliveryID < due[j].DeliveryID
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+func (db *memdb) GetWebhookDelivery(webhookID, deliveryID string) (api.WebhookDelivery, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if w, _ := db.webhook(webhookID); w != nil {
+		for _, delivery := range w.deliveries {
+			if delivery.DeliveryID == deliveryID {
+				return delivery, nil
+			}
+		}
+	}
+	return api.WebhookDelivery{}, fmt.Errorf("delivery %s to webhook %s: %w", deliveryID, webhookID, ErrDeliveryNotFound)
+}
+
+func (db *memdb) GetWebhookDeliveries(webhookID string, page Page) ([]api.WebhookDelivery, PageInfo, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	w, _ := db.webhook(webhookID)
+	if w == nil {
+		return nil, PageInfo{}, nil
+	}
+	rows, info := memPage(w.keys, page)
+	deliveries := make([]api.WebhookDelivery, 0, len(rows))
+	for _, i := range rows {
+		deliveries = append(deliveries, w.deliveries[i])
+	}
+	return deliveries, info, nil
+}
+
+func (db *memdb) UpdateWebhookDelivery(delivery api.WebhookDelivery) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if w, _ := db.webhook(delivery.WebhookID); w != nil {
+		for i := range w.deliveries {
+			saved := &w.deliveries[i]
+			if saved.DeliveryID != delivery.DeliveryID {
+				continue
+			}
+			saved.Status, saved.Attempts = delivery.Status, delivery.Attempts
+			saved.LastStatusCode, saved.LastError = delivery.LastStatusCode, delivery.LastError
+			saved.NextAttemptAt, saved.DeliveredAt = utcTime(delivery.NextAttemptAt), utcTime(delivery.DeliveredAt)
+			return nil
+		}
+	}
+	return fmt.Errorf("delivery %s to webhook %s: %w", delivery.DeliveryID, delivery.WebhookID, ErrDeliveryNotFound)
+}
+
+// webhook returns the webhook with the ID and its conversation, or nil if it does not exist.
+func (db *memdb) webhook(webhookID string) (*memWebhook, *memConversation) {
+	for _, c := range db.conversations {
+		for _, w := range c.webhooks {
+			if w.webhook.WebhookID == webhookID {
+				return w, c
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (db *memdb) CreateBot(username string) (api.User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.usernames[username]; ok {
+		return api.User{}, fmt.Errorf("username %q already exists", username)
+	}
+	user := api.User{UserID: db.newID(), Username: username, IsBot: true}
+	db.users[user.UserID] = &memUser{user: user}
+	db.usernames[username] = user.UserID
+	return user, nil
+}
+
+func (db *memdb) GetBots() ([]api.User, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var bots []api.User
+	for _, u := range db.users {
+		if u.user.IsBot {
+			bots = append(bots, u.user)
+		}
+	}
+	sort.Slice(bots, func(i, j int) bool { return bots[i].UserID < bots[j].UserID })
+	return bots, nil
+}
+
+func (db *memdb) CreateAPIKey(userID string, createdAt time.Time) (api.APIKey, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	u, ok := db.users[userID]
+	if !ok || !u.user.IsBot {
+		return api.APIKey{}, fmt.Errorf("bot with ID %q: %w", userID, ErrUserNotFound)
+	}
+
+	key := api.APIKey{KeyID: db.newID(), UserID: userID, CreatedAt: createdAt.UTC()}
+	secret := api.APIKeyPrefix + db.newID() + db.newID()
+	u.keys = append(u.keys, memAPIKey{key: key, hash: hashAPIKey(secret)})
+	key.Key = secret
+	return key, nil
+}
+
+func (db *memdb) GetAPIKeys(userID string) ([]api.APIKey, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	u, ok := db.users[userID]
+	if !ok || len(u.keys) == 0 {
+		return nil, nil
+	}
+	keys := make([]api.APIKey, 0, len(u.keys))
+	for _, k := range u.keys {
+		keys = append(keys, k.key)
+	}
+	return keys, nil
+}
+
+func (db *memdb) DeleteAPIKey(userID, keyID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if u, ok := db.users[userID]; ok {
+		for i, k := range u.keys {
+			if k.key.KeyID == keyID {
+				u.keys = append(u.keys[:i:i], u.keys[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("API key %s of user %s: %w", keyID, userID, ErrAPIKeyNotFound)
+}
+
+func (db *memdb) GetUserByAPIKey(key string, usedAt time.Time) (api.User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	hash := hashAPIKey(key)
+	for _, u := range db.users {
+		for i := range u.keys {
+			if u.keys[i].hash == hash {
+				u.keys[i].key.LastUsedAt = utcTime(&usedAt)
+				return u.user, nil
+			}
+		}
+	}
+	return api.User{}, ErrAPIKeyNotFound
+}
+
+func (db *memdb) CreateIncomingWebhook(conversationID, userID string, createdAt time.Time) (api.IncomingWebhook, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return api.IncomingWebhook{}, fmt.Errorf("conversation with ID %q: %w", conversationID, ErrConversationNotFound)
+	}
+	if _, ok := db.users[userID]; !ok {
+		return api.IncomingWebhook{}, fmt.Errorf("failed to create incoming webhook: user %s does not exist", userID)
+	}
+
+	webhook := api.IncomingWebhook{
+		Token:          db.newID(),
+		ConversationID: conversationID,
+		UserID:         userID,
+		CreatedAt:      createdAt.UTC(),
+	}
+	c.incoming = append(c.incoming, webhook)
+	return webhook, nil
+}
+
+func (db *memdb) GetIncomingWebhook(token string) (api.IncomingWebhook, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, c := range db.conversations {
+		for _, webhook := range c.incoming {
+			if webhook.Token == token {
+				return webhook, nil
+			}
+		}
+	}
+	return api.IncomingWebhook{}, ErrIncomingWebhookNotFound
+}
+
+func (db *memdb) GetIncomingWebhooks(conversationID, userID string) ([]api.IncomingWebhook, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return nil, nil
+	}
+	var webhooks []api.IncomingWebhook
+	for _, webhook := range c.incoming {
+		if webhook.UserID == userID {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks, nil
+}
+
+func (db *memdb) DeleteIncomingWebhook(conversationID, userID, token string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if c, ok := db.conversations[conversationID]; ok {
+		for i, webhook := range c.incoming {
+			if webhook.UserID == userID && webhook.Token == token {
+				c.incoming = append(c.incoming[:i:i], c.incoming[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("incoming webhook of user %s in conversation %s: %w", userID, conversationID, ErrIncomingWebhookNotFound)
+}
+
+// utcTime returns a copy of the time in UTC, or nil if t is nil.
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+func (db *memdb) AddMessages(conversationID string, messages []api.Message) ([]api.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.addMessages(conversationID, messages)
+}
+
+// addMessages saves the messages of the conversation. It must be called with the write lock held.
+func (db *memdb) addMessages(conversationID string, messages []api.Message) ([]api.Message, error) {
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return nil, fmt.Errorf("conversation with ID %q: %w", conversationID, ErrConversationNotFound)
+	}
+	for _, message := range messages {
+		if _, ok := db.users[message.SenderID]; message.SenderID != "" && !ok {
+			return nil, fmt.Errorf("failed to save message: sender %s does not exist", message.SenderID)
+		}
+		if message.System != nil {
+			if _, ok := db.users[message.System.ActorID]; message.System.ActorID != "" && !ok {
+				return nil, fmt.Errorf("failed to save message: actor %s does not exist", message.System.ActorID)
+			}
+		}
+	}
+
+	saved := make([]api.Message, 0, len(messages))
+	for _, message := range messages {
+		message.MessageID = db.newID()
+		message.ConversationID = conversationID
+		message.CreatedAt = message.CreatedAt.UTC()
+		if message.System != nil {
+			system := *message.System
+			message.System = &system
+		}
+		if message.Poll != nil {
+			poll := newPoll(*message.Poll)
+			message.Poll = &poll
+		}
+		m := memMessage{
+			key:     message.CreatedAt.Format(messageTimeLayout) + message.MessageID,
+			message: message,
+		}
+		c.messages = append(c.messages, m)
+		saved = append(saved, m.view(""))
+	}
+	sort.Slice(c.messages, func(i, j int) bool { return c.messages[i].key < c.messages[j].key })
+	return saved, nil
+}
+
+func (db *memdb) GetConversationMessages(conversationID string, page Page) ([]api.Message, PageInfo, error) {
+	return db.GetConversationMessagesForUser(conversationID, "", page)
+}
+
+func (db *memdb) GetConversationMessagesForUser(conversationID, userID string, page Page) ([]api.Message, PageInfo, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	c, ok := db.conversations[conversationID]
+	if !ok {
+		return nil, PageInfo{}, nil
+	}
+	var keys []string
+	var visible []int
+	for i, m := range c.messages {
+		if !m.hiddenFor[userID] {
+			keys = append(keys, m.key)
+			visible = append(visible, i)
+		}
+	}
+
+	rows, info := memPage(keys, page)
+	messages := make([]api.Message, 0, len(rows))
+	for _, i := range rows {
+		messages = append(messages, c.messages[visible[i]].view(userID))
+	}
+	return messages, info, nil
+}
+
+func (db *memdb) GetMessage(conversationID, messageID string) (api.Message, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	m, err := db.message(conversationID, messageID)
+	if err != nil {
+		return api.Message{}, err
+	}
+	return m.view(""), nil
+}
+
+func (db *memdb) EditMessage(conversationID, messageID, content string, editedAt time.Time) (api.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	m, err := db.message(conversationID, messageID)
+	if err != nil {
+		return api.Message{}, err
+	}
+	if m.message.DeletedAt != nil {
+		return api.Message{}, ErrMessageDeleted
+	}
+
+	written := m.message.CreatedAt
+	if m.message.EditedAt != nil {
+		written = *m.message.EditedAt
+	}
+	editedAt = editedAt.UTC()
+	m.history = append(m.history, api.MessageVersion{
+		Version:    len(m.history) + 1,
+		Content:    m.message.Content,
+		CreatedAt:  written,
+		ReplacedAt: editedAt,
+	})
+	m.message.Content, m.message.EditedAt = content, &editedAt
+	return m.view(""), nil
+}
+
+func (db *memdb) GetMessageHistory(conversationID, messageID string) ([]api.MessageVersion, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	m, err := db.message(conversationID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	return append([]api.MessageVersion(nil), m.history...), nil
+}
+
+func (db *memdb) DeleteMessageForEveryone(conversationID, messageID string, deletedAt time.Time) (api.Message, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	m, err := db.message(conversationID, messageID)
+	if err != nil {
+		return api.Message{}, err
+	}
+	if m.message.DeletedAt != nil {
+		return m.message, nil
+	}
+
+	deletedAt = deletedAt.UTC()
+	m.history, m.votes = nil, nil
+	m.message.Content, m.message.EditedAt, m.message.DeletedAt, m.message.Poll = "", nil, &deletedAt, nil
+	return m.message, nil
+}
+
+func (db *memdb) HideMessage(conversationID, messageID, userID string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	m, err := db.message(conversationID, messageID)
+	if err != nil {
+		return err
+	}
+	if _, ok := db.users[userID]; !ok {
+		return fmt.Errorf("failed to hide message %s: user %s does not exist", messageID, userID)
+	}
+	if m.hiddenFor == nil {
+		m.hiddenFor = make(map[string]bool)
+	}
+	m.hiddenFor[userID] = true
+	return nil
+}
+
+func (db *memdb) GetPoll(conversationID, messageID, userID string) (api.Poll, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	m, err := db.poll(conversationID, messageID)
+	if err != nil {
+		return api.Poll{}, err
+	}
+	return *m.view(userID).Poll, nil
+}
+
+func (db *memdb) Vote(conversationID, messageID, userID string, options []int, votedAt time.Time) (api.Poll, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	m, err := db.poll(conversationID, messageID)
+	if err != nil {
+		return api.Poll{}, err
+	}
+	if err := checkVote(*m.message.Poll, options); err != nil {
+		return api.Poll{}, err
+	}
+	if _, ok := db.users[userID]; !ok {
+		return api.Poll{}, fmt.Errorf("failed to save the vote of user %s: the user does not exist", userID)
+	}
+
+	m.removeVotes(userID)
+	for _, option := range options {
+		m.votes = append(m.votes, memVote{
+			pollVote: pollVote{option: option, userID: userID},
+			votedAt:  votedAt.UTC().Format(messageTimeLayout),
+		})
+	}
+	return *m.view(userID).Poll, nil
+}
+
+func (db *memdb) RetractVote(conversationID, messageID, userID string) (api.Poll, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	m, err := db.poll(conversationID, messageID)
+	if err != nil {
+		return api.Poll{}, err
+	}
+	if m.message.Poll.ClosedAt != nil {
+		return api.Poll{}, ErrPollClosed
+	}
+	m.removeVotes(userID)
+	return *m.view(userID).Poll, nil
+}
+
+func (db *memdb) ClosePoll(conversationID, messageID string, closedAt time.Time) (api.Poll, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	m, err := db.poll(conversationID, messageID)
+	if err != nil {
+		return api.Poll{}, err
+	}
+	if m.message.Poll.ClosedAt == nil {
+		// The stored poll is not shared with the callers, who get tallied copies
+		m.message.Poll.ClosedAt = utcTime(&closedAt)
+	}
+	return *m.view("").Poll, nil
+}
+
+// poll returns the message of the conversation, which must be a poll. It must be called with the lock held.
+func (db *memdb) poll(conversationID, messageID string) (*memMessage, error) {
+	m, err := db.message(conversationID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if m.message.Poll == nil {
+		return nil, fmt.Errorf("message with ID %q: %w", messageID, ErrPollNotFound)
+	}
+	return m, nil
+}
+
+// message returns the message of the conversation. It must be called with the lock held.
+func (db *memdb) message(conversationID, messageID string) (*memMessage, error) {
+	if c, ok := db.conversations[conversationID]; ok {
+		for i := range c.messages {
+			if c.messages[i].message.MessageID == messageID {
+				return &c.messages[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("message with ID %q: %w", messageID, ErrMessageNotFound)
+}
+
+func (db *memdb) Ping() error {
+	return nil
+}
+
+func (db *memdb) Vacuum() error {
+	return nil
+}
+
+func (db *memdb) IntegrityCheck() ([]string, error) {
+	return nil, nil
+}
+
+// Stats returns the number of items of each kind. The size is always zero.
+func (db *memdb) Stats() (Stats, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stats := Stats{SchemaVersion: SchemaVersion(), Users: len(db.users), Conversations: len(db.conversations)}
+	for _, c := range db.conversations {
+		if c.conversation.IsGroup {
+			stats.Groups++
+		}
+		stats.Memberships += len(c.members)
+		stats.Messages += len(c.messages)
+	}
+	return stats, nil
+}
+
+func (db *memdb) Backup(string) error {
+	return fmt.Errorf("backup of an in-memory database: %w", ErrNotSupported)
+}
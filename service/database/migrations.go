@@ -0,0 +1,347 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrations upgrade the SQLite schema one version at a time: migrations[i] upgrades it from version i+1 to version
+// i+2. Version 1 is the schema created by the initialize method of the dialect. Append new migrations at the end, never
+// change the existing ones: databases in the wild may have already applied them. Every migration must be added to
+// postgresMigrations too.
+var migrations = []func(tx *sql.Tx) error{
+	createMessageTable,
+	addUserPrivacy,
+	addMessageEdits,
+	addMessageDeletions,
+	addMemberRoles,
+	addInvites,
+	addSystemMessages,
+	addMemberSettings,
+	addPushSubscriptions,
+	addWebhooks,
+	addBots,
+	addPolls,
+	addSessions,
+}
+
+// SchemaVersion returns the version of the schema created and expected by this package.
+func SchemaVersion() int {
+	return len(migrations) + 1
+}
+
+// CurrentSchemaVersion returns the version of the schema of the database `db`, 0 for an empty database.
+func CurrentSchemaVersion(db *sql.DB) (int, error) {
+	d, err := dialectOf(db)
+	if err != nil {
+		return 0, err
+	}
+	return d.schemaVersion(db)
+}
+
+// Migrate creates the schema, or upgrades it to the latest version. It returns the versions before and after the
+// migration. Databases created by a newer version of this package are refused.
+func Migrate(db *sql.DB) (from int, to int, err error) {
+	d, err := dialectOf(db)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(d.migrations())+1 != SchemaVersion() {
+		return 0, 0, fmt.Errorf("the migrations of %T do not reach schema version %d", d, SchemaVersion())
+	}
+
+	from, err = d.schemaVersion(db)
+	if err != nil {
+		return 0, 0, err
+	}
+	if from > SchemaVersion() {
+		return from, from, fmt.Errorf("schema version %d is newer than the supported version %d", from, SchemaVersion())
+	}
+
+	if from == 0 {
+		if err := d.initialize(db); err != nil {
+			return from, from, err
+		}
+	}
+
+	version := from
+	if version < 1 {
+		version = 1
+	}
+	for ; version < SchemaVersion(); version++ {
+		if err := applyMigration(db, d, version); err != nil {
+			return from, version, err
+		}
+	}
+
+	if err := d.setSchemaVersion(db, version); err != nil {
+		return from, version, err
+	}
+	return from, version, nil
+}
+
+// applyMigration upgrades the schema from `version` to the next one, in a transaction.
+func applyMigration(db *sql.DB, d dialect, version int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := d.migrations()[version-1](tx); err != nil {
+		return fmt.Errorf("migrating schema from version %d to %d: %w", version, version+1, err)
+	}
+	if err := d.setSchemaVersion(tx, version+1); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// createMessageTable adds the messages of the conversations (version 2). Messages of deleted users are kept in groups,
+// without sender. CreatedAt is stored as text in messageTimeLayout, so that it sorts chronologically.
+func createMessageTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE message_table (
+			MessageID TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+			ConversationID TEXT NOT NULL,
+			SenderID TEXT,
+			Content TEXT NOT NULL,
+			CreatedAt TEXT NOT NULL,
+			FOREIGN KEY (ConversationID) REFERENCES conversation_table(ConversationID) ON DELETE CASCADE,
+			FOREIGN KEY (SenderID) REFERENCES user_table(UserID) ON DELETE SET NULL
+		);
+		CREATE INDEX message_conversation_index ON message_table (ConversationID, CreatedAt, MessageID);
+	`)
+	return err
+}
+
+// addUserPrivacy adds the privacy settings of the users (version 3).
+func addUserPrivacy(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE user_table ADD COLUMN HideLastSeen BOOLEAN NOT NULL DEFAULT 0 CHECK (HideLastSeen IN (0, 1))`)
+	return err
+}
+
+// addMessageEdits adds the time of the last edit of the messages, and the previous versions of the edited messages
+// (version 4). Times are stored in messageTimeLayout, like message_table.CreatedAt.
+func addMessageEdits(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		ALTER TABLE message_table ADD COLUMN EditedAt TEXT;
+		CREATE TABLE message_history_table (
+			MessageID TEXT NOT NULL,
+			Version INTEGER NOT NULL,
+			Content TEXT NOT NULL,
+			CreatedAt TEXT NOT NULL,
+			ReplacedAt TEXT NOT NULL,
+			PRIMARY KEY (MessageID, Version),
+			FOREIGN KEY (MessageID) REFERENCES message_table(MessageID) ON DELETE CASCADE
+		);
+	`)
+	return err
+}
+
+// addMessageDeletions adds the time the messages were deleted for everyone, and the messages deleted by each user for
+// themselves (version 5).
+func addMessageDeletions(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		ALTER TABLE message_table ADD COLUMN DeletedAt TEXT;
+		CREATE TABLE message_hidden_table (
+			MessageID TEXT NOT NULL,
+			UserID TEXT NOT NULL,
+			PRIMARY KEY (MessageID, UserID),
+			FOREIGN KEY (MessageID) REFERENCES message_table(MessageID) ON DELETE CASCADE,
+			FOREIGN KEY (UserID) REFERENCES user_table(UserID) ON DELETE CASCADE
+		);
+	`)
+	return err
+}
+
+// addMemberRoles adds the roles of the members (version 6). The join order of the members of existing groups is not
+// known: the owner of each group is its admin, or member, with the lowest ID.
+func addMemberRoles(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		ALTER TABLE user_conversation_table ADD COLUMN Role TEXT NOT NULL DEFAULT 'member' CHECK (Role IN ('owner', 'admin', 'member'));
+		UPDATE user_conversation_table SET Role = 'owner'
+		WHERE ConversationID IN (SELECT ConversationID FROM conversation_table WHERE IsGroup)
+		AND UserID = (
+			SELECT s.UserID FROM user_conversation_table s
+			WHERE s.ConversationID = user_conversation_table.ConversationID
+			ORDER BY s.UserID
+			LIMIT 1
+		);
+	`)
+	return err
+}
+
+// addInvites adds the invites to the groups, and the record of the users who joined with them (version 7).
+func addInvites(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE invite_table (
+			Token TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+			ConversationID TEXT NOT NULL,
+			CreatedBy TEXT,
+			CreatedAt TEXT NOT NULL,
+			ExpiresAt TEXT,
+			MaxUses INTEGER NOT NULL DEFAULT 0 CHECK (MaxUses >= 0),
+			Uses INTEGER NOT NULL DEFAULT 0,
+			RevokedAt TEXT,
+			FOREIGN KEY (ConversationID) REFERENCES conversation_table(ConversationID) ON DELETE CASCADE,
+			FOREIGN KEY (CreatedBy) REFERENCES user_table(UserID) ON DELETE SET NULL
+		);
+		CREATE INDEX invite_conversation_index ON invite_table (ConversationID, CreatedAt);
+		CREATE TABLE invite_join_table (
+			Token TEXT NOT NULL,
+			UserID TEXT NOT NULL,
+			JoinedAt TEXT NOT NULL,
+			PRIMARY KEY (Token, JoinedAt, UserID),
+			FOREIGN KEY (Token) REFERENCES invite_table(Token) ON DELETE CASCADE,
+			FOREIGN KEY (UserID) REFERENCES user_table(UserID) ON DELETE CASCADE
+		);
+	`)
+	return err
+}
+
+// addSystemMessages adds the changes to the groups recorded by the system messages (version 8).
+func addSystemMessages(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		ALTER TABLE message_table ADD COLUMN SystemAction TEXT;
+		ALTER TABLE message_table ADD COLUMN SystemActorID TEXT REFERENCES user_table(UserID) ON DELETE SET NULL;
+		ALTER TABLE message_table ADD COLUMN SystemTarget TEXT;
+	`)
+	return err
+}
+
+// addMemberSettings adds the settings of the members for their conversations (version 9).
+func addMemberSettings(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		ALTER TABLE user_conversation_table ADD COLUMN Pinned BOOLEAN NOT NULL DEFAULT 0 CHECK (Pinned IN (0, 1));
+		ALTER TABLE user_conversation_table ADD COLUMN MutedUntil TEXT;
+		ALTER TABLE user_conversation_table ADD COLUMN Archived BOOLEAN NOT NULL DEFAULT 0 CHECK (Archived IN (0, 1));
+	`)
+	return err
+}
+
+// addPushSubscriptions adds the Web Push subscriptions of the users (version 10).
+func addPushSubscriptions(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE push_subscription_table (
+			SubscriptionID TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+			UserID TEXT NOT NULL,
+			Endpoint TEXT NOT NULL UNIQUE,
+			P256dh TEXT NOT NULL,
+			Auth TEXT NOT NULL,
+			CreatedAt TEXT NOT NULL,
+			FOREIGN KEY (UserID) REFERENCES user_table(UserID) ON DELETE CASCADE
+		);
+		CREATE INDEX push_subscription_user_index ON push_subscription_table (UserID, CreatedAt);
+	`)
+	return err
+}
+
+// addWebhooks adds the webhooks of the conversations, and the queue of their deliveries (version 11).
+func addWebhooks(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE webhook_table (
+			WebhookID TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+			ConversationID TEXT NOT NULL,
+			URL TEXT NOT NULL,
+			Secret TEXT NOT NULL DEFAULT (lower(hex(randomblob(32)))),
+			Events TEXT NOT NULL DEFAULT '',
+			CreatedBy TEXT,
+			CreatedAt TEXT NOT NULL,
+			FOREIGN KEY (ConversationID) REFERENCES conversation_table(ConversationID) ON DELETE CASCADE,
+			FOREIGN KEY (CreatedBy) REFERENCES user_table(UserID) ON DELETE SET NULL
+		);
+		CREATE INDEX webhook_conversation_index ON webhook_table (ConversationID, CreatedAt);
+		CREATE TABLE webhook_delivery_table (
+			DeliveryID TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+			WebhookID TEXT NOT NULL,
+			Event TEXT NOT NULL,
+			Data TEXT NOT NULL,
+			Status TEXT NOT NULL DEFAULT 'pending' CHECK (Status IN ('pending', 'delivered', 'dead')),
+			Attempts INTEGER NOT NULL DEFAULT 0,
+			NextAttemptAt TEXT,
+			LastStatusCode INTEGER NOT NULL DEFAULT 0,
+			LastError TEXT NOT NULL DEFAULT '',
+			CreatedAt TEXT NOT NULL,
+			DeliveredAt TEXT,
+			FOREIGN KEY (WebhookID) REFERENCES webhook_table(WebhookID) ON DELETE CASCADE
+		);
+		CREATE INDEX webhook_delivery_webhook_index ON webhook_delivery_table (WebhookID, CreatedAt);
+		CREATE INDEX webhook_delivery_queue_index ON webhook_delivery_table (Status, NextAttemptAt);
+	`)
+	return err
+}
+
+// addBots adds the bot accounts, their API keys and their incoming webhooks (version 12). Only the SHA-256 hashes of
+// the API keys are stored.
+func addBots(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		ALTER TABLE user_table ADD COLUMN IsBot BOOLEAN NOT NULL DEFAULT 0 CHECK (IsBot IN (0, 1));
+		CREATE TABLE api_key_table (
+			KeyID TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+			UserID TEXT NOT NULL,
+			KeyHash TEXT NOT NULL UNIQUE,
+			CreatedAt TEXT NOT NULL,
+			LastUsedAt TEXT,
+			FOREIGN KEY (UserID) REFERENCES user_table(UserID) ON DELETE CASCADE
+		);
+		CREATE INDEX api_key_user_index ON api_key_table (UserID, CreatedAt);
+		CREATE TABLE incoming_webhook_table (
+			Token TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+			ConversationID TEXT NOT NULL,
+			UserID TEXT NOT NULL,
+			CreatedAt TEXT NOT NULL,
+			FOREIGN KEY (ConversationID) REFERENCES conversation_table(ConversationID) ON DELETE CASCADE,
+			FOREIGN KEY (UserID) REFERENCES user_table(UserID) ON DELETE CASCADE
+		);
+		CREATE INDEX incoming_webhook_conversation_index ON incoming_webhook_table (ConversationID, CreatedAt);
+	`)
+	return err
+}
+
+// addPolls adds the polls of the messages of the poll type, their options and the votes of the members (version 13).
+// Options are identified by their position in the poll, from 0.
+func addPolls(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE poll_table (
+			MessageID TEXT PRIMARY KEY,
+			Question TEXT NOT NULL,
+			MultipleChoice BOOLEAN NOT NULL DEFAULT 0 CHECK (MultipleChoice IN (0, 1)),
+			Anonymous BOOLEAN NOT NULL DEFAULT 0 CHECK (Anonymous IN (0, 1)),
+			ClosedAt TEXT,
+			FOREIGN KEY (MessageID) REFERENCES message_table(MessageID) ON DELETE CASCADE
+		);
+		CREATE TABLE poll_option_table (
+			MessageID TEXT NOT NULL,
+			Position INTEGER NOT NULL,
+			Text TEXT NOT NULL,
+			PRIMARY KEY (MessageID, Position),
+			FOREIGN KEY (MessageID) REFERENCES poll_table(MessageID) ON DELETE CASCADE
+		);
+		CREATE TABLE poll_vote_table (
+			MessageID TEXT NOT NULL,
+			Position INTEGER NOT NULL,
+			UserID TEXT NOT NULL,
+			VotedAt TEXT NOT NULL,
+			PRIMARY KEY (MessageID, Position, UserID),
+			FOREIGN KEY (MessageID, Position) REFERENCES poll_option_table(MessageID, Position) ON DELETE CASCADE,
+			FOREIGN KEY (UserID) REFERENCES user_table(UserID) ON DELETE CASCADE
+		);
+	`)
+	return err
+}
+
+// addSessions adds the sessions of the users (version 14). Like the API keys, only the SHA-256 hashes of the tokens
+// are stored.
+func addSessions(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE session_table (
+			TokenHash TEXT PRIMARY KEY,
+			UserID TEXT NOT NULL,
+			CreatedAt TEXT NOT NULL,
+			FOREIGN KEY (UserID) REFERENCES user_table(UserID) ON DELETE CASCADE
+		);
+		CREATE INDEX session_user_index ON session_table (UserID);
+	`)
+	return err
+}
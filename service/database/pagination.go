@@ -0,0 +1,91 @@
+package database
+
+import "fmt"
+
+// DefaultPageLimit is the number of items returned when Page.Limit is not set
+const DefaultPageLimit = 50
+
+// MaxPageLimit is the maximum number of items that can be requested in a single page
+const MaxPageLimit = 500
+
+// Page selects a window of a list. Lists are sorted by a stable and unique key (usually the primary key), and pages are
+// selected relative to the key of an item: After returns the items following it, Before the items preceding it.
+// At most one between After and Before can be set; when both are empty, the first page is returned.
+type Page struct {
+	Limit  int
+	After  string
+	Before string
+}
+
+// PageInfo describes where the returned page is in the list. NextKey is the key to be used as Page.After to get the
+// following page, PrevKey is the key to be used as Page.Before to get the preceding page. They are empty when there are
+// no more items in that direction.
+type PageInfo struct {
+	NextKey string
+	PrevKey string
+}
+
+// limit returns the number of items to return, applying the default and the maximum.
+func (p Page) limit() int {
+	if p.Limit <= 0 {
+		return DefaultPageLimit
+	}
+	if p.Limit > MaxPageLimit {
+		return MaxPageLimit
+	}
+	return p.Limit
+}
+
+// keysetClause returns the WHERE condition, the ORDER BY clause and the LIMIT for the page, using `column` as the sort
+// key. The items are fetched in reverse order when going backward, and one more item than needed is fetched to know
+// whether there are more: use pageResult to build the final page.
+func (p Page) keysetClause(column string) (where string, orderLimit string, args []interface{}) {
+	switch {
+	case p.Before != "":
+		where = fmt.Sprintf("%s < ?", column)
+		args = append(args, p.Before)
+		orderLimit = fmt.Sprintf("ORDER BY %s DESC LIMIT %d", column, p.limit()+1)
+	case p.After != "":
+		where = fmt.Sprintf("%s > ?", column)
+		args = append(args, p.After)
+		orderLimit = fmt.Sprintf("ORDER BY %s ASC LIMIT %d", column, p.limit()+1)
+	default:
+		where = "1 = 1"
+		orderLimit = fmt.Sprintf("ORDER BY %s ASC LIMIT %d", column, p.limit()+1)
+	}
+	return where, orderLimit, args
+}
+
+// pageResult trims the rows fetched with keysetClause to the page size, restores the ascending order and computes the
+// PageInfo. `key` returns the sort key of the item at index i of the slice.
+func (p Page) pageResult(n int, swap func(i, j int), key func(i int) string) (size int, info PageInfo) {
+	more := n > p.limit()
+	size = n
+	if more {
+		size = p.limit()
+	}
+
+	if p.Before != "" {
+		// Rows were fetched in descending order
+		for i, j := 0, size-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+		if size > 0 {
+			info.NextKey = key(size - 1)
+			if more {
+				info.PrevKey = key(0)
+			}
+		}
+		return size, info
+	}
+
+	if size > 0 {
+		if more {
+			info.NextKey = key(size - 1)
+		}
+		if p.After != "" {
+			info.PrevKey = key(0)
+		}
+	}
+	return size, info
+}
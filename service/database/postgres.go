@@ -0,0 +1,302 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// postgresDialect is the dialect of PostgreSQL (version 13 or later, for gen_random_uuid). The schema version is kept
+// in the schema_version table. The schema mirrors the SQLite one: IDs are 32 hexadecimal digits, and the columns used
+// as sort keys use the "C" collation, so that they sort the same way as in SQLite.
+type postgresDialect struct{}
+
+func (postgresDialect) rebind(query string) string {
+	return rebindNumbered(query)
+}
+
+func (postgresDialect) isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func (postgresDialect) checkConnection(db *sql.DB) error {
+	return db.Ping()
+}
+
+func (postgresDialect) schemaVersion(db *sql.DB) (int, error) {
+	var exists bool
+	if err := db.QueryRow(`SELECT to_regclass('schema_version') IS NOT NULL`).Scan(&exists); err != nil {
+		return 0, fmt.Errorf("checking for the schema version: %w", err)
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	var version int
+	err := db.QueryRow("SELECT Version FROM schema_version").Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	return version, nil
+}
+
+func (postgresDialect) setSchemaVersion(db execer, version int) error {
+	if _, err := db.Exec("UPDATE schema_version SET Version = $1", version); err != nil {
+		return fmt.Errorf("saving schema version %d: %w", version, err)
+	}
+	return nil
+}
+
+func (postgresDialect) initialize(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE schema_version (
+			Version INTEGER NOT NULL
+		);
+		INSERT INTO schema_version (Version) VALUES (1);
+
+		CREATE TABLE user_table (
+			UserID TEXT COLLATE "C" PRIMARY KEY DEFAULT (replace(gen_random_uuid()::text, '-', '')),
+			Username TEXT NOT NULL UNIQUE,
+			Photo TEXT
+		);
+
+		CREATE TABLE conversation_table (
+			ConversationID TEXT COLLATE "C" PRIMARY KEY DEFAULT (replace(gen_random_uuid()::text, '-', '')),
+			IsGroup BOOLEAN NOT NULL,
+			GroupName TEXT,
+			GroupPhoto TEXT
+		);
+
+		CREATE TABLE user_conversation_table (
+			UserID TEXT COLLATE "C" NOT NULL REFERENCES user_table(UserID) ON DELETE CASCADE,
+			ConversationID TEXT COLLATE "C" NOT NULL REFERENCES conversation_table(ConversationID) ON DELETE CASCADE,
+			PRIMARY KEY (UserID, ConversationID)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("creating the schema: %w", err)
+	}
+	return nil
+}
+
+// postgresMigrations are the PostgreSQL version of `migrations`.
+var postgresMigrations = []func(tx *sql.Tx) error{
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE message_table (
+				MessageID TEXT COLLATE "C" PRIMARY KEY DEFAULT (replace(gen_random_uuid()::text, '-', '')),
+				ConversationID TEXT COLLATE "C" NOT NULL REFERENCES conversation_table(ConversationID) ON DELETE CASCADE,
+				SenderID TEXT COLLATE "C" REFERENCES user_table(UserID) ON DELETE SET NULL,
+				Content TEXT NOT NULL,
+				CreatedAt TEXT COLLATE "C" NOT NULL
+			);
+			CREATE INDEX message_conversation_index ON message_table (ConversationID, CreatedAt, MessageID);
+		`)
+		return err
+	},
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`ALTER TABLE user_table ADD COLUMN HideLastSeen BOOLEAN NOT NULL DEFAULT FALSE`)
+		return err
+	},
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			ALTER TABLE message_table ADD COLUMN EditedAt TEXT COLLATE "C";
+			CREATE TABLE message_history_table (
+				MessageID TEXT COLLATE "C" NOT NULL REFERENCES message_table(MessageID) ON DELETE CASCADE,
+				Version INTEGER NOT NULL,
+				Content TEXT NOT NULL,
+				CreatedAt TEXT COLLATE "C" NOT NULL,
+				ReplacedAt TEXT COLLATE "C" NOT NULL,
+				PRIMARY KEY (MessageID, Version)
+			);
+		`)
+		return err
+	},
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			ALTER TABLE message_table ADD COLUMN DeletedAt TEXT COLLATE "C";
+			CREATE TABLE message_hidden_table (
+				MessageID TEXT COLLATE "C" NOT NULL REFERENCES message_table(MessageID) ON DELETE CASCADE,
+				UserID TEXT COLLATE "C" NOT NULL REFERENCES user_table(UserID) ON DELETE CASCADE,
+				PRIMARY KEY (MessageID, UserID)
+			);
+		`)
+		return err
+	},
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			ALTER TABLE user_conversation_table ADD COLUMN Role TEXT NOT NULL DEFAULT 'member' CHECK (Role IN ('owner', 'admin', 'member'));
+			UPDATE user_conversation_table SET Role = 'owner'
+			WHERE ConversationID IN (SELECT ConversationID FROM conversation_table WHERE IsGroup)
+			AND UserID = (
+				SELECT s.UserID FROM user_conversation_table s
+				WHERE s.ConversationID = user_conversation_table.ConversationID
+				ORDER BY s.UserID
+				LIMIT 1
+			);
+		`)
+		return err
+	},
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE invite_table (
+				Token TEXT COLLATE "C" PRIMARY KEY DEFAULT (replace(gen_random_uuid()::text, '-', '')),
+				ConversationID TEXT COLLATE "C" NOT NULL REFERENCES conversation_table(ConversationID) ON DELETE CASCADE,
+				CreatedBy TEXT COLLATE "C" REFERENCES user_table(UserID) ON DELETE SET NULL,
+				CreatedAt TEXT COLLATE "C" NOT NULL,
+				ExpiresAt TEXT COLLATE "C",
+				MaxUses INTEGER NOT NULL DEFAULT 0 CHECK (MaxUses >= 0),
+				Uses INTEGER NOT NULL DEFAULT 0,
+				RevokedAt TEXT COLLATE "C"
+			);
+			CREATE INDEX invite_conversation_index ON invite_table (ConversationID, CreatedAt);
+			CREATE TABLE invite_join_table (
+				Token TEXT COLLATE "C" NOT NULL REFERENCES invite_table(Token) ON DELETE CASCADE,
+				UserID TEXT COLLATE "C" NOT NULL REFERENCES user_table(UserID) ON DELETE CASCADE,
+				JoinedAt TEXT COLLATE "C" NOT NULL,
+				PRIMARY KEY (Token, JoinedAt, UserID)
+			);
+		`)
+		return err
+	},
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			ALTER TABLE message_table ADD COLUMN SystemAction TEXT;
+			ALTER TABLE message_table ADD COLUMN SystemActorID TEXT COLLATE "C" REFERENCES user_table(UserID) ON DELETE SET NULL;
+			ALTER TABLE message_table ADD COLUMN SystemTarget TEXT;
+		`)
+		return err
+	},
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			ALTER TABLE user_conversation_table ADD COLUMN Pinned BOOLEAN NOT NULL DEFAULT FALSE;
+			ALTER TABLE user_conversation_table ADD COLUMN MutedUntil TEXT COLLATE "C";
+			ALTER TABLE user_conversation_table ADD COLUMN Archived BOOLEAN NOT NULL DEFAULT FALSE;
+		`)
+		return err
+	},
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE push_subscription_table (
+				SubscriptionID TEXT COLLATE "C" PRIMARY KEY DEFAULT (replace(gen_random_uuid()::text, '-', '')),
+				UserID TEXT COLLATE "C" NOT NULL REFERENCES user_table(UserID) ON DELETE CASCADE,
+				Endpoint TEXT NOT NULL UNIQUE,
+				P256dh TEXT NOT NULL,
+				Auth TEXT NOT NULL,
+				CreatedAt TEXT COLLATE "C" NOT NULL
+			);
+			CREATE INDEX push_subscription_user_index ON push_subscription_table (UserID, CreatedAt);
+		`)
+		return err
+	},
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE webhook_table (
+				WebhookID TEXT COLLATE "C" PRIMARY KEY DEFAULT (replace(gen_random_uuid()::text, '-', '')),
+				ConversationID TEXT COLLATE "C" NOT NULL REFERENCES conversation_table(ConversationID) ON DELETE CASCADE,
+				URL TEXT NOT NULL,
+				Secret TEXT NOT NULL DEFAULT (replace(gen_random_uuid()::text, '-', '') || replace(gen_random_uuid()::text, '-', '')),
+				Events TEXT NOT NULL DEFAULT '',
+				CreatedBy TEXT COLLATE "C" REFERENCES user_table(UserID) ON DELETE SET NULL,
+				CreatedAt TEXT COLLATE "C" NOT NULL
+			);
+			CREATE INDEX webhook_conversation_index ON webhook_table (ConversationID, CreatedAt);
+			CREATE TABLE webhook_delivery_table (
+				DeliveryID TEXT COLLATE "C" PRIMARY KEY DEFAULT (replace(gen_random_uuid()::text, '-', '')),
+				WebhookID TEXT COLLATE "C" NOT NULL REFERENCES webhook_table(WebhookID) ON DELETE CASCADE,
+				Event TEXT NOT NULL,
+				Data TEXT NOT NULL,
+				Status TEXT NOT NULL DEFAULT 'pending' CHECK (Status IN ('pending', 'delivered', 'dead')),
+				Attempts INTEGER NOT NULL DEFAULT 0,
+				NextAttemptAt TEXT COLLATE "C",
+				LastStatusCode INTEGER NOT NULL DEFAULT 0,
+				LastError TEXT NOT NULL DEFAULT '',
+				CreatedAt TEXT COLLATE "C" NOT NULL,
+				DeliveredAt TEXT COLLATE "C"
+			);
+			CREATE INDEX webhook_delivery_webhook_index ON webhook_delivery_table (WebhookID, CreatedAt);
+			CREATE INDEX webhook_delivery_queue_index ON webhook_delivery_table (Status, NextAttemptAt);
+		`)
+		return err
+	},
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			ALTER TABLE user_table ADD COLUMN IsBot BOOLEAN NOT NULL DEFAULT FALSE;
+			CREATE TABLE api_key_table (
+				KeyID TEXT COLLATE "C" PRIMARY KEY DEFAULT (replace(gen_random_uuid()::text, '-', '')),
+				UserID TEXT COLLATE "C" NOT NULL REFERENCES user_table(UserID) ON DELETE CASCADE,
+				KeyHash TEXT NOT NULL UNIQUE,
+				CreatedAt TEXT COLLATE "C" NOT NULL,
+				LastUsedAt TEXT COLLATE "C"
+			);
+			CREATE INDEX api_key_user_index ON api_key_table (UserID, CreatedAt);
+			CREATE TABLE incoming_webhook_table (
+				Token TEXT COLLATE "C" PRIMARY KEY DEFAULT (replace(gen_random_uuid()::text, '-', '')),
+				ConversationID TEXT COLLATE "C" NOT NULL REFERENCES conversation_table(ConversationID) ON DELETE CASCADE,
+				UserID TEXT COLLATE "C" NOT NULL REFERENCES user_table(UserID) ON DELETE CASCADE,
+				CreatedAt TEXT COLLATE "C" NOT NULL
+			);
+			CREATE INDEX incoming_webhook_conversation_index ON incoming_webhook_table (ConversationID, CreatedAt);
+		`)
+		return err
+	},
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE poll_table (
+				MessageID TEXT COLLATE "C" PRIMARY KEY REFERENCES message_table(MessageID) ON DELETE CASCADE,
+				Question TEXT NOT NULL,
+				MultipleChoice BOOLEAN NOT NULL DEFAULT FALSE,
+				Anonymous BOOLEAN NOT NULL DEFAULT FALSE,
+				ClosedAt TEXT COLLATE "C"
+			);
+			CREATE TABLE poll_option_table (
+				MessageID TEXT COLLATE "C" NOT NULL REFERENCES poll_table(MessageID) ON DELETE CASCADE,
+				Position INTEGER NOT NULL,
+				Text TEXT NOT NULL,
+				PRIMARY KEY (MessageID, Position)
+			);
+			CREATE TABLE poll_vote_table (
+				MessageID TEXT COLLATE "C" NOT NULL,
+				Position INTEGER NOT NULL,
+				UserID TEXT COLLATE "C" NOT NULL REFERENCES user_table(UserID) ON DELETE CASCADE,
+				VotedAt TEXT COLLATE "C" NOT NULL,
+				PRIMARY KEY (MessageID, Position, UserID),
+				FOREIGN KEY (MessageID, Position) REFERENCES poll_option_table(MessageID, Position) ON DELETE CASCADE
+			);
+		`)
+		return err
+	},
+	func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE session_table (
+				TokenHash TEXT COLLATE "C" PRIMARY KEY,
+				UserID TEXT COLLATE "C" NOT NULL REFERENCES user_table(UserID) ON DELETE CASCADE,
+				CreatedAt TEXT COLLATE "C" NOT NULL
+			);
+			CREATE INDEX session_user_index ON session_table (UserID);
+		`)
+		return err
+	},
+}
+
+func (postgresDialect) migrations() []func(tx *sql.Tx) error {
+	return postgresMigrations
+}
+
+func (postgresDialect) size(db *sql.DB) (int64, error) {
+	var size int64
+	if err := db.QueryRow("SELECT pg_database_size(current_database())").Scan(&size); err != nil {
+		return 0, fmt.Errorf("reading database size: %w", err)
+	}
+	return size, nil
+}
+
+// integrityCheck finds nothing: PostgreSQL enforces the foreign keys itself, and checks its pages on its own.
+func (postgresDialect) integrityCheck(db *sql.DB) ([]string, error) {
+	return nil, db.Ping()
+}
@@ -0,0 +1,197 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+)
+
+// sqliteDialect is the dialect of SQLite. The schema version is kept in `PRAGMA user_version`.
+type sqliteDialect struct{}
+
+// SQLiteDSN returns the data source name for the SQLite file, enabling foreign keys on every connection of the pool.
+func SQLiteDSN(filename string) string {
+	if strings.Contains(filename, "?") {
+		return filename + "&_foreign_keys=on"
+	}
+	return filename + "?_foreign_keys=on"
+}
+
+func (sqliteDialect) rebind(query string) string {
+	return query
+}
+
+func (sqliteDialect) isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint
+}
+
+func (sqliteDialect) checkConnection(db *sql.DB) error {
+	var foreignKeys bool
+	if err := db.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+		return fmt.Errorf("checking foreign keys support: %w", err)
+	}
+	if !foreignKeys {
+		return errors.New("foreign keys are disabled, open the database with _foreign_keys=on")
+	}
+	return nil
+}
+
+func (sqliteDialect) schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	if version == 0 {
+		// Databases created before schema versioning have the tables but no version
+		var tables int
+		err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='user_table'`).Scan(&tables)
+		if err != nil {
+			return 0, fmt.Errorf("checking for existing tables: %w", err)
+		}
+		if tables > 0 {
+			version = 1
+		}
+	}
+	return version, nil
+}
+
+func (sqliteDialect) setSchemaVersion(db execer, version int) error {
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+		return fmt.Errorf("saving schema version %d: %w", version, err)
+	}
+	return nil
+}
+
+func (sqliteDialect) migrations() []func(tx *sql.Tx) error {
+	return migrations
+}
+
+func (sqliteDialect) size(db *sql.DB) (int64, error) {
+	var pageCount, pageSize int64
+	if err := db.QueryRow("PRAGMA page_count").Scan(&pageCount); err != nil {
+		return 0, fmt.Errorf("reading page count: %w", err)
+	}
+	if err := db.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, fmt.Errorf("reading page size: %w", err)
+	}
+	return pageCount * pageSize, nil
+}
+
+// integrityCheck runs the SQLite integrity check and the foreign key check.
+func (sqliteDialect) integrityCheck(db *sql.DB) ([]string, error) {
+	var problems []string
+
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, fmt.Errorf("integrity check: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return nil, fmt.Errorf("integrity check: %w", err)
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("integrity check: %w", err)
+	}
+
+	fkRows, err := db.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return nil, fmt.Errorf("foreign key check: %w", err)
+	}
+	defer fkRows.Close()
+	for fkRows.Next() {
+		var table, parent string
+		var rowid, fkid interface{}
+		if err := fkRows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return nil, fmt.Errorf("foreign key check: %w", err)
+		}
+		problems = append(problems, fmt.Sprintf("row %v of %s references a missing row of %s", rowid, table, parent))
+	}
+	if err := fkRows.Err(); err != nil {
+		return nil, fmt.Errorf("foreign key check: %w", err)
+	}
+
+	return problems, nil
+}
+
+func (sqliteDialect) initialize(db *sql.DB) error {
+	// User table schema
+	user_table_schema := `
+		CREATE TABLE user_table (
+			UserID TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))),
+			Username TEXT NOT NULL UNIQUE,
+			Photo TEXT
+		);
+	`
+
+	// Conversation table schema
+	conversation_table_schema := `
+		CREATE TABLE conversation_table (
+			ConversationID TEXT PRIMARY KEY DEFAULT (lower(hex(randomblob(16)))), -- Generate a UUID
+			IsGroup BOOLEAN NOT NULL CHECK (IsGroup IN (0, 1)), -- Boolean value for group indicator
+			GroupName TEXT, -- Optional group name
+			GroupPhoto TEXT -- Optional group photo
+		);
+	`
+
+	user_conversation_table_schema := `
+		CREATE TABLE user_conversation_table (
+			UserID TEXT NOT NULL,                       -- User ID
+			ConversationID TEXT NOT NULL,               -- Conversation ID
+			PRIMARY KEY (UserID, ConversationID),       -- Composite primary key
+			FOREIGN KEY (UserID) REFERENCES user_table(UserID) ON DELETE CASCADE,  -- Link to user_table
+			FOREIGN KEY (ConversationID) REFERENCES conversation_table(ConversationID) ON DELETE CASCADE -- Link to conversation_table
+		);
+	`
+
+	// Initialize TABLES
+	if err := ensureTableExists(db, "user_table", user_table_schema); err != nil {
+		return err
+	}
+
+	if err := ensureTableExists(db, "conversation_table", conversation_table_schema); err != nil {
+		return err
+	}
+
+	if err := ensureTableExists(db, "user_conversation_table", user_conversation_table_schema); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func ensureTableExists(db *sql.DB, tableName, createStmt string) error {
+
+	// Init logging
+	logger := logrus.New()
+	logger.SetOutput(os.Stdout)
+	logger.SetLevel(logrus.DebugLevel)
+	// logger.SetLevel(logrus.InfoLevel)
+
+	logger.Infof("ensureTableExists %s", tableName)
+
+	var existingTable string
+	err := db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name=?;`, tableName).Scan(&existingTable)
+	if errors.Is(err, sql.ErrNoRows) {
+		logger.Infof("do not exists %s", tableName)
+		_, err := db.Exec(createStmt)
+		logger.Infof("ddl command result %v", err)
+		if err != nil {
+			return fmt.Errorf("error creating table %s: %w", tableName, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("error checking for table %s: %w", tableName, err)
+	}
+	return nil
+}
@@ -0,0 +1,470 @@
+/*
+Package export builds the account data export ("takeout") of a user: a ZIP archive with everything the user can see in
+AlChats, both as JSON (for machines) and as HTML (for humans).
+
+The archive is written while the data is read from the database, page by page, so it is never buffered in memory:
+Write can send it directly to an http.ResponseWriter.
+
+The archive contains:
+
+	profile.json             the user profile
+	conversations.json       the conversations of the user, with their members
+	messages/<id>.json       the messages of each conversation that the user can see, oldest first
+	transcripts/<id>.html    a human-readable transcript of each conversation
+	photos.json              the photos set by the user: their profile photo and the group photos they changed
+	photos/<name>            the photos above that are stored in AlChats (data URLs), as image files
+	index.html               a human-readable overview of the above
+
+Photos set as links to other sites are only listed in photos.json: AlChats does not store them.
+*/
+package export
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"AlChats/service/globaltime"
+	"archive/zip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Photo is a photo set by the user, as listed in photos.json
+type Photo struct {
+	// Source is "profile" for the profile photo, or "group" for the photo of a group
+	Source         string     `json:"source"`
+	ConversationID string     `json:"conversationId,omitempty"`
+	SetAt          *time.Time `json:"setAt,omitempty"`
+
+	// URL is the photo as it was set, unless it is a data URL, which is written to File instead
+	URL  string `json:"url,omitempty"`
+	File string `json:"file,omitempty"`
+}
+
+// photoExtensions are the file extensions of the image types of the data URLs
+var photoExtensions = map[string]string{
+	"image/png":     ".png",
+	"image/jpeg":    ".jpg",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/svg+xml": ".svg",
+}
+
+// Write writes the export archive of `user` to w.
+func Write(w io.Writer, db database.AppDatabase, user models.User) error {
+	zw := zip.NewWriter(w)
+	modified := globaltime.Now()
+
+	if err := writeJSON(zw, "profile.json", modified, user); err != nil {
+		return err
+	}
+	if err := writeConversations(zw, db, user, modified); err != nil {
+		return err
+	}
+	photos, err := writeMessages(zw, db, user, modified)
+	if err != nil {
+		return err
+	}
+	if photos, err = writePhotos(zw, user, photos, modified); err != nil {
+		return err
+	}
+	if err := writeIndex(zw, db, user, photos, modified); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// create adds a new file to the archive.
+func create(zw *zip.Writer, name string, modified time.Time) (io.Writer, error) {
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return nil, fmt.Errorf("adding %s to the archive: %w", name, err)
+	}
+	return f, nil
+}
+
+// writeJSON adds a file with the indented JSON of v to the archive.
+func writeJSON(zw *zip.Writer, name string, modified time.Time, v interface{}) error {
+	f, err := create(zw, name, modified)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	return nil
+}
+
+// forEachConversation calls fn for every conversation of the user, with its members, reading them page by page.
+func forEachConversation(db database.AppDatabase, userID string, fn func(models.ConversationDetails) error) error {
+	var page = database.Page{Limit: database.MaxPageLimit}
+	for {
+		conversations, info, err := db.GetAllConversationsByMember(userID, page)
+		if err != nil {
+			return err
+		}
+		for _, conversation := range conversations {
+			members, err := db.GetConversationMemberRoles(conversation.ConversationID)
+			if err != nil {
+				return err
+			}
+			if err := fn(models.ConversationDetails{Conversation: conversation, Members: members}); err != nil {
+				return err
+			}
+		}
+		if info.NextKey == "" {
+			return nil
+		}
+		page.After = info.NextKey
+	}
+}
+
+// writeJSONArray writes a JSON array to f, with the items that `each` emits one at a time.
+func writeJSONArray(f io.Writer, each func(emit func(v interface{}) error) error) error {
+	if _, err := io.WriteString(f, "["); err != nil {
+		return err
+	}
+	var first = true
+	err := each(func(v interface{}) error {
+		sep := ",\n  "
+		if first {
+			sep, first = "\n  ", false
+		}
+		buf, err := json.MarshalIndent(v, "  ", "  ")
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, sep); err != nil {
+			return err
+		}
+		_, err = f.Write(buf)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if !first {
+		if _, err := io.WriteString(f, "\n"); err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(f, "]\n")
+	return err
+}
+
+// writeConversations adds conversations.json to the archive, encoding one conversation at a time.
+func writeConversations(zw *zip.Writer, db database.AppDatabase, user models.User, modified time.Time) error {
+	f, err := create(zw, "conversations.json", modified)
+	if err != nil {
+		return err
+	}
+
+	err = writeJSONArray(f, func(emit func(v interface{}) error) error {
+		return forEachConversation(db, user.UserID, func(details models.ConversationDetails) error {
+			return emit(details)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("writing conversations.json: %w", err)
+	}
+	return nil
+}
+
+// forEachMessage calls fn for every message of the conversation that the user can see, oldest first, reading them
+// page by page.
+func forEachMessage(db database.AppDatabase, conversationID, userID string, fn func(models.Message) error) error {
+	var page = database.Page{Limit: database.MaxPageLimit}
+	for {
+		messages, info, err := db.GetConversationMessagesForUser(conversationID, userID, page)
+		if err != nil {
+			return err
+		}
+		for _, message := range messages {
+			if err := fn(message); err != nil {
+				return err
+			}
+		}
+		if info.NextKey == "" {
+			return nil
+		}
+		page.After = info.NextKey
+	}
+}
+
+// writeMessages adds the messages and the transcript of each conversation to the archive, and returns the group
+// photos changed by the user, found on the way.
+func writeMessages(zw *zip.Writer, db database.AppDatabase, user models.User, modified time.Time) ([]Photo, error) {
+	var photos []Photo
+	err := forEachConversation(db, user.UserID, func(details models.ConversationDetails) error {
+		name := "messages/" + details.ConversationID + ".json"
+		f, err := create(zw, name, modified)
+		if err != nil {
+			return err
+		}
+		err = writeJSONArray(f, func(emit func(v interface{}) error) error {
+			return forEachMessage(db, details.ConversationID, user.UserID, func(message models.Message) error {
+				if system := message.System; system != nil && system.Action == models.SystemPhotoChanged &&
+					system.ActorID == user.UserID && system.Target != "" {
+					setAt := message.CreatedAt
+					photos = append(photos, Photo{
+						Source: "group", ConversationID: details.ConversationID, SetAt: &setAt, URL: system.Target,
+					})
+				}
+				return emit(message)
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+
+		return writeTranscript(zw, db, user, details, modified)
+	})
+	return photos, err
+}
+
+// decodeDataURL returns the type and the content of a base64 data URL (e.g., `data:image/png;base64,...`).
+func decodeDataURL(photo string) (mediaType string, data []byte, err error) {
+	if !strings.HasPrefix(photo, "data:") {
+		return "", nil, errors.New("not a data URL")
+	}
+	i := strings.Index(photo, ",")
+	if i < 0 {
+		return "", nil, errors.New("malformed data URL")
+	}
+	params, payload := photo[len("data:"):i], photo[i+1:]
+	if !strings.HasSuffix(params, ";base64") {
+		decoded, err := url.PathUnescape(payload)
+		return strings.Split(params, ";")[0], []byte(decoded), err
+	}
+	data, err = base64.StdEncoding.DecodeString(payload)
+	return strings.TrimSuffix(params, ";base64"), data, err
+}
+
+// writePhotos adds the photos set by the user to the archive: the files of the ones stored as data URLs, and the list
+// of all of them in photos.json. It returns the list: the profile photo first, then `groupPhotos` in order.
+func writePhotos(zw *zip.Writer, user models.User, groupPhotos []Photo, modified time.Time) ([]Photo, error) {
+	var all = []Photo{}
+	if user.Photo != "" {
+		all = append(all, Photo{Source: "profile", URL: user.Photo})
+	}
+	all = append(all, groupPhotos...)
+
+	for i := range all {
+		mediaType, data, err := decodeDataURL(all[i].URL)
+		if err != nil {
+			continue // A link to another site, kept in photos.json only
+		}
+		ext, ok := photoExtensions[strings.ToLower(mediaType)]
+		if !ok {
+			ext = ".bin"
+		}
+		all[i].File = fmt.Sprintf("photos/%s-%d%s", all[i].Source, i+1, ext)
+		all[i].URL = ""
+
+		f, err := create(zw, all[i].File, modified)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write(data); err != nil {
+			return nil, fmt.Errorf("writing %s: %w", all[i].File, err)
+		}
+	}
+	return all, writeJSON(zw, "photos.json", modified, all)
+}
+
+// templates renders index.html and the transcripts in parts, so that conversations and messages can be written one at
+// a time
+var templates = template.Must(template.New("export").Parse(`
+{{- define "title" -}}
+{{ if .IsGroup }}{{ .GroupName }}{{ else }}Conversation with {{ range $i, $m := .Members }}{{ if $i }}, {{ end }}{{ $m.Username }}{{ end }}{{ end }}
+{{- end -}}
+
+{{- define "header" -}}
+<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>AlChats export of {{ .User.Username }}</title>
+</head>
+<body>
+<h1>AlChats export of {{ .User.Username }}</h1>
+<p>Exported on {{ .Exported.Format "2006-01-02 15:04:05 MST" }}.</p>
+<h2>Profile</h2>
+<ul>
+<li>User ID: {{ .User.UserID }}</li>
+<li>Username: {{ .User.Username }}</li>
+{{- if .User.Photo }}
+<li>Photo: see below</li>
+{{- end }}
+</ul>
+<h2>Conversations</h2>
+{{ end -}}
+
+{{- define "conversation" -}}
+<section>
+<h3>{{ template "title" . }}</h3>
+<p>Conversation ID: {{ .ConversationID }}</p>
+<p>Members:</p>
+<ul>
+{{- range .Members }}
+<li>{{ .Username }} ({{ .UserID }}){{ if $.IsGroup }}, {{ .Role }}{{ end }}</li>
+{{- end }}
+</ul>
+<p><a href="transcripts/{{ .ConversationID }}.html">Transcript</a> - <a href="messages/{{ .ConversationID }}.json">Messages (JSON)</a></p>
+</section>
+{{ end -}}
+
+{{- define "photos" -}}
+<h2>Photos</h2>
+{{- if . }}
+<ul>
+{{- range . }}
+<li>{{ if eq .Source "profile" }}Profile photo{{ else }}Photo of group {{ .ConversationID }}{{ end }}
+{{- if .SetAt }}, set on {{ .SetAt.Format "2006-01-02 15:04:05 MST" }}{{ end }}:
+{{ if .File }}<a href="{{ .File }}">{{ .File }}</a>{{ else }}<a href="{{ .URL }}">{{ .URL }}</a> (not stored in AlChats){{ end }}</li>
+{{- end }}
+</ul>
+{{- else }}
+<p>No photos.</p>
+{{- end }}
+{{ end -}}
+
+{{- define "footer" -}}
+</body>
+</html>
+{{ end -}}
+
+{{- define "transcript-header" -}}
+<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{ template "title" . }}</title>
+</head>
+<body>
+<h1>{{ template "title" . }}</h1>
+<p>Conversation ID: {{ .ConversationID }}. <a href="../index.html">Back to the export</a></p>
+<ol>
+{{ end -}}
+
+{{- define "message" -}}
+<li id="{{ .Message.MessageID }}">
+<time datetime="{{ .Message.CreatedAt.Format "2006-01-02T15:04:05Z07:00" }}">{{ .Message.CreatedAt.Format "2006-01-02 15:04:05 MST" }}</time>
+{{- if .Message.System }}
+<em>{{ .Message.Content }}</em>
+{{- else }}
+<strong>{{ .Sender }}</strong>:
+{{- if .Message.DeletedAt }}
+<em>message deleted</em>
+{{- else if .Message.Poll }}
+poll &ldquo;{{ .Message.Poll.Question }}&rdquo;{{ if .Message.Poll.ClosedAt }} (closed){{ end }}
+<ul>
+{{- range .Message.Poll.Options }}
+<li>{{ .Text }}: {{ .Votes }}</li>
+{{- end }}
+</ul>
+{{- else }}
+{{ .Message.Content }}{{ if .Message.EditedAt }} <em>(edited)</em>{{ end }}
+{{- end }}
+{{- end }}
+</li>
+{{ end -}}
+
+{{- define "transcript-footer" -}}
+</ol>
+</body>
+</html>
+{{ end -}}
+`))
+
+// writeTranscript adds transcripts/<id>.html, the human-readable messages of the conversation, to the archive.
+func writeTranscript(zw *zip.Writer, db database.AppDatabase, user models.User, details models.ConversationDetails, modified time.Time) error {
+	name := "transcripts/" + details.ConversationID + ".html"
+	f, err := create(zw, name, modified)
+	if err != nil {
+		return err
+	}
+
+	// The senders are the members, or former members looked up once
+	usernames := make(map[string]string)
+	for _, member := range details.Members {
+		usernames[member.UserID] = member.Username
+	}
+	sender := func(userID string) (string, error) {
+		if userID == "" {
+			return "deleted user", nil
+		}
+		if username, ok := usernames[userID]; ok {
+			return username, nil
+		}
+		u, err := db.GetUserByID(userID)
+		if errors.Is(err, database.ErrUserNotFound) {
+			u.Username = "deleted user"
+		} else if err != nil {
+			return "", err
+		}
+		usernames[userID] = u.Username
+		return u.Username, nil
+	}
+
+	if err := templates.ExecuteTemplate(f, "transcript-header", details); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	err = forEachMessage(db, details.ConversationID, user.UserID, func(message models.Message) error {
+		username, err := sender(message.SenderID)
+		if err != nil {
+			return err
+		}
+		return templates.ExecuteTemplate(f, "message", struct {
+			Sender  string
+			Message models.Message
+		}{Sender: username, Message: message})
+	})
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	if err := templates.ExecuteTemplate(f, "transcript-footer", nil); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	return nil
+}
+
+// writeIndex adds index.html, a human-readable overview of the export, to the archive.
+func writeIndex(zw *zip.Writer, db database.AppDatabase, user models.User, photos []Photo, modified time.Time) error {
+	f, err := create(zw, "index.html", modified)
+	if err != nil {
+		return err
+	}
+
+	err = templates.ExecuteTemplate(f, "header", struct {
+		User     models.User
+		Exported time.Time
+	}{User: user, Exported: modified})
+	if err != nil {
+		return fmt.Errorf("writing index.html: %w", err)
+	}
+
+	err = forEachConversation(db, user.UserID, func(details models.ConversationDetails) error {
+		return templates.ExecuteTemplate(f, "conversation", details)
+	})
+	if err != nil {
+		return fmt.Errorf("writing index.html: %w", err)
+	}
+
+	if err := templates.ExecuteTemplate(f, "photos", photos); err != nil {
+		return fmt.Errorf("writing index.html: %w", err)
+	}
+	if err := templates.ExecuteTemplate(f, "footer", nil); err != nil {
+		return fmt.Errorf("writing index.html: %w", err)
+	}
+	return nil
+}
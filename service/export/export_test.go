@@ -0,0 +1,147 @@
+package export
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestWrite(t *testing.T) {
+	dbconn, err := sql.Open("sqlite3", database.SQLiteDSN(filepath.Join(t.TempDir(), "test.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbconn.Close()
+	db, err := database.New(dbconn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, _ := db.SetUser("alice")
+	bob, _ := db.SetUser("bob")
+	carol, _ := db.SetUser("carol")
+	direct, err := db.SetConversation([]string{alice.UserID, bob.UserID}, false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := db.SetConversation([]string{alice.UserID, bob.UserID, carol.UserID}, true, "<friends>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SetConversation([]string{bob.UserID, carol.UserID}, false, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	sent, err := db.AddMessages(direct.ConversationID, []models.Message{
+		{SenderID: alice.UserID, Content: "Hi <Bob>", CreatedAt: start},
+		{SenderID: bob.UserID, Content: "Hidden", CreatedAt: start.Add(time.Minute)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.HideMessage(direct.ConversationID, sent[1].MessageID, alice.UserID); err != nil {
+		t.Fatal(err)
+	}
+	photo := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte("png bytes"))
+	_, err = db.AddMessages(group.ConversationID, []models.Message{
+		{Content: "alice changed the group photo", CreatedAt: start, System: &models.SystemEvent{
+			Action: models.SystemPhotoChanged, ActorID: alice.UserID, Target: photo,
+		}},
+		{SenderID: carol.UserID, Content: "Nice photo", CreatedAt: start.Add(time.Minute)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice.Photo = "https://example.com/alice.jpg"
+
+	var buf bytes.Buffer
+	if err := Write(&buf, db, alice); err != nil {
+		t.Fatalf("writing export: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("reading archive: %v", err)
+	}
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(rc)
+		_ = rc.Close()
+	}
+
+	var profile models.User
+	if err := json.Unmarshal(files["profile.json"], &profile); err != nil || profile != alice {
+		t.Errorf("unexpected profile.json: %s (%v)", files["profile.json"], err)
+	}
+
+	var conversations []models.ConversationDetails
+	if err := json.Unmarshal(files["conversations.json"], &conversations); err != nil {
+		t.Fatalf("invalid conversations.json: %v\n%s", err, files["conversations.json"])
+	}
+	if len(conversations) != 2 {
+		t.Errorf("expected the 2 conversations of alice, got %d", len(conversations))
+	}
+	for _, c := range conversations {
+		if c.IsGroup && len(c.Members) != 3 || !c.IsGroup && len(c.Members) != 2 {
+			t.Errorf("unexpected members in %+v", c)
+		}
+	}
+
+	// The messages that alice can see, as JSON and as a transcript
+	var messages []models.Message
+	if err := json.Unmarshal(files["messages/"+direct.ConversationID+".json"], &messages); err != nil {
+		t.Fatalf("invalid messages of the direct conversation: %v", err)
+	}
+	if len(messages) != 1 || messages[0].Content != "Hi <Bob>" {
+		t.Errorf("expected only the message not hidden to alice, got %+v", messages)
+	}
+	if err := json.Unmarshal(files["messages/"+group.ConversationID+".json"], &messages); err != nil || len(messages) != 2 {
+		t.Errorf("expected the 2 messages of the group, got %+v (%v)", messages, err)
+	}
+	transcript := string(files["transcripts/"+direct.ConversationID+".html"])
+	if !strings.Contains(transcript, "<strong>alice</strong>:") || !strings.Contains(transcript, "Hi &lt;Bob&gt;") ||
+		strings.Contains(transcript, "Hidden") {
+		t.Errorf("unexpected transcript:\n%s", transcript)
+	}
+	if transcript := string(files["transcripts/"+group.ConversationID+".html"]); !strings.Contains(transcript, "Nice photo") {
+		t.Errorf("unexpected transcript:\n%s", transcript)
+	}
+
+	// The photos set by alice: the profile one is a link, the group one is stored
+	var photos []Photo
+	if err := json.Unmarshal(files["photos.json"], &photos); err != nil || len(photos) != 2 {
+		t.Fatalf("unexpected photos.json: %s (%v)", files["photos.json"], err)
+	}
+	if photos[0].Source != "profile" || photos[0].URL != alice.Photo || photos[0].File != "" {
+		t.Errorf("unexpected profile photo %+v", photos[0])
+	}
+	if photos[1].Source != "group" || photos[1].ConversationID != group.ConversationID || photos[1].File == "" {
+		t.Errorf("unexpected group photo %+v", photos[1])
+	}
+	if content := string(files[photos[1].File]); content != "png bytes" {
+		t.Errorf("unexpected content of %s: %q", photos[1].File, content)
+	}
+
+	index := string(files["index.html"])
+	// The members are listed in the order of their IDs, which are random
+	if !strings.Contains(index, "&lt;friends&gt;") || !strings.Contains(index, "Conversation with alice, bob") &&
+		!strings.Contains(index, "Conversation with bob, alice") || !strings.Contains(index, "transcripts/"+group.ConversationID+".html") {
+		t.Errorf("unexpected index.html:\n%s", index)
+	}
+}
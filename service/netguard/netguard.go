@@ -0,0 +1,79 @@
+/*
+Package netguard keeps the requests that the server sends to the URLs of its users (webhooks, push subscriptions) out
+of the network of the server: they can only reach public addresses, not the loopback, link-local, private or
+unspecified ones.
+
+URLs are checked twice: when they are registered, with CheckHost, so that the users get an error; and when the
+connections are opened, with the Control function of the dialer (see NewClient), since the host may resolve to another
+address by then.
+*/
+package netguard
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// LookupIPAddr resolves the host names for CheckHost. Tests can replace it to work without a DNS server.
+var LookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// IsPublic returns whether the server may connect to the address on behalf of its users.
+func IsPublic(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsPrivate() &&
+		!ip.IsUnspecified()
+}
+
+// CheckHost returns an error unless all the addresses of the host (a name or an IP address) are public.
+func CheckHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !IsPublic(ip) {
+			return fmt.Errorf("%s is not a public address", host)
+		}
+		return nil
+	}
+
+	addrs, err := LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("cannot resolve %s", host)
+	}
+	for _, addr := range addrs {
+		if !IsPublic(addr.IP) {
+			return fmt.Errorf("%s resolves to %s, which is not a public address", host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// Control refuses the connections to the addresses that are not public. It is meant for net.Dialer.Control, which
+// receives the address actually dialed.
+func Control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !IsPublic(ip) {
+		return fmt.Errorf("connecting to %s: not a public address", host)
+	}
+	return nil
+}
+
+// NewClient returns an HTTP client that only connects to public addresses, redirects included, with the timeout (0
+// for none). It ignores the proxy of the environment, since it would connect to the proxy in place of the host.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: Control}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+}
@@ -0,0 +1,67 @@
+package netguard
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsPublic(t *testing.T) {
+	for address, public := range map[string]bool{
+		"93.184.215.14":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"fd00::1":         false,
+		"169.254.169.254": false,
+		"fe80::1":         false,
+		"0.0.0.0":         false,
+		"::":              false,
+		"::ffff:10.0.0.1": false,
+	} {
+		if IsPublic(net.ParseIP(address)) != public {
+			t.Errorf("IsPublic(%s) = %v", address, !public)
+		}
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	LookupIPAddr = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "example.com":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.215.14")}}, nil
+		case "rebind.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.215.14")}, {IP: net.ParseIP("127.0.0.1")}}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	defer func() { LookupIPAddr = net.DefaultResolver.LookupIPAddr }()
+
+	for host, valid := range map[string]bool{
+		"example.com":        true,
+		"93.184.215.14":      true,
+		"rebind.example.com": false,
+		"unknown.example":    false,
+		"169.254.169.254":    false,
+		"::1":                false,
+	} {
+		if err := CheckHost(context.Background(), host); (err == nil) != valid {
+			t.Errorf("CheckHost(%q) = %v", host, err)
+		}
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	if resp, err := NewClient(0).Get(srv.URL); err == nil {
+		resp.Body.Close()
+		t.Errorf("expected the connection to the loopback address to be refused")
+	}
+}
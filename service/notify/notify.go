@@ -0,0 +1,101 @@
+/*
+Package notify delivers the notifications of new messages to the users who are not connected.
+
+A Notifier sends a Notification to a user, through one of the implementations of this package:
+
+  - WebPush delivers it to the browsers of the user with the Web Push protocol (RFC 8030), encrypting the payload for
+    each subscription (RFC 8291) and identifying the server with its VAPID key pair (RFC 8292)
+  - Webhook posts it as JSON to a fixed URL, e.g. a gateway to a mobile push service
+  - Log only writes it to the log, for development
+
+The subscriptions of the users are registered through the API and kept in the database; the Notifier receives the
+ones of the recipient with each notification. Subscriptions that the push service reports as expired are returned in
+a *GoneError, so that the caller can delete them.
+
+For example:
+
+	notifier, err := notify.NewWebPush(notify.WebPushConfig{
+		PrivateKey: cfg.Notify.VAPIDPrivateKey,
+		Subject:    "mailto:admin@example.com",
+	})
+	if err != nil {
+		return err
+	}
+	err = notifier.Notify(ctx, notification, subscriptions)
+*/
+package notify
+
+import (
+	"AlChats/service/api/models"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Notification is a new message, as shown to the user it is sent to
+type Notification struct {
+	UserID         string    `json:"userId"`         // User the notification is sent to
+	ConversationID string    `json:"conversationId"` // Conversation of the message
+	MessageID      string    `json:"messageId"`      // New message
+	SenderID       string    `json:"senderId"`       // Author of the message
+	Title          string    `json:"title"`          // The sender, and the group if the message was sent to one
+	Body           string    `json:"body"`           // Content of the message
+	SentAt         time.Time `json:"sentAt"`         // When the message was sent
+}
+
+// Notifier sends notifications to users. Implementations must be safe for concurrent use.
+type Notifier interface {
+	// Notify sends the notification to the user, through the subscriptions of the user (implementations that do not
+	// deliver to the clients directly ignore them). It returns an error if the notification could not be sent, which
+	// is a *GoneError when some subscriptions have expired.
+	Notify(ctx context.Context, n Notification, subscriptions []models.PushSubscription) error
+}
+
+// GoneError reports the subscriptions that do not exist anymore on the push service: they should be deleted. The
+// notification was delivered to the other subscriptions, unless Err is set.
+type GoneError struct {
+	SubscriptionIDs []string
+
+	// Err is the error of the other subscriptions, if any
+	Err error
+}
+
+func (e *GoneError) Error() string {
+	msg := fmt.Sprintf("expired subscriptions %s", strings.Join(e.SubscriptionIDs, ", "))
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *GoneError) Unwrap() error {
+	return e.Err
+}
+
+// Log is a Notifier writing the notifications to the log, for development
+type Log struct {
+	logger logrus.FieldLogger
+}
+
+// NewLog returns a new Log notifier, writing to the logger at the info level.
+func NewLog(logger logrus.FieldLogger) (*Log, error) {
+	if logger == nil {
+		return nil, errors.New("logger is required")
+	}
+	return &Log{logger: logger}, nil
+}
+
+// Notify writes the notification to the log.
+func (l *Log) Notify(_ context.Context, n Notification, subscriptions []models.PushSubscription) error {
+	l.logger.WithFields(logrus.Fields{
+		"user":          n.UserID,
+		"conversation":  n.ConversationID,
+		"message":       n.MessageID,
+		"subscriptions": len(subscriptions),
+	}).Infof("notification: %s: %s", n.Title, n.Body)
+	return nil
+}
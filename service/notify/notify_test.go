@@ -0,0 +1,366 @@
+package notify
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/netguard"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var testNotification = Notification{
+	UserID:         "bob",
+	ConversationID: "c1",
+	MessageID:      "m1",
+	SenderID:       "alice",
+	Title:          "alice in climbing",
+	Body:           "see you at the gym",
+	SentAt:         time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+}
+
+// pushClient is a browser subscribed to a push service: it decrypts the notifications it receives
+type pushClient struct {
+	private    []byte
+	public     []byte
+	authSecret []byte
+}
+
+func newPushClient(t *testing.T) pushClient {
+	t.Helper()
+	private, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authSecret := make([]byte, 16)
+	if _, err := rand.Read(authSecret); err != nil {
+		t.Fatal(err)
+	}
+	return pushClient{private: private, public: elliptic.Marshal(elliptic.P256(), x, y), authSecret: authSecret}
+}
+
+func (c pushClient) keys() models.PushKeys {
+	return models.PushKeys{
+		P256dh: base64.RawURLEncoding.EncodeToString(c.public),
+		Auth:   base64.RawURLEncoding.EncodeToString(c.authSecret),
+	}
+}
+
+// decrypt reverses encrypt, as a browser does (RFC 8291).
+func (c pushClient) decrypt(body []byte) ([]byte, error) {
+	if len(body) < headerSize {
+		return nil, errors.New("body shorter than the header")
+	}
+	salt := body[:saltSize]
+	if rs := binary.BigEndian.Uint32(body[saltSize:]); rs != recordSize {
+		return nil, errors.New("unexpected record size")
+	}
+	if body[saltSize+4] != keySize {
+		return nil, errors.New("unexpected key ID length")
+	}
+	serverKey := body[saltSize+5 : headerSize]
+
+	curve := elliptic.P256()
+	x, y := elliptic.Unmarshal(curve, serverKey)
+	if x == nil {
+		return nil, errors.New("invalid server key")
+	}
+	sharedX, _ := curve.ScalarMult(x, y, c.private)
+	cek, nonce := contentKeys(sharedX.FillBytes(make([]byte, 32)), c.authSecret, salt, c.public, serverKey)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, body[headerSize:], nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(plaintext) == 0 || plaintext[len(plaintext)-1] != 2 {
+		return nil, errors.New("missing the last record delimiter")
+	}
+	return plaintext[:len(plaintext)-1], nil
+}
+
+// checkVAPID verifies the Authorization header of a push request (RFC 8292).
+func checkVAPID(header, publicKey, audience string) error {
+	if !strings.HasPrefix(header, "vapid t=") || !strings.HasSuffix(header, ", k="+publicKey) {
+		return errors.New("unexpected Authorization header " + header)
+	}
+	token := strings.TrimSuffix(strings.TrimPrefix(header, "vapid t="), ", k="+publicKey)
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("the token is not a JWT")
+	}
+
+	key, _ := base64.RawURLEncoding.DecodeString(publicKey)
+	x, y := elliptic.Unmarshal(elliptic.P256(), key)
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) != 64 || x == nil {
+		return errors.New("invalid key or signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, digest[:], r, s) {
+		return errors.New("invalid signature")
+	}
+
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	raw, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return err
+	}
+	if claims.Aud != audience || claims.Sub != "mailto:admin@example.com" || claims.Exp <= time.Now().Unix() {
+		return errors.New("unexpected claims " + string(raw))
+	}
+	return nil
+}
+
+func TestWebPush(t *testing.T) {
+	privateKey, publicKey, err := GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifier, err := NewWebPush(WebPushConfig{PrivateKey: privateKey, Subject: "mailto:admin@example.com", TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notifier.PublicKey() != publicKey {
+		t.Errorf("expected the public key of the pair, got %s", notifier.PublicKey())
+	}
+
+	// The push service stand-in: /ok delivers to the client, /gone forgot the subscription, /broken fails
+	client := newPushClient(t)
+	var mu sync.Mutex
+	var delivered [][]byte
+	var problems []string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+			return
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if r.Header.Get("Content-Encoding") != "aes128gcm" || r.Header.Get("TTL") != "3600" {
+			problems = append(problems, "unexpected headers")
+		}
+		if err := checkVAPID(r.Header.Get("Authorization"), publicKey, srv.URL); err != nil {
+			problems = append(problems, err.Error())
+		}
+		body, _ := io.ReadAll(r.Body)
+		payload, err := client.decrypt(body)
+		if err != nil {
+			problems = append(problems, "decrypting: "+err.Error())
+		}
+		delivered = append(delivered, payload)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+	// The push service listens on the loopback address, which the default client refuses
+	notifier.client = srv.Client()
+
+	subscription := func(id, path string) models.PushSubscription {
+		return models.PushSubscription{SubscriptionID: id, Endpoint: srv.URL + path, Keys: client.keys()}
+	}
+	err = notifier.Notify(context.Background(), testNotification, []models.PushSubscription{subscription("s1", "/ok")})
+	if err != nil {
+		t.Fatalf("notifying: %v", err)
+	}
+	if len(problems) > 0 {
+		t.Errorf("the push service rejected the request: %v", problems)
+	}
+	var received Notification
+	if len(delivered) != 1 || json.Unmarshal(delivered[0], &received) != nil || received != testNotification {
+		t.Errorf("expected the notification to be delivered, got %q", delivered)
+	}
+
+	// Expired subscriptions are reported, without stopping the delivery to the others
+	err = notifier.Notify(context.Background(), testNotification, []models.PushSubscription{
+		subscription("s2", "/gone"), subscription("s3", "/broken"), subscription("s4", "/ok"),
+	})
+	var gone *GoneError
+	if !errors.As(err, &gone) || len(gone.SubscriptionIDs) != 1 || gone.SubscriptionIDs[0] != "s2" || gone.Err == nil ||
+		!strings.Contains(gone.Err.Error(), "s3") {
+		t.Errorf("expected s2 to be gone and s3 to fail, got %v", err)
+	}
+	if len(delivered) != 2 {
+		t.Errorf("expected the notification to be delivered to s4, got %d deliveries", len(delivered))
+	}
+
+	// Invalid keys fail before sending anything
+	bad := subscription("s5", "/ok")
+	bad.Keys.P256dh = "AAAA"
+	if err := notifier.Notify(context.Background(), testNotification, []models.PushSubscription{bad}); err == nil {
+		t.Error("expected an error for an invalid p256dh key")
+	}
+}
+
+// TestWebPushEncryptionExample checks the key derivation against the example of RFC 8291 (appendix A).
+func TestWebPushEncryptionExample(t *testing.T) {
+	decode := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	client := pushClient{
+		private:    decode("q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94"),
+		public:     decode("BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"),
+		authSecret: decode("BTBZMqHH6r4Tts7J_aSIgg"),
+	}
+	body := decode("DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6Tlz" +
+		"AC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN")
+	plaintext, err := client.decrypt(body)
+	if err != nil || string(plaintext) != "When I grow up, I want to be a watermelon" {
+		t.Errorf("unexpected plaintext %q: %v", plaintext, err)
+	}
+}
+
+func TestWebPushLongBody(t *testing.T) {
+	n := testNotification
+	n.Body = strings.Repeat("né<", 2000)
+	payload, err := encodePayload(n)
+	if err != nil || len(payload) > maxPayload {
+		t.Fatalf("expected the payload to fit: %v, %d bytes", err, len(payload))
+	}
+	var decoded Notification
+	if err := json.Unmarshal(payload, &decoded); err != nil || !strings.HasPrefix(n.Body, decoded.Body) || decoded.Body == "" {
+		t.Errorf("expected a prefix of the body: %v, %q", err, decoded.Body)
+	}
+}
+
+func TestNewWebPushErrors(t *testing.T) {
+	privateKey, _, err := GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cfg := range []WebPushConfig{
+		{Subject: "mailto:admin@example.com"},
+		{PrivateKey: "not base64!", Subject: "mailto:admin@example.com"},
+		{PrivateKey: base64.RawURLEncoding.EncodeToString(make([]byte, 32)), Subject: "mailto:admin@example.com"},
+		{PrivateKey: privateKey, Subject: "admin@example.com"},
+	} {
+		if _, err := NewWebPush(cfg); err == nil {
+			t.Errorf("expected an error for %+v", cfg)
+		}
+	}
+}
+
+func TestCheckSubscription(t *testing.T) {
+	netguard.LookupIPAddr = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		if host == "push.example" {
+			return []net.IPAddr{{IP: net.ParseIP("93.184.215.14")}}, nil
+		}
+		return []net.IPAddr{{IP: net.ParseIP("10.0.0.2")}}, nil
+	}
+	defer func() { netguard.LookupIPAddr = net.DefaultResolver.LookupIPAddr }()
+
+	valid := models.PushSubscription{Endpoint: "https://push.example/1", Keys: newPushClient(t).keys()}
+	if err := CheckSubscription(context.Background(), valid); err != nil {
+		t.Errorf("expected a valid subscription: %v", err)
+	}
+
+	shortAuth, notAPoint := valid, valid
+	shortAuth.Keys.Auth = base64.RawURLEncoding.EncodeToString(make([]byte, 8))
+	notAPoint.Keys.P256dh = base64.RawURLEncoding.EncodeToString(append([]byte{4}, make([]byte, 64)...))
+	for _, subscription := range []models.PushSubscription{
+		{Endpoint: "push.example/1", Keys: valid.Keys},
+		{Endpoint: "http://push.example/1", Keys: valid.Keys},
+		{Endpoint: "https://internal.example/1", Keys: valid.Keys},
+		{Endpoint: "https://127.0.0.1/1", Keys: valid.Keys},
+		{Endpoint: "https://[fe80::1]/1", Keys: valid.Keys},
+		{Endpoint: "mailto:admin@example.com", Keys: valid.Keys},
+		{Endpoint: valid.Endpoint},
+		shortAuth,
+		notAPoint,
+	} {
+		if err := CheckSubscription(context.Background(), subscription); err == nil {
+			t.Errorf("expected an error for %+v", subscription)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var received []Notification
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n Notification
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&n) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = append(received, n)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	notifier, err := NewWebhook(WebhookConfig{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(context.Background(), testNotification, nil); err != nil {
+		t.Fatalf("notifying: %v", err)
+	}
+	if len(received) != 1 || received[0] != testNotification {
+		t.Errorf("expected the notification, got %+v", received)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := notifier.Notify(context.Background(), testNotification, nil); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected an error for a failed delivery, got %v", err)
+	}
+
+	if _, err := NewWebhook(WebhookConfig{URL: "ftp://example.com"}); err == nil {
+		t.Error("expected an error for a non HTTP URL")
+	}
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&buf)
+	notifier, err := NewLog(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(context.Background(), testNotification, nil); err != nil {
+		t.Fatalf("notifying: %v", err)
+	}
+	if !strings.Contains(buf.String(), "see you at the gym") || !strings.Contains(buf.String(), "user=bob") {
+		t.Errorf("expected the notification in the log, got %q", buf.String())
+	}
+}
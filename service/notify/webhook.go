@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"AlChats/service/api/models"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// WebhookConfig is used to provide the configuration to the NewWebhook function.
+type WebhookConfig struct {
+	// URL receives the notifications, as POST requests with the Notification as JSON body
+	URL string
+
+	// Client sends the requests (default: a client with a 10 seconds timeout)
+	Client *http.Client
+}
+
+// Webhook is a Notifier posting the notifications to a URL. Any 2xx response is a successful delivery.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook returns a new Webhook notifier.
+func NewWebhook(cfg WebhookConfig) (*Webhook, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("webhook URL is required")
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q", cfg.URL)
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Webhook{url: cfg.URL, client: cfg.Client}, nil
+}
+
+// Notify posts the notification to the webhook. The subscriptions are not used.
+func (w *Webhook) Notify(ctx context.Context, n Notification, _ []models.PushSubscription) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("encoding notification: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting notification: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+package notify
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/globaltime"
+	"AlChats/service/netguard"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Sizes of the Web Push encryption (RFC 8291): the payload, with its header and padding delimiter, must fit in a single
+// record of recordSize bytes.
+const (
+	recordSize  = 4096
+	saltSize    = 16
+	keySize     = 65 // Uncompressed P-256 public keys
+	headerSize  = saltSize + 4 + 1 + keySize
+	tagSize     = 16
+	maxPayload  = recordSize - headerSize - tagSize - 1
+	vapidExpiry = 12 * time.Hour
+)
+
+// WebPushConfig is used to provide the configuration to the NewWebPush function.
+type WebPushConfig struct {
+	// PrivateKey is the VAPID private key: the P-256 private scalar, base64url encoded (see GenerateVAPIDKeys). The
+	// clients subscribe with the matching public key (see WebPush.PublicKey).
+	PrivateKey string
+
+	// Subject is the contact of the operator for the push services: a `mailto:` or `https:` URL
+	Subject string
+
+	// TTL is how long the push services keep the notifications for the clients that are offline (default 24h)
+	TTL time.Duration
+
+	// Client sends the requests (default: a client with a 10 seconds timeout, that only connects to public addresses,
+	// see netguard.NewClient)
+	Client *http.Client
+}
+
+// WebPush is a Notifier delivering the notifications to the push subscriptions of the users
+type WebPush struct {
+	key     *ecdsa.PrivateKey
+	subject string
+	ttl     time.Duration
+	client  *http.Client
+}
+
+// NewWebPush returns a new WebPush notifier.
+func NewWebPush(cfg WebPushConfig) (*WebPush, error) {
+	key, err := parseVAPIDKey(cfg.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(cfg.Subject, "mailto:") && !strings.HasPrefix(cfg.Subject, "https:") {
+		return nil, errors.New("the VAPID subject must be a mailto: or https: URL")
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 24 * time.Hour
+	}
+	if cfg.Client == nil {
+		cfg.Client = netguard.NewClient(10 * time.Second)
+	}
+	return &WebPush{key: key, subject: cfg.Subject, ttl: cfg.TTL, client: cfg.Client}, nil
+}
+
+// GenerateVAPIDKeys returns a new VAPID key pair, base64url encoded: the private key for WebPushConfig, and the public
+// key for the clients.
+func GenerateVAPIDKeys() (privateKey, publicKey string, err error) {
+	private, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(private),
+		base64.RawURLEncoding.EncodeToString(elliptic.Marshal(elliptic.P256(), x, y)), nil
+}
+
+// parseVAPIDKey decodes a private key encoded as by GenerateVAPIDKeys.
+func parseVAPIDKey(encoded string) (*ecdsa.PrivateKey, error) {
+	if encoded == "" {
+		return nil, errors.New("the VAPID private key is required")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil || len(b) != 32 {
+		return nil, errors.New("the VAPID private key must be 32 bytes, base64url encoded")
+	}
+	curve := elliptic.P256()
+	d := new(big.Int).SetBytes(b)
+	if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("invalid VAPID private key")
+	}
+	key := &ecdsa.PrivateKey{D: d}
+	key.Curve = curve
+	key.X, key.Y = curve.ScalarBaseMult(b)
+	return key, nil
+}
+
+// PublicKey returns the VAPID public key, base64url encoded: clients pass it as the applicationServerKey when they
+// subscribe.
+func (w *WebPush) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(elliptic.Marshal(w.key.Curve, w.key.X, w.key.Y))
+}
+
+// Notify sends the notification to every subscription of the user. The subscriptions are tried even if some fail.
+func (w *WebPush) Notify(ctx context.Context, n Notification, subscriptions []models.PushSubscription) error {
+	payload, err := encodePayload(n)
+	if err != nil {
+		return err
+	}
+
+	var gone []string
+	var failed error
+	for _, subscription := range subscriptions {
+		err := w.send(ctx, payload, subscription)
+		if errors.Is(err, errGone) {
+			gone = append(gone, subscription.SubscriptionID)
+		} else if err != nil && failed == nil {
+			failed = fmt.Errorf("subscription %s: %w", subscription.SubscriptionID, err)
+		}
+	}
+	if len(gone) > 0 {
+		return &GoneError{SubscriptionIDs: gone, Err: failed}
+	}
+	return failed
+}
+
+// errGone is returned by send when the push service does not know the subscription anymore
+var errGone = errors.New("subscription gone")
+
+// send encrypts the payload for the subscription and posts it to the push service.
+func (w *WebPush) send(ctx context.Context, payload []byte, subscription models.PushSubscription) error {
+	endpoint, err := url.Parse(subscription.Endpoint)
+	if err != nil || (endpoint.Scheme != "https" && endpoint.Scheme != "http") {
+		return fmt.Errorf("invalid endpoint %q", subscription.Endpoint)
+	}
+	body, err := encrypt(payload, subscription.Keys, rand.Reader)
+	if err != nil {
+		return err
+	}
+	token, err := w.vapidToken(endpoint.Scheme + "://" + endpoint.Host)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(w.ttl/time.Second)))
+	req.Header.Set("Authorization", "vapid t="+token+", k="+w.PublicKey())
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return errGone
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("push service answered %s", resp.Status)
+	}
+	return nil
+}
+
+// vapidToken returns the JWT identifying the server to the push service at `audience` (RFC 8292), signed with ES256.
+func (w *WebPush) vapidToken(audience string) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": audience,
+		"exp": globaltime.Now().Add(vapidExpiry).Unix(),
+		"sub": w.subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, w.key, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("signing the VAPID token: %w", err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// encodePayload returns the notification as JSON, shortening the body if it does not fit in a push message.
+func encodePayload(n Notification) ([]byte, error) {
+	for {
+		payload, err := json.Marshal(n)
+		if err != nil {
+			return nil, fmt.Errorf("encoding notification: %w", err)
+		}
+		if len(payload) <= maxPayload {
+			return payload, nil
+		}
+		if n.Body == "" {
+			return nil, errors.New("the notification is too large")
+		}
+		// Cut a quarter of the body, on a character boundary
+		cut := len(n.Body) * 3 / 4
+		for cut > 0 && !utf8.RuneStart(n.Body[cut]) {
+			cut--
+		}
+		n.Body = n.Body[:cut]
+	}
+}
+
+// CheckSubscription returns an error if the subscription cannot receive notifications: its endpoint must be an HTTPS
+// URL whose host only resolves to public addresses, and its keys those of the Push API.
+func CheckSubscription(ctx context.Context, subscription models.PushSubscription) error {
+	endpoint, err := url.Parse(subscription.Endpoint)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+		return errors.New("the endpoint must be an HTTPS URL")
+	}
+	if _, _, err = parseKeys(subscription.Keys); err != nil {
+		return err
+	}
+	return netguard.CheckHost(ctx, endpoint.Hostname())
+}
+
+// parseKeys decodes the keys of a subscription: the public key of the client, uncompressed, and its 16 bytes
+// authentication secret.
+func parseKeys(keys models.PushKeys) (clientKey, authSecret []byte, err error) {
+	clientKey, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(keys.P256dh, "="))
+	if err != nil || len(clientKey) != keySize {
+		return nil, nil, errors.New("invalid p256dh key")
+	}
+	if _, err := ecdh.P256().NewPublicKey(clientKey); err != nil {
+		return nil, nil, errors.New("the p256dh key is not a P-256 point")
+	}
+	authSecret, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(keys.Auth, "="))
+	if err != nil || len(authSecret) != 16 {
+		return nil, nil, errors.New("invalid auth secret")
+	}
+	return clientKey, authSecret, nil
+}
+
+// encrypt encrypts the payload for the keys of a subscription, with the aes128gcm content encoding of RFC 8291: a
+// single record, with the ephemeral public key of the server as key ID.
+func encrypt(payload []byte, keys models.PushKeys, random io.Reader) ([]byte, error) {
+	clientKey, authSecret, err := parseKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+	clientPublic, err := ecdh.P256().NewPublicKey(clientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	serverPrivate, err := ecdh.P256().GenerateKey(random)
+	if err != nil {
+		return nil, err
+	}
+	serverKey := serverPrivate.PublicKey().Bytes()
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(random, salt); err != nil {
+		return nil, err
+	}
+
+	shared, err := serverPrivate.ECDH(clientPublic)
+	if err != nil {
+		return nil, err
+	}
+	cek, nonce := contentKeys(shared, authSecret, salt, clientKey, serverKey)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, salt)
+	binary.BigEndian.PutUint32(header[saltSize:], recordSize)
+	header[saltSize+4] = keySize
+	copy(header[saltSize+5:], serverKey)
+
+	// The 0x02 delimiter marks the last (and only) record, without padding
+	plaintext := append(append([]byte{}, payload...), 2)
+	return gcm.Seal(header, nonce, plaintext, nil), nil
+}
+
+// contentKeys derives the content encryption key and the nonce from the ECDH secret (RFC 8291, section 3.4).
+func contentKeys(sharedSecret, authSecret, salt, clientKey, serverKey []byte) (cek, nonce []byte) {
+	keyInfo := append(append([]byte("WebPush: info\x00"), clientKey...), serverKey...)
+	ikm := hkdf(authSecret, sharedSecret, keyInfo, 32)
+	cek = hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce = hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+	return cek, nonce
+}
+
+// hkdf is HKDF-SHA-256 (RFC 5869) for outputs of at most 32 bytes, which need a single expansion block.
+func hkdf(salt, secret, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:length]
+}
@@ -0,0 +1,365 @@
+/*
+Package realtime delivers events to the users connected through WebSocket, and receives their requests.
+
+Every WebSocket message is a JSON Frame, in both directions:
+
+	{"v": 1, "type": "message.send", "id": "42", "data": {...}}
+
+`v` is the protocol version (ProtocolVersion); frames with other versions are refused with an "error" frame. `id` is
+chosen by the client for its requests, and is repeated in the "ack" or "error" frame that answers them. The meaning of
+`type` and `data` is up to the Handler of the Hub, except for the "hello" frame, sent by the server when the connection
+is opened, and the "error" frame.
+
+The Hub keeps a queue of frames for each connection. Clients that do not read their frames fast enough (the queue is
+full) are disconnected, with the close code 1013 (try again later) if it can still be delivered: they should reconnect
+and fetch what they missed with the REST API. The server pings every connection periodically, and drops the ones that
+do not answer.
+*/
+package realtime
+
+import (
+	"AlChats/service/websocket"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ProtocolVersion is the version of the frame protocol
+const ProtocolVersion = 1
+
+// Frame types handled by the Hub itself
+const (
+	TypeHello = "hello"
+	TypeAck   = "ack"
+	TypeError = "error"
+)
+
+// Frame is the envelope of every WebSocket message
+type Frame struct {
+	V    int             `json:"v"`
+	Type string          `json:"type"`
+	ID   string          `json:"id,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Handler handles the frames received from a client. It is called sequentially for each connection.
+type Handler func(c *Client, f Frame)
+
+// ErrClosed is returned by Serve when the Hub is closed
+var ErrClosed = errors.New("the hub is closed")
+
+// Config is used to provide dependencies and configuration to the New function.
+type Config struct {
+	// Logger where log entries are sent
+	Logger logrus.FieldLogger
+
+	// PingInterval is the time between pings (default 30s). Connections are dropped if nothing (pongs included) is
+	// received for two intervals.
+	PingInterval time.Duration
+
+	// WriteTimeout is the maximum time to write a frame to a connection (default 10s)
+	WriteTimeout time.Duration
+
+	// QueueSize is the number of frames queued for each connection before the client is considered too slow
+	// (default 64)
+	QueueSize int
+
+	// ReadLimit is the maximum size of a received frame (default 64 KiB)
+	ReadLimit int64
+}
+
+// Hub keeps track of the connected clients and sends them frames.
+type Hub struct {
+	logger       logrus.FieldLogger
+	pingInterval time.Duration
+	writeTimeout time.Duration
+	queueSize    int
+	readLimit    int64
+
+	mu      sync.Mutex
+	clients map[string]map[*Client]struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// New returns a new Hub instance
+func New(cfg Config) (*Hub, error) {
+	if cfg.Logger == nil {
+		return nil, errors.New("logger is required")
+	}
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = 30 * time.Second
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = 10 * time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 64
+	}
+	if cfg.ReadLimit <= 0 {
+		cfg.ReadLimit = 64 << 10
+	}
+	return &Hub{
+		logger:       cfg.Logger,
+		pingInterval: cfg.PingInterval,
+		writeTimeout: cfg.WriteTimeout,
+		queueSize:    cfg.QueueSize,
+		readLimit:    cfg.ReadLimit,
+		clients:      make(map[string]map[*Client]struct{}),
+	}, nil
+}
+
+// Client is a WebSocket connection of a user
+type Client struct {
+	hub    *Hub
+	conn   *websocket.Conn
+	userID string
+
+	send       chan []byte
+	done       chan struct{}
+	readerDone chan struct{}
+	closeOnce  sync.Once
+	closeCode  int
+	closeText  string
+
+	// deadlineMu orders the write deadlines set by the writer and by Send when the client is too slow
+	deadlineMu sync.Mutex
+}
+
+// UserID returns the user of the connection.
+func (c *Client) UserID() string {
+	return c.userID
+}
+
+// Serve runs the connection of the user until it is closed, passing the received frames to the handler. The
+// connection is closed when Serve returns.
+func (h *Hub) Serve(conn *websocket.Conn, userID string, handler Handler) error {
+	c := &Client{
+		hub:        h,
+		conn:       conn,
+		userID:     userID,
+		send:       make(chan []byte, h.queueSize),
+		done:       make(chan struct{}),
+		readerDone: make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		_ = conn.WriteClose(websocket.CloseGoingAway, "server shutting down")
+		_ = conn.Close()
+		return ErrClosed
+	}
+	if h.clients[userID] == nil {
+		h.clients[userID] = make(map[*Client]struct{})
+	}
+	h.clients[userID][c] = struct{}{}
+	h.wg.Add(1)
+	h.mu.Unlock()
+	defer h.wg.Done()
+
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		c.writeLoop()
+	}()
+
+	c.Send(Frame{Type: TypeHello, Data: mustMarshal(map[string]interface{}{
+		"userId":   userID,
+		"versions": []int{ProtocolVersion},
+	})})
+	err := c.readLoop(handler)
+
+	h.mu.Lock()
+	delete(h.clients[userID], c)
+	if len(h.clients[userID]) == 0 {
+		delete(h.clients, userID)
+	}
+	h.mu.Unlock()
+
+	c.Close(websocket.CloseNormalClosure, "")
+	close(c.readerDone)
+	<-writerDone
+
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		return nil
+	}
+	return err
+}
+
+// readLoop reads the frames of the client until the connection is closed.
+func (c *Client) readLoop(handler Handler) error {
+	pongWait := 2 * c.hub.pingInterval
+	c.conn.SetReadLimit(c.hub.readLimit)
+	_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func([]byte) {
+		_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		messageType, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		var f Frame
+		if messageType != websocket.TextMessage || json.Unmarshal(data, &f) != nil {
+			c.SendError("", "frames must be JSON text messages")
+			continue
+		}
+		if f.V != ProtocolVersion {
+			c.SendError(f.ID, "unsupported protocol version")
+			continue
+		}
+		handler(c, f)
+	}
+}
+
+// writeLoop sends the queued frames and the pings, and the close frame at the end.
+func (c *Client) writeLoop() {
+	ticker := time.NewTicker(c.hub.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data := <-c.send:
+			if !c.armWrite() {
+				continue
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				c.Close(websocket.CloseInternalError, "")
+				_ = c.conn.Close()
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.hub.writeTimeout)); err != nil {
+				c.Close(websocket.CloseInternalError, "")
+				_ = c.conn.Close()
+				return
+			}
+		case <-c.done:
+			// Closing handshake: send the close frame, then wait for the peer to answer (ending the read loop)
+			if err := c.conn.WriteClose(c.closeCode, c.closeText); err == nil {
+				select {
+				case <-c.readerDone:
+				case <-time.After(c.hub.writeTimeout):
+				}
+			}
+			_ = c.conn.Close()
+			return
+		}
+	}
+}
+
+// armWrite sets the deadline for writing a frame. It returns false if the connection is being closed.
+func (c *Client) armWrite() bool {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.hub.writeTimeout))
+	return true
+}
+
+// Send queues a frame for the client, setting its version. If the queue is full, the client is disconnected.
+func (c *Client) Send(f Frame) {
+	f.V = ProtocolVersion
+	data, err := json.Marshal(f)
+	if err != nil {
+		c.hub.logger.WithError(err).Error("encoding websocket frame")
+		return
+	}
+
+	select {
+	case <-c.done:
+	case c.send <- data:
+	default:
+		c.hub.logger.WithField("user", c.userID).Warning("websocket client too slow, disconnecting")
+		c.Close(websocket.CloseTryAgainLater, "client too slow")
+		// Unblock the writer if it is stuck on the connection: the client is not reading anyway
+		c.deadlineMu.Lock()
+		_ = c.conn.SetWriteDeadline(time.Now())
+		c.deadlineMu.Unlock()
+	}
+}
+
+// SendAck answers the request with the given id, with optional data.
+func (c *Client) SendAck(id string, data interface{}) {
+	c.Send(Frame{Type: TypeAck, ID: id, Data: mustMarshal(data)})
+}
+
+// SendError answers the request with the given id with an error.
+func (c *Client) SendError(id string, message string) {
+	c.Send(Frame{Type: TypeError, ID: id, Data: mustMarshal(map[string]string{"error": message})})
+}
+
+// Close starts the closing handshake of the connection with the code and reason. Calls after the first one have no
+// effect.
+func (c *Client) Close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode, c.closeText = code, reason
+		close(c.done)
+	})
+}
+
+// Publish sends the frame to every connection of the users, except `except` (usually the connection that caused the
+// event; it can be nil).
+func (h *Hub) Publish(userIDs []string, f Frame, except *Client) {
+	var targets []*Client
+	h.mu.Lock()
+	for _, userID := range userIDs {
+		for c := range h.clients[userID] {
+			if c != except {
+				targets = append(targets, c)
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	for _, c := range targets {
+		c.Send(f)
+	}
+}
+
+// Connected reports whether the user has at least one open connection.
+func (h *Hub) Connected(userID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients[userID]) > 0
+}
+
+// Close disconnects every client with the close code 1001 (going away) and waits for the connections to be closed.
+// New connections are refused.
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	h.closed = true
+	for _, clients := range h.clients {
+		for c := range clients {
+			c.Close(websocket.CloseGoingAway, "server shutting down")
+		}
+	}
+	h.mu.Unlock()
+
+	h.wg.Wait()
+	return nil
+}
+
+// mustMarshal encodes v as JSON; v must be encodable (maps and structs of plain values).
+func mustMarshal(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
@@ -0,0 +1,123 @@
+package realtime
+
+import (
+	"AlChats/service/websocket"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newTestHub starts a server connecting every request to the hub as the user in the `user` query parameter.
+func newTestHub(t *testing.T, cfg Config) (*Hub, *httptest.Server) {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg.Logger = logger
+
+	hub, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		_ = hub.Serve(conn, r.URL.Query().Get("user"), func(c *Client, f Frame) {
+			c.SendAck(f.ID, map[string]string{"echo": f.Type})
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return hub, srv
+}
+
+// waitFor polls the condition until it is true, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+	t.Fatalf("timeout waiting for %s", what)
+}
+
+func TestSlowClientIsDisconnected(t *testing.T) {
+	hub, srv := newTestHub(t, Config{QueueSize: 4, WriteTimeout: 500 * time.Millisecond})
+
+	conn, _, err := websocket.Dial(context.Background(), srv.URL+"?user=slow", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitFor(t, "the connection", func() bool { return hub.Connected("slow") })
+
+	// The client never reads: once the socket buffers are full, the queue fills up too
+	payload, _ := json.Marshal(strings.Repeat("x", 64<<10))
+	for i := 0; i < 2000 && hub.Connected("slow"); i++ {
+		hub.Publish([]string{"slow"}, Frame{Type: "event", Data: payload}, nil)
+	}
+	waitFor(t, "the slow client to be disconnected", func() bool { return !hub.Connected("slow") })
+}
+
+func TestProtocol(t *testing.T) {
+	hub, srv := newTestHub(t, Config{})
+
+	conn, _, err := websocket.Dial(context.Background(), srv.URL+"?user=alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	read := func() Frame {
+		t.Helper()
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("reading: %v", err)
+		}
+		var f Frame
+		if err := json.Unmarshal(data, &f); err != nil || f.V != ProtocolVersion {
+			t.Fatalf("invalid frame %s", data)
+		}
+		return f
+	}
+
+	if f := read(); f.Type != TypeHello || !strings.Contains(string(f.Data), `"userId":"alice"`) {
+		t.Errorf("expected hello, got %+v", f)
+	}
+	for frame, expected := range map[string]string{
+		`{"v":1,"type":"ping","id":"1"}`: TypeAck,
+		`{"v":9,"type":"ping","id":"1"}`: TypeError,
+		`not json`:                       TypeError,
+	} {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+		if f := read(); f.Type != expected {
+			t.Errorf("%s: expected %s, got %+v", frame, expected, f)
+		}
+	}
+
+	// The client answers the close frame of the hub while reading
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	if err := hub.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if hub.Connected("alice") {
+		t.Error("expected no connections after closing the hub")
+	}
+}
@@ -0,0 +1,398 @@
+/*
+Package webhooks delivers the events of the conversations to their webhooks, for integrations like bots and CI systems.
+
+The events are queued in the database (see database.AppDatabase.EnqueueWebhookEvent), so that they survive restarts; a
+Dispatcher posts the due deliveries to the webhooks. Each request has the JSON Payload as body, and the headers:
+
+  - X-AlChats-Event: the event
+  - X-AlChats-Delivery: the ID of the delivery, the same for every attempt
+  - X-AlChats-Signature: `sha256=` followed by the hex HMAC-SHA256 of the body, keyed with the secret of the webhook
+
+The webhooks can only be on public addresses (see netguard): CheckURL rejects the others when a webhook is
+registered, and the default client refuses to connect to them.
+
+Any 2xx response is a successful delivery. A failed attempt is retried with an exponential backoff, starting from
+RetryDelay and doubling at each attempt; after MaxAttempts attempts the delivery is dead-lettered: it is kept in the
+delivery log with the status models.DeliveryDead, and is not retried unless requested.
+
+The deliveries are not ordered across retries: while a failed delivery waits for its retry, the following events of the
+webhook are still posted when they are due. Receivers needing the order should sort the events by Payload.CreatedAt.
+
+Deliveries are not claimed before they are posted: when several instances of the server share a database, each of them
+posts every due delivery. Run a single Dispatcher per database (e.g., disable the webhooks on the other instances).
+
+For example:
+
+	dispatcher, err := webhooks.New(webhooks.Config{Database: db, Logger: logger})
+	if err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	go dispatcher.Run(stop)
+*/
+package webhooks
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"AlChats/service/globaltime"
+	"AlChats/service/netguard"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Defaults of Config
+const (
+	DefaultMaxAttempts   = 8
+	DefaultRetryDelay    = 30 * time.Second
+	DefaultMaxRetryDelay = 6 * time.Hour
+	DefaultPollInterval  = 10 * time.Second
+	DefaultTimeout       = 10 * time.Second
+	DefaultConcurrency   = 4
+)
+
+// batchSize is how many due deliveries are read from the database at a time
+const batchSize = 50
+
+// maxErrorLength is the length of the errors kept in the delivery log
+const maxErrorLength = 200
+
+// Headers of the requests to the webhooks
+const (
+	HeaderEvent     = "X-AlChats-Event"
+	HeaderDelivery  = "X-AlChats-Delivery"
+	HeaderSignature = "X-AlChats-Signature"
+)
+
+// Config is used to provide dependencies and configuration to the New function.
+type Config struct {
+	// Database keeps the webhooks and the queue of their deliveries
+	Database database.AppDatabase
+
+	// Logger where log entries are sent
+	Logger logrus.FieldLogger
+
+	// Client sends the requests (default: a client that only connects to public addresses, see netguard.NewClient)
+	Client *http.Client
+
+	// Timeout bounds each attempt (default DefaultTimeout)
+	Timeout time.Duration
+
+	// Concurrency is how many webhooks are posted to at the same time (default DefaultConcurrency)
+	Concurrency int
+
+	// MaxAttempts is how many times a delivery is attempted before it is dead-lettered (default DefaultMaxAttempts)
+	MaxAttempts int
+
+	// RetryDelay is the delay before the first retry, doubled at each following one (default DefaultRetryDelay)
+	RetryDelay time.Duration
+
+	// MaxRetryDelay caps the delay between two attempts (default DefaultMaxRetryDelay)
+	MaxRetryDelay time.Duration
+
+	// PollInterval is how often Run looks for the deliveries due, besides when it is woken up (default
+	// DefaultPollInterval)
+	PollInterval time.Duration
+}
+
+// Payload is the body of the requests to the webhooks
+type Payload struct {
+	DeliveryID     string          `json:"deliveryId"`
+	WebhookID      string          `json:"webhookId"`
+	ConversationID string          `json:"conversationId"`
+	Event          string          `json:"event"`
+	CreatedAt      time.Time       `json:"createdAt"` // When the event happened
+	Data           json.RawMessage `json:"data"`      // Depends on the event (see models.WebhookEvents)
+}
+
+// Dispatcher posts the queued events to the webhooks
+type Dispatcher struct {
+	db     database.AppDatabase
+	logger logrus.FieldLogger
+	client *http.Client
+
+	timeout       time.Duration
+	concurrency   int
+	maxAttempts   int
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+	pollInterval  time.Duration
+
+	// wake asks Run to look for the deliveries due without waiting for the next poll
+	wake chan struct{}
+}
+
+// New returns a new Dispatcher.
+func New(cfg Config) (*Dispatcher, error) {
+	if cfg.Database == nil {
+		return nil, errors.New("database is required")
+	}
+	if cfg.Logger == nil {
+		return nil, errors.New("logger is required")
+	}
+	if cfg.Client == nil {
+		cfg.Client = netguard.NewClient(0)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = DefaultConcurrency
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = DefaultRetryDelay
+	}
+	if cfg.MaxRetryDelay <= 0 {
+		cfg.MaxRetryDelay = DefaultMaxRetryDelay
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = DefaultPollInterval
+	}
+	return &Dispatcher{
+		db:            cfg.Database,
+		logger:        cfg.Logger,
+		client:        cfg.Client,
+		timeout:       cfg.Timeout,
+		concurrency:   cfg.Concurrency,
+		maxAttempts:   cfg.MaxAttempts,
+		retryDelay:    cfg.RetryDelay,
+		maxRetryDelay: cfg.MaxRetryDelay,
+		pollInterval:  cfg.PollInterval,
+		wake:          make(chan struct{}, 1),
+	}, nil
+}
+
+// Run delivers the due deliveries until `stop` is closed. Failures are logged, and retried at the next poll.
+func (d *Dispatcher) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	for {
+		for {
+			n, err := d.DeliverDue(ctx)
+			if err != nil {
+				d.logger.WithError(err).Error("delivering the webhook events")
+			}
+			// A full batch means that more deliveries may be due
+			if err != nil || n < batchSize {
+				break
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// Wake makes Run look for the deliveries due now, e.g. after events were queued.
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// DeliverDue attempts a batch of the deliveries that are due, and returns how many were attempted. The deliveries of
+// a webhook are attempted in order, stopping at the first one that fails, so that the later ones are not posted before
+// it within the batch; those of different webhooks are attempted concurrently (up to Config.Concurrency at a time), so
+// that a slow webhook does not hold up the others.
+func (d *Dispatcher) DeliverDue(ctx context.Context) (int, error) {
+	due, err := d.db.GetDueWebhookDeliveries(globaltime.Now(), batchSize)
+	if err != nil {
+		return 0, err
+	}
+	var webhookIDs []string
+	byWebhook := make(map[string][]models.WebhookDelivery)
+	for _, delivery := range due {
+		if _, ok := byWebhook[delivery.WebhookID]; !ok {
+			webhookIDs = append(webhookIDs, delivery.WebhookID)
+		}
+		byWebhook[delivery.WebhookID] = append(byWebhook[delivery.WebhookID], delivery)
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		attempted int
+		firstErr  error
+	)
+	slots := make(chan struct{}, d.concurrency)
+	for _, webhookID := range webhookIDs {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(deliveries []models.WebhookDelivery) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			for _, delivery := range deliveries {
+				err := ctx.Err()
+				delivered := false
+				if err == nil {
+					delivered, err = d.attempt(ctx, delivery)
+				}
+				mu.Lock()
+				if err == nil {
+					attempted++
+				} else if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				if err != nil || !delivered {
+					return
+				}
+			}
+		}(byWebhook[webhookID])
+	}
+	wg.Wait()
+	return attempted, firstErr
+}
+
+// attempt posts the delivery to its webhook, and saves the outcome. It returns whether the webhook received the
+// delivery, and only the errors of the database.
+func (d *Dispatcher) attempt(ctx context.Context, delivery models.WebhookDelivery) (bool, error) {
+	webhook, err := d.db.GetWebhook(delivery.WebhookID)
+	if errors.Is(err, database.ErrWebhookNotFound) {
+		// Deleted in the meantime, with its deliveries
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	status, err := d.post(ctx, webhook, delivery)
+	delivered := err == nil
+	now := globaltime.Now()
+	delivery.Attempts++
+	delivery.LastStatusCode = status
+	delivery.LastError = ""
+	switch {
+	case err == nil:
+		delivery.Status, delivery.NextAttemptAt, delivery.DeliveredAt = models.DeliveryDelivered, nil, &now
+	case delivery.Attempts >= d.maxAttempts:
+		delivery.Status, delivery.NextAttemptAt = models.DeliveryDead, nil
+	default:
+		next := now.Add(d.backoff(delivery.Attempts))
+		delivery.NextAttemptAt = &next
+	}
+	if err != nil {
+		delivery.LastError = truncate(err.Error(), maxErrorLength)
+		d.logger.WithError(err).WithFields(logrus.Fields{
+			"webhook":  webhook.WebhookID,
+			"delivery": delivery.DeliveryID,
+			"attempts": delivery.Attempts,
+			"status":   delivery.Status,
+		}).Warning("webhook delivery failed")
+	}
+
+	err = d.db.UpdateWebhookDelivery(delivery)
+	if errors.Is(err, database.ErrDeliveryNotFound) {
+		return delivered, nil
+	}
+	return delivered, err
+}
+
+// backoff returns the delay before the attempt following the `attempts`-th one.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.retryDelay
+	for i := 1; i < attempts && delay < d.maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > d.maxRetryDelay {
+		delay = d.maxRetryDelay
+	}
+	return delay
+}
+
+// post sends the delivery to the webhook. It returns the HTTP status of the response (0 if there was none), and an
+// error if the delivery failed.
+func (d *Dispatcher) post(ctx context.Context, webhook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(Payload{
+		DeliveryID:     delivery.DeliveryID,
+		WebhookID:      webhook.WebhookID,
+		ConversationID: webhook.ConversationID,
+		Event:          delivery.Event,
+		CreatedAt:      delivery.CreatedAt,
+		Data:           delivery.Data,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("encoding the payload: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "AlChats-Webhooks/1")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.DeliveryID)
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// CheckURL returns an error if the webhooks cannot post to the URL: it must be an absolute HTTP(S) URL, whose host
+// only resolves to public addresses.
+func CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("the url must be an absolute http or https URL")
+	}
+	return netguard.CheckHost(ctx, u.Hostname())
+}
+
+// Sign returns the value of the X-AlChats-Signature header for the body: `sha256=` followed by the hex HMAC-SHA256 of
+// the body, keyed with the secret of the webhook. Receivers should compare it in constant time, e.g. with hmac.Equal.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// truncate shortens s to at most n bytes, on a character boundary.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,341 @@
+package webhooks
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"AlChats/service/globaltime"
+	"AlChats/service/netguard"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// receiver is a webhook endpoint: it records the requests, and answers with the statuses in order (then 200)
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (rcv *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.bodies = append(rcv.bodies, body)
+	rcv.headers = append(rcv.headers, r.Header.Clone())
+	status := http.StatusOK
+	if len(rcv.statuses) > 0 {
+		status, rcv.statuses = rcv.statuses[0], rcv.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+// setup returns a dispatcher, and a webhook of a new conversation posting to `url`.
+func setup(t *testing.T, url string) (*Dispatcher, database.AppDatabase, models.Webhook) {
+	t.Helper()
+	db := database.NewMemory()
+	alice, _ := db.SetUser("alice")
+	bob, _ := db.SetUser("bob")
+	conversation, err := db.SetConversation([]string{alice.UserID, bob.UserID}, false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook, err := db.CreateWebhook(models.Webhook{
+		ConversationID: conversation.ConversationID,
+		URL:            url,
+		CreatedBy:      alice.UserID,
+		CreatedAt:      globaltime.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	// The receivers listen on the loopback address, which the default client refuses
+	d, err := New(Config{Database: db, Logger: logger, Client: &http.Client{}, MaxAttempts: 3, RetryDelay: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, db, webhook
+}
+
+func TestDeliver(t *testing.T) {
+	rcv := &receiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	d, db, webhook := setup(t, srv.URL)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	globaltime.FixedTime = now
+	defer func() { globaltime.FixedTime = time.Time{} }()
+
+	if n, err := db.EnqueueWebhookEvent(webhook.ConversationID, models.EventMessageNew, []byte(`{"content":"hi"}`), now); err != nil || n != 1 {
+		t.Fatalf("expected a delivery to be queued: %v, %d", err, n)
+	}
+	if n, err := d.DeliverDue(context.Background()); err != nil || n != 1 {
+		t.Fatalf("expected a delivery to be attempted: %v, %d", err, n)
+	}
+
+	if len(rcv.bodies) != 1 {
+		t.Fatalf("expected a request, got %d", len(rcv.bodies))
+	}
+	body, header := rcv.bodies[0], rcv.headers[0]
+	if header.Get(HeaderEvent) != models.EventMessageNew || header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected headers %v", header)
+	}
+	if !hmac.Equal([]byte(header.Get(HeaderSignature)), []byte(Sign(webhook.Secret, body))) {
+		t.Errorf("the signature %q does not match the body", header.Get(HeaderSignature))
+	}
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.WebhookID != webhook.WebhookID || payload.ConversationID != webhook.ConversationID ||
+		payload.DeliveryID != header.Get(HeaderDelivery) || string(payload.Data) != `{"content":"hi"}` ||
+		!payload.CreatedAt.Equal(now) {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+
+	delivery, err := db.GetWebhookDelivery(webhook.WebhookID, payload.DeliveryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delivery.Status != models.DeliveryDelivered || delivery.Attempts != 1 || delivery.LastStatusCode != http.StatusOK ||
+		delivery.NextAttemptAt != nil || delivery.DeliveredAt == nil || !delivery.DeliveredAt.Equal(now) {
+		t.Errorf("unexpected delivery %+v", delivery)
+	}
+
+	// Nothing is due anymore
+	if n, err := d.DeliverDue(context.Background()); err != nil || n != 0 {
+		t.Errorf("expected nothing to be due: %v, %d", err, n)
+	}
+}
+
+func TestRetryAndDeadLetter(t *testing.T) {
+	rcv := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable}}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	d, db, webhook := setup(t, srv.URL)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	globaltime.FixedTime = now
+	defer func() { globaltime.FixedTime = time.Time{} }()
+	if _, err := db.EnqueueWebhookEvent(webhook.ConversationID, models.EventMemberJoined, []byte(`{}`), now); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries := func() models.WebhookDelivery {
+		t.Helper()
+		list, _, err := db.GetWebhookDeliveries(webhook.WebhookID, database.Page{})
+		if err != nil || len(list) != 1 {
+			t.Fatalf("expected a delivery: %v, %+v", err, list)
+		}
+		return list[0]
+	}
+
+	// The retries are delayed by 1, then 2 minutes
+	for attempt, delay := range []time.Duration{time.Minute, 2 * time.Minute} {
+		if n, err := d.DeliverDue(context.Background()); err != nil || n != 1 {
+			t.Fatalf("attempt %d: expected a delivery to be attempted: %v, %d", attempt+1, err, n)
+		}
+		delivery := deliveries()
+		if delivery.Status != models.DeliveryPending || delivery.Attempts != attempt+1 || delivery.LastError == "" ||
+			delivery.NextAttemptAt == nil || !delivery.NextAttemptAt.Equal(now.Add(delay)) {
+			t.Fatalf("attempt %d: unexpected delivery %+v", attempt+1, delivery)
+		}
+
+		// Not due before the delay
+		globaltime.FixedTime = now.Add(delay - time.Second)
+		if n, err := d.DeliverDue(context.Background()); err != nil || n != 0 {
+			t.Fatalf("attempt %d: expected nothing to be due yet: %v, %d", attempt+1, err, n)
+		}
+		now = now.Add(delay)
+		globaltime.FixedTime = now
+	}
+
+	// The last attempt fails too: the delivery is dead-lettered
+	if n, err := d.DeliverDue(context.Background()); err != nil || n != 1 {
+		t.Fatalf("expected the last attempt: %v, %d", err, n)
+	}
+	delivery := deliveries()
+	if delivery.Status != models.DeliveryDead || delivery.Attempts != 3 || delivery.LastStatusCode != http.StatusServiceUnavailable ||
+		delivery.NextAttemptAt != nil || delivery.DeliveredAt != nil {
+		t.Errorf("unexpected dead delivery %+v", delivery)
+	}
+	globaltime.FixedTime = now.Add(24 * time.Hour)
+	if n, err := d.DeliverDue(context.Background()); err != nil || n != 0 {
+		t.Errorf("expected dead deliveries not to be retried: %v, %d", err, n)
+	}
+	if len(rcv.bodies) != 3 {
+		t.Errorf("expected 3 requests, got %d", len(rcv.bodies))
+	}
+}
+
+func TestStopAtFailure(t *testing.T) {
+	rcv := &receiver{statuses: []int{http.StatusInternalServerError}}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	d, db, webhook := setup(t, srv.URL)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	globaltime.FixedTime = now
+	defer func() { globaltime.FixedTime = time.Time{} }()
+	for i, event := range []string{models.EventMessageNew, models.EventMessageEdited} {
+		if _, err := db.EnqueueWebhookEvent(webhook.ConversationID, event, []byte(`{}`), now.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second event is not posted before the first one, which failed
+	if n, err := d.DeliverDue(context.Background()); err != nil || n != 1 {
+		t.Fatalf("expected a single attempt: %v, %d", err, n)
+	}
+	if len(rcv.headers) != 1 || rcv.headers[0].Get(HeaderEvent) != models.EventMessageNew {
+		t.Errorf("expected only the first event to be posted, got %v", rcv.headers)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{retryDelay: 30 * time.Second, maxRetryDelay: time.Hour}
+	for attempts, want := range map[int]time.Duration{
+		1: 30 * time.Second, 2: time.Minute, 3: 2 * time.Minute, 7: 32 * time.Minute, 8: time.Hour, 100: time.Hour,
+	} {
+		if got := d.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, expected %v", attempts, got, want)
+		}
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	d, db, webhook := setup(t, srv.URL)
+
+	if _, err := db.EnqueueWebhookEvent(webhook.ConversationID, models.EventMessageNew, []byte(`{}`), globaltime.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.DeliverDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	list, _, err := db.GetWebhookDeliveries(webhook.WebhookID, database.Page{})
+	if err != nil || len(list) != 1 || list[0].LastStatusCode != 0 || list[0].LastError == "" || list[0].Status != models.DeliveryPending {
+		t.Errorf("expected the failure to be recorded: %v, %+v", err, list)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	d, db, webhook := setup(t, srv.URL)
+	d.timeout = 50 * time.Millisecond
+
+	if _, err := db.EnqueueWebhookEvent(webhook.ConversationID, models.EventMessageNew, []byte(`{}`), globaltime.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.DeliverDue(context.Background()); err != nil || n != 1 {
+		t.Fatalf("expected a delivery to be attempted: %v, %d", err, n)
+	}
+	list, _, err := db.GetWebhookDeliveries(webhook.WebhookID, database.Page{})
+	if err != nil || len(list) != 1 || list[0].LastError == "" || list[0].Status != models.DeliveryPending {
+		t.Errorf("expected the attempt to time out: %v, %+v", err, list)
+	}
+}
+
+func TestSlowWebhook(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	fast := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fast <- struct{}{}
+	}))
+	defer srv.Close()
+
+	d, db, webhook := setup(t, slow.URL)
+	if _, err := db.CreateWebhook(models.Webhook{ConversationID: webhook.ConversationID, URL: srv.URL, CreatedBy: webhook.CreatedBy}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.EnqueueWebhookEvent(webhook.ConversationID, models.EventMessageNew, []byte(`{}`), globaltime.Now()); err != nil || n != 2 {
+		t.Fatalf("expected two deliveries to be queued: %v, %d", err, n)
+	}
+
+	done := make(chan int)
+	go func() {
+		n, _ := d.DeliverDue(context.Background())
+		done <- n
+	}()
+	select {
+	case <-fast:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slow webhook held up the other one")
+	}
+	close(release)
+	if n := <-done; n != 2 {
+		t.Errorf("expected two deliveries to be attempted, got %d", n)
+	}
+}
+
+func TestInternalAddress(t *testing.T) {
+	rcv := &receiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	d, db, webhook := setup(t, srv.URL)
+	d.client = netguard.NewClient(0)
+
+	if _, err := db.EnqueueWebhookEvent(webhook.ConversationID, models.EventMessageNew, []byte(`{}`), globaltime.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.DeliverDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	list, _, err := db.GetWebhookDeliveries(webhook.WebhookID, database.Page{})
+	if err != nil || len(list) != 1 || list[0].LastError == "" || len(rcv.bodies) != 0 {
+		t.Errorf("expected the connection to the loopback address to be refused: %v, %+v", err, list)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	netguard.LookupIPAddr = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		if host == "ci.example.com" {
+			return []net.IPAddr{{IP: net.ParseIP("93.184.215.14")}}, nil
+		}
+		return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+	}
+	defer func() { netguard.LookupIPAddr = net.DefaultResolver.LookupIPAddr }()
+
+	for rawURL, valid := range map[string]bool{
+		"https://ci.example.com/hooks/chat":       true,
+		"http://93.184.215.14:8080/hooks":         true,
+		"http://localhost:8080":                   false,
+		"http://127.0.0.1:8080":                   false,
+		"http://10.0.0.2/hooks":                   false,
+		"http://169.254.169.254/latest/meta-data": false,
+		"http://[::1]/hooks":                      false,
+		"http://0.0.0.0:8080":                     false,
+		"ftp://example.com":                       false,
+		"/relative":                               false,
+		"https://":                                false,
+		"":                                        false,
+	} {
+		if err := CheckURL(context.Background(), rawURL); (err == nil) != valid {
+			t.Errorf("CheckURL(%q) = %v", rawURL, err)
+		}
+	}
+}
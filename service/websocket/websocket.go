@@ -0,0 +1,348 @@
+/*
+Package websocket is a small implementation of the WebSocket protocol (RFC 6455) on top of the standard library.
+
+It supports what AlChats needs and nothing more: the opening handshake (Upgrade on the server side, Dial on the client
+side), text and binary messages (fragmented or not), ping/pong and the closing handshake. Extensions (e.g.,
+compression) and subprotocols are not supported.
+
+A Conn supports one concurrent reader and any number of concurrent writers. Control frames received while reading
+(pings and closes) are answered by ReadMessage itself.
+*/
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // Required by the protocol, not used for security
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Message types (frame opcodes)
+const (
+	continuationFrame = 0
+	TextMessage       = 1
+	BinaryMessage     = 2
+	CloseMessage      = 8
+	PingMessage       = 9
+	PongMessage       = 10
+)
+
+// Close codes
+const (
+	CloseNormalClosure   = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+	CloseTryAgainLater   = 1013
+)
+
+// DefaultReadLimit is the maximum size of a received message, unless changed with Conn.SetReadLimit
+const DefaultReadLimit = 1 << 20
+
+// acceptGUID is appended to the handshake key to compute Sec-WebSocket-Accept
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	// ErrCloseSent is returned when writing after the close frame has been sent
+	ErrCloseSent = errors.New("websocket: close frame already sent")
+
+	// ErrReadLimit is returned when a received message is larger than the read limit
+	ErrReadLimit = errors.New("websocket: message too big")
+
+	errProtocol = errors.New("websocket: protocol error")
+)
+
+// CloseError is returned by ReadMessage when the peer closes the connection
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with code %d %s", e.Code, e.Text)
+}
+
+// Conn is a WebSocket connection
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	// client connections mask the frames they send, server connections require masked frames
+	client bool
+
+	readLimit   int64
+	pongHandler func(data []byte)
+
+	wmu       sync.Mutex
+	closeSent bool
+
+	// writeDeadline is the last deadline set with SetWriteDeadline, restored after the control frames
+	dmu           sync.Mutex
+	writeDeadline time.Time
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, client bool) *Conn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	return &Conn{conn: conn, br: br, client: client, readLimit: DefaultReadLimit}
+}
+
+// SetReadLimit sets the maximum size of a received message. Larger messages close the connection.
+func (c *Conn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// SetPongHandler sets the function called by ReadMessage for each pong received. It must be set before reading.
+func (c *Conn) SetPongHandler(h func(data []byte)) {
+	c.pongHandler = h
+}
+
+// SetReadDeadline sets the deadline for reading from the underlying connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for writing to the underlying connection. WriteControl does not change it.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.dmu.Lock()
+	defer c.dmu.Unlock()
+	c.writeDeadline = t
+	return c.conn.SetWriteDeadline(t)
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close closes the underlying connection, without the closing handshake (see WriteClose).
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered and pongs passed to the pong handler while
+// waiting for it. When the peer closes the connection, the close frame is echoed and a *CloseError is returned.
+func (c *Conn) ReadMessage() (messageType int, data []byte, err error) {
+	messageType = -1
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return -1, nil, err
+		}
+
+		switch opcode {
+		case PingMessage:
+			if err := c.WriteControl(PongMessage, payload, time.Now().Add(time.Second)); err != nil && !errors.Is(err, ErrCloseSent) {
+				return -1, nil, err
+			}
+			continue
+		case PongMessage:
+			if c.pongHandler != nil {
+				c.pongHandler(payload)
+			}
+			continue
+		case CloseMessage:
+			closeErr := &CloseError{Code: CloseNoStatus}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Text = string(payload[2:])
+			}
+			// Echo the close frame, unless we started the closing handshake
+			echo := payload
+			if len(payload) >= 2 {
+				echo = payload[:2]
+			}
+			_ = c.WriteControl(CloseMessage, echo, time.Now().Add(time.Second))
+			return -1, nil, closeErr
+		case TextMessage, BinaryMessage:
+			if messageType != -1 {
+				return -1, nil, c.fail(CloseProtocolError, "expected a continuation frame")
+			}
+			messageType = opcode
+		case continuationFrame:
+			if messageType == -1 {
+				return -1, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return -1, nil, c.fail(CloseProtocolError, "unknown opcode")
+		}
+
+		if int64(len(data)+len(payload)) > c.readLimit {
+			_ = c.fail(CloseMessageTooBig, "message too big")
+			return -1, nil, ErrReadLimit
+		}
+		data = append(data, payload...)
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(data) {
+				return -1, nil, c.fail(CloseInvalidPayload, "invalid UTF-8")
+			}
+			return messageType, data, nil
+		}
+	}
+}
+
+// readFrame reads a single frame, unmasking its payload.
+func (c *Conn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = int(header[0] & 0x0f)
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7f)
+
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	if masked == c.client {
+		return false, 0, nil, c.fail(CloseProtocolError, "invalid masking")
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if opcode >= CloseMessage && (!fin || length > 125) {
+		return false, 0, nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+	if length < 0 || length > c.readLimit {
+		_ = c.fail(CloseMessageTooBig, "message too big")
+		return false, 0, nil, ErrReadLimit
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// fail starts the closing handshake after a protocol violation of the peer, and returns the error.
+func (c *Conn) fail(code int, reason string) error {
+	_ = c.WriteClose(code, reason)
+	return fmt.Errorf("%w: %s", errProtocol, reason)
+}
+
+// WriteMessage sends a text or binary message in a single frame.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.writeFrame(messageType, data)
+}
+
+// WriteControl sends a ping, pong or close frame, waiting at most until the deadline. The deadline set with
+// SetWriteDeadline (e.g., for a message about to be written by another goroutine) is restored afterwards.
+func (c *Conn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	if messageType < CloseMessage || len(data) > 125 {
+		return fmt.Errorf("websocket: invalid control frame")
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	defer func() {
+		c.dmu.Lock()
+		defer c.dmu.Unlock()
+		_ = c.conn.SetWriteDeadline(c.writeDeadline)
+	}()
+	return c.writeFrame(messageType, data)
+}
+
+// WriteClose starts the closing handshake with the code and reason. The caller should keep reading until
+// ReadMessage returns the *CloseError of the peer, then close the connection.
+func (c *Conn) WriteClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > 125 {
+		payload = payload[:125]
+	}
+	return c.WriteControl(CloseMessage, payload, time.Now().Add(time.Second))
+}
+
+// writeFrame writes a final frame; c.wmu must be held.
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	if c.closeSent {
+		return ErrCloseSent
+	}
+
+	var header = make([]byte, 2, 14)
+	header[0] = 0x80 | byte(opcode)
+	switch n := len(payload); {
+	case n <= 125:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	frame := append(header, payload...)
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame[1] |= 0x80
+		frame = append(frame[:len(header)], mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	}
+
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// acceptKey computes the Sec-WebSocket-Accept header for the Sec-WebSocket-Key of the handshake.
+func acceptKey(key string) string {
+	h := sha1.New() //nolint:gosec
+	_, _ = h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,181 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newEchoServer starts a server that sends back every message it receives.
+func newEchoServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetReadLimit(1 << 10)
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(messageType, data); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestEcho(t *testing.T) {
+	srv := newEchoServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := Dial(ctx, srv.URL, nil)
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// Messages of every length encoding (7 bits, 16 bits) and types
+	for _, msg := range []struct {
+		messageType int
+		data        string
+	}{
+		{TextMessage, "hello"},
+		{TextMessage, ""},
+		{BinaryMessage, strings.Repeat("x", 1000)},
+	} {
+		if err := conn.WriteMessage(msg.messageType, []byte(msg.data)); err != nil {
+			t.Fatalf("writing: %v", err)
+		}
+		messageType, data, err := conn.ReadMessage()
+		if err != nil || messageType != msg.messageType || string(data) != msg.data {
+			t.Errorf("expected %d %q back, got %d %q (%v)", msg.messageType, msg.data, messageType, data, err)
+		}
+	}
+
+	// Pings are answered while reading
+	pong := make(chan string, 1)
+	conn.SetPongHandler(func(data []byte) { pong <- string(data) })
+	if err := conn.WriteControl(PingMessage, []byte("ping"), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(TextMessage, []byte("after ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "after ping" {
+		t.Errorf("unexpected message %q (%v)", data, err)
+	}
+	if got := <-pong; got != "ping" {
+		t.Errorf("expected pong with the ping payload, got %q", got)
+	}
+
+	// Messages over the read limit close the connection
+	if err := conn.WriteMessage(TextMessage, []byte(strings.Repeat("x", 2000))); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = conn.ReadMessage()
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != CloseMessageTooBig {
+		t.Errorf("expected close %d, got %v", CloseMessageTooBig, err)
+	}
+}
+
+func TestClosingHandshake(t *testing.T) {
+	srv := newEchoServer(t)
+	conn, _, err := Dial(context.Background(), srv.URL, nil)
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteClose(CloseNormalClosure, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(TextMessage, []byte("late")); !errors.Is(err, ErrCloseSent) {
+		t.Errorf("expected ErrCloseSent, got %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != CloseNormalClosure {
+		t.Errorf("expected the close to be echoed, got %v", err)
+	}
+}
+
+func TestBadHandshake(t *testing.T) {
+	srv := newEchoServer(t)
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for a plain request, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired || resp.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("expected 426 for an unsupported version, got %d", resp.StatusCode)
+	}
+}
+
+func TestAcceptKey(t *testing.T) {
+	// Example from RFC 6455, section 1.3
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("unexpected accept key %s", got)
+	}
+}
+
+// deadlineConn records the last write deadline set on the connection.
+type deadlineConn struct {
+	net.Conn
+	deadline time.Time
+}
+
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func TestWriteControlKeepsDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go func() { _, _ = io.Copy(io.Discard, server) }()
+
+	dc := &deadlineConn{Conn: client}
+	conn := newConn(dc, bufio.NewReader(dc), true)
+	deadline := time.Now().Add(time.Minute)
+	if err := conn.SetWriteDeadline(deadline); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteControl(PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if !dc.deadline.Equal(deadline) {
+		t.Errorf("expected the write deadline %v to be restored, got %v", deadline, dc.deadline)
+	}
+}
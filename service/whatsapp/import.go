@@ -0,0 +1,172 @@
+package whatsapp
+
+import (
+	"AlChats/service/api/models"
+	"AlChats/service/database"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// MediaPlaceholder is the content of the imported messages that had an attachment
+const MediaPlaceholder = "<Media omitted>"
+
+// PlaceholderPrefix is the prefix of the usernames of the users created for unmapped participants
+const PlaceholderPrefix = "whatsapp-"
+
+// defaultGroupName is used for imported groups without a name
+const defaultGroupName = "WhatsApp chat"
+
+// ErrUnknownParticipant is returned by Import when the participants mapping refers to a name that is not in the chat
+var ErrUnknownParticipant = errors.New("participant not found in the chat")
+
+// ImportOptions control how a chat is imported
+type ImportOptions struct {
+	// Participants maps names in the chat to the usernames of existing AlChats users. A placeholder user is created for
+	// each participant not in the map.
+	Participants map[string]string
+
+	// Members are the IDs of users to add to the conversation even if they did not write in the chat, e.g. the user
+	// importing it
+	Members []string
+
+	// GroupName is the name of the conversation. When empty, the group name found in the chat is used.
+	GroupName string
+}
+
+// Result describes an imported chat
+type Result struct {
+	Conversation models.Conversation `json:"conversation"`
+	Messages     int                 `json:"messages"`     // Number of imported messages
+	Skipped      int                 `json:"skipped"`      // Number of system lines, which are not imported
+	Placeholders []models.User       `json:"placeholders"` // Users created for the unmapped participants
+}
+
+// Import saves the chat in the database: the placeholder users, the conversation and the messages, with their
+// original timestamps, are created together with database.ImportChat, so a failed import leaves nothing behind. The
+// conversation is a 1:1 conversation if it has two members and no group name, a group otherwise; the first of
+// opts.Members (e.g. the user importing the chat) is the owner of a group.
+func Import(db database.AppDatabase, chat Chat, opts ImportOptions) (Result, error) {
+	var result = Result{Placeholders: []models.User{}}
+
+	// Check the whole mapping before writing anything
+	var inChat = make(map[string]bool)
+	for _, name := range chat.Participants {
+		inChat[name] = true
+	}
+	var users = make(map[string]string)
+	for name, username := range opts.Participants {
+		if !inChat[name] {
+			return result, fmt.Errorf("%s: %w", name, ErrUnknownParticipant)
+		}
+		user, err := db.GetUserByUsername(username)
+		if err != nil {
+			return result, err
+		}
+		users[name] = user.UserID
+	}
+	for _, userID := range opts.Members {
+		if _, err := db.GetUserByID(userID); err != nil {
+			return result, err
+		}
+	}
+
+	var messages []models.Message
+	for _, message := range chat.Messages {
+		if message.System() {
+			result.Skipped++
+			continue
+		}
+		content := message.Text
+		if message.Media {
+			content = MediaPlaceholder
+		}
+		messages = append(messages, models.Message{Content: content, CreatedAt: message.Time})
+	}
+	if len(messages) == 0 {
+		return result, ErrNoMessages
+	}
+
+	// Members of the conversation, without duplicates (more names may be mapped to the same user), and their index
+	var imported database.ChatImport
+	var index = make(map[string]int)
+	for _, userID := range append(append([]string{}, opts.Members...), participantIDs(chat, users)...) {
+		if _, ok := index[userID]; !ok {
+			index[userID] = len(imported.MemberIDs)
+			imported.MemberIDs = append(imported.MemberIDs, userID)
+		}
+	}
+	var senders = make(map[string]int)
+	for name, userID := range users {
+		senders[name] = index[userID]
+	}
+	// The database adds a suffix to the placeholder usernames that are taken (see database.ChatImport)
+	for _, name := range chat.Participants {
+		if _, ok := senders[name]; ok {
+			continue
+		}
+		senders[name] = len(imported.MemberIDs) + len(imported.NewUsernames)
+		imported.NewUsernames = append(imported.NewUsernames, PlaceholderPrefix+slug(name))
+	}
+	if len(imported.MemberIDs)+len(imported.NewUsernames) < 2 {
+		return result, errors.New("a conversation needs at least two members")
+	}
+
+	imported.GroupName = opts.GroupName
+	if imported.GroupName == "" {
+		imported.GroupName = chat.Subject
+	}
+	imported.IsGroup = len(imported.MemberIDs)+len(imported.NewUsernames) > 2 || imported.GroupName != ""
+	if imported.IsGroup && imported.GroupName == "" {
+		imported.GroupName = defaultGroupName
+	}
+
+	for _, message := range chat.Messages {
+		if !message.System() {
+			imported.Senders = append(imported.Senders, senders[message.Sender])
+		}
+	}
+	imported.Messages = messages
+
+	conversation, placeholders, err := db.ImportChat(imported)
+	if err != nil {
+		return result, err
+	}
+	result.Conversation = conversation
+	result.Placeholders = append(result.Placeholders, placeholders...)
+	result.Messages = len(messages)
+	return result, nil
+}
+
+// participantIDs returns the user IDs of the mapped participants, in the order of the chat.
+func participantIDs(chat Chat, users map[string]string) []string {
+	var ids []string
+	for _, name := range chat.Participants {
+		if userID, ok := users[name]; ok {
+			ids = append(ids, userID)
+		}
+	}
+	return ids
+}
+
+// slug turns a name (or a phone number) into a lowercase identifier made of letters, digits and dashes.
+func slug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return "user"
+	}
+	return b.String()
+}
@@ -0,0 +1,302 @@
+/*
+Package whatsapp imports chats exported with the "Export chat" feature of WhatsApp into AlChats.
+
+The export is a text file with one message per line, prefixed by the date and time it was sent. The format depends on
+the phone and on its language; both the Android one:
+
+	31/12/2020, 21:41 - Alice: Happy new year!
+
+and the iOS one:
+
+	[31/12/2020, 21:41:05] Alice: Happy new year!
+
+are supported, with any date order (day/month/year, month/day/year, year-month-day), 12 or 24 hour clock, and
+multi-line messages. Lines without a sender (e.g., "Alice added Bob") are system lines: they are parsed, but not
+imported. Media is not included in text exports, so media messages are imported as MediaPlaceholder.
+
+Use Parse to read an export, then Import to save it in the database.
+*/
+package whatsapp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoMessages is returned by Parse when the file does not contain any message
+var ErrNoMessages = errors.New("no messages found, is this a WhatsApp chat export?")
+
+// DateOrder is the order of the day, month and year fields in the dates of an export
+type DateOrder string
+
+const (
+	DayMonthYear DateOrder = "DMY"
+	MonthDayYear DateOrder = "MDY"
+	YearMonthDay DateOrder = "YMD"
+)
+
+// ParseOptions are the settings of the export that cannot be read from the file itself
+type ParseOptions struct {
+	// Location is the time zone of the phone that exported the chat, as dates are written without it. UTC if nil.
+	Location *time.Location
+
+	// DateOrder of the dates. When empty, it is detected from the dates in the file; if they are all ambiguous (day
+	// and month both 12 or less), month/day/year is assumed for 12-hour clocks and day/month/year otherwise.
+	DateOrder DateOrder
+}
+
+// Message is a message of the chat
+type Message struct {
+	Time time.Time
+
+	// Sender is the name (or phone number) of the author, as shown in the phone of who exported the chat. It is empty
+	// for system lines.
+	Sender string
+
+	// Text of the message; lines after the first one are separated by "\n"
+	Text string
+
+	// Media is set for attachments, which are not included in text exports
+	Media bool
+}
+
+// System reports whether the message is a system line, like "Alice added Bob", rather than a message of a user.
+func (m Message) System() bool {
+	return m.Sender == ""
+}
+
+// Chat is a parsed export
+type Chat struct {
+	// Messages in the order of the file, system lines included
+	Messages []Message
+
+	// Participants are the senders of the messages, in order of first message
+	Participants []string
+
+	// Subject is the last group name found in the system lines, if any
+	Subject string
+}
+
+var (
+	// datePattern and timePattern match the timestamp of a message, e.g. "31/12/2020, 21:41" or "12/31/20, 9:41 PM".
+	// Timestamps may start with invisible direction marks, and have non-breaking spaces (e.g., before "PM").
+	datePattern  = `(\d{1,4})[./-](\d{1,2})[./-](\d{1,4}),?`
+	timePattern  = `(\d{1,2})[:.](\d{2})(?:[:.](\d{2}))?(?:` + space + `*([AaPp])\.?` + space + `*[Mm]\.?)?`
+	space        = `[ \t\x{00A0}\x{202F}]`
+	marksPattern = `[\x{200E}\x{200F}]*`
+
+	androidLine = regexp.MustCompile(`^` + marksPattern + datePattern + space + `+` + timePattern + space + `+[-–]` +
+		space + `+(.*)$`)
+	iosLine = regexp.MustCompile(`^` + marksPattern + `\[` + datePattern + space + `+` + timePattern + `\]` + space +
+		`+(.*)$`)
+
+	// mediaTexts are the placeholders of omitted attachments, in the languages of the most common exports
+	mediaTexts = map[string]bool{
+		"<Media omitted>":         true,
+		"<Medien ausgeschlossen>": true,
+		"<Multimedia omitido>":    true,
+		"<Médias omis>":           true,
+		"<Media omessi>":          true,
+		"<Mídia oculta>":          true,
+		"<Media weggelaten>":      true,
+	}
+	mediaLine = regexp.MustCompile(`^(?:<attached: .+>|(?:image|video|audio|sticker|GIF|document) omitted)$`)
+
+	// subjectLines extract the group name from the system lines
+	subjectLines = []*regexp.Regexp{
+		regexp.MustCompile(`created group ["“](.+)["”]$`),
+		regexp.MustCompile(`changed the subject (?:from ["“].*["”] )?to ["“](.+)["”]$`),
+		regexp.MustCompile(`changed the group name (?:from ["“].*["”] )?to ["“](.+)["”]$`),
+	}
+)
+
+const (
+	byteOrderMark   = "\ufeff"
+	leftToRightMark = "\u200e"
+)
+
+// record is a message whose timestamp has not been interpreted yet, as the date order is known only at the end
+type record struct {
+	line   int
+	fields [7]string // date (3 fields), hours, minutes, seconds, AM/PM
+	rest   string
+}
+
+// Parse reads a WhatsApp chat export.
+func Parse(r io.Reader, opts ParseOptions) (Chat, error) {
+	var records []record
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if n == 1 {
+			line = strings.TrimPrefix(line, byteOrderMark)
+		}
+
+		match := androidLine.FindStringSubmatch(line)
+		if match == nil {
+			match = iosLine.FindStringSubmatch(line)
+		}
+		if match != nil {
+			var rec = record{line: n, rest: match[8]}
+			copy(rec.fields[:], match[1:8])
+			records = append(records, rec)
+			continue
+		}
+
+		// Lines without timestamp continue the previous message
+		if len(records) == 0 {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			return Chat{}, fmt.Errorf("line %d: %w", n, ErrNoMessages)
+		}
+		records[len(records)-1].rest += "\n" + line
+	}
+	if err := scanner.Err(); err != nil {
+		return Chat{}, fmt.Errorf("reading the export: %w", err)
+	}
+	if len(records) == 0 {
+		return Chat{}, ErrNoMessages
+	}
+
+	order := opts.DateOrder
+	if order == "" {
+		var err error
+		if order, err = detectDateOrder(records); err != nil {
+			return Chat{}, err
+		}
+	}
+	loc := opts.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	var chat Chat
+	var seen = make(map[string]bool)
+	for _, rec := range records {
+		ts, err := rec.time(order, loc)
+		if err != nil {
+			return Chat{}, fmt.Errorf("line %d: %w", rec.line, err)
+		}
+		message := parseMessage(rec.rest)
+		message.Time = ts
+
+		if message.System() {
+			for _, re := range subjectLines {
+				if m := re.FindStringSubmatch(message.Text); m != nil {
+					chat.Subject = m[1]
+				}
+			}
+		} else if !seen[message.Sender] {
+			seen[message.Sender] = true
+			chat.Participants = append(chat.Participants, message.Sender)
+		}
+		chat.Messages = append(chat.Messages, message)
+	}
+	return chat, nil
+}
+
+// parseMessage splits the text after the timestamp in sender and text.
+func parseMessage(rest string) Message {
+	first := rest
+	if i := strings.IndexByte(rest, '\n'); i >= 0 {
+		first = rest[:i]
+	}
+	i := strings.Index(first, ": ")
+	if i <= 0 {
+		return Message{Text: strings.TrimPrefix(rest, leftToRightMark)}
+	}
+
+	message := Message{Sender: strings.TrimPrefix(rest[:i], leftToRightMark), Text: rest[i+2:]}
+	text := strings.TrimSpace(strings.TrimPrefix(message.Text, leftToRightMark))
+	switch {
+	case mediaTexts[text] || mediaLine.MatchString(text):
+		message.Media = true
+	case strings.HasPrefix(message.Text, leftToRightMark):
+		// iOS writes system lines (e.g., "Messages and calls are end-to-end encrypted") as sent by the group, with a
+		// left-to-right mark before the text
+		return Message{Text: text}
+	}
+	return message
+}
+
+// detectDateOrder finds the date order consistent with all the dates of the export.
+func detectDateOrder(records []record) (DateOrder, error) {
+	var dayFirst, monthFirst, twelveHours bool
+	for _, rec := range records {
+		if len(rec.fields[0]) == 4 {
+			return YearMonthDay, nil
+		}
+		a, _ := strconv.Atoi(rec.fields[0])
+		b, _ := strconv.Atoi(rec.fields[1])
+		dayFirst = dayFirst || a > 12
+		monthFirst = monthFirst || b > 12
+		twelveHours = twelveHours || rec.fields[6] != ""
+	}
+
+	switch {
+	case dayFirst && monthFirst:
+		return "", errors.New("the dates do not have a consistent day/month order")
+	case dayFirst:
+		return DayMonthYear, nil
+	case monthFirst:
+		return MonthDayYear, nil
+	case twelveHours:
+		return MonthDayYear, nil
+	default:
+		return DayMonthYear, nil
+	}
+}
+
+// time interprets the timestamp of the record.
+func (rec record) time(order DateOrder, loc *time.Location) (time.Time, error) {
+	var n [6]int
+	for i := range n {
+		if rec.fields[i] == "" {
+			continue
+		}
+		n[i], _ = strconv.Atoi(rec.fields[i])
+	}
+
+	var year, month, day int
+	switch order {
+	case DayMonthYear:
+		day, month, year = n[0], n[1], n[2]
+	case MonthDayYear:
+		month, day, year = n[0], n[1], n[2]
+	case YearMonthDay:
+		year, month, day = n[0], n[1], n[2]
+	default:
+		return time.Time{}, fmt.Errorf("unknown date order %s", order)
+	}
+	if year < 100 {
+		year += 2000
+	}
+
+	hour, minute, second := n[3], n[4], n[5]
+	switch strings.ToUpper(rec.fields[6]) {
+	case "A":
+		if hour == 12 {
+			hour = 0
+		}
+	case "P":
+		if hour < 12 {
+			hour += 12
+		}
+	}
+
+	ts := time.Date(year, time.Month(month), day, hour, minute, second, 0, loc)
+	if ts.Day() != day || int(ts.Month()) != month || ts.Hour() != hour || minute > 59 || second > 59 {
+		return time.Time{}, fmt.Errorf("invalid date %s/%s/%s %s:%s", rec.fields[0], rec.fields[1], rec.fields[2],
+			rec.fields[3], rec.fields[4])
+	}
+	return ts, nil
+}
@@ -0,0 +1,177 @@
+package whatsapp
+
+import (
+	"AlChats/service/database"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestParse(t *testing.T) {
+	rome, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		t.Skipf("time zone database not available: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		export  string
+		opts    ParseOptions
+		first   time.Time
+		senders []string
+		texts   []string
+		subject string
+	}{
+		{
+			name: "android, day first",
+			export: "31/12/2020, 21:41 - Messages and calls are end-to-end encrypted.\n" +
+				"31/12/2020, 21:41 - Alice: Happy new year!\n" +
+				"01/01/2021, 00:02 - Bob Smith: Same to you\n" +
+				"and to everybody\n" +
+				"01/01/2021, 00:03 - Alice: <Media omitted>\n",
+			first:   time.Date(2020, 12, 31, 21, 41, 0, 0, time.UTC),
+			senders: []string{"", "Alice", "Bob Smith", "Alice"},
+			texts:   []string{"Messages and calls are end-to-end encrypted.", "Happy new year!", "Same to you\nand to everybody", "<Media omitted>"},
+		},
+		{
+			name: "android, US locale",
+			export: "12/31/20, 9:41 PM - Alice created group \"New year\"\r\n" +
+				"12/31/20, 9:41 PM - Alice: Hi: how are you?\r\n" +
+				"1/1/21, 12:05 AM - +1 555 0100: Fine\r\n",
+			opts:    ParseOptions{Location: rome},
+			first:   time.Date(2020, 12, 31, 21, 41, 0, 0, rome),
+			senders: []string{"", "Alice", "+1 555 0100"},
+			texts:   []string{"Alice created group \"New year\"", "Hi: how are you?", "Fine"},
+			subject: "New year",
+		},
+		{
+			name: "iOS",
+			export: "\ufeff[31.12.20, 21:41:05] Friends: \u200eMessages and calls are end-to-end encrypted.\n" +
+				"[31.12.20, 21:41:07] Alice: Hello\n" +
+				"\u200e[31.12.20, 21:42:00] Bob: \u200eimage omitted\n" +
+				"[31.12.20, 21:42:10] Bob: \u200e<attached: 00000012-PHOTO-2020-12-31-21-42-10.jpg>\n",
+			first:   time.Date(2020, 12, 31, 21, 41, 5, 0, time.UTC),
+			senders: []string{"", "Alice", "Bob", "Bob"},
+			texts:   []string{"Messages and calls are end-to-end encrypted.", "Hello", "\u200eimage omitted", "\u200e<attached: 00000012-PHOTO-2020-12-31-21-42-10.jpg>"},
+		},
+		{
+			name:    "ambiguous dates with explicit order",
+			export:  "02/03/2021, 10:00 - Alice: Hi\n",
+			opts:    ParseOptions{DateOrder: MonthDayYear},
+			first:   time.Date(2021, 2, 3, 10, 0, 0, 0, time.UTC),
+			senders: []string{"Alice"},
+			texts:   []string{"Hi"},
+		},
+		{
+			name:    "year first",
+			export:  "2021-03-02 10:00 - Alice: Hej\n",
+			first:   time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC),
+			senders: []string{"Alice"},
+			texts:   []string{"Hej"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			chat, err := Parse(strings.NewReader(tc.export), tc.opts)
+			if err != nil {
+				t.Fatalf("parsing: %v", err)
+			}
+			if len(chat.Messages) != len(tc.senders) {
+				t.Fatalf("expected %d messages, got %d: %+v", len(tc.senders), len(chat.Messages), chat.Messages)
+			}
+			if !chat.Messages[0].Time.Equal(tc.first) {
+				t.Errorf("expected first message at %v, got %v", tc.first, chat.Messages[0].Time)
+			}
+			for i, m := range chat.Messages {
+				if m.Sender != tc.senders[i] || m.Text != tc.texts[i] {
+					t.Errorf("message %d: expected %q: %q, got %q: %q", i, tc.senders[i], tc.texts[i], m.Sender, m.Text)
+				}
+			}
+			if chat.Subject != tc.subject {
+				t.Errorf("expected subject %q, got %q", tc.subject, chat.Subject)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for name, export := range map[string]string{
+		"empty":           "",
+		"not an export":   "hello\nworld\n",
+		"mixed day order": "31/01/2021, 10:00 - Alice: Hi\n01/31/2021, 10:00 - Alice: Hi\n",
+		"invalid date":    "31/02/2021, 10:00 - Alice: Hi\n",
+	} {
+		if _, err := Parse(strings.NewReader(export), ParseOptions{}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	dbconn, err := sql.Open("sqlite3", database.SQLiteDSN(filepath.Join(t.TempDir(), "test.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbconn.Close()
+	db, err := database.New(dbconn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, _ := db.SetUser("alice")
+	if _, err := db.SetUser(PlaceholderPrefix + "bob-smith"); err != nil {
+		t.Fatal(err)
+	}
+
+	chat, err := Parse(strings.NewReader(
+		"31/12/2020, 21:40 - Alice created group \"Friends\"\n"+
+			"31/12/2020, 21:41 - Alice Rossi: Happy new year!\n"+
+			"01/01/2021, 00:02 - Bob Smith: <Media omitted>\n"+
+			"01/01/2021, 00:03 - Carol: Cheers\n"), ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Import(db, chat, ImportOptions{Participants: map[string]string{"Dave": "alice"}}); !errors.Is(err, ErrUnknownParticipant) {
+		t.Errorf("expected ErrUnknownParticipant, got %v", err)
+	}
+	if _, err := Import(db, chat, ImportOptions{Participants: map[string]string{"Carol": "nobody"}}); !errors.Is(err, database.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+
+	result, err := Import(db, chat, ImportOptions{Participants: map[string]string{"Alice Rossi": "alice"}})
+	if err != nil {
+		t.Fatalf("importing: %v", err)
+	}
+	if !result.Conversation.IsGroup || result.Conversation.GroupName != "Friends" {
+		t.Errorf("expected the group Friends, got %+v", result.Conversation)
+	}
+	if result.Messages != 3 || result.Skipped != 1 {
+		t.Errorf("expected 3 messages and 1 skipped line, got %d and %d", result.Messages, result.Skipped)
+	}
+	var placeholders []string
+	for _, u := range result.Placeholders {
+		placeholders = append(placeholders, u.Username)
+	}
+	if strings.Join(placeholders, ",") != "whatsapp-bob-smith-2,whatsapp-carol" {
+		t.Errorf("unexpected placeholders %v", placeholders)
+	}
+
+	messages, _, err := db.GetConversationMessages(result.Conversation.ConversationID, database.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("expected 3 saved messages, got %d", len(messages))
+	}
+	if messages[0].SenderID != alice.UserID || !messages[0].CreatedAt.Equal(time.Date(2020, 12, 31, 21, 41, 0, 0, time.UTC)) {
+		t.Errorf("first message not backdated or with the wrong sender: %+v", messages[0])
+	}
+	if messages[1].Content != MediaPlaceholder || messages[1].SenderID != result.Placeholders[0].UserID {
+		t.Errorf("unexpected media message %+v", messages[1])
+	}
+}